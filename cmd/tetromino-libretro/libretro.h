@@ -0,0 +1,88 @@
+// The parts of the libretro API (https://github.com/libretro/RetroArch/blob/master/libretro-common/include/libretro.h)
+// that the core uses
+
+#include <stdbool.h>
+#include <stddef.h>
+#include <stdint.h>
+
+#define RETRO_API_VERSION 1
+
+#define RETRO_DEVICE_JOYPAD 1
+
+#define RETRO_DEVICE_ID_JOYPAD_B 0
+#define RETRO_DEVICE_ID_JOYPAD_SELECT 2
+#define RETRO_DEVICE_ID_JOYPAD_START 3
+#define RETRO_DEVICE_ID_JOYPAD_UP 4
+#define RETRO_DEVICE_ID_JOYPAD_DOWN 5
+#define RETRO_DEVICE_ID_JOYPAD_LEFT 6
+#define RETRO_DEVICE_ID_JOYPAD_RIGHT 7
+#define RETRO_DEVICE_ID_JOYPAD_A 8
+
+#define RETRO_REGION_NTSC 0
+
+#define RETRO_ENVIRONMENT_SET_PIXEL_FORMAT 10
+#define RETRO_ENVIRONMENT_GET_SAVE_DIRECTORY 31
+
+#define RETRO_PIXEL_FORMAT_XRGB8888 1
+
+struct retro_system_info {
+	const char *library_name;
+	const char *library_version;
+	const char *valid_extensions;
+	bool need_fullpath;
+	bool block_extract;
+};
+
+struct retro_game_geometry {
+	unsigned base_width;
+	unsigned base_height;
+	unsigned max_width;
+	unsigned max_height;
+	float aspect_ratio;
+};
+
+struct retro_system_timing {
+	double fps;
+	double sample_rate;
+};
+
+struct retro_system_av_info {
+	struct retro_game_geometry geometry;
+	struct retro_system_timing timing;
+};
+
+struct retro_game_info {
+	const char *path;
+	const void *data;
+	size_t size;
+	const char *meta;
+};
+
+typedef bool (*retro_environment_t)(unsigned cmd, void *data);
+typedef void (*retro_video_refresh_t)(const void *data, unsigned width, unsigned height, size_t pitch);
+typedef void (*retro_audio_sample_t)(int16_t left, int16_t right);
+typedef size_t (*retro_audio_sample_batch_t)(const int16_t *data, size_t frames);
+typedef void (*retro_input_poll_t)(void);
+typedef int16_t (*retro_input_state_t)(unsigned port, unsigned device, unsigned index, unsigned id);
+
+// Go can't call C function pointers directly so it calls these instead
+
+static inline bool call_environment(retro_environment_t cb, unsigned cmd, void *data) {
+	return cb(cmd, data);
+}
+
+static inline void call_video_refresh(retro_video_refresh_t cb, const void *data, unsigned width, unsigned height, size_t pitch) {
+	cb(data, width, height, pitch);
+}
+
+static inline size_t call_audio_sample_batch(retro_audio_sample_batch_t cb, const int16_t *data, size_t frames) {
+	return cb(data, frames);
+}
+
+static inline void call_input_poll(retro_input_poll_t cb) {
+	cb();
+}
+
+static inline int16_t call_input_state(retro_input_state_t cb, unsigned port, unsigned device, unsigned index, unsigned id) {
+	return cb(port, device, index, id);
+}
@@ -0,0 +1,380 @@
+// Command tetromino-libretro builds Tetromino as a libretro core, so that RetroArch and other
+// libretro frontends can load it:
+//
+//	go build -buildmode=c-shared -o tetromino_libretro.so ./cmd/tetromino-libretro
+//
+// Battery saves are written by the core itself to the frontend's save directory. Save states are
+// serialized in the same format as the save state slots of the tetromino command.
+package main
+
+/*
+#include "libretro.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"image"
+	"log"
+	"unsafe"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+	"github.com/scottyw/tetromino/pkg/gb/sgb"
+)
+
+// framesPerSecond is the refresh rate of a real Gameboy
+const framesPerSecond = 4194304.0 / 70224
+
+// joypadButtons maps the buttons of the libretro joypad to Gameboy buttons
+var joypadButtons = map[C.unsigned]gb.Button{
+	C.RETRO_DEVICE_ID_JOYPAD_A:      gb.A,
+	C.RETRO_DEVICE_ID_JOYPAD_B:      gb.B,
+	C.RETRO_DEVICE_ID_JOYPAD_SELECT: gb.Select,
+	C.RETRO_DEVICE_ID_JOYPAD_START:  gb.Start,
+	C.RETRO_DEVICE_ID_JOYPAD_UP:     gb.Up,
+	C.RETRO_DEVICE_ID_JOYPAD_DOWN:   gb.Down,
+	C.RETRO_DEVICE_ID_JOYPAD_LEFT:   gb.Left,
+	C.RETRO_DEVICE_ID_JOYPAD_RIGHT:  gb.Right,
+}
+
+// core holds the callbacks given by the frontend and the Gameboy that is running
+var core struct {
+	environment      C.retro_environment_t
+	videoRefresh     C.retro_video_refresh_t
+	audioSampleBatch C.retro_audio_sample_batch_t
+	inputPoll        C.retro_input_poll_t
+	inputState       C.retro_input_state_t
+
+	opts     gb.Options
+	gameboy  *gb.Gameboy
+	speakers *speakers
+	// pixels holds the most recent frame in the XRGB8888 format used by the frontend
+	pixels  []byte
+	width   int
+	height  int
+	pressed [4]map[gb.Button]bool
+	// stateSize is the size of buffer that the frontend is asked for to hold a save state
+	stateSize int
+}
+
+// Strings returned to the frontend are allocated when the core is loaded, since the frontend may ask
+// for them before retro_init, and never freed
+var (
+	libraryName     = C.CString("Tetromino")
+	libraryVersion  = C.CString("0.1")
+	validExtensions = C.CString("gb|gbc")
+)
+
+// speakers collects the samples of each frame so that they can be passed to the frontend together
+type speakers struct {
+	buffer  *audio.RingBuffer
+	samples []float32
+	batch   []int16
+}
+
+func newSpeakers() *speakers {
+	return &speakers{
+		buffer:  audio.NewRingBuffer(4096),
+		samples: make([]float32, 8192),
+		batch:   make([]int16, 8192),
+	}
+}
+
+// Buffer returns the ring buffer of samples that the speakers play
+func (s *speakers) Buffer() *audio.RingBuffer {
+	return s.buffer
+}
+
+// play passes the samples of the last frame to the frontend
+func (s *speakers) play() {
+	n := s.buffer.Read(s.samples)
+	for i, sample := range s.samples[:n] {
+		if sample > 1 {
+			sample = 1
+		} else if sample < -1 {
+			sample = -1
+		}
+		s.batch[i] = int16(sample * 32767)
+	}
+	if n > 0 && core.audioSampleBatch != nil {
+		C.call_audio_sample_batch(core.audioSampleBatch, (*C.int16_t)(unsafe.Pointer(&s.batch[0])), C.size_t(n/2))
+	}
+}
+
+func main() {}
+
+//export retro_set_environment
+func retro_set_environment(cb C.retro_environment_t) {
+	core.environment = cb
+}
+
+//export retro_set_video_refresh
+func retro_set_video_refresh(cb C.retro_video_refresh_t) {
+	core.videoRefresh = cb
+}
+
+//export retro_set_audio_sample
+func retro_set_audio_sample(cb C.retro_audio_sample_t) {
+	// Samples are always passed in batches
+}
+
+//export retro_set_audio_sample_batch
+func retro_set_audio_sample_batch(cb C.retro_audio_sample_batch_t) {
+	core.audioSampleBatch = cb
+}
+
+//export retro_set_input_poll
+func retro_set_input_poll(cb C.retro_input_poll_t) {
+	core.inputPoll = cb
+}
+
+//export retro_set_input_state
+func retro_set_input_state(cb C.retro_input_state_t) {
+	core.inputState = cb
+}
+
+//export retro_init
+func retro_init() {}
+
+//export retro_deinit
+func retro_deinit() {}
+
+//export retro_api_version
+func retro_api_version() C.unsigned {
+	return C.RETRO_API_VERSION
+}
+
+//export retro_get_system_info
+func retro_get_system_info(info *C.struct_retro_system_info) {
+	info.library_name = libraryName
+	info.library_version = libraryVersion
+	info.valid_extensions = validExtensions
+	info.need_fullpath = false
+	info.block_extract = false
+}
+
+//export retro_get_system_av_info
+func retro_get_system_av_info(info *C.struct_retro_system_av_info) {
+	width, height := 160, 144
+	if core.gameboy != nil {
+		width, height = core.gameboy.ScreenSize()
+	}
+	info.geometry.base_width = C.unsigned(width)
+	info.geometry.base_height = C.unsigned(height)
+	// A Super Game Boy border makes the screen larger
+	info.geometry.max_width = sgb.Width
+	info.geometry.max_height = sgb.Height
+	info.geometry.aspect_ratio = C.float(float64(width) / float64(height))
+	info.timing.fps = framesPerSecond
+	info.timing.sample_rate = 44100
+}
+
+//export retro_set_controller_port_device
+func retro_set_controller_port_device(port, device C.unsigned) {}
+
+//export retro_reset
+func retro_reset() {
+	if core.gameboy == nil {
+		return
+	}
+	if err := core.gameboy.Close(); err != nil {
+		log.Printf("Failed to save before resetting: %v", err)
+	}
+	start(core.opts)
+}
+
+//export retro_run
+func retro_run() {
+	if core.gameboy == nil {
+		return
+	}
+	readInput()
+	core.gameboy.RunFrames(1)
+	if core.videoRefresh != nil && len(core.pixels) > 0 {
+		C.call_video_refresh(core.videoRefresh, unsafe.Pointer(&core.pixels[0]), C.unsigned(core.width), C.unsigned(core.height), C.size_t(core.width*4))
+	}
+	core.speakers.play()
+}
+
+// readInput presses and releases buttons on each controller that a Super Game Boy can read
+func readInput() {
+	if core.inputPoll == nil || core.inputState == nil {
+		return
+	}
+	C.call_input_poll(core.inputPoll)
+	for player := range core.pressed {
+		for id, button := range joypadButtons {
+			down := C.call_input_state(core.inputState, C.unsigned(player), C.RETRO_DEVICE_JOYPAD, 0, id) != 0
+			if down != core.pressed[player][button] {
+				core.gameboy.PlayerButtonAction(player, button, down)
+				core.pressed[player][button] = down
+			}
+		}
+	}
+}
+
+//export retro_serialize_size
+func retro_serialize_size() C.size_t {
+	if core.gameboy == nil {
+		return 0
+	}
+	// Save states change size with the contents of memory and the thumbnail, so the frontend's buffer
+	// has room for each byte of memory to take twice the space that it takes now
+	if core.stateSize == 0 {
+		var buf bytes.Buffer
+		if err := core.gameboy.SaveState(&buf); err != nil {
+			log.Printf("Failed to measure the save state: %v", err)
+			return 0
+		}
+		core.stateSize = 2*buf.Len() + 1<<16
+	}
+	return C.size_t(core.stateSize)
+}
+
+//export retro_serialize
+func retro_serialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if core.gameboy == nil {
+		return false
+	}
+	var buf bytes.Buffer
+	if err := core.gameboy.SaveState(&buf); err != nil {
+		log.Printf("Failed to save state: %v", err)
+		return false
+	}
+	out := (*[1 << 30]byte)(data)[:size:size]
+	if buf.Len() > len(out) {
+		log.Printf("Failed to save state: %d bytes don't fit in %d", buf.Len(), len(out))
+		return false
+	}
+	// The state is followed by zeros, which loading ignores
+	n := copy(out, buf.Bytes())
+	for i := range out[n:] {
+		out[n+i] = 0
+	}
+	return true
+}
+
+//export retro_unserialize
+func retro_unserialize(data unsafe.Pointer, size C.size_t) C.bool {
+	if core.gameboy == nil {
+		return false
+	}
+	in := C.GoBytes(data, C.int(size))
+	if err := core.gameboy.LoadState(bytes.NewReader(in)); err != nil {
+		log.Printf("Failed to load state: %v", err)
+		return false
+	}
+	return true
+}
+
+//export retro_cheat_reset
+func retro_cheat_reset() {
+	if core.gameboy == nil {
+		return
+	}
+	for _, c := range core.gameboy.Cheats() {
+		core.gameboy.RemoveCheat(c.Code)
+	}
+}
+
+//export retro_cheat_set
+func retro_cheat_set(index C.unsigned, enabled C.bool, code *C.char) {
+	if core.gameboy == nil || !bool(enabled) {
+		return
+	}
+	if err := core.gameboy.AddCheat(C.GoString(code), ""); err != nil {
+		log.Printf("Failed to add cheat: %v", err)
+	}
+}
+
+//export retro_load_game
+func retro_load_game(game *C.struct_retro_game_info) C.bool {
+	if game == nil || game.data == nil {
+		return false
+	}
+	format := C.unsigned(C.RETRO_PIXEL_FORMAT_XRGB8888)
+	if !C.call_environment(core.environment, C.RETRO_ENVIRONMENT_SET_PIXEL_FORMAT, unsafe.Pointer(&format)) {
+		log.Printf("The frontend doesn't support XRGB8888")
+		return false
+	}
+	opts := gb.Options{
+		Rom: C.GoBytes(game.data, C.int(game.size)),
+	}
+	// The path names the battery save, which is kept in the frontend's save directory
+	if game.path != nil {
+		opts.RomFilename = C.GoString(game.path)
+	}
+	var dir *C.char
+	if C.call_environment(core.environment, C.RETRO_ENVIRONMENT_GET_SAVE_DIRECTORY, unsafe.Pointer(&dir)) && dir != nil {
+		opts.SaveDir = C.GoString(dir)
+	}
+	return C.bool(start(opts))
+}
+
+// start creates a Gameboy that runs when the frontend asks for each frame
+func start(opts gb.Options) bool {
+	gameboy, err := gb.NewGameboy(opts)
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return false
+	}
+	core.opts = opts
+	core.gameboy = gameboy
+	core.stateSize = 0
+	core.speakers = newSpeakers()
+	gameboy.RegisterSpeakers(core.speakers)
+	core.width, core.height = gameboy.ScreenSize()
+	core.pixels = make([]byte, core.width*core.height*4)
+	gameboy.OnFrame(convertFrame)
+	for player := range core.pressed {
+		core.pressed[player] = map[gb.Button]bool{}
+	}
+	return true
+}
+
+// convertFrame copies the visible part of a frame into the pixels passed to the frontend, where each
+// pixel is a little-endian 32-bit XRGB value
+func convertFrame(frame *image.RGBA) {
+	for y := 0; y < core.height; y++ {
+		row := frame.Pix[y*frame.Stride:]
+		out := core.pixels[y*core.width*4:]
+		for x := 0; x < core.width; x++ {
+			out[x*4] = row[x*4+2]
+			out[x*4+1] = row[x*4+1]
+			out[x*4+2] = row[x*4]
+			out[x*4+3] = 0xff
+		}
+	}
+}
+
+//export retro_load_game_special
+func retro_load_game_special(gameType C.unsigned, info *C.struct_retro_game_info, count C.size_t) C.bool {
+	return false
+}
+
+//export retro_unload_game
+func retro_unload_game() {
+	if core.gameboy == nil {
+		return
+	}
+	if err := core.gameboy.Close(); err != nil {
+		log.Printf("Failed to save: %v", err)
+	}
+	core.gameboy = nil
+}
+
+//export retro_get_region
+func retro_get_region() C.unsigned {
+	return C.RETRO_REGION_NTSC
+}
+
+//export retro_get_memory_data
+func retro_get_memory_data(id C.unsigned) unsafe.Pointer {
+	return nil
+}
+
+//export retro_get_memory_size
+func retro_get_memory_size(id C.unsigned) C.size_t {
+	return 0
+}
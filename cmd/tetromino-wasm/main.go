@@ -0,0 +1,115 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command tetromino-wasm runs Tetromino in a web page. The page loads a ROM from the "rom" query
+// parameter or from a file picked by the player, e.g. index.html?rom=tetris.gb
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"syscall/js"
+
+	"github.com/scottyw/tetromino/pkg/config"
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/web"
+)
+
+func main() {
+	document := js.Global().Get("document")
+	roms := make(chan []byte)
+	pickRoms(document.Call("getElementById", "rom"), roms)
+	if location, err := url.Parse(js.Global().Get("location").Get("href").String()); err == nil {
+		if rom := location.Query().Get("rom"); rom != "" {
+			go fetchRom(location, rom, roms)
+		}
+	}
+
+	// Browsers only play audio once the page has been interacted with
+	speakers := web.NewWebAudioSpeakers()
+	resume := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		speakers.Resume()
+		return nil
+	})
+	document.Call("addEventListener", "click", resume)
+	document.Call("addEventListener", "keydown", resume)
+
+	var display *web.CanvasDisplay
+	cancel := func() {}
+	for rom := range roms {
+		gameboy, err := gb.NewGameboy(gb.Options{Rom: rom, RewindBuffer: config.Default().RewindBuffer})
+		if err != nil {
+			showStatus(fmt.Sprintf("Failed to create the Gameboy: %v", err))
+			continue
+		}
+		if display == nil {
+			display, err = web.NewCanvasDisplay(gameboy, document.Call("getElementById", "screen"), config.Default().Keys)
+			if err != nil {
+				showStatus(fmt.Sprintf("Failed to create the display: %v", err))
+				return
+			}
+		} else {
+			display.SetGameboy(gameboy)
+		}
+		gameboy.RegisterDisplay(display)
+		gameboy.RegisterSpeakers(speakers)
+		showStatus("Click or press a key to start the sound")
+
+		// Stop the previous ROM before starting the next one
+		cancel()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go gameboy.Run(ctx)
+	}
+}
+
+// pickRoms sends the contents of each file picked with a file input to a channel
+func pickRoms(input js.Value, roms chan<- []byte) {
+	var loaded js.Func
+	loaded = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		data := js.Global().Get("Uint8Array").New(args[0])
+		rom := make([]byte, data.Length())
+		js.CopyBytesToGo(rom, data)
+		go func() { roms <- rom }()
+		return nil
+	})
+	input.Call("addEventListener", "change", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if files := input.Get("files"); files.Length() > 0 {
+			files.Index(0).Call("arrayBuffer").Call("then", loaded)
+		}
+		return nil
+	}))
+}
+
+// fetchRom downloads a ROM from a URL relative to the page and sends it to a channel
+func fetchRom(page *url.URL, rom string, roms chan<- []byte) {
+	location, err := page.Parse(rom)
+	if err != nil {
+		showStatus(fmt.Sprintf("Failed to find the ROM: %v", err))
+		return
+	}
+	resp, err := http.Get(location.String())
+	if err != nil {
+		showStatus(fmt.Sprintf("Failed to download the ROM: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		showStatus(fmt.Sprintf("Failed to download the ROM: %s", resp.Status))
+		return
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		showStatus(fmt.Sprintf("Failed to download the ROM: %v", err))
+		return
+	}
+	roms <- data
+}
+
+// showStatus displays a message below the screen
+func showStatus(message string) {
+	js.Global().Get("document").Call("getElementById", "status").Set("textContent", message)
+}
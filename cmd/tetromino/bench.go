@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// A frame is 17556 machine cycles of 4 clock cycles at 4.194304MHz
+const frameSeconds = 17556 * 4 / 4194304.0
+
+// parseArgs allows the ROM filename to appear before or after the flags
+func parseArgs(fs *flag.FlagSet, args []string) (string, error) {
+	var rom string
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		rom = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if rom == "" {
+		rom = fs.Arg(0)
+	}
+	if rom == "" {
+		return "", fmt.Errorf("No ROM filename was specified")
+	}
+	return rom, nil
+}
+
+// bench runs a ROM headless with no pacing and reports how fast the emulator runs
+func bench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	frames := fs.Int("frames", 3600, "Number of frames to run")
+	warmup := fs.Int("warmup", 60, "Number of frames to run before measuring")
+	parallel := fs.Bool("parallel", false, "When true, each frame is drawn at its end by one goroutine per CPU")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino bench rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	opts := gb.Options{RomFilename: rom}
+	if *parallel {
+		opts.RenderWorkers = runtime.NumCPU()
+	}
+	gameboy, err := gb.NewGameboy(opts)
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return 1
+	}
+	gameboy.RunFrames(*warmup)
+	gameboy.ResetFrameStats()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	instructions := gameboy.Instructions()
+	t0 := time.Now()
+	gameboy.RunFrames(*frames)
+	wall := time.Since(t0)
+	runtime.ReadMemStats(&after)
+	instructions = gameboy.Instructions() - instructions
+
+	emulated := float64(*frames) * frameSeconds
+	fmt.Printf("Frames:           %d\n", *frames)
+	fmt.Printf("Wall time:        %v\n", wall)
+	fmt.Printf("Emulated time:    %.3fs\n", emulated)
+	fmt.Printf("Speed:            %.2fx (%.1f frames/sec)\n", emulated/wall.Seconds(), float64(*frames)/wall.Seconds())
+	fmt.Printf("Instructions/sec: %.0f\n", float64(instructions)/wall.Seconds())
+	fmt.Printf("Allocations:      %d (%.1f per frame)\n", after.Mallocs-before.Mallocs, float64(after.Mallocs-before.Mallocs)/float64(*frames))
+	fmt.Printf("Bytes allocated:  %d (%.1f per frame)\n", after.TotalAlloc-before.TotalAlloc, float64(after.TotalAlloc-before.TotalAlloc)/float64(*frames))
+	fmt.Printf("GC cycles:        %d\n", after.NumGC-before.NumGC)
+	stats := gameboy.FrameStats()
+	fmt.Printf("Frame time:       min %v, avg %v, p95 %v, p99 %v, max %v (last %d frames)\n", stats.Min, stats.Avg, stats.P95, stats.P99, stats.Max, stats.Frames)
+	fmt.Printf("Breakdown:        emulation %v, render %v, sleep %v\n", stats.Emulation, stats.Render, stats.Sleep)
+	return 0
+}
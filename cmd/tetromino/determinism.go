@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/input"
+)
+
+// determinism runs a ROM headless twice in lockstep and reports the first frame where the runs differ
+func determinism(args []string) int {
+	fs := flag.NewFlagSet("determinism", flag.ExitOnError)
+	frames := fs.Int("frames", 3600, "Number of frames to run")
+	interval := fs.Int("interval", 1, "Number of frames between state hash comparisons")
+	inputScript := fs.String("input", "", "Input script of timed button presses to play back in both runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino determinism rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	var script *input.Script
+	if *inputScript != "" {
+		script, err = input.Load(*inputScript)
+		if err != nil {
+			log.Printf("Failed to load the input script: %v", err)
+			return 1
+		}
+	}
+	divergence, err := gb.CheckDeterminism(gb.Options{RomFilename: rom}, *frames, *interval, func(gameboy *gb.Gameboy) error {
+		if script != nil {
+			script.Attach(gameboy)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to check determinism: %v", err)
+		return 1
+	}
+	if divergence != nil {
+		fmt.Println(divergence)
+		return 1
+	}
+	fmt.Printf("Both runs matched for %d frames\n", *frames)
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gym"
+	"github.com/scottyw/tetromino/pkg/remote"
+)
+
+// gymEnv runs a ROM headless as a reinforcement learning environment driven through the remote API
+func gymEnv(args []string) int {
+	fs := flag.NewFlagSet("gym", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8082", "Address to serve the remote control API on, with /gym/reset and /gym/step for agents")
+	frameSkip := fs.Int("frameskip", gym.DefaultFrameSkip, "Number of frames that each step runs with its buttons held")
+	maxSteps := fs.Int("max-steps", 0, "Number of steps after which an episode ends, or 0 for no limit")
+	done := fs.String("done", "", "Condition that ends an episode when it becomes true at the end of a step, e.g. \"[0xC0A0]==0\"")
+	symbolFile := fs.String("symbols", "", "Symbol file (e.g. from RGBDS) whose names can be used in -done")
+	forceDMG := fs.Bool("dmg", false, "When true, Game Boy Color games run as they would on the original Game Boy")
+	superGameboy := fs.Bool("sgb", false, "When true, games run on a Super Game Boy with its border and colours")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino gym rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	env, err := gym.New(gym.Options{
+		Gameboy:   gb.Options{RomFilename: rom, SymbolFilename: *symbolFile, ForceDMG: *forceDMG, SGB: *superGameboy},
+		FrameSkip: *frameSkip,
+		MaxSteps:  *maxSteps,
+		Done:      *done,
+	})
+	if err != nil {
+		log.Printf("Failed to create the environment: %v", err)
+		return 1
+	}
+	server, err := remote.NewEnv(env, rom)
+	if err != nil {
+		log.Printf("Failed to create the environment: %v", err)
+		return 1
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancelFunc()
+	}()
+	if err := server.ListenAndServe(ctx, *addr); err != nil {
+		log.Printf("Failed to serve the remote control API: %v", err)
+		return 1
+	}
+	return 0
+}
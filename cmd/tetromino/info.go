@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/scottyw/tetromino/pkg/dat"
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/logging"
+)
+
+// info prints the cartridge header and hash of a ROM without running it
+func info(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	datFile := fs.String("dat", "", "DAT file of known ROM dumps, such as No-Intro's, to check the ROM against")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino info rom.gb\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	romInfo, err := gb.ReadRomInfo(rom)
+	if err != nil {
+		log.Printf("Failed to read the ROM: %v", err)
+		return 1
+	}
+	supported := "supported"
+	if !romInfo.Supported {
+		supported = "not supported"
+	}
+	model := "DMG"
+	switch {
+	case romInfo.CGBOnly:
+		model = "CGB only"
+	case romInfo.CGB:
+		model = "CGB or DMG"
+	}
+	if romInfo.SGB {
+		model += " with SGB functions"
+	}
+	checksum := "valid"
+	if !romInfo.ChecksumValid {
+		checksum = "invalid"
+	}
+	fmt.Printf("Title:           %s\n", romInfo.Title)
+	fmt.Printf("Cartridge type:  0x%02x %s (%s)\n", romInfo.CartridgeType, romInfo.Mapper(), supported)
+	fmt.Printf("ROM:             %d banks (%d bytes)\n", romInfo.ROMBanks, romInfo.Size)
+	fmt.Printf("RAM:             %d banks\n", romInfo.RAMBanks)
+	fmt.Printf("Battery:         %t\n", romInfo.Battery)
+	fmt.Printf("Hardware:        %s\n", model)
+	fmt.Printf("Header checksum: 0x%02x (%s)\n", romInfo.Checksum, checksum)
+	fmt.Printf("SHA-1:           %s\n", romInfo.SHA1)
+	if *datFile != "" {
+		d, err := dat.Load(*datFile)
+		if err != nil {
+			log.Printf("Failed to check the dump: %v", err)
+			return 1
+		}
+		data, err := ioutil.ReadFile(rom)
+		if err != nil {
+			log.Printf("Failed to check the dump: %v", err)
+			return 1
+		}
+		message, _ := d.Check(data)
+		fmt.Printf("Dump:            %s\n", message)
+	}
+	return 0
+}
+
+// checkDump logs whether a ROM is a good dump according to a DAT file
+func checkDump(log *logging.Logger, datFilename, romFilename string) {
+	d, err := dat.Load(datFilename)
+	if err != nil {
+		log.Warnf("Failed to check the dump: %v", err)
+		return
+	}
+	rom, err := ioutil.ReadFile(romFilename)
+	if err != nil {
+		log.Warnf("Failed to check the dump: %v", err)
+		return
+	}
+	if message, good := d.Check(rom); good {
+		log.Infof("The ROM is %s", message)
+	} else {
+		log.Warnf("The ROM is %s", message)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// lockstep runs a ROM headless against a reference trace and reports the first instruction that differs
+func lockstep(args []string) int {
+	fs := flag.NewFlagSet("lockstep", flag.ExitOnError)
+	traceFile := fs.String("trace", "", "Reference trace with a line of registers per instruction, e.g. in Gameboy Doctor format")
+	traceLength := fs.Int("trace-length", 32, "Number of preceding instructions to show at a mismatch")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino lockstep rom.gb -trace reference.log [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if *traceFile == "" {
+		fmt.Println("No reference trace was specified")
+		return 1
+	}
+	f, err := os.Open(*traceFile)
+	if err != nil {
+		log.Printf("Failed to open the reference trace: %v", err)
+		return 1
+	}
+	defer f.Close()
+	gameboy, err := gb.NewGameboy(gb.Options{RomFilename: rom, TraceLength: *traceLength})
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return 1
+	}
+	matched, mismatch, err := gameboy.CompareTrace(f)
+	if err != nil {
+		log.Printf("Failed to compare traces: %v", err)
+		return 1
+	}
+	if mismatch != nil {
+		fmt.Printf("Matched %d instructions\n", matched)
+		fmt.Println(mismatch)
+		return 1
+	}
+	fmt.Printf("Matched all %d instructions in the reference trace\n", matched)
+	return 0
+}
@@ -1,85 +1,118 @@
 package main
 
 import (
-	"context"
-	"flag"
 	"fmt"
 	"log"
 	"os"
-	"runtime/pprof"
+	"path/filepath"
+	"strings"
 
+	"github.com/scottyw/tetromino/pkg/achievements"
 	"github.com/scottyw/tetromino/pkg/gb"
-	"github.com/scottyw/tetromino/pkg/ui"
 )
 
-func main() {
+type stringsFlag []string
 
-	// Command line flags
-	fast := flag.Bool("fast", false, "When true, Tetromino runs the emulator as fast as possible (audio support is disabled)")
-	debugCPU := flag.Bool("debugcpu", false, "When true, CPU debugging is enabled")
-	debugLCD := flag.Bool("debuglcd", false, "When true, colour-based LCD debugging is enabled")
-	enableTiming := flag.Bool("timing", false, "When true, timing is output every 60 frames")
-	enableProfiling := flag.Bool("profiling", false, "When true, CPU profiling data is written to 'cpuprofile.pprof'")
-	flag.Parse()
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
 
-	// CPU profiling
-	if *enableProfiling {
-		f, err := os.Create("cpuprofile.pprof")
-		if err != nil {
-			log.Printf("Failed to write cpuprofile.pprof: %v", err)
-			return
-		}
-		err = pprof.StartCPUProfile(f)
-		if err != nil {
-			log.Printf("Failed to start cpu profile: %v", err)
-			return
-		}
-		defer pprof.StopCPUProfile()
-	}
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
 
-	rom := flag.Arg(0)
-	if rom == "" {
-		fmt.Println("No ROM filename was specified")
-		os.Exit(1)
-	}
+// subcommands maps each subcommand name to the function that runs it with the remaining arguments
+var subcommands = map[string]func(args []string) int{
+	"run":         run,
+	"resume":      resume,
+	"recent":      recent,
+	"debug":       debug,
+	"info":        info,
+	"bench":       bench,
+	"screenshot":  screenshot,
+	"determinism": determinism,
+	"lockstep":    lockstep,
+	"gym":         gymEnv,
+	"test":        testROM,
+	"stream":      streamROM,
+}
 
-	opts := gb.Options{
-		RomFilename: rom,
-		DebugCPU:    *debugCPU,
-		DebugLCD:    *debugLCD,
-	}
+const usage = `Usage: tetromino <command> rom.gb [flags]
 
-	// Run context
-	ctx, cancelFunc := context.WithCancel(context.Background())
+Commands:
+  run          Play a ROM (the default when no command is given)
+  resume       Play the most recently played ROM again
+  recent       List the most recently played ROMs
+  debug        Play a ROM under the terminal, browser or gdb debugger
+  info         Show the cartridge header and hash of a ROM
+  bench        Measure how fast a ROM runs headless
+  screenshot   Run a ROM headless and save the final frame as a PNG
+  determinism  Run a ROM twice and report the first frame where the runs differ
+  lockstep     Compare execution of a ROM against a reference trace
+  test         Run a ROM headless and exit with status 0 if a condition passes it
+  gym          Serve a ROM headless as a reinforcement learning environment
+  stream       Serve a ROM headless for playing and watching in a browser
 
-	// Create the Gameboy emulator
-	gameboy := gb.NewGameboy(opts)
+Run "tetromino <command> -help" for the flags of each command.
+`
 
-	// Create a display
-	display, err := ui.NewGLDisplay(gameboy, cancelFunc)
-	if err != nil {
-		log.Printf("Failed to create display: %v", err)
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
 		return
 	}
-	defer display.Cleanup()
-	gameboy.RegisterDisplay(display)
-
-	// Create speakers if we are not running in fast mode
-	if !*fast {
-		speakers, err := ui.NewPortaudioSpeakers()
-		if err != nil {
-			log.Printf("Failed to create speakers: %v", err)
-			return
-		}
-		defer speakers.Cleanup()
-		gameboy.RegisterSpeakers(speakers)
+	command, ok := subcommands[args[0]]
+	if !ok {
+		// Play the ROM when no command is given so that "tetromino rom.gb" keeps working
+		os.Exit(run(args))
 	}
+	os.Exit(command(args[1:]))
+}
 
-	// Start running the emulator
-	if *enableTiming {
-		gameboy.Time(ctx)
-	} else {
-		gameboy.Run(ctx)
+// defaultSessionDir returns the directory under the user's config directory where debugger sessions
+// are saved, or an empty string if there is no config directory
+func defaultSessionDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(dir, "tetromino", "sessions")
+}
 
+func loadAchievements(gameboy *gb.Gameboy, rom, user, token string) {
+	hash, err := achievements.HashFile(rom)
+	if err != nil {
+		log.Printf("Failed to hash ROM for RetroAchievements: %v", err)
+		return
+	}
+	client := achievements.NewClient(user, token)
+	gameID, err := client.GameID(hash)
+	if err != nil {
+		log.Printf("Failed to identify game with RetroAchievements: %v", err)
+		return
+	}
+	list, err := client.Achievements(gameID)
+	if err != nil {
+		log.Printf("Failed to fetch achievements: %v", err)
+		return
+	}
+	tracker, err := achievements.NewRuntime(gameboy, list)
+	if err != nil {
+		log.Printf("Some achievements are not supported: %v", err)
+	}
+	tracker.OnUnlock = func(a achievements.Achievement) {
+		go func() {
+			if err := client.Award(a.ID); err != nil {
+				log.Printf("Failed to award achievement: %v", err)
+			}
+		}()
+	}
+	log.Printf("Loaded %d achievements for RetroAchievements game %d", tracker.Remaining(), gameID)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/menu"
+)
+
+// stateSlots is the number of save state slots that the menu can choose between
+const stateSlots = 4
+
+var errNoStateFile = errors.New("save states need a ROM file")
+
+// pauseMenu returns the menu shown over the game. It changes the palette of the current Gameboy,
+// offers to reset it when reset isn't nil and calls quit to stop playing.
+func pauseMenu(current func() *gb.Gameboy, reset func(), quit func()) *menu.Menu {
+	slot := 1
+	// The header of the selected slot's save state is read again whenever the slot changes
+	var header *gb.StateHeader
+	readSlot := func() {
+		header = nil
+		if filename := current().StateFilename(slot); filename != "" {
+			if h, err := gb.ReadStateHeaderFile(filename); err == nil {
+				header = &h
+			}
+		}
+	}
+	readSlot()
+	preview := func() *image.RGBA {
+		if header == nil {
+			return nil
+		}
+		return header.Thumbnail
+	}
+	palettes := append([]string{""}, gb.CompatPalettes()[1:]...)
+	palette := 0
+	changePalette := func(step int) error {
+		next := (palette + step + len(palettes)) % len(palettes)
+		if err := current().SetCompatPalette(palettes[next]); err != nil {
+			return err
+		}
+		palette = next
+		return nil
+	}
+	items := []menu.Item{{
+		Label:  func() string { return "Resume" },
+		Select: func() (bool, error) { return true, nil },
+	}}
+	if reset != nil {
+		items = append(items, menu.Item{
+			Label: func() string { return "Reset" },
+			Select: func() (bool, error) {
+				// The game starts again with its own colours
+				palette = 0
+				reset()
+				return true, nil
+			},
+		})
+	}
+	items = append(items,
+		menu.Item{
+			Label: func() string {
+				if header == nil {
+					return fmt.Sprintf("Slot %d: empty", slot)
+				}
+				return fmt.Sprintf("Slot %d: %s", slot, header.Created.Format("Jan 2 15:04"))
+			},
+			Select: func() (bool, error) {
+				slot = slot%stateSlots + 1
+				readSlot()
+				return false, nil
+			},
+			Change: func(step int) error {
+				slot = (slot-1+step+stateSlots)%stateSlots + 1
+				readSlot()
+				return nil
+			},
+			Preview: preview,
+		},
+		menu.Item{
+			Label: func() string { return "Save state" },
+			Select: func() (bool, error) {
+				filename := current().StateFilename(slot)
+				if filename == "" {
+					return false, errNoStateFile
+				}
+				if err := current().SaveStateFile(filename); err != nil {
+					return false, err
+				}
+				readSlot()
+				return true, nil
+			},
+			Preview: preview,
+		},
+		menu.Item{
+			Label: func() string { return "Load state" },
+			Select: func() (bool, error) {
+				if header == nil {
+					return false, fmt.Errorf("slot %d is empty", slot)
+				}
+				if err := current().LoadStateFile(current().StateFilename(slot)); err != nil {
+					return false, err
+				}
+				return true, nil
+			},
+			Preview: preview,
+		},
+		menu.Item{
+			Label: func() string {
+				if palettes[palette] == "" {
+					return "Palette: original"
+				}
+				return "Palette: " + palettes[palette]
+			},
+			Select: func() (bool, error) { return false, changePalette(1) },
+			Change: changePalette,
+		},
+		menu.Item{
+			Label: func() string { return "Quit" },
+			Select: func() (bool, error) {
+				quit()
+				return true, nil
+			},
+		},
+	)
+	return menu.New(items...)
+}
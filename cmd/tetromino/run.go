@@ -0,0 +1,797 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/config"
+	"github.com/scottyw/tetromino/pkg/crowd"
+	"github.com/scottyw/tetromino/pkg/debugger"
+	"github.com/scottyw/tetromino/pkg/discord"
+	"github.com/scottyw/tetromino/pkg/framehash"
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/ir"
+	"github.com/scottyw/tetromino/pkg/gb/lcd"
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gdbstub"
+	"github.com/scottyw/tetromino/pkg/heatmap"
+	"github.com/scottyw/tetromino/pkg/input"
+	"github.com/scottyw/tetromino/pkg/logging"
+	"github.com/scottyw/tetromino/pkg/metrics"
+	"github.com/scottyw/tetromino/pkg/netplay"
+	"github.com/scottyw/tetromino/pkg/remote"
+	"github.com/scottyw/tetromino/pkg/script"
+	"github.com/scottyw/tetromino/pkg/speedrun"
+	"github.com/scottyw/tetromino/pkg/ui"
+	"github.com/scottyw/tetromino/pkg/webdebug"
+)
+
+// playOptions holds the flags of the run and debug subcommands, which both play a ROM in a window
+type playOptions struct {
+	configFile       string
+	fast             bool
+	parallel         bool
+	accuratePPU      bool
+	mute             bool
+	fastForwardSpeed int
+	scale            int
+	forceDMG         bool
+	colorize         string
+	colorCorrection  string
+	pauseUnfocused   bool
+	rotate           int
+	mirror           bool
+	sgb              bool
+	intro            bool
+	infrared         string
+	infraredAddr     string
+	netplay          string
+	netplayAddr      string
+	netplayDelay     int
+	crowdAddr        string
+	crowdMode        string
+	crowdWindow      int
+	discordAppID     string
+	datFile          string
+	splits           string
+	liveSplitAddr    string
+	speedrunTimer    bool
+	debugLCD         bool
+	profiling        bool
+	memProfiling     bool
+	cheats           stringsFlag
+	cheatFile        string
+	saveFile         string
+	saveDir          string
+	saveBackups      int
+	rewindBuffer     int
+	patchFile        string
+	luaScript        string
+	inputScript      string
+	frameHash        string
+	frameHashAudio   bool
+	metricsAddr      string
+	raUser           string
+	raToken          string
+	logLevel         string
+	logJSON          bool
+	colours          *[4]color.RGBA
+	keys             map[string]string
+
+	// Flags of the run subcommand
+	blargg     bool
+	mooneye    bool
+	remoteAddr string
+
+	// Flags of the debug subcommand
+	debugging    bool
+	debugCPU     bool
+	gdbAddr      string
+	webDebug     string
+	traceLength  int
+	traceOnBreak bool
+	coverage     string
+	heatmapFile  string
+	ioLog        string
+	symbolFile   string
+	sessionDir   string
+}
+
+func (o *playOptions) addFlags(fs *flag.FlagSet) {
+	defaults := config.Default()
+	fs.StringVar(&o.configFile, "config", config.DefaultFilename(), "Config file whose settings are used unless overridden by flags")
+	fs.BoolVar(&o.fast, "fast", defaults.Audio == config.NoAudio, "When true, Tetromino runs the emulator as fast as possible (audio support is disabled)")
+	fs.BoolVar(&o.mute, "mute", false, "When true, games run at normal speed without sound")
+	fs.BoolVar(&o.pauseUnfocused, "pause-unfocused", defaults.PauseUnfocused, "When true, the game and its sound pause while the window is in the background")
+	fs.BoolVar(&o.parallel, "parallel", false, "When true with -fast, each frame is drawn at its end by one goroutine per CPU, which is faster but less accurate")
+	fs.BoolVar(&o.accuratePPU, "accurate-ppu", false, "When true, each line is drawn a pixel at a time as the Game Boy does, which is slower but keeps the timing of mode 3 and changes made part way through a line")
+	fs.IntVar(&o.fastForwardSpeed, "ffspeed", defaults.FastForwardSpeed, "Speed multiplier used while the fast-forward key is held")
+	fs.IntVar(&o.scale, "scale", defaults.Scale, "Size of the window as a multiple of the size of the LCD")
+	fs.IntVar(&o.rotate, "rotate", defaults.Rotate, "Degrees to turn the picture clockwise, either 0, 90, 180 or 270, such as for a monitor on its side")
+	fs.BoolVar(&o.mirror, "mirror", defaults.Mirror, "When true, the picture is flipped horizontally")
+	fs.BoolVar(&o.forceDMG, "dmg", false, "When true, Game Boy Color games run as they would on the original Game Boy")
+	fs.StringVar(&o.colorize, "colorize", defaults.Colorize, "Colour original Game Boy games as a Game Boy Color does, with \"auto\" to pick a palette by title like its boot ROM or a button combination such as \"up+a\"")
+	fs.StringVar(&o.colorCorrection, "color-correction", defaults.ColorCorrection, "Correct the colours of Game Boy Color games with \"cgb\" to look like its LCD or \"gba\" to look like a Game Boy Advance, or \"raw\" to show them as they are")
+	fs.BoolVar(&o.sgb, "sgb", defaults.SGB, "When true, games run on a Super Game Boy with its border and colours")
+	fs.BoolVar(&o.intro, "intro", defaults.Intro, "When true, the logo scrolls down and the chime plays before the game starts, as the original Game Boy's boot ROM does")
+	fs.StringVar(&o.infrared, "ir", "", "Face the Game Boy Color's infrared port at its own LED with \"loopback\" or at another emulator with \"listen\" or \"connect\"")
+	fs.StringVar(&o.infraredAddr, "ir-addr", "localhost:7777", "Address where -ir listen waits for the other emulator and -ir connect finds it")
+	fs.StringVar(&o.netplay, "netplay", "", "Play with someone running another emulator by hosting with \"host\" or joining with \"join\"")
+	fs.StringVar(&o.netplayAddr, "netplay-addr", "localhost:7778", "Address where -netplay host waits for the other emulator and -netplay join finds it")
+	fs.IntVar(&o.netplayDelay, "netplay-delay", netplay.DefaultDelay, "Number of frames that buttons take to have an effect with -netplay host, which hides the time they take to reach the other emulator")
+	fs.StringVar(&o.crowdAddr, "crowd", "", "Let an audience play by sending buttons over a WebSocket at /ws on this address (e.g. localhost:8090)")
+	fs.StringVar(&o.crowdMode, "crowd-mode", crowd.DefaultOptions.Mode.String(), "How -crowd chooses buttons, either \"democracy\" to press the most popular or \"anarchy\" to press them all")
+	fs.IntVar(&o.crowdWindow, "crowd-window", crowd.DefaultOptions.Window, "Number of frames that -crowd counts votes over in democracy")
+	fs.StringVar(&o.splits, "splits", "", "File of speedrun splits with one \"name: trigger\" per line, where the trigger is \"pc 0x1234\" or a condition such as \"[0xC0A0]==5\"")
+	fs.StringVar(&o.liveSplitAddr, "livesplit", "", "Send the start and splits timed by -splits to the LiveSplit Server component at this address (e.g. "+speedrun.DefaultLiveSplitAddr+")")
+	fs.BoolVar(&o.speedrunTimer, "timer", false, "When true, a speedrun timer is shown over the screen")
+	fs.BoolVar(&o.debugLCD, "debuglcd", false, "When true, colour-based LCD debugging is enabled")
+	fs.BoolVar(&o.profiling, "profiling", false, "When true, CPU profiling data is written to 'cpuprofile.pprof'")
+	fs.BoolVar(&o.memProfiling, "memprofile", false, "When true, memory allocation profiling data is written to 'memprofile.pprof' on exit")
+	fs.Var(&o.cheats, "cheat", "GameShark or Game Genie code to apply (may be repeated)")
+	fs.StringVar(&o.cheatFile, "cheats", "", "File containing cheat codes, one per line, each optionally followed by a description")
+	fs.StringVar(&o.saveFile, "save", "", "Battery save file (defaults to the ROM filename with a .sav extension)")
+	fs.StringVar(&o.datFile, "dat", "", "DAT file of known ROM dumps, such as No-Intro's, to check the ROM against")
+	fs.StringVar(&o.patchFile, "patch", "", "IPS or BPS patch to apply to the ROM (defaults to the ROM filename with an .ips or .bps extension, if there is one)")
+	fs.StringVar(&o.saveDir, "save-dir", defaults.SaveDir, "Directory for battery saves when -save is not given (defaults to the directory of the ROM)")
+	fs.IntVar(&o.saveBackups, "save-backups", defaults.SaveBackups, "Number of earlier copies of the battery save to keep in a backups directory alongside it, or 0 for none")
+	fs.IntVar(&o.rewindBuffer, "rewind-buffer", defaults.RewindBuffer, "Megabytes of snapshots kept so that holding the rewind key steps back through the game, or 0 to turn rewinding off")
+	fs.StringVar(&o.luaScript, "script", "", "Lua script to run alongside the emulator")
+	fs.StringVar(&o.inputScript, "input", "", "Input script of timed button presses to play back while the emulator runs")
+	fs.StringVar(&o.frameHash, "framehash", "", "Write the frame number and a hash of every frame to this file, one per line, so that two runs can be diffed")
+	fs.BoolVar(&o.frameHashAudio, "framehash-audio", false, "When true with -framehash, each line also has a hash of the audio made during the frame")
+	fs.StringVar(&o.metricsAddr, "metrics", "", "Serve runtime metrics for Prometheus at /metrics and as expvars at /debug/vars on this address (e.g. localhost:9090)")
+	fs.StringVar(&o.raUser, "ra-user", "", "RetroAchievements username")
+	fs.StringVar(&o.raToken, "ra-token", "", "RetroAchievements API token")
+	fs.StringVar(&o.logLevel, "log-level", "info", "Log level from debug, info, warn and error, optionally followed by levels for subsystems e.g. warn,lcd=debug,script=info")
+	fs.BoolVar(&o.logJSON, "log-json", false, "When true, log lines are written to stderr as JSON objects")
+}
+
+// loadConfig applies the settings from the config file, including any overrides for the ROM, to
+// any flag that wasn't given
+func (o *playOptions) loadConfig(fs *flag.FlagSet, rom string) error {
+	c, err := config.Load(o.configFile)
+	if err != nil {
+		return err
+	}
+	// A ROM that can't be read is reported when the Gameboy is created
+	if info, err := gb.ReadRomInfo(rom); err == nil {
+		c = c.ForGame(info.Title, info.SHA1)
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["fast"] {
+		o.fast = c.Audio == config.NoAudio
+	}
+	if !given["ffspeed"] {
+		o.fastForwardSpeed = c.FastForwardSpeed
+	}
+	if !given["scale"] {
+		o.scale = c.Scale
+	}
+	if !given["pause-unfocused"] {
+		o.pauseUnfocused = c.PauseUnfocused
+	}
+	if !given["rotate"] {
+		o.rotate = c.Rotate
+	}
+	if !given["mirror"] {
+		o.mirror = c.Mirror
+	}
+	if !given["save-dir"] {
+		o.saveDir = c.SaveDir
+	}
+	if !given["save-backups"] {
+		o.saveBackups = c.SaveBackups
+	}
+	if !given["rewind-buffer"] {
+		o.rewindBuffer = c.RewindBuffer
+	}
+	if !given["dmg"] {
+		o.forceDMG = c.ForceDMG
+	}
+	if !given["colorize"] {
+		o.colorize = c.Colorize
+	}
+	if !given["color-correction"] {
+		o.colorCorrection = c.ColorCorrection
+	}
+	if !given["sgb"] {
+		o.sgb = c.SGB
+	}
+	if !given["intro"] {
+		o.intro = c.Intro
+	}
+	o.discordAppID = c.DiscordAppID
+	if !given["dat"] {
+		o.datFile = c.DAT
+	}
+	o.colours = c.Palette
+	o.keys = c.Keys
+	o.cheats = append(c.Cheats, o.cheats...)
+	return nil
+}
+
+// runFlags returns the flags of the run subcommand and of the resume subcommand, which shares them
+func runFlags(name, usage string) (*flag.FlagSet, *playOptions) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var o playOptions
+	o.addFlags(fs)
+	fs.BoolVar(&o.blargg, "blargg", false, "When true, run the ROM headless as a blargg test ROM and exit with status 0 if it passes")
+	fs.BoolVar(&o.mooneye, "mooneye", false, "When true, run the ROM headless as a mooneye-gb test ROM and exit with status 0 if it passes")
+	fs.StringVar(&o.remoteAddr, "remote", "", "Serve the remote control API on this address (e.g. localhost:8081)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs, &o
+}
+
+// run plays a ROM
+func run(args []string) int {
+	fs, o := runFlags("run", "tetromino run rom.gb [flags]")
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := o.loadConfig(fs, rom); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return play(rom, *o)
+}
+
+// resume plays the most recently played ROM again
+func resume(args []string) int {
+	fs, o := runFlags("resume", "tetromino resume [flags]")
+	if err := fs.Parse(args); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	recent, err := config.LoadRecent(config.RecentFilename())
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if len(recent) == 0 {
+		fmt.Println("No ROM has been played yet")
+		return 1
+	}
+	rom := recent[0].Filename
+	if err := o.loadConfig(fs, rom); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return play(rom, *o)
+}
+
+// recent lists the most recently played ROMs
+func recent(args []string) int {
+	fs := flag.NewFlagSet("recent", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino recent\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	roms, err := config.LoadRecent(config.RecentFilename())
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	for _, r := range roms {
+		fmt.Printf("%s  %-16s  %s\n", r.Played.Format("2006-01-02 15:04"), r.Title, r.Filename)
+	}
+	return 0
+}
+
+// debug plays a ROM under the terminal debugger, or under the browser or gdb debugger if requested
+func debug(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	o := playOptions{debugging: true}
+	o.addFlags(fs)
+	fs.BoolVar(&o.debugCPU, "debugcpu", false, "When true, every executed instruction is logged with the registers by the cpu subsystem")
+	fs.StringVar(&o.gdbAddr, "gdb", "", "Listen for the gdb remote protocol on this address (e.g. localhost:2345) instead of using the terminal debugger")
+	fs.StringVar(&o.webDebug, "webdebug", "", "Serve the browser-based debugger on this address (e.g. localhost:8080) instead of using the terminal debugger")
+	fs.IntVar(&o.traceLength, "trace-length", cpu.DefaultTraceLength, "Number of recently executed instructions to keep for trace dumps")
+	fs.BoolVar(&o.traceOnBreak, "trace-on-break", false, "When true, dump the recent instructions to a file whenever the debugger stops")
+	fs.StringVar(&o.coverage, "coverage", "", "Write a map of the executed ROM addresses to this file on exit")
+	fs.StringVar(&o.heatmapFile, "heatmap", "", "Write memory access counts to this file on exit as a PNG heatmap or, with a .csv extension, as CSV")
+	fs.StringVar(&o.ioLog, "io-log", "", "Log writes to these comma-separated hardware registers to stderr with the PC and frame, e.g. LCDC,STAT,NR*,DIV,TIMA,DMA or * for all")
+	fs.StringVar(&o.symbolFile, "symbols", "", "Symbol file (e.g. from RGBDS) whose names can be used in the debuggers")
+	fs.StringVar(&o.sessionDir, "session-dir", defaultSessionDir(), "Directory where debugger breakpoints and watches are saved for each ROM (empty to disable)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino debug rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err := o.loadConfig(fs, rom); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return play(rom, o)
+}
+
+// openInfrared returns the device that the infrared port faces, waiting for the other emulator to
+// connect when listening
+func openInfrared(mode, addr string) (mem.InfraredPort, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "loopback":
+		return &ir.Loopback{}, nil
+	case "listen":
+		log.Printf("Waiting for another emulator to connect to the infrared port on %s", addr)
+		return ir.Listen(addr)
+	case "connect":
+		return ir.Dial(addr)
+	}
+	return nil, fmt.Errorf("unknown infrared mode %q: expected loopback, listen or connect", mode)
+}
+
+// startNetplay connects the Gameboy to another emulator, waiting for the other emulator to join when hosting
+func startNetplay(gameboy *gb.Gameboy, mode, addr string, delay int) (*netplay.Session, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "host":
+		log.Printf("Waiting for another emulator to join on %s", addr)
+		return netplay.Host(addr, gameboy, delay)
+	case "join":
+		return netplay.Join(addr, gameboy)
+	}
+	return nil, fmt.Errorf("unknown netplay mode %q: expected host or join", mode)
+}
+
+// resettable returns true if the menu can reset the game by starting a new Gameboy, which rules out
+// debuggers, remote control, netplay and anything else that watches or drives the first Gameboy
+func resettable(o playOptions, session *netplay.Session) bool {
+	return session == nil && o.gdbAddr == "" && o.webDebug == "" && !o.debugging && o.remoteAddr == "" &&
+		o.discordAppID == "" && o.crowdAddr == "" && o.splits == "" && !o.speedrunTimer && o.liveSplitAddr == "" &&
+		o.luaScript == "" && o.inputScript == "" && o.frameHash == "" && o.heatmapFile == "" && o.raUser == "" && o.metricsAddr == ""
+}
+
+// play runs a ROM in a window until the window closes or the process is interrupted
+func play(rom string, o playOptions) int {
+
+	// CPU profiling
+	if o.profiling {
+		f, err := os.Create("cpuprofile.pprof")
+		if err != nil {
+			log.Printf("Failed to write cpuprofile.pprof: %v", err)
+			return 1
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			log.Printf("Failed to start cpu profile: %v", err)
+			return 1
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	// Memory profiling
+	if o.memProfiling {
+		f, err := os.Create("memprofile.pprof")
+		if err != nil {
+			log.Printf("Failed to write memprofile.pprof: %v", err)
+			return 1
+		}
+		defer func() {
+			// The allocs profile covers every allocation since the start rather than only live memory
+			if err := pprof.Lookup("allocs").WriteTo(f, 0); err != nil {
+				log.Printf("Failed to write memory profile: %v", err)
+			}
+			f.Close()
+		}()
+	}
+
+	// Log to stderr
+	logger := logging.New(os.Stderr, logging.Info, o.logJSON)
+	if err := logger.Configure(o.logLevel); err != nil {
+		log.Printf("Failed to configure logging: %v", err)
+		return 1
+	}
+	logging.SetDefault(logger)
+
+	// Connect the infrared port
+	infrared, err := openInfrared(o.infrared, o.infraredAddr)
+	if err != nil {
+		log.Printf("Failed to connect the infrared port: %v", err)
+		return 1
+	}
+	if c, ok := infrared.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	colourCorrection, err := lcd.ParseColourCorrection(o.colorCorrection)
+	if err != nil {
+		log.Printf("Failed to set the colour correction: %v", err)
+		return 1
+	}
+
+	opts := gb.Options{
+		Logger:           logger,
+		RomFilename:      rom,
+		DebugCPU:         o.debugCPU,
+		DebugLCD:         o.debugLCD,
+		FastForwardSpeed: o.fastForwardSpeed,
+		Cheats:           o.cheats,
+		CheatFilename:    o.cheatFile,
+		SaveFilename:     o.saveFile,
+		SaveDir:          o.saveDir,
+		SaveBackups:      o.saveBackups,
+		RewindBuffer:     o.rewindBuffer,
+		PatchFilename:    o.patchFile,
+		TraceLength:      o.traceLength,
+		DumpTraceOnBreak: o.traceOnBreak,
+		CoverageFilename: o.coverage,
+		SymbolFilename:   o.symbolFile,
+		Colours:          o.colours,
+		ForceDMG:         o.forceDMG,
+		CompatPalette:    o.colorize,
+		ColourCorrection: colourCorrection,
+		SGB:              o.sgb,
+		BootIntro:        o.intro,
+		Infrared:         infrared,
+		AccuratePPU:      o.accuratePPU,
+	}
+	// Without speakers the clock keeps games to the right speed instead of the audio
+	opts.Pace = o.mute && !o.fast
+	// Rewinding one emulator would leave it out of step with the other
+	if o.netplay != "" {
+		opts.RewindBuffer = 0
+	}
+	if o.fast && o.parallel {
+		opts.RenderWorkers = runtime.NumCPU()
+	}
+	if o.ioLog != "" {
+		opts.IOLog = os.Stderr
+		opts.IOLogRegisters = strings.Split(o.ioLog, ",")
+	}
+
+	// Run a blargg test ROM
+	if o.blargg {
+		gameboy, result, err := gb.RunBlarggTest(opts, 5*60*60)
+		if err != nil {
+			log.Printf("Failed to run the test ROM: %v", err)
+			return 1
+		}
+		if err := gameboy.Close(); err != nil {
+			log.Printf("Failed to save: %v", err)
+		}
+		fmt.Println(strings.TrimSpace(result.Output))
+		if !result.Passed {
+			return 1
+		}
+		return 0
+	}
+
+	// Run a mooneye-gb test ROM
+	if o.mooneye {
+		gameboy, result, err := gb.RunMooneyeTest(opts, 2*60*60)
+		if err != nil {
+			log.Printf("Failed to run the test ROM: %v", err)
+			return 1
+		}
+		if err := gameboy.Close(); err != nil {
+			log.Printf("Failed to save: %v", err)
+		}
+		fmt.Println(result.Output)
+		if !result.Passed {
+			return 1
+		}
+		return 0
+	}
+
+	// Run context which is cancelled when the window closes or the process is interrupted
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancelFunc()
+	}()
+
+	// Create the Gameboy emulator
+	gameboy, err := gb.NewGameboy(opts)
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return 1
+	}
+
+	cartridge := gameboy.Cartridge()
+	logger.With("gb").Infof("Playing %s", cartridge)
+
+	// Remember the ROM so that it can be resumed
+	if err := config.AddRecent(config.RecentFilename(), config.Recent{Filename: rom, Title: cartridge.Title, Played: time.Now()}); err != nil {
+		log.Printf("Failed to update the recent ROMs: %v", err)
+	}
+
+	// Warn about bad dumps, which explain many glitches
+	if o.datFile != "" {
+		checkDump(logger.With("dat"), o.datFile, rom)
+	}
+
+	// Play with someone running another emulator
+	session, err := startNetplay(gameboy, o.netplay, o.netplayAddr, o.netplayDelay)
+	if err != nil {
+		log.Printf("Failed to start netplay: %v", err)
+		return 1
+	}
+	if session != nil {
+		defer session.Close()
+		log.Printf("Playing as player %d", session.Player()+1)
+	}
+
+	// Show the game on the user's Discord profile
+	if o.discordAppID != "" {
+		title := cartridge.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(rom), filepath.Ext(rom))
+		}
+		go discord.Run(ctx, o.discordAppID, title, gameboy)
+	}
+
+	// Let an audience play
+	if o.crowdAddr != "" {
+		mode, err := crowd.ParseMode(o.crowdMode)
+		if err != nil {
+			log.Printf("Failed to start crowd play: %v", err)
+			return 1
+		}
+		c := crowd.New(gameboy, crowd.Options{Mode: mode, Window: o.crowdWindow, Hold: crowd.DefaultOptions.Hold})
+		go func() {
+			if err := c.ListenAndServe(ctx, o.crowdAddr); err != nil {
+				log.Printf("Failed to serve crowd play: %v", err)
+			}
+		}()
+	}
+
+	// Time a speedrun
+	if o.splits != "" || o.speedrunTimer || o.liveSplitAddr != "" {
+		var splits []speedrun.Split
+		if o.splits != "" {
+			splits, err = speedrun.LoadSplitsFile(o.splits, gameboy.Symbols())
+			if err != nil {
+				log.Printf("Failed to load the splits: %v", err)
+				return 1
+			}
+		}
+		opts := speedrun.Options{Overlay: o.speedrunTimer}
+		if o.liveSplitAddr != "" {
+			opts.LiveSplit, err = speedrun.DialLiveSplit(o.liveSplitAddr)
+			if err != nil {
+				log.Printf("Failed to connect to LiveSplit: %v", err)
+				return 1
+			}
+			defer opts.LiveSplit.Close()
+		}
+		speedrun.New(gameboy, splits, opts)
+	}
+
+	// Run a Lua script
+	if o.luaScript != "" {
+		engine := script.NewEngine(gameboy)
+		defer engine.Close()
+		if err := engine.Run(o.luaScript); err != nil {
+			log.Printf("Failed to run script: %v", err)
+			return 1
+		}
+	}
+
+	// Play back scripted button presses, which only the local Gameboy would see during netplay
+	if o.inputScript != "" {
+		if session != nil {
+			log.Printf("Input scripts can't be played during netplay")
+			return 1
+		}
+		script, err := input.Load(o.inputScript)
+		if err != nil {
+			log.Printf("Failed to load the input script: %v", err)
+			return 1
+		}
+		script.Attach(gameboy)
+	}
+
+	// Log a hash of every frame
+	var hashes *framehash.Logger
+	if o.frameHash != "" {
+		f, err := os.Create(o.frameHash)
+		if err != nil {
+			log.Printf("Failed to create the frame hash log: %v", err)
+			return 1
+		}
+		defer f.Close()
+		hashes = framehash.New(gameboy, f, o.frameHashAudio)
+	}
+
+	// Count memory accesses
+	var accesses *heatmap.Heatmap
+	if o.heatmapFile != "" {
+		accesses = heatmap.New()
+		gameboy.AddMemoryHooks(accesses)
+	}
+
+	// Load RetroAchievements
+	if o.raUser != "" {
+		loadAchievements(gameboy, rom, o.raUser, o.raToken)
+	}
+
+	// Create a display
+	orientation, err := ui.NewOrientation(o.rotate, o.mirror)
+	if err != nil {
+		log.Printf("Failed to create display: %v", err)
+		return 1
+	}
+	display, err := ui.NewGLDisplay(gameboy, cancelFunc, o.scale, orientation, o.keys)
+	if err != nil {
+		log.Printf("Failed to create display: %v", err)
+		return 1
+	}
+	defer display.Cleanup()
+	gameboy.RegisterDisplay(display)
+
+	// Serve runtime metrics
+	var stats *metrics.Metrics
+	if o.metricsAddr != "" {
+		stats = metrics.New(gameboy)
+		go func() {
+			if err := stats.ListenAndServe(ctx, o.metricsAddr); err != nil {
+				log.Printf("Failed to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	// Create speakers if we are not running in fast mode
+	var speakers *ui.PortaudioSpeakers
+	if !o.fast && !o.mute {
+		speakers, err = ui.NewPortaudioSpeakers()
+		if err != nil {
+			log.Printf("Failed to create speakers: %v", err)
+			return 1
+		}
+		defer speakers.Cleanup()
+		gameboy.RegisterSpeakers(speakers)
+		if stats != nil {
+			stats.SetAudioUnderruns(speakers.Underruns)
+		}
+	}
+	if o.pauseUnfocused {
+		display.PauseWhenUnfocused(speakers)
+	}
+
+	// Gameboys started after the first, by resetting or by loading another ROM remotely, run on the
+	// same display and speakers
+	attach := func(loaded *gb.Gameboy) {
+		loaded.RegisterDisplay(display)
+		display.SetGameboy(loaded)
+		if speakers != nil {
+			loaded.RegisterSpeakers(speakers)
+		}
+	}
+
+	// Show a menu over the game. Resetting ends the run so that the ROM can start again, which is only
+	// offered when nothing else is attached to the first Gameboy.
+	var resetting bool
+	var endRun context.CancelFunc
+	var reset func()
+	if resettable(o, session) {
+		reset = func() {
+			resetting = true
+			endRun()
+		}
+	}
+	display.SetMenu(pauseMenu(func() *gb.Gameboy { return gameboy }, reset, cancelFunc), speakers)
+
+	// Restore the breakpoints and watches from the last debugging session of this ROM
+	if o.debugging && o.sessionDir != "" {
+		if err := gameboy.LoadSession(o.sessionDir); err != nil {
+			log.Printf("Failed to restore the debugger session: %v", err)
+		}
+	}
+
+	// Start running the emulator, under the control of a debugger if requested
+	switch {
+	case o.gdbAddr != "":
+		if err := gdbstub.ListenAndServe(ctx, o.gdbAddr, gameboy); err != nil {
+			log.Printf("Failed to serve gdb: %v", err)
+		}
+	case o.webDebug != "":
+		if err := webdebug.New(gameboy).ListenAndServe(ctx, o.webDebug); err != nil {
+			log.Printf("Failed to serve the debugger: %v", err)
+		}
+	case o.debugging:
+		// Leaving the terminal debugger lets the game run on
+		debugger.New(gameboy, os.Stdin, os.Stdout).Run(ctx)
+		gameboy.Run(ctx)
+	case o.remoteAddr != "":
+		// ROMs loaded by clients run with the same options, on the same display and speakers
+		server := remote.New(gameboy, rom, func(filename string) (*gb.Gameboy, error) {
+			romOpts := opts
+			romOpts.RomFilename = filename
+			romOpts.SaveFilename = ""
+			// Cheats and patches only make sense for the ROM they were given for
+			romOpts.Cheats = nil
+			romOpts.CheatFilename = ""
+			romOpts.PatchFilename = ""
+			loaded, err := gb.NewGameboy(romOpts)
+			if err != nil {
+				return nil, err
+			}
+			attach(loaded)
+			gameboy = loaded
+			return loaded, nil
+		})
+		if err := server.ListenAndServe(ctx, o.remoteAddr); err != nil {
+			log.Printf("Failed to serve the remote control API: %v", err)
+		}
+		gameboy = server.Gameboy()
+	default:
+		for {
+			var runCtx context.Context
+			runCtx, endRun = context.WithCancel(ctx)
+			gameboy.Run(runCtx)
+			endRun()
+			if !resetting {
+				break
+			}
+			resetting = false
+			if err := gameboy.Close(); err != nil {
+				log.Printf("Failed to save: %v", err)
+			}
+			gameboy, err = gb.NewGameboy(opts)
+			if err != nil {
+				log.Printf("Failed to reset the Gameboy: %v", err)
+				return 1
+			}
+			attach(gameboy)
+		}
+	}
+
+	// Save the debugger session
+	if o.debugging && o.sessionDir != "" {
+		if err := gameboy.SaveSession(o.sessionDir); err != nil {
+			log.Printf("Failed to save the debugger session: %v", err)
+		}
+	}
+
+	// Finish the frame hash log
+	if hashes != nil {
+		if err := hashes.Close(); err != nil {
+			log.Printf("Failed to write the frame hash log: %v", err)
+		}
+	}
+
+	// Write the memory access heatmap
+	if accesses != nil {
+		if err := accesses.Save(o.heatmapFile); err != nil {
+			log.Printf("Failed to write heatmap: %v", err)
+		}
+	}
+
+	// Flush persistent state before exiting
+	if err := gameboy.Close(); err != nil {
+		log.Printf("Failed to save: %v", err)
+	}
+	return 0
+}
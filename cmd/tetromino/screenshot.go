@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/input"
+)
+
+// screenshot runs a ROM headless for a number of frames and writes the final frame to a PNG file
+func screenshot(args []string) int {
+	fs := flag.NewFlagSet("screenshot", flag.ExitOnError)
+	frames := fs.Int("frames", 600, "Number of frames to run before taking the screenshot")
+	inputScript := fs.String("input", "", "Input script of timed button presses to play back, e.g. to get past the title screen")
+	forceDMG := fs.Bool("dmg", false, "When true, Game Boy Color games run as they would on the original Game Boy")
+	colorize := fs.String("colorize", "", "Colour original Game Boy games as a Game Boy Color does, with \"auto\" to pick a palette by title like its boot ROM or a button combination such as \"up+a\"")
+	superGameboy := fs.Bool("sgb", false, "When true, games run on a Super Game Boy with its border and colours")
+	output := fs.String("o", "", "PNG file to write (defaults to the ROM filename with a .png extension)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino screenshot rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(rom), filepath.Ext(rom)) + ".png"
+	}
+	gameboy, err := gb.NewGameboy(gb.Options{RomFilename: rom, ForceDMG: *forceDMG, CompatPalette: *colorize, SGB: *superGameboy})
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return 1
+	}
+	if *inputScript != "" {
+		script, err := input.Load(*inputScript)
+		if err != nil {
+			log.Printf("Failed to load the input script: %v", err)
+			return 1
+		}
+		script.Attach(gameboy)
+	}
+	gameboy.RunFrames(*frames)
+	if err := gameboy.Screenshot(*output); err != nil {
+		log.Printf("Failed to write screenshot: %v", err)
+		return 1
+	}
+	fmt.Println("Wrote", *output)
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/stream"
+)
+
+// streamROM runs a ROM headless at the speed of a real Gameboy and serves its frames to browsers, which
+// can also play it
+func streamROM(args []string) int {
+	fs := flag.NewFlagSet("stream", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8083", "Address to serve the page to play in at /, MJPEG at /mjpeg and the WebSocket at /ws")
+	quality := fs.Int("quality", stream.DefaultOptions.Quality, "Quality of MJPEG frames from 1 to 100")
+	frameSkip := fs.Int("frameskip", stream.DefaultOptions.FrameSkip, "Send every nth frame to save bandwidth")
+	saveFile := fs.String("save", "", "Battery save file (defaults to the ROM filename with a .sav extension)")
+	forceDMG := fs.Bool("dmg", false, "When true, Game Boy Color games run as they would on the original Game Boy")
+	superGameboy := fs.Bool("sgb", false, "When true, games run on a Super Game Boy with its border and colours")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino stream rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	// There are no speakers to keep the emulator to the speed of a real Gameboy so the clock does
+	gameboy, err := gb.NewGameboy(gb.Options{RomFilename: rom, SaveFilename: *saveFile, ForceDMG: *forceDMG, SGB: *superGameboy, Pace: true})
+	if err != nil {
+		log.Printf("Failed to create the Gameboy: %v", err)
+		return 1
+	}
+	server := stream.New(gameboy, stream.Options{Quality: *quality, FrameSkip: *frameSkip})
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancelFunc()
+	}()
+	go func() {
+		if err := server.ListenAndServe(ctx, *addr); err != nil {
+			log.Printf("Failed to serve the stream: %v", err)
+			cancelFunc()
+		}
+	}()
+	gameboy.Run(ctx)
+	if err := gameboy.Close(); err != nil {
+		log.Printf("Failed to save: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/input"
+)
+
+// testROM runs a ROM headless until a condition passes it, exiting with status 0 if it passes and 1
+// if it fails or runs out of time, so that test ROMs can gate CI
+func testROM(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	frames := fs.Int("frames", 10*60*60, "Number of frames after which the test fails")
+	timeout := fs.Duration("timeout", time.Minute, "Time after which the test fails, or 0 for no limit")
+	serial := fs.String("serial", "", "Pass once the serial output contains this text, e.g. \"Passed\"")
+	fail := fs.String("fail", "", "Fail as soon as the serial output contains this text, e.g. \"Failed\"")
+	memory := fs.String("memory", "", "Pass once this condition is true at the end of a frame, e.g. \"[0xA000]==0\"")
+	frameHash := fs.String("frame-hash", "", "Pass once the SHA-1 of the frame's RGBA pixels, as printed at the end of each test, matches")
+	verbose := fs.Bool("v", false, "When true, serial output is written to stdout as it arrives")
+	inputScript := fs.String("input", "", "Input script of timed button presses to play back")
+	symbolFile := fs.String("symbols", "", "Symbol file (e.g. from RGBDS) whose names can be used in -memory")
+	forceDMG := fs.Bool("dmg", false, "When true, Game Boy Color games run as they would on the original Game Boy")
+	superGameboy := fs.Bool("sgb", false, "When true, games run on a Super Game Boy with its border and colours")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: tetromino test rom.gb [flags]\n")
+		fs.PrintDefaults()
+	}
+	rom, err := parseArgs(fs, args)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	// Saves are neither loaded nor written so that every run starts from the same state
+	data, err := ioutil.ReadFile(rom)
+	if err != nil {
+		log.Printf("Failed to read the ROM: %v", err)
+		return 1
+	}
+	opts := gb.Options{Rom: data, SymbolFilename: *symbolFile, ForceDMG: *forceDMG, SGB: *superGameboy}
+	if *verbose {
+		opts.SBWriter = os.Stdout
+	}
+	var script *input.Script
+	if *inputScript != "" {
+		script, err = input.Load(*inputScript)
+		if err != nil {
+			log.Printf("Failed to load the input script: %v", err)
+			return 1
+		}
+	}
+	condition := gb.TestCondition{Serial: *serial, Fail: *fail, Memory: *memory, FrameHash: *frameHash}
+	gameboy, result, err := gb.RunTest(opts, condition, *frames, *timeout, func(gameboy *gb.Gameboy) error {
+		if script != nil {
+			script.Attach(gameboy)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to run the test: %v", err)
+		return 1
+	}
+	if *verbose && result.Output != "" {
+		fmt.Println()
+	}
+	fmt.Printf("Frame hash: %s\n", gameboy.FrameHash())
+	switch {
+	case result.Passed:
+		fmt.Printf("PASS after %d frames\n", result.Frames)
+		return 0
+	case result.TimedOut:
+		fmt.Printf("FAIL: timed out after %d frames\n", result.Frames)
+	default:
+		fmt.Printf("FAIL after %d frames\n", result.Frames)
+	}
+	if !*verbose && result.Output != "" {
+		fmt.Println(result.Output)
+	}
+	return 1
+}
@@ -1,9 +1,12 @@
 module github.com/scottyw/tetromino
 
-go 1.14
+go 1.18
 
 require (
 	github.com/go-gl/gl v0.0.0-20190320180904-bf2b1f2f34d7
 	github.com/go-gl/glfw v0.0.0-20200222043503-6f7a984d4dc4
 	github.com/gordonklaus/portaudio v0.0.0-20180817120803-00e7307ccd93
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.26.0
 )
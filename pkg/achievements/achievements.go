@@ -0,0 +1,185 @@
+package achievements
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// DefaultURL is the RetroAchievements request endpoint
+const DefaultURL = "https://retroachievements.org/dorequest.php"
+
+// Notifications are shown for about five seconds
+const notificationFrames = 300
+
+// Hash returns the RetroAchievements hash of a Gameboy ROM, which is the MD5 of the whole file
+func Hash(rom []byte) string {
+	sum := md5.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile returns the RetroAchievements hash of a Gameboy ROM file
+func HashFile(filename string) (string, error) {
+	rom, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return Hash(rom), nil
+}
+
+// Achievement is a single achievement definition
+type Achievement struct {
+	ID          int    `json:"ID"`
+	Title       string `json:"Title"`
+	Description string `json:"Description"`
+	Points      int    `json:"Points"`
+	MemAddr     string `json:"MemAddr"`
+	Flags       int    `json:"Flags"`
+}
+
+// Client talks to the RetroAchievements web API
+type Client struct {
+	URL      string
+	Username string
+	Token    string
+	HTTP     *http.Client
+}
+
+// NewClient returns a client for the given user and API token
+func NewClient(username, token string) *Client {
+	return &Client{
+		URL:      DefaultURL,
+		Username: username,
+		Token:    token,
+		HTTP:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) request(params url.Values, response interface{}) error {
+	params.Set("u", c.Username)
+	params.Set("t", c.Token)
+	resp, err := c.HTTP.PostForm(c.URL, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RetroAchievements request %s failed: %s", params.Get("r"), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// GameID looks up the RetroAchievements game ID for a ROM hash
+func (c *Client) GameID(hash string) (int, error) {
+	var response struct {
+		Success bool `json:"Success"`
+		GameID  int  `json:"GameID"`
+	}
+	err := c.request(url.Values{"r": {"gameid"}, "m": {hash}}, &response)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Success || response.GameID == 0 {
+		return 0, fmt.Errorf("RetroAchievements does not recognise ROM hash %s", hash)
+	}
+	return response.GameID, nil
+}
+
+// Achievements fetches the achievement definitions for a game
+func (c *Client) Achievements(gameID int) ([]Achievement, error) {
+	var response struct {
+		Success   bool `json:"Success"`
+		PatchData struct {
+			Achievements []Achievement `json:"Achievements"`
+		} `json:"PatchData"`
+	}
+	err := c.request(url.Values{"r": {"patch"}, "g": {strconv.Itoa(gameID)}}, &response)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("RetroAchievements has no achievements for game %d", gameID)
+	}
+	return response.PatchData.Achievements, nil
+}
+
+// Award reports an unlocked achievement
+func (c *Client) Award(id int) error {
+	var response struct {
+		Success bool   `json:"Success"`
+		Error   string `json:"Error"`
+	}
+	err := c.request(url.Values{"r": {"awardachievement"}, "a": {strconv.Itoa(id)}, "h": {"0"}}, &response)
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("RetroAchievements award failed: %s", response.Error)
+	}
+	return nil
+}
+
+type active struct {
+	Achievement
+	trigger *Trigger
+}
+
+// Runtime evaluates achievement conditions at the end of each frame and shows a notification
+// over the screen when one unlocks
+type Runtime struct {
+	gameboy *gb.Gameboy
+	active  []*active
+
+	// OnUnlock is called when an achievement unlocks, typically to award it via the client
+	OnUnlock func(Achievement)
+}
+
+// NewRuntime registers the achievements with the Gameboy. Achievements whose conditions cannot
+// be parsed are skipped and reported in the returned error.
+func NewRuntime(gameboy *gb.Gameboy, list []Achievement) (*Runtime, error) {
+	r := &Runtime{
+		gameboy: gameboy,
+	}
+	var err error
+	for _, a := range list {
+		trigger, perr := ParseTrigger(a.MemAddr)
+		if perr != nil {
+			err = fmt.Errorf("skipped achievement %d (%s): %v", a.ID, a.Title, perr)
+			continue
+		}
+		r.active = append(r.active, &active{Achievement: a, trigger: trigger})
+	}
+	gameboy.OnFrame(r.frame)
+	return r, err
+}
+
+// Remaining returns the number of achievements that have not yet unlocked
+func (r *Runtime) Remaining() int {
+	return len(r.active)
+}
+
+func (r *Runtime) frame(_ *image.RGBA) {
+	remaining := r.active[:0]
+	for _, a := range r.active {
+		if a.trigger.Test(r.gameboy) {
+			notification := fmt.Sprintf("%s (%d)", a.Title, a.Points)
+			r.gameboy.Overlay().Message(notification, color.RGBA{0xff, 0xd7, 0x00, 0xff}, notificationFrames)
+			if r.OnUnlock != nil {
+				r.OnUnlock(a.Achievement)
+			}
+			continue
+		}
+		remaining = append(remaining, a)
+	}
+	r.active = remaining
+}
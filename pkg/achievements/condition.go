@@ -0,0 +1,290 @@
+package achievements
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Memory is the subset of the Gameboy needed to evaluate conditions
+type Memory interface {
+	ReadMemory(addr uint16) uint8
+}
+
+type size int
+
+const (
+	size8 = iota
+	size16
+	size32
+	sizeLower4
+	sizeUpper4
+	sizeBit0
+)
+
+type operand struct {
+	constant bool
+	delta    bool
+	size     size
+	bit      uint
+	value    uint32 // constant value or memory address
+	previous uint32
+}
+
+type condition struct {
+	flag     byte // 0, 'R' (reset if true) or 'P' (pause if true)
+	left     operand
+	cmp      string
+	right    operand
+	required uint32
+	hits     uint32
+}
+
+type group []*condition
+
+// Trigger is a parsed rcheevos-style memory condition string such as "0xH1234=5_d0xH1235<0xH1235"
+type Trigger struct {
+	core group
+	alts []group
+}
+
+// ParseTrigger parses the subset of the rcheevos condition syntax that covers the memory sizes,
+// delta values, comparisons, hit counts, reset/pause flags and alt groups used by most sets
+func ParseTrigger(s string) (*Trigger, error) {
+	t := &Trigger{}
+	for i, part := range strings.Split(s, "S") {
+		g, err := parseGroup(part)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			t.core = g
+		} else {
+			t.alts = append(t.alts, g)
+		}
+	}
+	return t, nil
+}
+
+func parseGroup(s string) (group, error) {
+	var g group
+	if s == "" {
+		return g, nil
+	}
+	for _, c := range strings.Split(s, "_") {
+		cond, err := parseCondition(c)
+		if err != nil {
+			return nil, err
+		}
+		g = append(g, cond)
+	}
+	return g, nil
+}
+
+var comparisons = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+func parseCondition(s string) (*condition, error) {
+	cond := &condition{}
+	if len(s) > 2 && s[1] == ':' {
+		cond.flag = s[0]
+		if cond.flag != 'R' && cond.flag != 'P' {
+			return nil, fmt.Errorf("unsupported condition flag: %s", s)
+		}
+		s = s[2:]
+	}
+	if strings.HasSuffix(s, ".") {
+		i := strings.LastIndex(s[:len(s)-1], ".")
+		if i < 0 {
+			return nil, fmt.Errorf("bad hit count: %s", s)
+		}
+		hits, err := strconv.ParseUint(s[i+1:len(s)-1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad hit count: %s", s)
+		}
+		cond.required = uint32(hits)
+		s = s[:i]
+	}
+	for _, cmp := range comparisons {
+		i := strings.Index(s, cmp)
+		if i < 0 {
+			continue
+		}
+		left, err := parseOperand(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseOperand(s[i+len(cmp):])
+		if err != nil {
+			return nil, err
+		}
+		cond.left = left
+		cond.cmp = cmp
+		cond.right = right
+		return cond, nil
+	}
+	return nil, fmt.Errorf("condition has no comparison: %s", s)
+}
+
+func parseOperand(s string) (operand, error) {
+	var op operand
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "d") {
+		op.delta = true
+		s = s[1:]
+	}
+	if !strings.HasPrefix(strings.ToLower(s), "0x") {
+		if strings.HasPrefix(strings.ToLower(s), "h") {
+			v, err := strconv.ParseUint(s[1:], 16, 32)
+			if err != nil {
+				return op, fmt.Errorf("bad constant: %s", s)
+			}
+			op.value = uint32(v)
+		} else {
+			v, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return op, fmt.Errorf("bad constant: %s", s)
+			}
+			op.value = uint32(v)
+		}
+		op.constant = true
+		return op, nil
+	}
+	s = s[2:]
+	op.size = size16
+	if len(s) > 0 {
+		switch c := s[0]; {
+		case c == 'H' || c == 'h':
+			op.size = size8
+			s = s[1:]
+		case c == 'X' || c == 'x':
+			op.size = size32
+			s = s[1:]
+		case c == 'L' || c == 'l':
+			op.size = sizeLower4
+			s = s[1:]
+		case c == 'U' || c == 'u':
+			op.size = sizeUpper4
+			s = s[1:]
+		case c >= 'M' && c <= 'T':
+			op.size = sizeBit0
+			op.bit = uint(c - 'M')
+			s = s[1:]
+		case c == ' ':
+			s = s[1:]
+		}
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || v > 0xffff {
+		return op, fmt.Errorf("bad address: %s", s)
+	}
+	op.value = uint32(v)
+	return op, nil
+}
+
+func (op *operand) read(m Memory) uint32 {
+	if op.constant {
+		return op.value
+	}
+	addr := uint16(op.value)
+	var v uint32
+	switch op.size {
+	case size8:
+		v = uint32(m.ReadMemory(addr))
+	case size16:
+		v = uint32(m.ReadMemory(addr)) | uint32(m.ReadMemory(addr+1))<<8
+	case size32:
+		v = uint32(m.ReadMemory(addr)) | uint32(m.ReadMemory(addr+1))<<8 |
+			uint32(m.ReadMemory(addr+2))<<16 | uint32(m.ReadMemory(addr+3))<<24
+	case sizeLower4:
+		v = uint32(m.ReadMemory(addr) & 0x0f)
+	case sizeUpper4:
+		v = uint32(m.ReadMemory(addr) >> 4)
+	case sizeBit0:
+		v = uint32(m.ReadMemory(addr)>>op.bit) & 1
+	}
+	current := v
+	if op.delta {
+		v = op.previous
+	}
+	op.previous = current
+	return v
+}
+
+func (c *condition) test(m Memory) bool {
+	l := c.left.read(m)
+	r := c.right.read(m)
+	switch c.cmp {
+	case "=":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+func (g group) reset() {
+	for _, c := range g {
+		c.hits = 0
+	}
+}
+
+// evaluate returns whether the group is true and whether a reset condition fired
+func (g group) evaluate(m Memory) (bool, bool) {
+	// Pause conditions are checked first and stop the rest of the group being evaluated
+	for _, c := range g {
+		if c.flag == 'P' && c.test(m) {
+			return false, false
+		}
+	}
+	result := true
+	reset := false
+	for _, c := range g {
+		switch c.flag {
+		case 'P':
+			continue
+		case 'R':
+			if c.test(m) {
+				reset = true
+			}
+			continue
+		}
+		if c.required > 0 {
+			if c.test(m) && c.hits < c.required {
+				c.hits++
+			}
+			result = result && c.hits >= c.required
+		} else {
+			result = c.test(m) && result
+		}
+	}
+	return result, reset
+}
+
+// Test evaluates the trigger against memory and should be called exactly once per frame
+func (t *Trigger) Test(m Memory) bool {
+	result, reset := t.core.evaluate(m)
+	if len(t.alts) > 0 {
+		alt := false
+		for _, g := range t.alts {
+			r, rs := g.evaluate(m)
+			alt = alt || r
+			reset = reset || rs
+		}
+		result = result && alt
+	}
+	if reset {
+		t.core.reset()
+		for _, g := range t.alts {
+			g.reset()
+		}
+		return false
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package achievements
+
+import (
+	"testing"
+)
+
+type fakeMemory map[uint16]uint8
+
+func (m fakeMemory) ReadMemory(addr uint16) uint8 {
+	return m[addr]
+}
+
+func TestParseTriggerErrors(t *testing.T) {
+	for _, s := range []string{"0xH1234", "0xHzzzz=1", "Q:0xH1234=1", "0xH1234=1.2", "0xH1234=abc"} {
+		if _, err := ParseTrigger(s); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	m := fakeMemory{}
+	trigger, err := ParseTrigger("0xHc000=5_0xc001>=h100_0xNc003=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Test(m) {
+		t.Errorf("Should not trigger on empty memory")
+	}
+	m[0xc000] = 5
+	m[0xc002] = 1
+	m[0xc003] = 2
+	if !trigger.Test(m) {
+		t.Errorf("Should trigger")
+	}
+}
+
+func TestTriggerDeltaAndHits(t *testing.T) {
+	m := fakeMemory{}
+	trigger, err := ParseTrigger("0xHc000>d0xHc000.2._R:0xHc001=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{false, true} {
+		m[0xc000]++
+		if trigger.Test(m) != expected {
+			t.Errorf("Wrong result for frame %d", i)
+		}
+	}
+	m[0xc001] = 1
+	if trigger.Test(m) {
+		t.Errorf("Reset should stop the trigger")
+	}
+	m[0xc001] = 0
+	m[0xc000]++
+	if trigger.Test(m) {
+		t.Errorf("Hits should have been reset")
+	}
+}
+
+func TestTriggerAltsAndPause(t *testing.T) {
+	m := fakeMemory{0xc000: 1}
+	trigger, err := ParseTrigger("0xHc000=1_P:0xHc003=1S0xHc001=1S0xHc002=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Test(m) {
+		t.Errorf("Should not trigger without an alt")
+	}
+	m[0xc002] = 1
+	if !trigger.Test(m) {
+		t.Errorf("Should trigger with an alt")
+	}
+	m[0xc003] = 1
+	if trigger.Test(m) {
+		t.Errorf("Should not trigger while paused")
+	}
+}
@@ -0,0 +1,421 @@
+// Package config loads Tetromino's settings from a TOML file in the user's config directory, e.g.
+//
+//	scale = 4
+//	rotate = 90
+//	mirror = false
+//	fast_forward_speed = 8
+//	audio = "none"
+//	pause_unfocused = true
+//	save_dir = "~/Games/saves"
+//	save_backups = 3
+//	rewind_buffer = 32
+//	palette = ["#e0f8d0", "#88c070", "#346856", "#081820"]
+//	force_dmg = false
+//	colorize = "auto"
+//	color_correction = "cgb"
+//	sgb = false
+//	intro = true
+//	discord_app_id = "123456789012345678"
+//	dat = "~/Games/Nintendo - Game Boy.dat"
+//
+//	[keys]
+//	a = "X"
+//	b = "Z"
+//	start = "Enter"
+//	select = "RightShift"
+//	screenshot = "F12"
+//
+//	[game."TETRIS"]
+//	palette = ["#ffffff", "#a0a0ff", "#5050c0", "#000040"]
+//	cheats = ["010138CD"]
+//
+// Command line flags override the settings in the file. Sections named after a game, by the title
+// in its cartridge header or the SHA-1 hash of the ROM, override settings for that game only.
+package config
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Audio backends
+const (
+	// PortAudio plays sound through the default output device
+	PortAudio = "portaudio"
+	// NoAudio runs without sound, as fast as possible
+	NoAudio = "none"
+)
+
+// Actions lists the names that keys can be bound to in the [keys] section
+var Actions = []string{"up", "down", "left", "right", "a", "b", "start", "select", "screenshot", "dumptrace", "fastforward", "rewind", "menu"}
+
+// Config holds the settings used each time Tetromino is launched
+type Config struct {
+	// Scale multiplies the size of the window
+	Scale            int
+	FastForwardSpeed int
+	// Rotate turns the picture clockwise by 0, 90, 180 or 270 degrees
+	Rotate int
+	// Mirror flips the picture horizontally
+	Mirror bool
+	// Audio is the name of the audio backend
+	Audio string
+	// PauseUnfocused pauses the game and its sound while the window is in the background
+	PauseUnfocused bool
+	// SaveDir holds the battery saves of every ROM, which otherwise sit alongside the ROM
+	SaveDir string
+	// SaveBackups is the number of earlier copies of each battery save that are kept
+	SaveBackups int
+	// RewindBuffer is the number of megabytes kept for rewinding, or 0 to turn rewinding off
+	RewindBuffer int
+	// Palette replaces the four shades of grey, from lightest to darkest
+	Palette *[4]color.RGBA
+	// ForceDMG runs Game Boy Color games as they would run on the original Game Boy
+	ForceDMG bool
+	// Colorize colours games made for the original Game Boy as a Game Boy Color does, with "auto" to
+	// pick the palette by title or a button combination such as "up+a"
+	Colorize string
+	// ColorCorrection corrects the colours of Game Boy Color games to look like its LCD with "cgb" or
+	// like a Game Boy Advance with "gba", or shows them as they are with "raw"
+	ColorCorrection string
+	// SGB runs games on a Super Game Boy with its border and colours
+	SGB bool
+	// Intro scrolls the logo down and plays the chime before each game starts
+	Intro bool
+	// DiscordAppID is the ID of an application registered with Discord, which turns on showing the
+	// game being played on the user's Discord profile
+	DiscordAppID string
+	// DAT is a DAT file of known ROM dumps, such as No-Intro's, that each ROM is checked against
+	DAT string
+	// Keys maps each action to the name of a key, such as "X", "Enter" or "F12"
+	Keys map[string]string
+	// Cheats lists GameShark or Game Genie codes, which are only set for a particular game
+	Cheats []string
+	// Games holds the overrides for each game keyed by lower case title or hash
+	Games map[string]Game
+}
+
+// Game holds the settings that can be overridden for a particular game
+type Game struct {
+	FastForwardSpeed int
+	Palette          *[4]color.RGBA
+	ForceDMG         bool
+	Colorize         string
+	SGB              bool
+	Cheats           []string
+}
+
+// ForGame returns the settings for a game, with the overrides for its title applied and then
+// those for its hash
+func (c Config) ForGame(title, hash string) Config {
+	for _, key := range []string{title, hash} {
+		game, ok := c.Games[strings.ToLower(key)]
+		if key == "" || !ok {
+			continue
+		}
+		if game.FastForwardSpeed != 0 {
+			c.FastForwardSpeed = game.FastForwardSpeed
+		}
+		if game.Palette != nil {
+			c.Palette = game.Palette
+		}
+		if game.ForceDMG {
+			c.ForceDMG = true
+		}
+		if game.Colorize != "" {
+			c.Colorize = game.Colorize
+		}
+		if game.SGB {
+			c.SGB = true
+		}
+		c.Cheats = append(c.Cheats[:len(c.Cheats):len(c.Cheats)], game.Cheats...)
+	}
+	return c
+}
+
+// Default returns the settings used when there is no config file
+func Default() Config {
+	return Config{
+		Scale:            3,
+		FastForwardSpeed: 4,
+		Audio:            PortAudio,
+		ColorCorrection:  "raw",
+		SaveBackups:      3,
+		RewindBuffer:     32,
+		Keys: map[string]string{
+			"up":          "Up",
+			"down":        "Down",
+			"left":        "Left",
+			"right":       "Right",
+			"a":           "X",
+			"b":           "Z",
+			"start":       "A",
+			"select":      "S",
+			"screenshot":  "T",
+			"dumptrace":   "D",
+			"fastforward": "Tab",
+			"rewind":      "Backspace",
+			"menu":        "Escape",
+		},
+	}
+}
+
+// DefaultFilename returns the location of the config file under the user's config directory, or an
+// empty string if there is no config directory
+func DefaultFilename() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tetromino", "config.toml")
+}
+
+// Load reads a config file, returning the default settings if it doesn't exist
+func Load(filename string) (Config, error) {
+	if filename == "" {
+		return Default(), nil
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("Failed to read the config file at \"%s\" (%v)", filename, err)
+	}
+	defer f.Close()
+	c, err := Parse(f)
+	if err != nil {
+		return Config{}, fmt.Errorf("Failed to load the config file at \"%s\" (%v)", filename, err)
+	}
+	return c, nil
+}
+
+// Parse reads settings in TOML format, starting from the defaults
+func Parse(r io.Reader) (Config, error) {
+	doc, err := parseTOML(r)
+	if err != nil {
+		return Config{}, err
+	}
+	c := Default()
+	if err := c.apply(doc[""]); err != nil {
+		return Config{}, err
+	}
+	for name, t := range doc {
+		switch {
+		case name == "":
+		case name == "keys":
+			if err := c.bindKeys(t); err != nil {
+				return Config{}, err
+			}
+		case strings.HasPrefix(name, "game."):
+			if err := c.addGame(strings.TrimPrefix(name, "game."), t); err != nil {
+				return Config{}, err
+			}
+		default:
+			return Config{}, fmt.Errorf("unknown section [%s]", name)
+		}
+	}
+	return c, nil
+}
+
+// apply sets each top-level setting found in a table
+func (c *Config) apply(t table) error {
+	for _, key := range sortedKeys(t) {
+		var err error
+		switch key {
+		case "scale":
+			c.Scale, err = t.int(key, 1, 16)
+		case "rotate":
+			c.Rotate, err = t.int(key, 0, 270)
+			if err == nil && c.Rotate%90 != 0 {
+				err = fmt.Errorf("rotate: expected 0, 90, 180 or 270 but got %d", c.Rotate)
+			}
+		case "mirror":
+			c.Mirror, err = t.bool(key)
+		case "fast_forward_speed":
+			c.FastForwardSpeed, err = t.int(key, 2, 64)
+		case "audio":
+			c.Audio, err = t.string(key)
+			if err == nil && c.Audio != PortAudio && c.Audio != NoAudio {
+				err = fmt.Errorf("audio: expected %q or %q but got %q", PortAudio, NoAudio, c.Audio)
+			}
+		case "pause_unfocused":
+			c.PauseUnfocused, err = t.bool(key)
+		case "save_dir":
+			c.SaveDir, err = t.string(key)
+			c.SaveDir = expandHome(c.SaveDir)
+		case "save_backups":
+			c.SaveBackups, err = t.int(key, 0, 100)
+		case "rewind_buffer":
+			c.RewindBuffer, err = t.int(key, 0, 4096)
+		case "palette":
+			c.Palette, err = t.palette(key)
+		case "force_dmg":
+			c.ForceDMG, err = t.bool(key)
+		case "colorize":
+			c.Colorize, err = t.string(key)
+		case "color_correction":
+			c.ColorCorrection, err = t.string(key)
+			if err == nil && c.ColorCorrection != "raw" && c.ColorCorrection != "cgb" && c.ColorCorrection != "gba" {
+				err = fmt.Errorf("color_correction: expected \"raw\", \"cgb\" or \"gba\" but got %q", c.ColorCorrection)
+			}
+		case "sgb":
+			c.SGB, err = t.bool(key)
+		case "intro":
+			c.Intro, err = t.bool(key)
+		case "discord_app_id":
+			c.DiscordAppID, err = t.string(key)
+		case "dat":
+			c.DAT, err = t.string(key)
+			c.DAT = expandHome(c.DAT)
+		default:
+			err = fmt.Errorf("unknown setting %s", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) bindKeys(t table) error {
+	for _, action := range sortedKeys(t) {
+		if !isAction(action) {
+			return fmt.Errorf("keys: unknown action %s, expected one of %s", action, strings.Join(Actions, ", "))
+		}
+		key, err := t.string(action)
+		if err != nil {
+			return fmt.Errorf("keys: %v", err)
+		}
+		c.Keys[action] = key
+	}
+	return nil
+}
+
+// addGame reads the overrides for the game with a title or hash
+func (c *Config) addGame(name string, t table) error {
+	var game Game
+	for _, key := range sortedKeys(t) {
+		var err error
+		switch key {
+		case "fast_forward_speed":
+			game.FastForwardSpeed, err = t.int(key, 2, 64)
+		case "palette":
+			game.Palette, err = t.palette(key)
+		case "force_dmg":
+			game.ForceDMG, err = t.bool(key)
+		case "colorize":
+			game.Colorize, err = t.string(key)
+		case "sgb":
+			game.SGB, err = t.bool(key)
+		case "cheats":
+			game.Cheats, err = t.strings(key)
+		default:
+			err = fmt.Errorf("unknown setting %s", key)
+		}
+		if err != nil {
+			return fmt.Errorf("game %q: %v", name, err)
+		}
+	}
+	if c.Games == nil {
+		c.Games = map[string]Game{}
+	}
+	c.Games[strings.ToLower(name)] = game
+	return nil
+}
+
+func isAction(name string) bool {
+	for _, action := range Actions {
+		if action == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t table) int(key string, min, max int) (int, error) {
+	n, ok := t[key].(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: expected a number", key)
+	}
+	if n < int64(min) || n > int64(max) {
+		return 0, fmt.Errorf("%s: expected a number from %d to %d but got %d", key, min, max, n)
+	}
+	return int(n), nil
+}
+
+func (t table) bool(key string) (bool, error) {
+	b, ok := t[key].(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: expected true or false", key)
+	}
+	return b, nil
+}
+
+func (t table) string(key string) (string, error) {
+	s, ok := t[key].(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string", key)
+	}
+	return s, nil
+}
+
+func (t table) strings(key string) ([]string, error) {
+	values, ok := t[key].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list of strings", key)
+	}
+	var ss []string
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a list of strings", key)
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// palette reads four colours in #rrggbb format
+func (t table) palette(key string) (*[4]color.RGBA, error) {
+	values, ok := t[key].([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("%s: expected four colours from lightest to darkest", key)
+	}
+	var palette [4]color.RGBA
+	for i, value := range values {
+		s, _ := value.(string)
+		var r, g, b uint8
+		if n, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil || n != 3 || len(s) != 7 {
+			return nil, fmt.Errorf("%s: expected a colour such as \"#88c070\" but got %v", key, value)
+		}
+		palette[i] = color.RGBA{r, g, b, 0xff}
+	}
+	return &palette, nil
+}
+
+func sortedKeys(t table) []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(`# Settings
+scale = 4
+rotate = 270
+mirror = true
+audio = "none" # no sound
+pause_unfocused = true
+save_backups = 10
+rewind_buffer = 0
+discord_app_id = "1234"
+dat = "/dats/gb.dat"
+intro = true
+palette = ["#e0f8d0", "#88c070", "#346856", '#081820']
+
+[keys]
+start = "Enter"
+screenshot = "F12"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Scale = 4
+	expected.Rotate = 270
+	expected.Mirror = true
+	expected.Audio = NoAudio
+	expected.PauseUnfocused = true
+	expected.SaveBackups = 10
+	expected.RewindBuffer = 0
+	expected.DiscordAppID = "1234"
+	expected.DAT = "/dats/gb.dat"
+	expected.Intro = true
+	expected.Palette = &[4]color.RGBA{{0xe0, 0xf8, 0xd0, 0xff}, {0x88, 0xc0, 0x70, 0xff}, {0x34, 0x68, 0x56, 0xff}, {0x08, 0x18, 0x20, 0xff}}
+	expected.Keys["start"] = "Enter"
+	expected.Keys["screenshot"] = "F12"
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %+v but got %+v", expected, c)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"scale = 0",
+		"scale = \"big\"",
+		"rotate = 45",
+		"save_backups = -1",
+		"rewind_buffer = -1",
+		"rotate = 360",
+		"speed = 2",
+		"audio = \"alsa\"",
+		"color_correction = \"vivid\"",
+		"palette = [\"#ffffff\"]",
+		"palette = [\"#ffffff\", \"#aaaaaa\", \"#777777\", \"black\"]",
+		"scale = 2\nscale = 3",
+		"[keys]\njump = \"Space\"",
+		"[sound]",
+		"save_dir = \"unterminated",
+	} {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tetromino-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := Load(filepath.Join(dir, "missing.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, Default()) {
+		t.Errorf("expected the default settings but got %+v", c)
+	}
+	filename := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(filename, []byte("save_dir = \"~/saves\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	if c.SaveDir != filepath.Join(home, "saves") {
+		t.Errorf("expected the save directory under %s but got %s", home, c.SaveDir)
+	}
+}
+
+func TestForGame(t *testing.T) {
+	c, err := Parse(strings.NewReader(`fast_forward_speed = 8
+
+[game."Tetris"]
+cheats = ["010138CD"]
+force_dmg = true
+
+[game.30DEF8804393401F7EA68A6A34D783966C69A6CF]
+fast_forward_speed = 2
+cheats = ["00A-17B-C49"]
+colorize = "up+a"
+palette = ["#ffffff", "#a0a0ff", "#5050c0", "#000040"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tetris := c.ForGame("TETRIS", "30def8804393401f7ea68a6a34d783966c69a6cf")
+	if tetris.FastForwardSpeed != 2 || tetris.Palette == nil || !tetris.ForceDMG || tetris.Colorize != "up+a" || !reflect.DeepEqual(tetris.Cheats, []string{"010138CD", "00A-17B-C49"}) {
+		t.Errorf("expected the overrides for both title and hash but got %+v", tetris)
+	}
+	other := c.ForGame("MARIOLAND", "0000")
+	if other.FastForwardSpeed != 8 || other.Palette != nil || other.ForceDMG || len(other.Cheats) != 0 {
+		t.Errorf("expected no overrides but got %+v", other)
+	}
+	if _, err := Parse(strings.NewReader("[game.Tetris]\nscale = 2")); err == nil {
+		t.Error("expected an error for a setting that can't be overridden per game")
+	}
+}
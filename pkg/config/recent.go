@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MaxRecent is the number of recently played ROMs that are remembered
+const MaxRecent = 10
+
+// Recent records when a ROM was last played
+type Recent struct {
+	Filename string    `json:"filename"`
+	Title    string    `json:"title,omitempty"`
+	Played   time.Time `json:"played"`
+}
+
+// RecentFilename returns the location of the list of recently played ROMs under the user's config
+// directory, or an empty string if there is no config directory
+func RecentFilename() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tetromino", "recent.json")
+}
+
+// LoadRecent reads the list of recently played ROMs, most recent first
+func LoadRecent(filename string) ([]Recent, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the recent ROMs at \"%s\" (%v)", filename, err)
+	}
+	var recent []Recent
+	if err := json.Unmarshal(data, &recent); err != nil {
+		return nil, fmt.Errorf("Failed to load the recent ROMs at \"%s\" (%v)", filename, err)
+	}
+	return recent, nil
+}
+
+// AddRecent moves a ROM to the top of the list of recently played ROMs, dropping the oldest once
+// there are more than MaxRecent
+func AddRecent(filename string, rom Recent) error {
+	if filename == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(rom.Filename)
+	if err != nil {
+		return err
+	}
+	rom.Filename = abs
+	recent, err := LoadRecent(filename)
+	if err != nil {
+		return err
+	}
+	updated := []Recent{rom}
+	for _, r := range recent {
+		if r.Filename != rom.Filename && len(updated) < MaxRecent {
+			updated = append(updated, r)
+		}
+	}
+	data, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
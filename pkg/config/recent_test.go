@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tetromino-recent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "tetromino", "recent.json")
+	for i := 0; i < MaxRecent+2; i++ {
+		rom := filepath.Join(dir, fmt.Sprintf("rom%d.gb", i%(MaxRecent+1)))
+		if err := AddRecent(filename, Recent{Filename: rom, Played: time.Unix(int64(i), 0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recent, err := LoadRecent(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != MaxRecent {
+		t.Fatalf("expected %d recent ROMs but got %d", MaxRecent, len(recent))
+	}
+	// The first ROM was played again last so it moves to the top rather than appearing twice
+	if recent[0].Filename != filepath.Join(dir, "rom0.gb") || recent[1].Filename != filepath.Join(dir, fmt.Sprintf("rom%d.gb", MaxRecent)) {
+		t.Errorf("unexpected order: %v", recent)
+	}
+}
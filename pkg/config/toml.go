@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// table holds the keys of one section of a TOML file
+type table map[string]interface{}
+
+// document holds each section of a TOML file keyed by its dotted name, with "" for the top level
+type document map[string]table
+
+// parseTOML reads the subset of TOML used for settings: [sections] with dotted and quoted names,
+// key = value pairs with strings, integers, booleans and single-line arrays of them, and comments
+func parseTOML(r io.Reader) (document, error) {
+	doc := document{"": table{}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: expected ] at the end of the section name", line)
+			}
+			names, err := splitKey(text[1 : len(text)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			section = strings.Join(names, ".")
+			if _, ok := doc[section]; ok {
+				return nil, fmt.Errorf("line %d: section [%s] is defined twice", line, section)
+			}
+			doc[section] = table{}
+			continue
+		}
+		eq := strings.Index(text, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		names, err := splitKey(text[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(names) != 1 {
+			return nil, fmt.Errorf("line %d: dotted keys are not supported", line)
+		}
+		value, err := parseValue(strings.TrimSpace(text[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, ok := doc[section][names[0]]; ok {
+			return nil, fmt.Errorf("line %d: %s is defined twice", line, names[0])
+		}
+		doc[section][names[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// stripComment removes a # comment that isn't inside a string
+func stripComment(text string) string {
+	var quote rune
+	for i, c := range text {
+		switch {
+		case quote == 0 && c == '#':
+			return text[:i]
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == c && (c == '\'' || text[i-1] != '\\'):
+			quote = 0
+		}
+	}
+	return text
+}
+
+// splitKey splits a dotted key such as game."Super Mario Land" into its parts
+func splitKey(key string) ([]string, error) {
+	var names []string
+	for _, part := range splitOutsideQuotes(key, '.') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("bad key %q", strings.TrimSpace(key))
+		}
+		if part[0] == '"' || part[0] == '\'' {
+			value, err := parseString(part)
+			if err != nil {
+				return nil, err
+			}
+			part = value
+		} else if strings.ContainsAny(part, " \t\"'=[]") {
+			return nil, fmt.Errorf("bad key %q", part)
+		}
+		names = append(names, part)
+	}
+	return names, nil
+}
+
+func parseValue(text string) (interface{}, error) {
+	switch {
+	case text == "":
+		return nil, fmt.Errorf("expected a value")
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case text[0] == '"' || text[0] == '\'':
+		return parseString(text)
+	case text[0] == '[':
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("expected ] at the end of the array")
+		}
+		values := []interface{}{}
+		for _, item := range splitOutsideQuotes(text[1:len(text)-1], ',') {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				// Allow a trailing comma
+				continue
+			}
+			value, err := parseValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+	n, err := strconv.ParseInt(strings.Replace(text, "_", "", -1), 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad value %s", text)
+	}
+	return n, nil
+}
+
+// parseString reads a "basic string" with escapes or a 'literal string' without them
+func parseString(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("unterminated string %s", text)
+	}
+	if text[0] == '\'' {
+		return text[1 : len(text)-1], nil
+	}
+	return strconv.Unquote(text)
+}
+
+// splitOutsideQuotes splits text at each separator that isn't inside a string
+func splitOutsideQuotes(text string, separator rune) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, c := range text {
+		switch {
+		case quote == 0 && c == separator:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == c && (c == '\'' || text[i-1] != '\\'):
+			quote = 0
+		}
+	}
+	return append(parts, text[start:])
+}
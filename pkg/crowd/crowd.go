@@ -0,0 +1,285 @@
+// Package crowd lets an audience play a game together, as on "Twitch Plays" streams. Viewers send
+// buttons as lines of text over a WebSocket, typically relayed from a chat by a bot, and the buttons
+// are pressed either by vote or in the order they arrive.
+package crowd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"golang.org/x/net/websocket"
+)
+
+// Mode is how the buttons sent by viewers are chosen between
+type Mode int
+
+const (
+	// Democracy presses the button with the most votes at the end of each window
+	Democracy Mode = iota
+	// Anarchy presses every button in the order they arrive
+	Anarchy
+)
+
+// ParseMode returns the mode with a name of "democracy" or "anarchy"
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(name) {
+	case "democracy":
+		return Democracy, nil
+	case "anarchy":
+		return Anarchy, nil
+	}
+	return 0, fmt.Errorf("unknown crowd mode %q: expected democracy or anarchy", name)
+}
+
+func (m Mode) String() string {
+	if m == Anarchy {
+		return "anarchy"
+	}
+	return "democracy"
+}
+
+// maxQueue is the most buttons waiting to be pressed in anarchy, beyond which more are dropped so
+// that the game keeps up with the chat
+const maxQueue = 32
+
+// Options configures a crowd
+type Options struct {
+	Mode Mode
+	// Window is the number of frames that votes are counted over in democracy
+	Window int
+	// Hold is the number of frames that each chosen button is held for
+	Hold int
+}
+
+// DefaultOptions counts votes over 5 seconds and holds buttons for about a tenth of a second
+var DefaultOptions = Options{Mode: Democracy, Window: 300, Hold: 6}
+
+// Status describes the votes and buttons waiting to be pressed, for stream overlays
+type Status struct {
+	Mode  string         `json:"mode"`
+	Votes map[string]int `json:"votes"`
+	// Queued is the number of buttons waiting to be pressed in anarchy
+	Queued int `json:"queued"`
+	// Last is the last button pressed
+	Last string `json:"last,omitempty"`
+}
+
+// Crowd presses the buttons chosen by viewers on a Gameboy
+type Crowd struct {
+	gameboy *gb.Gameboy
+	window  int
+	hold    int
+
+	mutex sync.Mutex
+	mode  Mode
+	votes map[string]vote
+	cast  int
+	queue []vote
+	last  string
+
+	// These are only used at the end of frames
+	holding   bool
+	held      gb.Button
+	release   int
+	windowEnd int
+}
+
+// vote is a viewer's latest vote and the order in which it was cast, which breaks ties
+type vote struct {
+	button gb.Button
+	name   string
+	order  int
+}
+
+// New returns a crowd that presses buttons on a Gameboy at the end of its frames
+func New(gameboy *gb.Gameboy, opts Options) *Crowd {
+	if opts.Window < 1 {
+		opts.Window = DefaultOptions.Window
+	}
+	if opts.Hold < 1 {
+		opts.Hold = DefaultOptions.Hold
+	}
+	c := &Crowd{
+		gameboy:   gameboy,
+		window:    opts.Window,
+		hold:      opts.Hold,
+		mode:      opts.Mode,
+		votes:     map[string]vote{},
+		windowEnd: opts.Window,
+	}
+	gameboy.OnFrame(c.frameEnd)
+	return c
+}
+
+// Send handles a line of text from a viewer, which is a button name such as "a" or "up". The viewer
+// may be given before the button as in "alice: a" when a bot relays a chat, otherwise the viewer is
+// the sender. In democracy each viewer has one vote in each window, so a new vote replaces the old.
+func (c *Crowd) Send(sender, text string) error {
+	viewer := sender
+	if i := strings.Index(text, ":"); i >= 0 {
+		viewer = strings.TrimSpace(text[:i])
+		text = text[i+1:]
+	}
+	name := strings.ToLower(strings.TrimSpace(text))
+	button, err := gb.ParseButton(name)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch c.mode {
+	case Democracy:
+		c.votes[viewer] = vote{button: button, name: name, order: c.cast}
+		c.cast++
+	case Anarchy:
+		if len(c.queue) < maxQueue {
+			c.queue = append(c.queue, vote{button: button, name: name})
+		}
+	}
+	return nil
+}
+
+// SetMode switches between democracy and anarchy, discarding votes and queued buttons
+func (c *Crowd) SetMode(mode Mode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.mode = mode
+	c.votes = map[string]vote{}
+	c.queue = nil
+}
+
+// Status returns the current votes and queue
+func (c *Crowd) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status := Status{Mode: c.mode.String(), Votes: map[string]int{}, Queued: len(c.queue), Last: c.last}
+	for _, v := range c.votes {
+		status.Votes[v.name]++
+	}
+	return status
+}
+
+// frameEnd releases the button once it has been held long enough and otherwise chooses the next one,
+// so that there is a frame between buttons for games to see the same button pressed twice
+func (c *Crowd) frameEnd(_ *image.RGBA) {
+	frame := c.gameboy.FrameCount()
+	if c.holding {
+		if frame >= c.release {
+			c.gameboy.ButtonAction(c.held, false)
+			c.holding = false
+		}
+		return
+	}
+	button, ok := c.next(frame)
+	if !ok {
+		return
+	}
+	c.gameboy.ButtonAction(button, true)
+	c.holding = true
+	c.held = button
+	c.release = frame + c.hold
+}
+
+// next returns the button to press next, if any
+func (c *Crowd) next(frame int) (gb.Button, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch c.mode {
+	case Democracy:
+		if frame < c.windowEnd {
+			return 0, false
+		}
+		c.windowEnd = frame + c.window
+		winner, ok := c.tally()
+		c.votes = map[string]vote{}
+		if ok {
+			c.last = winner.name
+		}
+		return winner.button, ok
+	case Anarchy:
+		if len(c.queue) == 0 {
+			return 0, false
+		}
+		next := c.queue[0]
+		c.queue = c.queue[1:]
+		c.last = next.name
+		return next.button, true
+	}
+	return 0, false
+}
+
+// tally returns the button with the most votes, breaking ties in favour of the button voted for first
+func (c *Crowd) tally() (vote, bool) {
+	counts := map[gb.Button]int{}
+	first := map[gb.Button]vote{}
+	for _, v := range c.votes {
+		counts[v.button]++
+		if f, ok := first[v.button]; !ok || v.order < f.order {
+			first[v.button] = v
+		}
+	}
+	var winner vote
+	for button, v := range first {
+		if winner.name == "" || counts[button] > counts[winner.button] || counts[button] == counts[winner.button] && v.order < winner.order {
+			winner = v
+		}
+	}
+	return winner, winner.name != ""
+}
+
+// ListenAndServe serves the WebSocket on an HTTP address until the context is done
+func (c *Crowd) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: c.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	c.gameboy.Logger().With("crowd").Infof("Crowd play listening at ws://%s/ws", addr)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the HTTP handler that accepts buttons over a WebSocket at /ws and reports the
+// status as JSON at /status
+func (c *Crowd) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(c.serveWebSocket))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+	})
+	return mux
+}
+
+// serveWebSocket reads a button from each line of each message, replying to lines that aren't buttons
+func (c *Crowd) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	sender := ws.Request().RemoteAddr
+	for {
+		var message string
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(strings.NewReader(message))
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			if err := c.Send(sender, scanner.Text()); err != nil {
+				if err := websocket.Message.Send(ws, err.Error()); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
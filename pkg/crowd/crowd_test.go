@@ -0,0 +1,100 @@
+package crowd
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"golang.org/x/net/websocket"
+)
+
+// press records the buttons pressed on a Gameboy instead of pressing them
+type press struct {
+	frame   int
+	button  gb.Button
+	pressed bool
+}
+
+func newGameboy(t *testing.T) (*gb.Gameboy, *[]press) {
+	t.Helper()
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var presses []press
+	gameboy.InterceptButtons(func(player int, button gb.Button, pressed bool) {
+		presses = append(presses, press{gameboy.FrameCount(), button, pressed})
+	})
+	return gameboy, &presses
+}
+
+func TestDemocracy(t *testing.T) {
+	gameboy, presses := newGameboy(t)
+	c := New(gameboy, Options{Mode: Democracy, Window: 10, Hold: 2})
+	for _, line := range []string{"alice: b", "bob: a", "carol: a", "dave:start", "alice: start"} {
+		if err := c.Send("bot", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send("bot", "erin: jump"); err == nil {
+		t.Error("expected an error for an unknown button")
+	}
+	if status := c.Status(); !reflect.DeepEqual(status.Votes, map[string]int{"a": 2, "start": 2}) {
+		t.Errorf("unexpected votes %v", status.Votes)
+	}
+	gameboy.RunFrames(15)
+	// A and Start tie so the first voted for wins
+	expected := []press{{10, gb.A, true}, {12, gb.A, false}}
+	if !reflect.DeepEqual(*presses, expected) {
+		t.Errorf("expected %v but got %v", expected, *presses)
+	}
+	if status := c.Status(); len(status.Votes) != 0 || status.Last != "a" {
+		t.Errorf("expected the votes to be cleared after pressing A but got %+v", status)
+	}
+}
+
+func TestAnarchy(t *testing.T) {
+	gameboy, presses := newGameboy(t)
+	c := New(gameboy, Options{Mode: Anarchy, Hold: 1})
+	for _, line := range []string{"up", "up", "a"} {
+		if err := c.Send("viewer", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gameboy.RunFrames(10)
+	expected := []press{
+		{0, gb.Up, true}, {1, gb.Up, false},
+		{2, gb.Up, true}, {3, gb.Up, false},
+		{4, gb.A, true}, {5, gb.A, false},
+	}
+	if !reflect.DeepEqual(*presses, expected) {
+		t.Errorf("expected %v but got %v", expected, *presses)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	gameboy, _ := newGameboy(t)
+	c := New(gameboy, DefaultOptions)
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := websocket.Message.Send(ws, "alice: start\nbob: start\n\nselect\nfly"); err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := websocket.Message.Receive(ws, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply, "fly") {
+		t.Errorf("expected an error about the unknown button but got %q", reply)
+	}
+	if status := c.Status(); !reflect.DeepEqual(status.Votes, map[string]int{"start": 2, "select": 1}) {
+		t.Errorf("unexpected votes %v", status.Votes)
+	}
+}
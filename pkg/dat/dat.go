@@ -0,0 +1,130 @@
+// Package dat reads DAT files of known ROM dumps, such as those published by No-Intro, so that a ROM
+// can be checked against them. A ROM that isn't a known good dump explains many glitches that would
+// otherwise be blamed on the emulator.
+package dat
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Entry is a ROM listed in a DAT
+type Entry struct {
+	// Game is the name of the game, such as "Tetris (World) (Rev 1)"
+	Game string
+	// Name is the filename of the ROM
+	Name string
+	Size int
+	CRC  string
+	SHA1 string
+	// Status is "verified", "baddump" or "nodump", or empty for a dump that has not been verified
+	Status string
+}
+
+// BadDump returns true if the DAT lists the ROM as a bad dump
+func (e Entry) BadDump() bool {
+	return e.Status == "baddump" || strings.Contains(e.Game, "[b]")
+}
+
+// DAT holds the ROMs listed in a DAT file, keyed by hash
+type DAT struct {
+	// Name is the name of the DAT from its header, such as "Nintendo - Game Boy"
+	Name   string
+	bySHA1 map[string]Entry
+	byCRC  map[string]Entry
+}
+
+// Parse reads a DAT in the Logiqx XML format used by No-Intro and clrmamepro
+func Parse(r io.Reader) (*DAT, error) {
+	var doc struct {
+		Header struct {
+			Name string `xml:"name"`
+		} `xml:"header"`
+		Games []struct {
+			Name string `xml:"name,attr"`
+			ROMs []struct {
+				Name   string `xml:"name,attr"`
+				Size   string `xml:"size,attr"`
+				CRC    string `xml:"crc,attr"`
+				SHA1   string `xml:"sha1,attr"`
+				Status string `xml:"status,attr"`
+			} `xml:"rom"`
+		} `xml:"game"`
+	}
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	d := &DAT{Name: doc.Header.Name, bySHA1: map[string]Entry{}, byCRC: map[string]Entry{}}
+	for _, game := range doc.Games {
+		for _, rom := range game.ROMs {
+			size, err := strconv.Atoi(rom.Size)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad size %q", game.Name, rom.Size)
+			}
+			entry := Entry{
+				Game:   game.Name,
+				Name:   rom.Name,
+				Size:   size,
+				CRC:    strings.ToLower(rom.CRC),
+				SHA1:   strings.ToLower(rom.SHA1),
+				Status: rom.Status,
+			}
+			if entry.SHA1 != "" {
+				d.bySHA1[entry.SHA1] = entry
+			}
+			if entry.CRC != "" {
+				d.byCRC[crcKey(entry.CRC, size)] = entry
+			}
+		}
+	}
+	if len(d.bySHA1) == 0 && len(d.byCRC) == 0 {
+		return nil, fmt.Errorf("no ROMs found")
+	}
+	return d, nil
+}
+
+// Load reads a DAT file
+func Load(filename string) (*DAT, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the DAT file at \"%s\" (%v)", filename, err)
+	}
+	defer f.Close()
+	d, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the DAT file at \"%s\" (%v)", filename, err)
+	}
+	return d, nil
+}
+
+func crcKey(crc string, size int) string {
+	return fmt.Sprintf("%s:%d", crc, size)
+}
+
+// Lookup finds a ROM by its SHA-1, or by its CRC32 and size for DATs without SHA-1 hashes
+func (d *DAT) Lookup(rom []byte) (Entry, bool) {
+	if entry, ok := d.bySHA1[fmt.Sprintf("%x", sha1.Sum(rom))]; ok {
+		return entry, true
+	}
+	entry, ok := d.byCRC[crcKey(fmt.Sprintf("%08x", crc32.ChecksumIEEE(rom)), len(rom))]
+	return entry, ok
+}
+
+// Check describes whether a ROM is a known good dump, a known bad dump or not listed in the DAT, in
+// words that follow "The ROM is", returning true only for a good dump
+func (d *DAT) Check(rom []byte) (string, bool) {
+	entry, ok := d.Lookup(rom)
+	switch {
+	case !ok:
+		return fmt.Sprintf("unknown to %s, so it may be a bad dump, a hack or homebrew", d.Name), false
+	case entry.BadDump():
+		return fmt.Sprintf("a bad dump of %s, which may glitch or crash", entry.Game), false
+	}
+	return fmt.Sprintf("a good dump of %s", entry.Game), true
+}
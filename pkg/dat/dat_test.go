@@ -0,0 +1,52 @@
+package dat
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	good := []byte("good rom")
+	bad := []byte("bad rom")
+	crcOnly := []byte("crc only")
+	d, err := Parse(strings.NewReader(fmt.Sprintf(`<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Game Boy</name></header>
+	<game name="Good (World)"><rom name="Good (World).gb" size="8" crc="00000000" sha1="%X" status="verified"/></game>
+	<game name="Bad (World)"><rom name="Bad (World).gb" size="7" crc="00000000" sha1="%x" status="baddump"/></game>
+	<game name="Old (World)"><rom name="Old (World).gb" size="8" crc="%08X"/></game>
+</datafile>`, sha1.Sum(good), sha1.Sum(bad), crc32.ChecksumIEEE(crcOnly))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		rom     []byte
+		message string
+		good    bool
+	}{
+		{good, "a good dump of Good (World)", true},
+		{bad, "a bad dump of Bad (World), which may glitch or crash", false},
+		{crcOnly, "a good dump of Old (World)", true},
+		{[]byte("homebrew"), "unknown to Nintendo - Game Boy, so it may be a bad dump, a hack or homebrew", false},
+	} {
+		message, ok := d.Check(test.rom)
+		if message != test.message || ok != test.good {
+			t.Errorf("%s: expected %q (%v) but got %q (%v)", test.rom, test.message, test.good, message, ok)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"not xml",
+		"<datafile></datafile>",
+		`<datafile><game name="x"><rom name="x.gb" size="big" crc="00000000"/></game></datafile>`,
+	} {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
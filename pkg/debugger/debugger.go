@@ -0,0 +1,456 @@
+// Package debugger implements an interactive terminal debugger for the Gameboy
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/cheat"
+)
+
+const help = `Commands (addresses and values are hexadecimal, or symbols when a symbol file is loaded):
+  s [n]            step n instructions (default 1)
+  n                step over a call, running until it returns
+  o                step out of the current routine, running until it returns
+  c                continue until a breakpoint (press enter to stop)
+  b <addr> [cond]  set a breakpoint, optionally only stopping when a condition is true
+                   e.g. b 0150 A==0x3C && [0xC0A0]>5
+  d <addr>         delete a breakpoint
+  bi <name> [off]  break when an interrupt is dispatched: vblank, stat, timer, serial or joypad
+  bb [off]         break when the ROM or RAM bank changes
+  bl <line> [mode] break when LY reaches a decimal line, optionally once the LCD is in mode 0-3
+  bl off           stop breaking on a scanline
+  m <addr>         show memory from an address
+  w <addr> <value> write a byte to memory
+  f [n]            run n frames (default 1)
+  tile <n> <x> <y> <colour>
+                   set a pixel in tile n (0-17f) of video RAM to colour 0-3
+  pal <name> <value>
+                   set the bgp, obp0 or obp1 palette register
+  t                dump the recently executed instructions to a file
+  watch <expr>     show the value of an expression, address or symbol sampled at every V-Blank
+  unwatch <expr>   stop watching an expression
+  search <op>      search RAM for cheats: start, exact <value>, inc, dec, same, changed
+  h                show this help
+  q                quit the debugger and keep the emulator running
+`
+
+// Debugger is an interactive terminal debugger
+type Debugger struct {
+	gameboy *gb.Gameboy
+	out     io.Writer
+	lines   chan string
+	pending []string
+	memory  uint16
+	search  *cheat.Search
+	message string
+}
+
+// New returns a debugger that reads commands from in and draws to out
+func New(gameboy *gb.Gameboy, in io.Reader, out io.Writer) *Debugger {
+	d := &Debugger{
+		gameboy: gameboy,
+		out:     out,
+		lines:   make(chan string),
+		memory:  0xc000,
+		message: "Type h for help",
+	}
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			d.lines <- scanner.Text()
+		}
+		close(d.lines)
+	}()
+	return d
+}
+
+// Run shows the debugger and handles commands until the user quits or the context is done. The
+// emulator is paused while the debugger waits for a command.
+func (d *Debugger) Run(ctx context.Context) {
+	for {
+		d.draw()
+		var line string
+		if len(d.pending) > 0 {
+			line, d.pending = d.pending[0], d.pending[1:]
+		} else {
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case line, ok = <-d.lines:
+				if !ok {
+					return
+				}
+			}
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "q" {
+			return
+		}
+		d.message = ""
+		if err := d.handle(ctx, fields[0], fields[1:]); err != nil {
+			d.message = err.Error()
+		}
+	}
+}
+
+func (d *Debugger) handle(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "s":
+		n, err := optionalCount(args)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			d.gameboy.Step()
+		}
+	case "c":
+		d.run(ctx, d.gameboy.Continue)
+	case "n":
+		d.run(ctx, d.gameboy.StepOver)
+	case "o":
+		var err error
+		d.run(ctx, func(ctx context.Context) bool {
+			var stopped bool
+			stopped, err = d.gameboy.StepOut(ctx)
+			return stopped
+		})
+		return err
+	case "b":
+		addr, err := d.address(args, 0)
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			return d.gameboy.SetConditionalBreakpoint(addr, strings.Join(args[1:], " "))
+		}
+		d.gameboy.SetBreakpoint(addr)
+	case "bi":
+		if len(args) == 0 {
+			return fmt.Errorf("expected an interrupt name")
+		}
+		interrupt, err := gb.ParseInterrupt(args[0])
+		if err != nil {
+			return err
+		}
+		d.gameboy.SetBreakOnInterrupt(interrupt, !off(args[1:]))
+	case "bb":
+		d.gameboy.SetBreakOnBankSwitch(!off(args))
+	case "bl":
+		return d.breakOnScanline(args)
+	case "d":
+		addr, err := d.address(args, 0)
+		if err != nil {
+			return err
+		}
+		if !d.gameboy.ClearBreakpoint(addr) {
+			return fmt.Errorf("no breakpoint at 0x%04x", addr)
+		}
+	case "m":
+		addr, err := d.address(args, 0)
+		if err != nil {
+			return err
+		}
+		d.memory = addr
+	case "w":
+		addr, err := d.address(args, 0)
+		if err != nil {
+			return err
+		}
+		value, err := byteValue(args, 1)
+		if err != nil {
+			return err
+		}
+		d.gameboy.WriteMemory(addr, value)
+	case "f":
+		n, err := optionalCount(args)
+		if err != nil {
+			return err
+		}
+		d.gameboy.RunFrames(n)
+	case "tile":
+		return d.setTilePixel(args)
+	case "pal":
+		if len(args) == 0 {
+			return fmt.Errorf("expected a palette name")
+		}
+		value, err := byteValue(args, 1)
+		if err != nil {
+			return err
+		}
+		return d.gameboy.SetPalette(args[0], value)
+	case "t":
+		filename, err := d.gameboy.DumpTrace()
+		if err != nil {
+			return err
+		}
+		d.message = "Trace written to " + filename
+	case "watch":
+		return d.gameboy.AddWatch(strings.Join(args, " "))
+	case "unwatch":
+		if !d.gameboy.RemoveWatch(strings.Join(args, " ")) {
+			return fmt.Errorf("not watching %q", strings.Join(args, " "))
+		}
+	case "search":
+		return d.cheatSearch(args)
+	case "h":
+		d.message = help
+	default:
+		return fmt.Errorf("unknown command %q, type h for help", command)
+	}
+	return nil
+}
+
+// run executes the emulator until it stops by itself or the user presses enter
+func (d *Debugger) run(ctx context.Context, execute func(context.Context) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	input := make(chan string, 1)
+	go func() {
+		// Any input stops the emulator
+		select {
+		case line, ok := <-d.lines:
+			if ok {
+				input <- line
+			}
+			cancel()
+		case <-ctx.Done():
+		}
+		close(input)
+	}()
+	fmt.Fprintln(d.out, "Running... press enter to stop")
+	hit := execute(ctx)
+	cancel()
+	line, ok := <-input
+	if hit {
+		d.message = d.gameboy.StopReason()
+		// Input that arrived just as the emulator stopped is the next command
+		if ok {
+			d.pending = append(d.pending, line)
+		}
+	} else {
+		d.message = "Stopped"
+	}
+}
+
+func (d *Debugger) breakOnScanline(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a line")
+	}
+	if off(args) {
+		return d.gameboy.SetBreakOnScanline(nil)
+	}
+	line, err := strconv.ParseUint(args[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("bad line %q", args[0])
+	}
+	mode := -1
+	if len(args) > 1 {
+		mode, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad mode %q", args[1])
+		}
+	}
+	return d.gameboy.SetBreakOnScanline(&gb.ScanlineBreak{Line: uint8(line), Mode: mode})
+}
+
+func (d *Debugger) setTilePixel(args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("expected a tile, x, y and colour")
+	}
+	var values [4]int
+	for i, arg := range args {
+		value, err := parseNumber(arg, 16)
+		if err != nil {
+			return fmt.Errorf("bad value %q", arg)
+		}
+		values[i] = int(value)
+	}
+	return d.gameboy.SetTilePixel(values[0], values[1], values[2], uint8(values[3]))
+}
+
+func (d *Debugger) cheatSearch(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a search operation")
+	}
+	if args[0] == "start" {
+		d.search = d.gameboy.StartCheatSearch()
+		d.message = "Search started"
+		return nil
+	}
+	if d.search == nil {
+		return fmt.Errorf("start a search first")
+	}
+	var count int
+	switch args[0] {
+	case "exact":
+		value, err := byteValue(args, 1)
+		if err != nil {
+			return err
+		}
+		count = d.search.Exact(value)
+	case "inc":
+		count = d.search.Increased()
+	case "dec":
+		count = d.search.Decreased()
+	case "same":
+		count = d.search.Unchanged()
+	case "changed":
+		count = d.search.Changed()
+	default:
+		return fmt.Errorf("unknown search operation %q", args[0])
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d candidates", count)
+	for _, result := range d.search.Results(10) {
+		fmt.Fprintf(&b, "\n  0x%04x: %02x -> %02x", result.Address, result.Previous, result.Current)
+	}
+	d.message = b.String()
+	return nil
+}
+
+func (d *Debugger) draw() {
+	r := d.gameboy.Registers()
+	breakpoints := map[uint16]bool{}
+	for _, addr := range d.gameboy.Breakpoints() {
+		breakpoints[addr] = true
+	}
+
+	// Clear the terminal and move to the top left
+	fmt.Fprint(d.out, "\x1b[H\x1b[2J")
+
+	fmt.Fprintf(d.out, "Frame %d  Instructions %d\n\n", d.gameboy.FrameCount(), d.gameboy.Instructions())
+
+	fmt.Fprintln(d.out, "Registers")
+	fmt.Fprintf(d.out, "  AF %02x%02x  BC %02x%02x  DE %02x%02x  HL %02x%02x  SP %04x  PC %04x\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC)
+	fmt.Fprintf(d.out, "  Flags %s  IME %t  Halted %t  Stopped %t\n\n", flags(r.F), r.IME, r.Halted, r.Stopped)
+
+	fmt.Fprintln(d.out, "Timer")
+	fmt.Fprintf(d.out, "  %s\n\n", d.gameboy.TimerState())
+
+	fmt.Fprintln(d.out, "Disassembly")
+	addr := r.PC
+	for i := 0; i < 10; i++ {
+		instruction := d.gameboy.Disassemble(addr)
+		marker := " "
+		if breakpoints[addr] {
+			marker = "*"
+		}
+		if addr == r.PC {
+			marker += ">"
+		} else {
+			marker += " "
+		}
+		fmt.Fprintf(d.out, "%s %s\n", marker, instruction)
+		addr += uint16(len(instruction.Bytes))
+	}
+
+	fmt.Fprintln(d.out, "\nBreakpoints")
+	for _, addr := range d.gameboy.Breakpoints() {
+		fmt.Fprintf(d.out, "  0x%04x %s\n", addr, d.gameboy.BreakpointCondition(addr))
+	}
+	if scanline := d.gameboy.BreakOnScanline(); scanline != nil {
+		fmt.Fprintf(d.out, "  %s\n", scanline)
+	}
+
+	if watches := d.gameboy.Watches(); len(watches) > 0 {
+		fmt.Fprintln(d.out, "\nWatches")
+		for _, w := range watches {
+			fmt.Fprintf(d.out, "  %-24s %d (0x%02x)\n", w.Expression, w.Value, w.Value)
+		}
+	}
+
+	if calls := d.gameboy.CallStack(); len(calls) > 0 {
+		fmt.Fprintln(d.out, "\nCalls")
+		for i := len(calls) - 1; i >= 0 && i >= len(calls)-4; i-- {
+			fmt.Fprintf(d.out, "  %s\n", calls[i])
+		}
+	}
+
+	fmt.Fprintln(d.out, "\nStack")
+	for i := uint16(0); i < 8; i += 2 {
+		sp := r.SP + i
+		fmt.Fprintf(d.out, "  0x%04x: %02x%02x\n", sp, d.gameboy.PeekMemory(sp+1), d.gameboy.PeekMemory(sp))
+	}
+
+	fmt.Fprintln(d.out, "\nMemory")
+	for row := uint16(0); row < 8; row++ {
+		start := d.memory + row*16
+		fmt.Fprintf(d.out, "  0x%04x:", start)
+		for i := uint16(0); i < 16; i++ {
+			fmt.Fprintf(d.out, " %02x", d.gameboy.PeekMemory(start+i))
+		}
+		fmt.Fprintln(d.out)
+	}
+
+	if d.message != "" {
+		fmt.Fprintf(d.out, "\n%s\n", d.message)
+	}
+	fmt.Fprint(d.out, "\n> ")
+}
+
+func flags(f uint8) string {
+	var b strings.Builder
+	for i, name := range "ZNHC" {
+		if f&(0x80>>uint(i)) != 0 {
+			b.WriteRune(name)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+func parseNumber(s string, bits int) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "$")
+	return strconv.ParseUint(s, 16, bits)
+}
+
+func (d *Debugger) address(args []string, i int) (uint16, error) {
+	if len(args) <= i {
+		return 0, fmt.Errorf("expected an address")
+	}
+	if addr, ok := d.gameboy.Symbol(args[i]); ok {
+		return addr, nil
+	}
+	addr, err := parseNumber(args[i], 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", args[i])
+	}
+	return uint16(addr), nil
+}
+
+func byteValue(args []string, i int) (uint8, error) {
+	if len(args) <= i {
+		return 0, fmt.Errorf("expected a value")
+	}
+	value, err := parseNumber(args[i], 8)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", args[i])
+	}
+	return uint8(value), nil
+}
+
+func off(args []string) bool {
+	return len(args) > 0 && args[0] == "off"
+}
+
+func optionalCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad count %q", args[0])
+	}
+	return n, nil
+}
@@ -0,0 +1,56 @@
+package debugger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+func TestCommands(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := strings.Join([]string{
+		"s 2",
+		"b 0108 A==0x3c",
+		"b 0110 PC==0x110 && [0xc000]==0",
+		"c",
+		"watch 0xc000",
+		"search start",
+		"w c000 42",
+		"search exact 42",
+		"d 0108",
+		"d 0110",
+		"bi vblank",
+		"w ff0f 00",
+		"w ffff 01",
+		"w ff40 80",
+		"bl 144 1",
+		"c",
+		"bl off",
+		"c",
+		"tile 1 0 0 3",
+		"pal obp0 e4",
+		"q",
+	}, "\n")
+	var out bytes.Buffer
+	New(gameboy, strings.NewReader(commands), &out).Run(context.Background())
+	if pc := gameboy.Registers().PC; pc != 0x0040 {
+		t.Errorf("expected to stop at the VBlank handler at 0x0040 but PC is 0x%04x", pc)
+	}
+	if gameboy.PeekMemory(0x8010) != 0x80 || gameboy.PeekMemory(0x8011) != 0x80 || gameboy.PeekMemory(0xff48) != 0xe4 {
+		t.Errorf("expected the tile pixel and palette to be written")
+	}
+	if len(gameboy.Breakpoints()) != 0 {
+		t.Errorf("expected the breakpoint to be deleted but got %v", gameboy.Breakpoints())
+	}
+	for _, expected := range []string{"Breakpoint at 0x0110", "1 candidates", "VBlank interrupt", "Reached scanline 144 in mode 1", "0xc000                   66 (0x42)", "0xc000: 00 -> 42", "*> 0x0110: 00"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package discord shows what is being played on the user's Discord profile with Rich Presence, by
+// talking to the Discord app running on the same computer over its local IPC socket
+package discord
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Opcodes of the frames exchanged with Discord
+const (
+	opHandshake = 0
+	opFrame     = 1
+	opClose     = 2
+)
+
+// Activity is what the user is doing, as shown on their profile
+type Activity struct {
+	// Details is the first line, such as the game's title
+	Details string
+	// State is the second line, such as "Playing"
+	State string
+	// Start is when the activity started, from which Discord shows the time elapsed
+	Start time.Time
+}
+
+// Client is a connection to the Discord app
+type Client struct {
+	conn  io.ReadWriteCloser
+	nonce int
+}
+
+// Dial connects to the Discord app as the application registered with Discord with an ID, whose name
+// is shown above the activity
+func Dial(appID string) (*Client, error) {
+	var conn io.ReadWriteCloser
+	var err error
+	// Each running Discord app listens on the first free socket of 10
+	for i := 0; i < 10; i++ {
+		conn, err = dialIPC(i)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Discord is not running: %v", err)
+	}
+	c := &Client{conn: conn}
+	if err := c.handshake(appID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) handshake(appID string) error {
+	if err := c.write(opHandshake, map[string]interface{}{"v": 1, "client_id": appID}); err != nil {
+		return err
+	}
+	// Discord replies with a READY event
+	_, err := c.read()
+	return err
+}
+
+// SetActivity shows an activity on the user's profile. Discord only shows one update every 15
+// seconds, so more frequent updates are delayed.
+func (c *Client) SetActivity(activity Activity) error {
+	a := map[string]interface{}{
+		"details": activity.Details,
+		"state":   activity.State,
+	}
+	if !activity.Start.IsZero() {
+		a["timestamps"] = map[string]int64{"start": activity.Start.Unix()}
+	}
+	return c.command("SET_ACTIVITY", map[string]interface{}{"pid": os.Getpid(), "activity": a})
+}
+
+// ClearActivity removes the activity from the user's profile
+func (c *Client) ClearActivity() error {
+	return c.command("SET_ACTIVITY", map[string]interface{}{"pid": os.Getpid()})
+}
+
+// Close disconnects from Discord, which also removes the activity
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// response is a frame sent by Discord, which reports errors with an ERROR event
+type response struct {
+	Cmd  string `json:"cmd"`
+	Evt  string `json:"evt"`
+	Data struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"data"`
+}
+
+func (c *Client) command(cmd string, args interface{}) error {
+	c.nonce++
+	err := c.write(opFrame, map[string]interface{}{"cmd": cmd, "args": args, "nonce": strconv.Itoa(c.nonce)})
+	if err != nil {
+		return err
+	}
+	r, err := c.read()
+	if err != nil {
+		return err
+	}
+	if r.Evt == "ERROR" {
+		return fmt.Errorf("Discord rejected %s: %s", cmd, r.Data.Message)
+	}
+	return nil
+}
+
+// write sends a frame, which is the opcode and length of the JSON payload followed by the payload
+func (c *Client) write(op uint32, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 8+len(data))
+	binary.LittleEndian.PutUint32(frame, op)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(data)))
+	copy(frame[8:], data)
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+// read receives a frame, returning an error when Discord closes the connection
+func (c *Client) read() (response, error) {
+	var r response
+	var header [8]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return r, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, err
+	}
+	if binary.LittleEndian.Uint32(header[:]) == opClose {
+		return r, fmt.Errorf("Discord closed the connection: %s", r.Data.Message)
+	}
+	return r, nil
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package discord
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDiscord accepts a connection on the first socket, replies to each frame with a reply and
+// sends the payloads it receives to a channel
+func fakeDiscord(t *testing.T, reply string) <-chan map[string]interface{} {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tetromino-discord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	ln, err := net.Listen("unix", filepath.Join(dir, "discord-ipc-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		os.RemoveAll(dir)
+		if ok {
+			os.Setenv("XDG_RUNTIME_DIR", old)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	})
+	payloads := make(chan map[string]interface{}, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var header [8]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			var payload map[string]interface{}
+			json.Unmarshal(data, &payload)
+			payloads <- payload
+			frame := make([]byte, 8+len(reply))
+			binary.LittleEndian.PutUint32(frame, opFrame)
+			binary.LittleEndian.PutUint32(frame[4:], uint32(len(reply)))
+			copy(frame[8:], reply)
+			conn.Write(frame)
+		}
+	}()
+	return payloads
+}
+
+func TestSetActivity(t *testing.T) {
+	payloads := fakeDiscord(t, `{"cmd":"DISPATCH","evt":"READY"}`)
+	c, err := Dial("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if handshake := <-payloads; handshake["client_id"] != "1234" {
+		t.Errorf("expected a handshake with the application ID but got %v", handshake)
+	}
+	start := time.Unix(1600000000, 0)
+	if err := c.SetActivity(Activity{Details: "TETRIS", State: "Playing", Start: start}); err != nil {
+		t.Fatal(err)
+	}
+	command := <-payloads
+	activity, _ := command["args"].(map[string]interface{})["activity"].(map[string]interface{})
+	if command["cmd"] != "SET_ACTIVITY" || activity["details"] != "TETRIS" || activity["state"] != "Playing" {
+		t.Errorf("unexpected command %v", command)
+	}
+	if timestamps, _ := activity["timestamps"].(map[string]interface{}); timestamps["start"] != float64(1600000000) {
+		t.Errorf("expected the start time to be shown but got %v", activity["timestamps"])
+	}
+}
+
+func TestSetActivityError(t *testing.T) {
+	fakeDiscord(t, `{"cmd":"SET_ACTIVITY","evt":"ERROR","data":{"code":4000,"message":"bad activity"}}`)
+	c, err := Dial("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.SetActivity(Activity{Details: "TETRIS"}); err == nil {
+		t.Error("expected an error when Discord rejects the activity")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package discord
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// dialIPC connects to a Unix socket in the first of the temporary directories that Discord uses
+func dialIPC(n int) (io.ReadWriteCloser, error) {
+	dir := "/tmp"
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		if value := os.Getenv(env); value != "" {
+			dir = value
+			break
+		}
+	}
+	return net.Dial("unix", filepath.Join(dir, fmt.Sprintf("discord-ipc-%d", n)))
+}
@@ -0,0 +1,12 @@
+package discord
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// dialIPC opens a named pipe, which can be read and written like a file
+func dialIPC(n int) (io.ReadWriteCloser, error) {
+	return os.OpenFile(fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, n), os.O_RDWR, 0)
+}
@@ -0,0 +1,60 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// updateInterval is how often the activity is updated, which is as often as Discord shows updates
+const updateInterval = 15 * time.Second
+
+// Run shows the title of the game running on a Gameboy on the user's profile until the context is
+// done, connecting again whenever Discord is restarted
+func Run(ctx context.Context, appID, title string, gameboy *gb.Gameboy) {
+	log := gameboy.Logger().With("discord")
+	start := time.Now()
+	ticker := time.NewTicker(updateInterval)
+	defer ticker.Stop()
+	var client *Client
+	var shown Activity
+	for {
+		if client == nil {
+			var err error
+			if client, err = Dial(appID); err != nil {
+				log.Debugf("Failed to connect to Discord: %v", err)
+			}
+			shown = Activity{}
+		}
+		if client != nil {
+			activity := Activity{Details: title, State: state(gameboy), Start: start}
+			if activity != shown {
+				if err := client.SetActivity(activity); err != nil {
+					log.Warnf("Failed to update Discord: %v", err)
+					client.Close()
+					client = nil
+				} else {
+					shown = activity
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if client != nil {
+				client.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// state describes what the emulator is doing
+func state(gameboy *gb.Gameboy) string {
+	if speed := gameboy.Speed(); speed > 1 {
+		return fmt.Sprintf("Fast-forwarding at %dx", speed)
+	}
+	return "Playing"
+}
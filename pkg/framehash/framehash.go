@@ -0,0 +1,93 @@
+// Package framehash logs a hash of every frame, and optionally of the audio played during it, so that
+// rendering regressions can be bisected by diffing the logs of two runs of the same ROM and inputs
+package framehash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image"
+	"io"
+	"math"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// Logger writes a line for each frame such as "42 9f4b1c0e5d2a7788", or with audio such as
+// "42 9f4b1c0e5d2a7788 0c1d2e3f40516273". Audio is only made while the sound is on so runs should be
+// compared at the same speed, as fast-forwarding skips some of it.
+type Logger struct {
+	gameboy     *gb.Gameboy
+	w           *bufio.Writer
+	audio       hash.Hash64
+	err         error
+	unsubscribe []func()
+}
+
+// New starts logging the frames of a Gameboy, and the audio too when audio is true
+func New(gameboy *gb.Gameboy, w io.Writer, audio bool) *Logger {
+	l := &Logger{
+		gameboy: gameboy,
+		w:       bufio.NewWriter(w),
+	}
+	if audio {
+		l.audio = fnv.New64a()
+		l.unsubscribe = append(l.unsubscribe, gameboy.Subscribe(l.samples, gb.AudioEvent))
+	}
+	l.unsubscribe = append(l.unsubscribe, gameboy.Subscribe(l.frame, gb.FrameEvent))
+	return l
+}
+
+func (l *Logger) samples(e gb.Event) {
+	var b [4]byte
+	for _, sample := range e.Samples {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(sample))
+		l.audio.Write(b[:])
+	}
+}
+
+func (l *Logger) frame(e gb.Event) {
+	if l.err != nil {
+		return
+	}
+	line := fmt.Sprintf("%d %016x", e.Frame, Hash(l.gameboy.Frame()))
+	if l.audio != nil {
+		line += fmt.Sprintf(" %016x", l.audio.Sum64())
+		l.audio.Reset()
+	}
+	_, l.err = fmt.Fprintln(l.w, line)
+}
+
+// Close stops logging and flushes the log, returning the first error writing it
+func (l *Logger) Close() error {
+	for _, unsubscribe := range l.unsubscribe {
+		unsubscribe()
+	}
+	if err := l.w.Flush(); l.err == nil {
+		l.err = err
+	}
+	return l.err
+}
+
+// Hash returns the FNV-1a hash of the RGBA pixels of a frame, row by row
+func Hash(frame image.Image) uint64 {
+	h := fnv.New64a()
+	bounds := frame.Bounds()
+	if rgba, ok := frame.(*image.RGBA); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			h.Write(rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)])
+		}
+		return h.Sum64()
+	}
+	var b [4]byte
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, bl, a := frame.At(x, y).RGBA()
+			b[0], b[1], b[2], b[3] = uint8(r>>8), uint8(g>>8), uint8(bl>>8), uint8(a>>8)
+			h.Write(b[:])
+		}
+	}
+	return h.Sum64()
+}
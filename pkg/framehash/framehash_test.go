@@ -0,0 +1,61 @@
+package framehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+func TestLogger(t *testing.T) {
+	run := func() string {
+		gameboy, err := gb.NewGameboy(gb.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		hashes := New(gameboy, &buf, true)
+		gameboy.RunFrames(3)
+		if err := hashes.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Frames after closing aren't logged
+		gameboy.RunFrames(1)
+		return buf.String()
+	}
+	first, second := run(), run()
+	if first != second {
+		t.Errorf("expected two runs to log the same hashes but got\n%s\nand\n%s", first, second)
+	}
+	lines := strings.Split(strings.TrimSpace(first), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a line for each of 3 frames but got %q", first)
+	}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) != 3 || len(fields[1]) != 16 || len(fields[2]) != 16 {
+			t.Errorf("expected the frame, video hash and audio hash but got %q", line)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 160, 144))
+	draw.Draw(rgba, image.Rect(10, 10, 20, 20), image.NewUniform(color.RGBA{0x12, 0x34, 0x56, 0xff}), image.Point{}, draw.Src)
+	nrgba := image.NewNRGBA(rgba.Rect)
+	draw.Draw(nrgba, nrgba.Rect, rgba, image.Point{}, draw.Src)
+	if Hash(rgba) != Hash(nrgba) {
+		t.Error("expected the same pixels to hash the same whatever the image type")
+	}
+	if Hash(rgba) != Hash(rgba.SubImage(rgba.Rect)) {
+		t.Error("expected a sub-image of the whole frame to hash the same")
+	}
+	before := Hash(rgba)
+	rgba.Set(159, 143, color.RGBA{0xff, 0, 0, 0xff})
+	if Hash(rgba) == before {
+		t.Error("expected a changed pixel to change the hash")
+	}
+}
@@ -0,0 +1,78 @@
+package gb
+
+import (
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+)
+
+const allocROM = "testdata/blargg/cpu_instrs/cpu_instrs.gb"
+
+// drainedSpeakers collect the samples of each frame so that audio generation is part of the core loop
+type drainedSpeakers struct {
+	buffer  *audio.RingBuffer
+	samples []float32
+}
+
+func newDrainedSpeakers() *drainedSpeakers {
+	return &drainedSpeakers{buffer: audio.NewRingBuffer(4096), samples: make([]float32, 8192)}
+}
+
+func (s *drainedSpeakers) Buffer() *audio.RingBuffer {
+	return s.buffer
+}
+
+func (s *drainedSpeakers) drain() {
+	s.buffer.Read(s.samples)
+}
+
+var allocOptions = map[string]Options{
+	"DMG": {RomFilename: allocROM, ForceDMG: true},
+	"CGB": {RomFilename: allocROM},
+	"SGB": {RomFilename: allocROM, SGB: true},
+}
+
+func newAllocGameboy(tb testing.TB, opts Options) (*Gameboy, *drainedSpeakers) {
+	gameboy, err := NewGameboy(opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	speakers := newDrainedSpeakers()
+	gameboy.RegisterSpeakers(speakers)
+	// Let the ROM get past its start up before measuring
+	for i := 0; i < 60; i++ {
+		gameboy.RunFrames(1)
+		speakers.drain()
+	}
+	return gameboy, speakers
+}
+
+// TestZeroAllocationsPerFrame keeps the core loop of the CPU, LCD and audio free of allocations
+func TestZeroAllocationsPerFrame(t *testing.T) {
+	for name, opts := range allocOptions {
+		gameboy, speakers := newAllocGameboy(t, opts)
+		allocs := testing.AllocsPerRun(60, func() {
+			gameboy.RunFrames(1)
+			speakers.drain()
+		})
+		if allocs != 0 {
+			t.Errorf("expected no allocations per frame in %s mode but got %.1f", name, allocs)
+		}
+	}
+}
+
+func benchmarkFrames(b *testing.B, mode string) {
+	gameboy, speakers := newAllocGameboy(b, allocOptions[mode])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gameboy.RunFrames(1)
+		speakers.drain()
+	}
+}
+
+func BenchmarkFrameDMG(b *testing.B) { benchmarkFrames(b, "DMG") }
+
+func BenchmarkFrameCGB(b *testing.B) { benchmarkFrames(b, "CGB") }
+
+func BenchmarkFrameSGB(b *testing.B) { benchmarkFrames(b, "SGB") }
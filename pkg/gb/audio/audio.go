@@ -2,17 +2,26 @@ package audio
 
 import (
 	"math"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	frameSeqTicks = 4194304 / 512      // 512Hz
 	samplerPeriod = 95.108934240362812 // 44100 Hz
+
+	// When running faster than 1x only one block of samples in every "speed" blocks is sent to the
+	// speakers. Each block is 10ms of audio and is faded in and out to avoid clicks at the joins.
+	blockSamples = 441
+	fadeSamples  = 32
+
+	// Samples are passed to the speakers in batches rather than one at a time, which only delays
+	// them by about 1.5ms
+	batchSamples = 64
 )
 
 // Audio stream
 type Audio struct {
-	l             chan float32
-	r             chan float32
+	buffer        *RingBuffer
 	ch1           *square
 	ch2           *square
 	ch3           *wave
@@ -21,6 +30,12 @@ type Audio struct {
 	ticks         uint64
 	frameSeqTicks uint64
 	samplerTicks  float64
+	speed         int32 // Set from the UI goroutine so it is accessed atomically
+	samples       uint64
+	batch         [batchSamples * 2]float32
+	batched       int
+	wantBatch     func() bool
+	onBatch       func(samples []float32)
 }
 
 // NewAudio initializes our internal channel for audio data
@@ -31,6 +46,7 @@ func NewAudio() *Audio {
 		ch3:     &wave{waveram: [16]uint8{0x84, 0x40, 0x43, 0xAA, 0x2D, 0x78, 0x92, 0x3C, 0x60, 0x59, 0x59, 0xB0, 0x34, 0xB8, 0x2E, 0xDA}},
 		ch4:     &noise{},
 		control: &control{},
+		speed:   1,
 	}
 
 	// Set default values for the NR registers
@@ -56,16 +72,44 @@ func NewAudio() *Audio {
 	return &audio
 }
 
-// Speakers abstracts over a real-world implementation of the Gameboy speakers
+// Speakers abstracts over a real-world implementation of the Gameboy speakers, which play the samples
+// written to their ring buffer
 type Speakers interface {
-	Left() chan float32
-	Right() chan float32
+	Buffer() *RingBuffer
 }
 
-// RegisterSpeakers associates real-world audio output with the audio subsystem
+// RegisterSpeakers associates real-world audio output with the audio subsystem, or removes it when
+// speakers is nil
 func (a *Audio) RegisterSpeakers(speakers Speakers) {
-	a.l = speakers.Left()
-	a.r = speakers.Right()
+	if speakers == nil {
+		a.buffer = nil
+		return
+	}
+	a.buffer = speakers.Buffer()
+}
+
+// OnBatch calls a function with each batch of samples while wanted returns true, sampling even when
+// there are no speakers. The batch is reused so the function must copy it to keep it.
+func (a *Audio) OnBatch(wanted func() bool, hook func(samples []float32)) {
+	a.wantBatch = wanted
+	a.onBatch = hook
+}
+
+// Waited returns the total time spent waiting for the speakers to play samples, which is what keeps
+// the emulator running at the speed of a real Gameboy
+func (a *Audio) Waited() time.Duration {
+	if a.buffer == nil {
+		return 0
+	}
+	return a.buffer.Waited()
+}
+
+// SetSpeed sets the emulation speed multiplier so that the audio stays at the correct pitch
+func (a *Audio) SetSpeed(speed int) {
+	if speed < 1 {
+		speed = 1
+	}
+	atomic.StoreInt32(&a.speed, int32(speed))
 }
 
 // EndMachineCycle emulates the audio hardware at the end of a machine cycle
@@ -80,21 +124,12 @@ func (a *Audio) EndMachineCycle() {
 func (a *Audio) tickClock() {
 	if a.ticks >= 4194304 {
 		a.ticks = 0
-		a.frameSeqTicks = 0
 		a.samplerTicks = 0
 	}
 
 	// Tick every clock cycle
 	a.tickTimer()
 
-	// Tick the frame sequencer at 512 Hz
-	if a.ticks%frameSeqTicks == 0 {
-		a.tickFrameSequencer()
-		if a.frameSeqTicks >= 512 {
-			a.frameSeqTicks = 0
-		}
-	}
-
 	// Tick this function at 44100 Hz
 	if a.ticks == uint64(math.Round(a.samplerTicks*samplerPeriod)) {
 		a.tickSampler()
@@ -111,6 +146,15 @@ func (a *Audio) tickTimer() {
 	a.ch4.tickTimer()
 }
 
+// StepFrameSequencer clocks the length counters, volume envelopes and sweep. It's stepped at 512Hz by
+// the timer's divider, which is shared with the APU, so resetting DIV changes when it next steps.
+func (a *Audio) StepFrameSequencer() {
+	a.tickFrameSequencer()
+	if a.frameSeqTicks >= 512 {
+		a.frameSeqTicks = 0
+	}
+}
+
 func (a *Audio) tickFrameSequencer() {
 
 	// Step   Length Ctr  Vol Env     Sweep
@@ -148,6 +192,34 @@ func (a *Audio) tickFrameSequencer() {
 }
 
 func (a *Audio) tickSampler() {
-	a.takeSample()
+	gain, keep := a.blockGain()
+	if keep {
+		a.takeSample(gain)
+	}
+	a.samples++
 	a.samplerTicks++
 }
+
+// blockGain decides whether the current sample is kept when running faster than 1x. The kept
+// samples are played at the normal rate so the speakers still throttle the emulator, but now to
+// "speed" times faster than a real Gameboy, and the pitch is unchanged because each kept block
+// is played back exactly as it was sampled.
+func (a *Audio) blockGain() (float32, bool) {
+	speed := atomic.LoadInt32(&a.speed)
+	if speed <= 1 {
+		return 1, true
+	}
+	block := a.samples / blockSamples
+	if block%uint64(speed) != 0 {
+		return 0, false
+	}
+	position := a.samples % blockSamples
+	switch {
+	case position < fadeSamples:
+		return float32(position) / fadeSamples, true
+	case position >= blockSamples-fadeSamples:
+		return float32(blockSamples-1-position) / fadeSamples, true
+	default:
+		return 1, true
+	}
+}
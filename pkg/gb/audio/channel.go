@@ -1,8 +1,9 @@
 package audio
 
-func (a *Audio) takeSample() {
+func (a *Audio) takeSample(gain float32) {
 
-	if !a.control.on || a.l == nil || a.r == nil {
+	listening := a.wantBatch != nil && a.wantBatch()
+	if !a.control.on || a.buffer == nil && !listening {
 		return
 	}
 
@@ -21,7 +22,7 @@ func (a *Audio) takeSample() {
 	wave4 = a.ch4.takeSample()
 
 	// Hardcode master volume for now
-	masterVolume := float32(0.6)
+	masterVolume := float32(0.6) * gain
 
 	// Mix left channel
 	left := float32(0)
@@ -39,7 +40,6 @@ func (a *Audio) takeSample() {
 	}
 	left /= 4
 	left *= float32(a.control.volumeLeft) / 8 * masterVolume
-	a.l <- left
 
 	// Mix right channel
 	right := float32(0)
@@ -57,6 +57,18 @@ func (a *Audio) takeSample() {
 	}
 	right /= 4
 	right *= float32(a.control.volumeRight) / 8 * masterVolume
-	a.r <- right
+
+	a.batch[a.batched] = left
+	a.batch[a.batched+1] = right
+	a.batched += 2
+	if a.batched == len(a.batch) {
+		if a.buffer != nil {
+			a.buffer.WriteSamples(a.batch[:])
+		}
+		if listening {
+			a.onBatch(a.batch[:])
+		}
+		a.batched = 0
+	}
 
 }
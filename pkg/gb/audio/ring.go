@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RingBuffer passes stereo samples from the emulator to the speakers without locks. The emulator
+// writes samples as it generates them and waits only when the buffer is full, which keeps it running
+// at the speed that the speakers play, while the speakers read whatever is available without ever
+// waiting for the emulator.
+type RingBuffer struct {
+	// The counters come first so that they stay 64-bit aligned for sync/atomic on 32-bit platforms
+	read    uint64    // Stereo samples read so far, updated atomically
+	written uint64    // Stereo samples written so far, updated atomically
+	waited  int64     // Nanoseconds spent waiting for space, updated atomically
+	samples []float32 // Interleaved left and right samples
+	mask    uint64
+	space   chan struct{}
+	closed  int32
+}
+
+// NewRingBuffer returns a ring buffer holding at least a number of stereo samples
+func NewRingBuffer(size int) *RingBuffer {
+	capacity := 1
+	for capacity < size {
+		capacity *= 2
+	}
+	return &RingBuffer{
+		samples: make([]float32, capacity*2),
+		mask:    uint64(capacity - 1),
+		space:   make(chan struct{}, 1),
+	}
+}
+
+// Write adds a stereo sample, waiting for the speakers to make space if the buffer is full. Samples
+// are dropped once the buffer is closed.
+func (b *RingBuffer) Write(left, right float32) {
+	for {
+		written := atomic.LoadUint64(&b.written)
+		if written-atomic.LoadUint64(&b.read) <= b.mask {
+			i := (written & b.mask) * 2
+			b.samples[i] = left
+			b.samples[i+1] = right
+			atomic.StoreUint64(&b.written, written+1)
+			return
+		}
+		if atomic.LoadInt32(&b.closed) != 0 {
+			return
+		}
+		b.wait()
+	}
+}
+
+// WriteSamples adds interleaved left and right samples, copying as many as fit at a time and waiting
+// for the speakers to make space for the rest. Samples are dropped once the buffer is closed.
+func (b *RingBuffer) WriteSamples(samples []float32) {
+	for len(samples) >= 2 {
+		written := atomic.LoadUint64(&b.written)
+		space := b.mask + 1 - (written - atomic.LoadUint64(&b.read))
+		if space == 0 {
+			if atomic.LoadInt32(&b.closed) != 0 {
+				return
+			}
+			b.wait()
+			continue
+		}
+		count := uint64(len(samples) / 2)
+		if count > space {
+			count = space
+		}
+		for n := uint64(0); n < count; n++ {
+			i := ((written + n) & b.mask) * 2
+			b.samples[i] = samples[n*2]
+			b.samples[i+1] = samples[n*2+1]
+		}
+		atomic.StoreUint64(&b.written, written+count)
+		samples = samples[count*2:]
+	}
+}
+
+// Read fills out with as many interleaved left and right samples as are available and returns how
+// many values it filled
+func (b *RingBuffer) Read(out []float32) int {
+	read := atomic.LoadUint64(&b.read)
+	count := atomic.LoadUint64(&b.written) - read
+	if wanted := uint64(len(out) / 2); count > wanted {
+		count = wanted
+	}
+	for n := uint64(0); n < count; n++ {
+		i := ((read + n) & b.mask) * 2
+		out[n*2] = b.samples[i]
+		out[n*2+1] = b.samples[i+1]
+	}
+	atomic.StoreUint64(&b.read, read+count)
+	b.signal()
+	return int(count * 2)
+}
+
+// Buffered returns the number of stereo samples waiting to be read
+func (b *RingBuffer) Buffered() int {
+	return int(atomic.LoadUint64(&b.written) - atomic.LoadUint64(&b.read))
+}
+
+// Close stops Write from waiting for space, such as when the speakers stop playing
+func (b *RingBuffer) Close() {
+	atomic.StoreInt32(&b.closed, 1)
+	b.signal()
+}
+
+// wait blocks until the speakers read some samples, keeping track of how long it took
+func (b *RingBuffer) wait() {
+	t := time.Now()
+	<-b.space
+	atomic.AddInt64(&b.waited, int64(time.Since(t)))
+}
+
+// Waited returns the total time that writers have spent waiting for the speakers to make space
+func (b *RingBuffer) Waited() time.Duration {
+	return time.Duration(atomic.LoadInt64(&b.waited))
+}
+
+// signal wakes a writer waiting for space without blocking
+func (b *RingBuffer) signal() {
+	select {
+	case b.space <- struct{}{}:
+	default:
+	}
+}
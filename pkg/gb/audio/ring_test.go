@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	b := NewRingBuffer(3)
+	out := make([]float32, 16)
+	if n := b.Read(out); n != 0 {
+		t.Errorf("expected an empty buffer but read %d values", n)
+	}
+	for i := 0; i < 4; i++ {
+		b.Write(float32(i), -float32(i))
+	}
+	if n := b.Buffered(); n != 4 {
+		t.Errorf("expected the capacity to round up to 4 but got %d", n)
+	}
+	if n := b.Read(out[:4]); n != 4 || out[0] != 0 || out[1] != 0 || out[2] != 1 || out[3] != -1 {
+		t.Errorf("unexpected samples %v", out[:n])
+	}
+	b.Write(4, -4)
+	if n := b.Read(out); n != 6 || out[0] != 2 || out[4] != 4 || out[5] != -4 {
+		t.Errorf("unexpected samples %v", out[:n])
+	}
+}
+
+func TestRingBufferWaitsForSpace(t *testing.T) {
+	b := NewRingBuffer(4)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			b.Write(float32(i), float32(i))
+		}
+		close(done)
+	}()
+	out := make([]float32, 6)
+	next := float32(0)
+	for next < 1000 {
+		n := b.Read(out)
+		if n == 0 {
+			runtime.Gosched()
+		}
+		for i := 0; i < n; i += 2 {
+			if out[i] != next {
+				t.Fatalf("expected sample %v but got %v", next, out[i])
+			}
+			next++
+		}
+	}
+	<-done
+}
+
+func TestRingBufferClose(t *testing.T) {
+	b := NewRingBuffer(1)
+	b.Write(1, 1)
+	b.Close()
+	// The buffer is full but writing must not wait after it is closed
+	b.Write(2, 2)
+}
+
+func TestRingBufferWriteSamples(t *testing.T) {
+	b := NewRingBuffer(4)
+	done := make(chan struct{})
+	go func() {
+		// Each batch is larger than the buffer so has to be written in several parts
+		samples := make([]float32, 20)
+		for i := 0; i < 100; i += 10 {
+			for n := range samples {
+				samples[n] = float32(i + n/2)
+			}
+			b.WriteSamples(samples)
+		}
+		close(done)
+	}()
+	out := make([]float32, 6)
+	next := float32(0)
+	for next < 100 {
+		n := b.Read(out)
+		if n == 0 {
+			runtime.Gosched()
+		}
+		for i := 0; i < n; i += 2 {
+			if out[i] != next || out[i+1] != next {
+				t.Fatalf("expected sample %v but got %v", next, out[i:i+2])
+			}
+			next++
+		}
+	}
+	<-done
+}
@@ -0,0 +1,258 @@
+package audio
+
+// State is a snapshot of the APU, for save states. Samples not yet passed to the speakers aren't
+// included.
+type State struct {
+	Ch1           SquareState
+	Sweep         SweepState
+	Ch2           SquareState
+	Ch3           WaveState
+	Ch4           NoiseState
+	Control       ControlState
+	Ticks         uint64
+	FrameSeqTicks uint64
+	SamplerTicks  float64
+	Samples       uint64
+}
+
+// SweepState holds the frequency sweep of channel 1
+type SweepState struct {
+	Period          uint8
+	Increase        bool
+	Shift           uint8
+	Enabled         bool
+	Timer           uint8
+	ShadowFrequency uint16
+}
+
+// SquareState holds a square wave channel
+type SquareState struct {
+	Duty             uint8
+	Length           uint8
+	InitialVolume    uint8
+	EnvelopeIncrease bool
+	EnvelopeSweep    uint8
+	Frequency        uint16
+	LengthEnable     bool
+	Enabled          bool
+	DACEnabled       bool
+	DutyIndex        uint8
+	Volume           uint8
+	Timer            uint16
+	EnvelopeTimer    uint8
+}
+
+// WaveState holds the wave channel
+type WaveState struct {
+	Length       uint16
+	OutputLevel  uint8
+	Frequency    uint16
+	LengthEnable bool
+	WaveRAM      [16]uint8
+	Enabled      bool
+	DACEnabled   bool
+	Timer        uint16
+	OutputShift  uint8
+	Position     uint8
+	Sample       uint8
+}
+
+// NoiseState holds the noise channel
+type NoiseState struct {
+	Length           uint8
+	InitialVolume    uint8
+	EnvelopeIncrease bool
+	EnvelopeSweep    uint8
+	Shift            uint8
+	LFSRWidth        uint8
+	Divisor          uint8
+	LengthEnable     bool
+	Enabled          bool
+	DACEnabled       bool
+	Volume           uint8
+	Timer            uint16
+	EnvelopeTimer    uint8
+	LFSR             uint16
+}
+
+// ControlState holds the master controls in NR50-NR52
+type ControlState struct {
+	On                 bool
+	Ch1Right, Ch2Right bool
+	Ch3Right, Ch4Right bool
+	Ch1Left, Ch2Left   bool
+	Ch3Left, Ch4Left   bool
+	VinLeftEnable      bool
+	VolumeLeft         uint8
+	VinRightEnable     bool
+	VolumeRight        uint8
+	HighNibble         uint8
+}
+
+// Snapshot returns the state of the APU
+func (a *Audio) Snapshot() State {
+	s := a.ch1.sweep
+	c := a.control
+	return State{
+		Ch1: a.ch1.snapshot(),
+		Sweep: SweepState{
+			Period:          s.sweepPeriod,
+			Increase:        s.sweepIncrease,
+			Shift:           s.sweepShift,
+			Enabled:         s.sweepEnabled,
+			Timer:           s.sweepTimer,
+			ShadowFrequency: s.shadowFrequency,
+		},
+		Ch2: a.ch2.snapshot(),
+		Ch3: WaveState{
+			Length:       a.ch3.length,
+			OutputLevel:  a.ch3.outputLevel,
+			Frequency:    a.ch3.frequency,
+			LengthEnable: a.ch3.lengthEnable,
+			WaveRAM:      a.ch3.waveram,
+			Enabled:      a.ch3.enabled,
+			DACEnabled:   a.ch3.dacEnabled,
+			Timer:        a.ch3.timer,
+			OutputShift:  a.ch3.outputShift,
+			Position:     a.ch3.position,
+			Sample:       a.ch3.sample,
+		},
+		Ch4: NoiseState{
+			Length:           a.ch4.length,
+			InitialVolume:    a.ch4.initialVolume,
+			EnvelopeIncrease: a.ch4.envelopeIncrease,
+			EnvelopeSweep:    a.ch4.envelopeSweep,
+			Shift:            a.ch4.shift,
+			LFSRWidth:        a.ch4.lfsrWidth,
+			Divisor:          a.ch4.divisor,
+			LengthEnable:     a.ch4.lengthEnable,
+			Enabled:          a.ch4.enabled,
+			DACEnabled:       a.ch4.dacEnabled,
+			Volume:           a.ch4.volume,
+			Timer:            a.ch4.timer,
+			EnvelopeTimer:    a.ch4.envelopeTimer,
+			LFSR:             a.ch4.lfsr,
+		},
+		Control: ControlState{
+			On:             c.on,
+			Ch1Right:       c.ch1Right,
+			Ch2Right:       c.ch2Right,
+			Ch3Right:       c.ch3Right,
+			Ch4Right:       c.ch4Right,
+			Ch1Left:        c.ch1Left,
+			Ch2Left:        c.ch2Left,
+			Ch3Left:        c.ch3Left,
+			Ch4Left:        c.ch4Left,
+			VinLeftEnable:  c.vinLeftEnable,
+			VolumeLeft:     c.volumeLeft,
+			VinRightEnable: c.vinRightEnable,
+			VolumeRight:    c.volumeRight,
+			HighNibble:     c.highNibble,
+		},
+		Ticks:         a.ticks,
+		FrameSeqTicks: a.frameSeqTicks,
+		SamplerTicks:  a.samplerTicks,
+		Samples:       a.samples,
+	}
+}
+
+// Restore returns the APU to a state taken by Snapshot
+func (a *Audio) Restore(s State) {
+	a.ch1.restore(s.Ch1)
+	*a.ch1.sweep = sweep{
+		sweepPeriod:     s.Sweep.Period,
+		sweepIncrease:   s.Sweep.Increase,
+		sweepShift:      s.Sweep.Shift,
+		sweepEnabled:    s.Sweep.Enabled,
+		sweepTimer:      s.Sweep.Timer,
+		shadowFrequency: s.Sweep.ShadowFrequency,
+	}
+	a.ch2.restore(s.Ch2)
+	*a.ch3 = wave{
+		length:       s.Ch3.Length,
+		outputLevel:  s.Ch3.OutputLevel,
+		frequency:    s.Ch3.Frequency,
+		lengthEnable: s.Ch3.LengthEnable,
+		waveram:      s.Ch3.WaveRAM,
+		enabled:      s.Ch3.Enabled,
+		dacEnabled:   s.Ch3.DACEnabled,
+		timer:        s.Ch3.Timer,
+		outputShift:  s.Ch3.OutputShift,
+		position:     s.Ch3.Position,
+		sample:       s.Ch3.Sample,
+	}
+	*a.ch4 = noise{
+		length:           s.Ch4.Length,
+		initialVolume:    s.Ch4.InitialVolume,
+		envelopeIncrease: s.Ch4.EnvelopeIncrease,
+		envelopeSweep:    s.Ch4.EnvelopeSweep,
+		shift:            s.Ch4.Shift,
+		lfsrWidth:        s.Ch4.LFSRWidth,
+		divisor:          s.Ch4.Divisor,
+		lengthEnable:     s.Ch4.LengthEnable,
+		enabled:          s.Ch4.Enabled,
+		dacEnabled:       s.Ch4.DACEnabled,
+		volume:           s.Ch4.Volume,
+		timer:            s.Ch4.Timer,
+		envelopeTimer:    s.Ch4.EnvelopeTimer,
+		lfsr:             s.Ch4.LFSR,
+	}
+	*a.control = control{
+		on:             s.Control.On,
+		ch1Right:       s.Control.Ch1Right,
+		ch2Right:       s.Control.Ch2Right,
+		ch3Right:       s.Control.Ch3Right,
+		ch4Right:       s.Control.Ch4Right,
+		ch1Left:        s.Control.Ch1Left,
+		ch2Left:        s.Control.Ch2Left,
+		ch3Left:        s.Control.Ch3Left,
+		ch4Left:        s.Control.Ch4Left,
+		vinLeftEnable:  s.Control.VinLeftEnable,
+		volumeLeft:     s.Control.VolumeLeft,
+		vinRightEnable: s.Control.VinRightEnable,
+		volumeRight:    s.Control.VolumeRight,
+		highNibble:     s.Control.HighNibble,
+	}
+	a.ticks = s.Ticks
+	a.frameSeqTicks = s.FrameSeqTicks
+	a.samplerTicks = s.SamplerTicks
+	a.samples = s.Samples
+}
+
+func (s *square) snapshot() SquareState {
+	return SquareState{
+		Duty:             s.duty,
+		Length:           s.length,
+		InitialVolume:    s.initialVolume,
+		EnvelopeIncrease: s.envelopeIncrease,
+		EnvelopeSweep:    s.envelopeSweep,
+		Frequency:        s.frequency,
+		LengthEnable:     s.lengthEnable,
+		Enabled:          s.enabled,
+		DACEnabled:       s.dacEnabled,
+		DutyIndex:        s.dutyIndex,
+		Volume:           s.volume,
+		Timer:            s.timer,
+		EnvelopeTimer:    s.envelopeTimer,
+	}
+}
+
+// restore keeps the channel's sweep, which only channel 1 has
+func (s *square) restore(state SquareState) {
+	*s = square{
+		duty:             state.Duty,
+		length:           state.Length,
+		initialVolume:    state.InitialVolume,
+		envelopeIncrease: state.EnvelopeIncrease,
+		envelopeSweep:    state.EnvelopeSweep,
+		frequency:        state.Frequency,
+		lengthEnable:     state.LengthEnable,
+		sweep:            s.sweep,
+		enabled:          state.Enabled,
+		dacEnabled:       state.DACEnabled,
+		dutyIndex:        state.DutyIndex,
+		volume:           state.Volume,
+		timer:            state.Timer,
+		envelopeTimer:    state.EnvelopeTimer,
+	}
+}
@@ -1,51 +1,24 @@
 package gb
 
 import (
-	"bytes"
-	"context"
-	"fmt"
-	"strings"
 	"testing"
-	"time"
 )
 
 func runBlarggTest(t *testing.T, filename string) {
-	sbWriter := &bytes.Buffer{}
 	opts := Options{
 		RomFilename: "testdata/blargg/" + filename,
-		SBWriter:    sbWriter,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	gameboy := NewGameboy(opts)
-	go func() {
-		for {
-			result := sbWriter.String()
-			ram := string(gameboy.memory.CartRAM()[0][:])
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if strings.Contains(result, "Failed") ||
-					strings.Contains(result, "Passed") ||
-					strings.Contains(ram, "Failed") ||
-					strings.Contains(ram, "Passed") {
-					cancel()
-				}
-			}
-			// Check every 100ms for a result
-			time.Sleep(100 * time.Millisecond)
-		}
-	}()
-	gameboy.Run(ctx)
-	<-ctx.Done()
-	result := sbWriter.String()
-	ram := string(gameboy.memory.CartRAM()[0][:])
-	screenshotFilename := fmt.Sprintf("testresults/%s.png", strings.Replace(filename, "/", "_", -1))
-	gameboy.lcd.Screenshot(screenshotFilename)
-	if !strings.Contains(result, "Passed") &&
-		!strings.Contains(ram, "Passed") {
-		t.Errorf(result)
+	// Allow up to 5 minutes of emulated time
+	gameboy, result, err := RunBlarggTest(opts, 5*60*60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	screenshotFilename := testResultFilename(t, filename)
+	if err := gameboy.Screenshot(screenshotFilename); err != nil {
+		t.Error(err)
+	}
+	if !result.Passed {
+		t.Errorf(result.Output)
 		// fmt.Printf("| :boom: fail | %s | [pic](pkg/gb/%s) |\n", filename, screenshotFilename)
 	} else {
 		// fmt.Printf("| :green_heart: pass | %s | [pic](pkg/gb/%s) |\n", filename, screenshotFilename)
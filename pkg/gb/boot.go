@@ -0,0 +1,111 @@
+package gb
+
+import (
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+)
+
+// The boot ROM moves the logo one line each step, waiting about two frames between steps. The logo
+// stops after 100 steps, the two notes of the chime play on steps 98 and 100 and the boot ROM waits
+// 32 more steps before handing off to the game.
+const (
+	introFramesPerStep = 2
+	introScrollSteps   = 100
+	introSteps         = 132
+)
+
+// introRegistered is the ® drawn to the right of the logo, which the boot ROM keeps itself
+var introRegistered = [8]uint8{0x3c, 0x42, 0xb9, 0xa5, 0xb9, 0xa5, 0x42, 0x3c}
+
+// bootIntro plays the original Game Boy's logo scroll and chime while the CPU is held halted. It
+// restores the registers that the game expects from the boot ROM when it hands off.
+type bootIntro struct {
+	frame     int
+	registers cpu.Registers
+	timer     timer.State
+	ifReg     uint8
+}
+
+// startBootIntro draws the logo from the cartridge header into VRAM as the boot ROM does and holds
+// the CPU until the intro ends
+func (gb *Gameboy) startBootIntro(rom []byte) {
+	if gb.cgb || gb.opts.SGB {
+		// The Game Boy Color and Super Game Boy boot ROMs play different intros
+		gb.log.Infof("Starting without the intro, which is only played on the original Game Boy")
+		return
+	}
+	intro := &bootIntro{
+		registers: gb.dispatch.Registers(),
+		timer:     gb.timer.Snapshot(),
+		ifReg:     gb.memory.IF,
+	}
+	halted := intro.registers
+	halted.Halted = true
+	gb.dispatch.SetRegisters(halted)
+
+	// Each bit of the 48 byte logo at 0x0104 is doubled in both directions to make tiles 1 to 24
+	addr := uint16(0x8010)
+	for i := 0x0104; i < 0x0134; i++ {
+		var b uint8
+		if i < len(rom) {
+			b = rom[i]
+		}
+		for _, nibble := range []uint8{b >> 4, b & 0x0f} {
+			var row uint8
+			for bit := 3; bit >= 0; bit-- {
+				row <<= 2
+				if nibble&(1<<uint(bit)) != 0 {
+					row |= 0x03
+				}
+			}
+			for j := 0; j < 2; j++ {
+				gb.memory.Write(addr, row)
+				addr += 2
+			}
+		}
+	}
+	for _, row := range introRegistered {
+		gb.memory.Write(addr, row)
+		addr += 2
+	}
+	for i := uint16(0); i < 12; i++ {
+		gb.memory.Write(0x9904+i, uint8(0x01+i))
+		gb.memory.Write(0x9924+i, uint8(0x0d+i))
+	}
+	gb.memory.Write(0x9910, 0x19)
+	gb.memory.Write(0xff42, introScrollSteps)
+
+	// Channel 1 plays the chime
+	gb.memory.Write(0xff26, 0x80)
+	gb.memory.Write(0xff11, 0x80)
+	gb.memory.Write(0xff12, 0xf3)
+	gb.memory.Write(0xff25, 0xf3)
+	gb.memory.Write(0xff24, 0x77)
+	gb.intro = intro
+}
+
+// stepBootIntro runs at the end of each frame of the intro and hands off to the game once it is over
+func (gb *Gameboy) stepBootIntro() {
+	intro := gb.intro
+	intro.frame++
+	if intro.frame%introFramesPerStep != 0 {
+		return
+	}
+	step := intro.frame / introFramesPerStep
+	if step <= introScrollSteps {
+		gb.memory.Write(0xff42, uint8(introScrollSteps-step))
+	}
+	switch step {
+	case introScrollSteps - 2:
+		gb.memory.Write(0xff13, 0x83)
+		gb.memory.Write(0xff14, 0x87)
+	case introScrollSteps:
+		gb.memory.Write(0xff13, 0xc1)
+		gb.memory.Write(0xff14, 0x87)
+	case introSteps:
+		gb.dispatch.SetRegisters(intro.registers)
+		gb.timer.Restore(intro.timer)
+		gb.memory.IF = intro.ifReg
+		gb.intro = nil
+	}
+}
@@ -0,0 +1,68 @@
+package gb
+
+import (
+	"testing"
+)
+
+func TestBootIntro(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x00, 0x18, 0xfe}, // NOP, JR -2
+		0x0104: {0xce, 0xed, 0x66, 0x66},
+	})
+	gameboy, err := NewGameboy(Options{RomFilename: rom, BootIntro: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registers := cold.Registers()
+	div := cold.ReadMemory(0xff04)
+
+	// The first logo byte 0xce is drawn as the rows f0 f0 fc fc of tile 1
+	for i, expected := range []uint8{0xf0, 0xf0, 0xfc, 0xfc} {
+		if actual := gameboy.ReadMemory(0x8010 + uint16(i)*2); actual != expected {
+			t.Errorf("expected row %d of tile 1 to be %02x but got %02x", i, expected, actual)
+		}
+	}
+	if actual := gameboy.ReadMemory(0x9904); actual != 0x01 {
+		t.Errorf("expected the logo to start with tile 1 but got %d", actual)
+	}
+
+	gameboy.RunFrames(introFramesPerStep * 10)
+	if actual := gameboy.ReadMemory(0xff42); actual != introScrollSteps-10 {
+		t.Errorf("expected SCY to be %d after 10 steps but got %d", introScrollSteps-10, actual)
+	}
+	if actual := gameboy.Registers().PC; actual != 0x0100 {
+		t.Errorf("expected the game not to start during the intro but PC is %04x", actual)
+	}
+
+	gameboy.RunFrames(introFramesPerStep*introSteps - introFramesPerStep*10)
+	if gameboy.intro != nil {
+		t.Fatal("expected the intro to hand off to the game")
+	}
+	if actual := gameboy.ReadMemory(0xff42); actual != 0 {
+		t.Errorf("expected the logo to end at the top but SCY is %d", actual)
+	}
+	if actual := gameboy.Registers(); actual != registers {
+		t.Errorf("expected the registers to be %s but got %s", registers, actual)
+	}
+	if actual := gameboy.ReadMemory(0xff04); actual != div {
+		t.Errorf("expected DIV to be %02x but got %02x", div, actual)
+	}
+	gameboy.RunFrames(1)
+	if actual := gameboy.Registers().PC; actual == 0x0100 {
+		t.Error("expected the game to start after the intro")
+	}
+}
+
+func TestBootIntroCGB(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeCGBRom(t), BootIntro: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.intro != nil {
+		t.Error("expected Game Boy Color games to start without the intro")
+	}
+}
@@ -0,0 +1,231 @@
+package gb
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/ir"
+)
+
+// writeRom writes a ROM with the bytes at each address replaced
+func writeRom(t *testing.T, patches map[uint16][]byte) string {
+	t.Helper()
+	rom := make([]byte, 0x8000)
+	for addr, bytes := range patches {
+		copy(rom[addr:], bytes)
+	}
+	dir, err := ioutil.TempDir("", "tetromino-cgb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filename := filepath.Join(dir, "test.gb")
+	if err := ioutil.WriteFile(filename, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// writeCGBRom writes a ROM flagged as supporting the CGB that prepares a speed switch, executes STOP
+// and then loops forever
+func writeCGBRom(t *testing.T) string {
+	return writeRom(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // JP $0150
+		0x0143: {0x80},
+		0x0150: {
+			0x3e, 0x01, // LD A,$01
+			0xe0, 0x4d, // LDH (KEY1),A
+			0x10, 0x00, // STOP
+			0x18, 0xfe, // JR -2
+		},
+	})
+}
+
+func TestCGBMode(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeCGBRom(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gameboy.CGB() {
+		t.Fatal("expected CGB mode")
+	}
+	if a := gameboy.Registers().A; a != 0x11 {
+		t.Errorf("expected A to be 0x11 on a CGB but got 0x%02x", a)
+	}
+
+	// WRAM banks at D000-DFFF
+	for bank := uint8(1); bank < 8; bank++ {
+		gameboy.WriteMemory(0xff70, bank)
+		gameboy.WriteMemory(0xd000, 0x10+bank)
+	}
+	gameboy.WriteMemory(0xff70, 0)
+	if v := gameboy.ReadMemory(0xd000); v != 0x11 {
+		t.Errorf("expected bank 0 to select bank 1 but read 0x%02x", v)
+	}
+	gameboy.WriteMemory(0xff70, 5)
+	if v := gameboy.ReadMemory(0xf000); v != 0x15 {
+		t.Errorf("expected the echo to read bank 5 but read 0x%02x", v)
+	}
+
+	// HDMA copies from WRAM into the selected VRAM bank
+	for i := uint16(0); i < 0x20; i++ {
+		gameboy.WriteMemory(0xc100+i, uint8(i))
+	}
+	gameboy.WriteMemory(0xff4f, 1)
+	gameboy.WriteMemory(0xff51, 0xc1)
+	gameboy.WriteMemory(0xff52, 0x00)
+	gameboy.WriteMemory(0xff53, 0x00)
+	gameboy.WriteMemory(0xff54, 0x00)
+	gameboy.WriteMemory(0xff55, 0x01)
+	if v := gameboy.ReadMemory(0xff55); v != 0xff {
+		t.Errorf("expected the transfer to be complete but HDMA5 is 0x%02x", v)
+	}
+	if v := gameboy.ReadMemory(0x801f); v != 0x1f {
+		t.Errorf("expected the copy in VRAM bank 1 but read 0x%02x", v)
+	}
+	gameboy.WriteMemory(0xff4f, 0)
+	if v := gameboy.ReadMemory(0x801f); v != 0x00 {
+		t.Errorf("expected VRAM bank 0 to be untouched but read 0x%02x", v)
+	}
+
+	// Palette data auto-increments, so make colour 0 of BG palette 0 pure red
+	gameboy.WriteMemory(0xff68, 0x80)
+	gameboy.WriteMemory(0xff69, 0x1f)
+	gameboy.WriteMemory(0xff69, 0x00)
+	if v := gameboy.ReadMemory(0xff68); v != 0xc2 {
+		t.Errorf("expected BCPS to advance to 0xc2 but got 0x%02x", v)
+	}
+
+	var pixel color.RGBA
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		pixel = frame.RGBAAt(0, 0)
+	})
+	gameboy.RunFrames(2)
+	if pixel != (color.RGBA{0xff, 0x00, 0x00, 0xff}) {
+		t.Errorf("expected a red background but got %v", pixel)
+	}
+	if v := gameboy.ReadMemory(0xff4d); v != 0xfe {
+		t.Errorf("expected STOP to switch to double speed but KEY1 is 0x%02x", v)
+	}
+	if gameboy.Registers().Stopped {
+		t.Error("expected the speed switch not to stop the CPU")
+	}
+}
+
+func TestInfrared(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeCGBRom(t), Infrared: &ir.Loopback{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0xff56, 0x01)
+	if v := gameboy.ReadMemory(0xff56); v != 0x3f {
+		t.Errorf("expected no light while reading is disabled but RP is 0x%02x", v)
+	}
+	gameboy.WriteMemory(0xff56, 0xc1)
+	if v := gameboy.ReadMemory(0xff56); v != 0xfd {
+		t.Errorf("expected to receive light from the LED but RP is 0x%02x", v)
+	}
+	gameboy.WriteMemory(0xff56, 0xc0)
+	if v := gameboy.ReadMemory(0xff56); v != 0xfe {
+		t.Errorf("expected no light with the LED off but RP is 0x%02x", v)
+	}
+}
+
+func TestForceDMG(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeCGBRom(t), ForceDMG: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.CGB() {
+		t.Fatal("expected DMG mode")
+	}
+	if a := gameboy.Registers().A; a != 0x01 {
+		t.Errorf("expected A to be 0x01 on a DMG but got 0x%02x", a)
+	}
+	if v := gameboy.ReadMemory(0xff70); v != 0xff {
+		t.Errorf("expected SVBK to be unmapped but read 0x%02x", v)
+	}
+	gameboy.RunFrames(1)
+	if !gameboy.Registers().Stopped {
+		t.Error("expected STOP to stop the CPU")
+	}
+}
+
+func TestCompatPalette(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+		0x0134: []byte("POKEMON RED"),
+		0x014b: {0x01},
+	})
+	gameboy, err := NewGameboy(Options{RomFilename: rom, CompatPalette: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.CGB() {
+		t.Error("expected a game made for the original Game Boy not to run in CGB mode")
+	}
+	if a := gameboy.Registers().A; a != 0x11 {
+		t.Errorf("expected A to be 0x11 on a CGB but got 0x%02x", a)
+	}
+	var pixel color.RGBA
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		pixel = frame.RGBAAt(0, 0)
+	})
+	// Show colour 1 everywhere
+	gameboy.WriteMemory(0xff47, 0x55)
+	gameboy.RunFrames(1)
+	if pixel != (color.RGBA{0xff, 0x84, 0x84, 0xff}) {
+		t.Errorf("expected the red palette but got %v", pixel)
+	}
+
+	if palette := autoCompatPalette(make([]byte, 0x8000)); palette != defaultCompatPalette {
+		t.Errorf("expected the default palette for a game not published by Nintendo but got %s", palette)
+	}
+	if _, err := NewGameboy(Options{RomFilename: rom, CompatPalette: "sideways"}); err == nil {
+		t.Error("expected an error for an unknown palette")
+	}
+}
+
+func TestSetCompatPalette(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pixel color.RGBA
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		pixel = frame.RGBAAt(0, 0)
+	})
+	// Show colour 1 everywhere
+	gameboy.WriteMemory(0xff47, 0x55)
+	gameboy.RunFrames(1)
+	original := pixel
+	if err := gameboy.SetCompatPalette("up+a"); err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(1)
+	if pixel != (color.RGBA{0xff, 0x84, 0x84, 0xff}) {
+		t.Errorf("expected the red palette but got %v", pixel)
+	}
+	if err := gameboy.SetCompatPalette(""); err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(1)
+	if pixel != original {
+		t.Errorf("expected the original colour %v but got %v", original, pixel)
+	}
+	if err := gameboy.SetCompatPalette("sideways"); err == nil {
+		t.Error("expected an error for an unknown palette")
+	}
+
+	cgb, err := NewGameboy(Options{RomFilename: writeCGBRom(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cgb.SetCompatPalette("up"); err == nil {
+		t.Error("expected an error for a Game Boy Color game")
+	}
+}
@@ -0,0 +1,225 @@
+package cheat
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kind identifies the format of a cheat code
+type Kind int
+
+const (
+	// GameShark codes patch RAM once per frame
+	GameShark = iota
+	// GameGenie codes intercept reads from ROM
+	GameGenie = iota
+)
+
+// Cheat is a single parsed cheat code
+type Cheat struct {
+	Code        string
+	Description string
+	Kind        Kind
+	Address     uint16
+	Value       uint8
+	Compare     uint8
+	HasCompare  bool
+	Enabled     bool
+}
+
+// Writer is the subset of memory access needed to apply RAM patches
+type Writer interface {
+	Write(addr uint16, value byte)
+}
+
+// Parse a GameShark code (e.g. 010138CD) or a Game Genie code (e.g. 00A-17B-C49 or 00A-17B)
+func Parse(code string) (*Cheat, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if strings.Contains(code, "-") {
+		return parseGameGenie(code)
+	}
+	return parseGameShark(code)
+}
+
+// GameShark format is ttvvaaaa where tt is the type/bank, vv is the value and aaaa is the
+// address in little-endian byte order
+func parseGameShark(code string) (*Cheat, error) {
+	if len(code) != 8 {
+		return nil, fmt.Errorf("GameShark code must be 8 hex digits: %s", code)
+	}
+	u, err := strconv.ParseUint(code, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("GameShark code must be 8 hex digits: %s", code)
+	}
+	value := uint8(u >> 16)
+	addr := uint16(u&0xff)<<8 | uint16(u>>8)&0xff
+	if addr < 0xa000 || addr >= 0xfe00 {
+		return nil, fmt.Errorf("GameShark code must patch RAM: %s (0x%04x)", code, addr)
+	}
+	return &Cheat{
+		Code:    code,
+		Kind:    GameShark,
+		Address: addr,
+		Value:   value,
+		Enabled: true,
+	}, nil
+}
+
+// Game Genie format is ABC-DEF or ABC-DEF-GHI where AB is the new value, FCDE is the ROM address
+// (with F inverted) and G and I encode an optional compare value
+func parseGameGenie(code string) (*Cheat, error) {
+	parts := strings.Split(code, "-")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) != 3 || len(parts[1]) != 3 ||
+		(len(parts) == 3 && len(parts[2]) != 3) {
+		return nil, fmt.Errorf("Game Genie code must be in the form ABC-DEF or ABC-DEF-GHI: %s", code)
+	}
+	digits := strings.Join(parts, "")
+	nibbles := make([]uint8, len(digits))
+	for i, c := range digits {
+		n, err := strconv.ParseUint(string(c), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Game Genie code must contain only hex digits: %s", code)
+		}
+		nibbles[i] = uint8(n)
+	}
+	addr := uint16(nibbles[5]^0xf)<<12 | uint16(nibbles[2])<<8 | uint16(nibbles[3])<<4 | uint16(nibbles[4])
+	if addr >= 0x8000 {
+		return nil, fmt.Errorf("Game Genie code must patch ROM: %s (0x%04x)", code, addr)
+	}
+	cheat := &Cheat{
+		Code:    code,
+		Kind:    GameGenie,
+		Address: addr,
+		Value:   nibbles[0]<<4 | nibbles[1],
+		Enabled: true,
+	}
+	if len(parts) == 3 {
+		compare := nibbles[6]<<4 | nibbles[8]
+		compare = compare>>2 | compare<<6
+		cheat.Compare = compare ^ 0xba
+		cheat.HasCompare = true
+	}
+	return cheat, nil
+}
+
+// Engine holds the active cheats and applies them to the running Gameboy
+type Engine struct {
+	cheats   []*Cheat
+	romCheat map[uint16][]*Cheat
+}
+
+// NewEngine returns an engine with no cheats
+func NewEngine() *Engine {
+	return &Engine{
+		romCheat: map[uint16][]*Cheat{},
+	}
+}
+
+// Add parses and adds a cheat code with an optional description
+func (e *Engine) Add(code, description string) (*Cheat, error) {
+	cheat, err := Parse(code)
+	if err != nil {
+		return nil, err
+	}
+	cheat.Description = description
+	e.cheats = append(e.cheats, cheat)
+	e.index()
+	return cheat, nil
+}
+
+// Remove a cheat code, returning false if it was not found
+func (e *Engine) Remove(code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for i, cheat := range e.cheats {
+		if cheat.Code == code {
+			e.cheats = append(e.cheats[:i], e.cheats[i+1:]...)
+			e.index()
+			return true
+		}
+	}
+	return false
+}
+
+// Enable or disable a cheat code, returning false if it was not found
+func (e *Engine) Enable(code string, enabled bool) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, cheat := range e.cheats {
+		if cheat.Code == code {
+			cheat.Enabled = enabled
+			e.index()
+			return true
+		}
+	}
+	return false
+}
+
+// Cheats returns the cheats known to the engine
+func (e *Engine) Cheats() []Cheat {
+	cheats := make([]Cheat, len(e.cheats))
+	for i, cheat := range e.cheats {
+		cheats[i] = *cheat
+	}
+	return cheats
+}
+
+// Load reads cheats from a file with one code per line, optionally followed by a description.
+// Blank lines and lines starting with '#' are ignored.
+func (e *Engine) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		var description string
+		if len(fields) == 2 {
+			description = strings.TrimSpace(fields[1])
+		}
+		if _, err := e.Add(fields[0], description); err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func (e *Engine) index() {
+	e.romCheat = map[uint16][]*Cheat{}
+	for _, cheat := range e.cheats {
+		if cheat.Enabled && cheat.Kind == GameGenie {
+			e.romCheat[cheat.Address] = append(e.romCheat[cheat.Address], cheat)
+		}
+	}
+}
+
+// PatchROM implements Game Genie style interception of ROM reads
+func (e *Engine) PatchROM(addr uint16, value uint8) uint8 {
+	if len(e.romCheat) == 0 {
+		return value
+	}
+	for _, cheat := range e.romCheat[addr] {
+		if !cheat.HasCompare || cheat.Compare == value {
+			return cheat.Value
+		}
+	}
+	return value
+}
+
+// ApplyRAM writes GameShark patches to RAM and should be called once per frame
+func (e *Engine) ApplyRAM(w Writer) {
+	for _, cheat := range e.cheats {
+		if cheat.Enabled && cheat.Kind == GameShark {
+			w.Write(cheat.Address, cheat.Value)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cheat
+
+import (
+	"testing"
+)
+
+type recorder map[uint16]uint8
+
+func (r recorder) Write(addr uint16, value byte) {
+	r[addr] = value
+}
+
+func TestParseGameShark(t *testing.T) {
+	cheat, err := Parse("010138cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cheat.Kind != GameShark || cheat.Address != 0xcd38 || cheat.Value != 0x01 {
+		t.Errorf("Wrong GameShark decode: %+v", cheat)
+	}
+	for _, code := range []string{"0101", "01013800", "0101zzcd"} {
+		if _, err := Parse(code); err == nil {
+			t.Errorf("Expected error for %s", code)
+		}
+	}
+}
+
+func TestParseGameGenie(t *testing.T) {
+	cheat, err := Parse("00a-17b-c49")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cheat.Kind != GameGenie || cheat.Address != 0x4a17 || cheat.Value != 0x00 || !cheat.HasCompare || cheat.Compare != 0xc8 {
+		t.Errorf("Wrong Game Genie decode: %+v", cheat)
+	}
+	cheat, err = Parse("3E1-0AB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cheat.Address != 0x410a || cheat.Value != 0x3e || cheat.HasCompare {
+		t.Errorf("Wrong Game Genie decode: %+v", cheat)
+	}
+	for _, code := range []string{"00A-17B-C4", "00A-17", "00A-173-C49", "0GA-17B"} {
+		if _, err := Parse(code); err == nil {
+			t.Errorf("Expected error for %s", code)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	e := NewEngine()
+	if _, err := e.Add("010138CD", "Infinite lives"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Add("00A-17B-C49", ""); err != nil {
+		t.Fatal(err)
+	}
+	if e.PatchROM(0x4a17, 0xc8) != 0x00 || e.PatchROM(0x4a17, 0x12) != 0x12 || e.PatchROM(0x4a18, 0xc8) != 0xc8 {
+		t.Errorf("Wrong ROM patching")
+	}
+	r := recorder{}
+	e.ApplyRAM(r)
+	if len(r) != 1 || r[0xcd38] != 0x01 {
+		t.Errorf("Wrong RAM patching: %v", r)
+	}
+	e.Enable("00a-17b-c49", false)
+	if e.PatchROM(0x4a17, 0xc8) != 0xc8 {
+		t.Errorf("Disabled cheat should not patch ROM")
+	}
+	if !e.Remove("010138CD") || e.Remove("010138CD") || len(e.Cheats()) != 1 {
+		t.Errorf("Wrong cheat removal")
+	}
+}
+
+type fakeRAM map[uint16]uint8
+
+func (r fakeRAM) Read(addr uint16) byte {
+	return r[addr]
+}
+
+func TestSearch(t *testing.T) {
+	ram := fakeRAM{0xc000: 3, 0xc001: 3, 0xd000: 5}
+	s := NewSearch(ram)
+	if s.Exact(3) != 2 {
+		t.Errorf("Wrong exact search: %v", s.Results(0))
+	}
+	ram[0xc000] = 2
+	if s.Decreased() != 1 {
+		t.Errorf("Wrong decreased search: %v", s.Results(0))
+	}
+	if s.Unchanged() != 1 || s.Increased() != 0 {
+		t.Errorf("Wrong unchanged/increased search: %v", s.Results(0))
+	}
+	s = NewSearch(ram)
+	ram[0xd000] = 6
+	if s.Changed() != 1 || len(s.Results(1)) != 1 || s.Results(0)[0] != (Result{Address: 0xd000, Previous: 5, Current: 6}) {
+		t.Errorf("Wrong changed search: %v", s.Results(0))
+	}
+}
@@ -0,0 +1,106 @@
+package cheat
+
+// Reader is the subset of memory access needed to search RAM
+type Reader interface {
+	Read(addr uint16) byte
+}
+
+// Cart RAM (A000-BFFF) and internal RAM (C000-DFFF) are searched
+const (
+	searchStart = 0xa000
+	searchEnd   = 0xe000
+)
+
+// Result is a candidate address found by a search
+type Result struct {
+	Address  uint16
+	Previous uint8
+	Current  uint8
+}
+
+// Search narrows down the RAM addresses that might hold a game variable by comparing successive
+// snapshots of memory, so that cheat codes can be built for them
+type Search struct {
+	reader     Reader
+	previous   [searchEnd - searchStart]uint8
+	current    [searchEnd - searchStart]uint8
+	candidates [searchEnd - searchStart]bool
+}
+
+// NewSearch starts a search with every RAM address as a candidate
+func NewSearch(reader Reader) *Search {
+	s := &Search{
+		reader: reader,
+	}
+	for i := range s.candidates {
+		s.candidates[i] = true
+	}
+	s.snapshot()
+	s.previous = s.current
+	return s
+}
+
+func (s *Search) snapshot() {
+	s.previous = s.current
+	for i := range s.current {
+		s.current[i] = s.reader.Read(uint16(searchStart + i))
+	}
+}
+
+func (s *Search) filter(keep func(previous, current uint8) bool) int {
+	s.snapshot()
+	var count int
+	for i, candidate := range s.candidates {
+		if candidate {
+			s.candidates[i] = keep(s.previous[i], s.current[i])
+			if s.candidates[i] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Exact keeps candidates whose value is now equal to value
+func (s *Search) Exact(value uint8) int {
+	return s.filter(func(_, current uint8) bool { return current == value })
+}
+
+// Increased keeps candidates whose value has increased since the last snapshot
+func (s *Search) Increased() int {
+	return s.filter(func(previous, current uint8) bool { return current > previous })
+}
+
+// Decreased keeps candidates whose value has decreased since the last snapshot
+func (s *Search) Decreased() int {
+	return s.filter(func(previous, current uint8) bool { return current < previous })
+}
+
+// Unchanged keeps candidates whose value is the same as the last snapshot
+func (s *Search) Unchanged() int {
+	return s.filter(func(previous, current uint8) bool { return current == previous })
+}
+
+// Changed keeps candidates whose value is different from the last snapshot
+func (s *Search) Changed() int {
+	return s.filter(func(previous, current uint8) bool { return current != previous })
+}
+
+// Results lists up to max remaining candidates, or all of them if max is zero
+func (s *Search) Results(max int) []Result {
+	var results []Result
+	for i, candidate := range s.candidates {
+		if !candidate {
+			continue
+		}
+		if max > 0 && len(results) >= max {
+			break
+		}
+		results = append(results, Result{
+			Address:  uint16(searchStart + i),
+			Previous: s.previous[i],
+			Current:  s.current[i],
+		})
+	}
+	return results
+}
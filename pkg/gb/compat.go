@@ -0,0 +1,151 @@
+package gb
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// compatPalette holds the colours a Game Boy Color gives the background and the two sprite palettes
+// of a game made for the original Game Boy
+type compatPalette struct {
+	bg, obj0, obj1 [4]color.RGBA
+}
+
+func rgb(hex ...uint32) [4]color.RGBA {
+	var colours [4]color.RGBA
+	for i, h := range hex {
+		colours[i] = color.RGBA{uint8(h >> 16), uint8(h >> 8), uint8(h), 0xff}
+	}
+	return colours
+}
+
+var (
+	brown      = rgb(0xffffff, 0xffad63, 0x843100, 0x000000)
+	pastelRed  = rgb(0xffffff, 0xff8484, 0x943a3a, 0x000000)
+	darkBrown  = rgb(0xffe6c5, 0xce9c84, 0x846b29, 0x5a3108)
+	pastelBlue = rgb(0xffffff, 0x63a5ff, 0x0000ff, 0x000000)
+	darkBlue   = rgb(0xffffff, 0x8c8cde, 0x52528c, 0x000000)
+	grey       = rgb(0xffffff, 0xa5a5a5, 0x525252, 0x000000)
+	pastelMix  = rgb(0xffffa5, 0xff9494, 0x9494ff, 0x000000)
+	orange     = rgb(0xffffff, 0xffff00, 0xff0000, 0x000000)
+	yellow     = rgb(0xffffff, 0xffff00, 0x7b4a00, 0x000000)
+	green      = rgb(0xffffff, 0x7bff31, 0x008400, 0x000000)
+	lime       = rgb(0xffffff, 0x52ff00, 0xff4200, 0x000000)
+	darkGreen  = rgb(0xffffff, 0x7bff31, 0x0063c5, 0x000000)
+	reverse    = rgb(0x000000, 0x008484, 0xffde00, 0xffffff)
+)
+
+// compatPalettes holds the palettes chosen by holding a direction and optionally A or B while the
+// Game Boy Color boots
+var compatPalettes = map[string]compatPalette{
+	"up":      {brown, brown, brown},
+	"up+a":    {pastelRed, pastelRed, pastelRed},
+	"up+b":    {darkBrown, darkBrown, darkBrown},
+	"left":    {pastelBlue, pastelRed, pastelRed},
+	"left+a":  {darkBlue, pastelRed, brown},
+	"left+b":  {grey, grey, grey},
+	"down":    {pastelMix, pastelMix, pastelMix},
+	"down+a":  {orange, orange, orange},
+	"down+b":  {yellow, pastelBlue, green},
+	"right":   {lime, lime, lime},
+	"right+a": {darkGreen, pastelRed, pastelRed},
+	"right+b": {reverse, reverse, reverse},
+}
+
+// defaultCompatPalette is used for games that the boot ROM doesn't recognise
+const defaultCompatPalette = "right+a"
+
+// compatTitles maps the titles of Nintendo games to the palettes the boot ROM gives them. The boot ROM
+// looks games up by the checksum of their title so any title with the same checksum matches too.
+var compatTitles = map[string]string{
+	"POKEMON RED":  "up+a",
+	"POKEMON BLUE": "left",
+}
+
+var compatChecksums = func() map[uint8]string {
+	checksums := map[uint8]string{}
+	for title, palette := range compatTitles {
+		checksums[titleChecksum([]byte(title))] = palette
+	}
+	return checksums
+}()
+
+// CompatPalettes returns the names of the palettes that can be chosen with Options.CompatPalette
+func CompatPalettes() []string {
+	names := []string{"auto"}
+	for name := range compatPalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// chooseCompatPalette returns the palette named by a button combination, or picks one for the ROM as
+// the boot ROM does if the name is "auto"
+func chooseCompatPalette(name string, rom []byte) (compatPalette, error) {
+	name = strings.ToLower(name)
+	if name == "auto" {
+		name = autoCompatPalette(rom)
+	}
+	palette, ok := compatPalettes[name]
+	if !ok {
+		return compatPalette{}, fmt.Errorf("unknown palette %q: expected one of %s", name, strings.Join(CompatPalettes(), ", "))
+	}
+	return palette, nil
+}
+
+// SetCompatPalette changes the colours of a game made for the original Game Boy while it runs, to a
+// palette named by a button combination or back to the colours it started with if the name is empty.
+// Game Boy Color and Super Game Boy games keep their own colours.
+func (gb *Gameboy) SetCompatPalette(name string) error {
+	if gb.cgb || gb.opts.SGB {
+		return fmt.Errorf("only games made for the original Game Boy can change palette")
+	}
+	if gb.startPalette == nil {
+		var palette compatPalette
+		palette.bg, palette.obj0, palette.obj1 = gb.lcd.Palettes()
+		gb.startPalette = &palette
+	}
+	palette := *gb.startPalette
+	if name != "" {
+		var ok bool
+		palette, ok = compatPalettes[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown palette %q: expected one of %s", name, strings.Join(CompatPalettes()[1:], ", "))
+		}
+	}
+	gb.lcd.SetPalettes(palette.bg, palette.obj0, palette.obj1)
+	return nil
+}
+
+// autoCompatPalette finds the palette for a ROM from the checksum of its title, only recognising
+// games published by Nintendo
+func autoCompatPalette(rom []byte) string {
+	if len(rom) < 0x0150 {
+		return defaultCompatPalette
+	}
+	licensee := rom[0x014b]
+	if licensee == 0x33 {
+		// The new licensee code is two ASCII characters
+		if string(rom[0x0144:0x0146]) != "01" {
+			return defaultCompatPalette
+		}
+	} else if licensee != 0x01 {
+		return defaultCompatPalette
+	}
+	palette, ok := compatChecksums[titleChecksum(rom[0x0134:0x0144])]
+	if !ok {
+		return defaultCompatPalette
+	}
+	return palette
+}
+
+func titleChecksum(title []byte) uint8 {
+	var checksum uint8
+	for _, b := range title {
+		checksum += b
+	}
+	return checksum
+}
@@ -0,0 +1,80 @@
+package gb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// EnableCoverage starts recording which ROM bytes are executed as instructions, including their
+// operands, so that code can be told apart from data
+func (gb *Gameboy) EnableCoverage() {
+	if gb.coverage != nil {
+		return
+	}
+	gb.coverage = make([][0x4000]bool, gb.memory.ROMBanks())
+	gb.dispatch.OnExecute = gb.execute
+}
+
+func (gb *Gameboy) cover(pc uint16, length int) {
+	for i := 0; i < length; i++ {
+		addr := pc + uint16(i)
+		if bank, ok := gb.memory.ROMBank(addr); ok {
+			gb.coverage[bank][addr&0x3fff] = true
+		}
+	}
+}
+
+// Coverage returns the number of ROM bytes executed so far and the size of the ROM
+func (gb *Gameboy) Coverage() (int, int) {
+	var covered int
+	for _, bank := range gb.coverage {
+		for _, executed := range bank {
+			if executed {
+				covered++
+			}
+		}
+	}
+	return covered, gb.memory.ROMBanks() * 0x4000
+}
+
+// WriteCoverage writes the executed ROM address ranges, one per line, as the bank number followed
+// by the range as it appears in the CPU address space e.g. "01:4000-40ff"
+func (gb *Gameboy) WriteCoverage(w io.Writer) error {
+	covered, total := gb.Coverage()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s: %d of %d ROM bytes executed\n", gb.opts.RomFilename, covered, total)
+	for bank := range gb.coverage {
+		base := 0x4000
+		if bank == 0 {
+			base = 0
+		}
+		start := -1
+		for offset := 0; offset <= 0x4000; offset++ {
+			executed := offset < 0x4000 && gb.coverage[bank][offset]
+			switch {
+			case executed && start < 0:
+				start = offset
+			case !executed && start >= 0:
+				fmt.Fprintf(bw, "%02x:%04x-%04x\n", bank, base+start, base+offset-1)
+				start = -1
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// SaveCoverage writes the coverage map to a file
+func (gb *Gameboy) SaveCoverage(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = gb.WriteCoverage(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
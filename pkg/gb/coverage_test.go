@@ -0,0 +1,25 @@
+package gb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.EnableCoverage()
+	for i := 0; i < 4; i++ {
+		gameboy.Step()
+	}
+	var buf bytes.Buffer
+	if err := gameboy.WriteCoverage(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# : 4 of 32768 ROM bytes executed\n00:0100-0103\n"
+	if buf.String() != expected {
+		t.Errorf("expected coverage %q but got %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,75 @@
+package cpu
+
+import "fmt"
+
+// maxCallDepth limits the shadow call stack for code that calls without ever returning
+const maxCallDepth = 1024
+
+// Frame is an entry in the shadow call stack, recorded when a CALL or RST is taken or an interrupt
+// is dispatched
+type Frame struct {
+	Caller    uint16
+	Target    uint16
+	Return    uint16
+	SP        uint16
+	Interrupt bool
+}
+
+func (f Frame) String() string {
+	kind := "call"
+	if f.Interrupt {
+		kind = "interrupt"
+	}
+	return fmt.Sprintf("0x%04x: %s 0x%04x returning to 0x%04x (sp:%04x)", f.Caller, kind, f.Target, f.Return, f.SP)
+}
+
+// pendingCall is an instruction or interrupt that may push a frame when it completes
+type pendingCall struct {
+	active    bool
+	caller    uint16
+	ret       uint16
+	sp        uint16
+	interrupt bool
+}
+
+// IsCall returns true if an opcode is a CALL or RST instruction
+func IsCall(opcode uint8) bool {
+	switch opcode {
+	case 0xc4, 0xcc, 0xcd, 0xd4, 0xdc, 0xc7, 0xcf, 0xd7, 0xdf, 0xe7, 0xef, 0xf7, 0xff:
+		return true
+	}
+	return false
+}
+
+// CallStack returns the shadow call stack with the innermost frame last
+func (d *Dispatch) CallStack() []Frame {
+	return append([]Frame(nil), d.callStack...)
+}
+
+// CallDepth returns the number of frames on the shadow call stack
+func (d *Dispatch) CallDepth() int {
+	return len(d.callStack)
+}
+
+// settleCallStack runs when an instruction completes. It pushes a frame if a call was taken and
+// drops any frames that have been returned from, which also copes with code that discards return
+// addresses or reloads SP.
+func (d *Dispatch) settleCallStack() {
+	sp := d.cpu.sp
+	if d.pending.active && sp == d.pending.sp-2 {
+		if len(d.callStack) == maxCallDepth {
+			d.callStack = append(d.callStack[:0], d.callStack[1:]...)
+		}
+		d.callStack = append(d.callStack, Frame{
+			Caller:    d.pending.caller,
+			Target:    d.cpu.pc,
+			Return:    d.pending.ret,
+			SP:        sp,
+			Interrupt: d.pending.interrupt,
+		})
+	}
+	d.pending = pendingCall{}
+	for len(d.callStack) > 0 && d.callStack[len(d.callStack)-1].SP < sp {
+		d.callStack = d.callStack[:len(d.callStack)-1]
+	}
+}
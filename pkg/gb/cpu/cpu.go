@@ -70,6 +70,43 @@ func NewCPU(debugCPU bool) *CPU {
 	}
 }
 
+// NewSGBCPU returns a CPU initialized as a Super Game Boy does on start
+func NewSGBCPU(debugCPU bool) *CPU {
+	return &CPU{
+		debugCPU: debugCPU,
+		ime:      true,
+		a:        0x01,
+		f:        0x00,
+		b:        0x00,
+		c:        0x14,
+		d:        0x00,
+		e:        0x00,
+		h:        0xc0,
+		l:        0x60,
+		sp:       0xfffe,
+		pc:       0x0100,
+	}
+}
+
+// NewCGBCPU returns a CPU initialized as a Gameboy Color does on start, which games check to detect
+// the hardware
+func NewCGBCPU(debugCPU bool) *CPU {
+	return &CPU{
+		debugCPU: debugCPU,
+		ime:      true,
+		a:        0x11,
+		f:        0x80,
+		b:        0x00,
+		c:        0x00,
+		d:        0xff,
+		e:        0x56,
+		h:        0x00,
+		l:        0x0d,
+		sp:       0xfffe,
+		pc:       0x0100,
+	}
+}
+
 func (cpu *CPU) bc() uint16 {
 	return uint16(cpu.b)<<8 + uint16(cpu.c)
 }
@@ -0,0 +1,70 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction is a single disassembled instruction
+type Instruction struct {
+	Addr  uint16
+	Bytes []uint8
+	Text  string
+}
+
+func (i Instruction) String() string {
+	var b strings.Builder
+	for _, value := range i.Bytes {
+		fmt.Fprintf(&b, "%02x ", value)
+	}
+	return fmt.Sprintf("0x%04x: %-9s %s", i.Addr, b.String(), i.Text)
+}
+
+// Disassemble decodes the instruction at an address, reading memory with the given function
+func Disassemble(read func(uint16) uint8, addr uint16) Instruction {
+	opcode := read(addr)
+	md := instructionMetadata[opcode]
+	if md == nil || md.Addr == "" {
+		return Instruction{Addr: addr, Bytes: []uint8{opcode}, Text: fmt.Sprintf("DB $%02x", opcode)}
+	}
+	length := md.Length
+	if opcode == 0xcb {
+		md = prefixedInstructionMetadata[read(addr+1)]
+		length = 2
+	}
+	bytes := make([]uint8, length)
+	for i := range bytes {
+		bytes[i] = read(addr + uint16(i))
+	}
+	var operands []string
+	for _, operand := range []string{md.Operand1, md.Operand2} {
+		if operand != "" {
+			operands = append(operands, formatOperand(operand, addr, bytes))
+		}
+	}
+	text := md.Mnemonic
+	if len(operands) > 0 {
+		text += " " + strings.Join(operands, ",")
+	}
+	return Instruction{Addr: addr, Bytes: bytes, Text: text}
+}
+
+// formatOperand replaces placeholders for immediate values in an operand with the values themselves
+func formatOperand(operand string, addr uint16, bytes []uint8) string {
+	switch {
+	case strings.Contains(operand, "d16"), strings.Contains(operand, "a16"):
+		u16 := uint16(bytes[1]) | uint16(bytes[2])<<8
+		operand = strings.Replace(operand, "d16", fmt.Sprintf("$%04x", u16), 1)
+		return strings.Replace(operand, "a16", fmt.Sprintf("$%04x", u16), 1)
+	case strings.Contains(operand, "d8"):
+		return strings.Replace(operand, "d8", fmt.Sprintf("$%02x", bytes[1]), 1)
+	case strings.Contains(operand, "a8"):
+		return strings.Replace(operand, "a8", fmt.Sprintf("$ff%02x", bytes[1]), 1)
+	case operand == "r8":
+		// Relative jumps are shown with their destination
+		return fmt.Sprintf("$%04x", addr+2+uint16(int8(bytes[1])))
+	case strings.Contains(operand, "r8"):
+		return strings.Replace(operand, "+r8", fmt.Sprintf("%+d", int8(bytes[1])), 1)
+	}
+	return operand
+}
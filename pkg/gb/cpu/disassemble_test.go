@@ -0,0 +1,40 @@
+package cpu
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	rom := []uint8{
+		0x00,       // NOP
+		0x3e, 0x42, // LD A,$42
+		0xc3, 0x50, 0x01, // JP $0150
+		0x20, 0xfe, // JR NZ,$0006
+		0xe0, 0x40, // LDH ($ff40),A
+		0xf8, 0xfc, // LD HL,SP-4
+		0xcb, 0x7c, // BIT 7,H
+		0xd3, // Illegal
+	}
+	read := func(addr uint16) uint8 {
+		if int(addr) < len(rom) {
+			return rom[addr]
+		}
+		return 0
+	}
+	expected := []string{
+		"NOP",
+		"LD A,$42",
+		"JP $0150",
+		"JR NZ,$0006",
+		"LDH ($ff40),A",
+		"LD HL,SP-4",
+		"BIT 7,H",
+		"DB $d3",
+	}
+	var addr uint16
+	for _, text := range expected {
+		instruction := Disassemble(read, addr)
+		if instruction.Text != text {
+			t.Errorf("0x%04x: expected %q but got %q", addr, text, instruction.Text)
+		}
+		addr += uint16(len(instruction.Bytes))
+	}
+}
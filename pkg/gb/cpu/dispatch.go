@@ -2,6 +2,7 @@ package cpu
 
 import (
 	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/logging"
 )
 
 // Dispatch determines how CPU instructions are dispatched
@@ -10,27 +11,65 @@ type Dispatch struct {
 	memory            *mem.Memory
 	normal            [256][]func()
 	prefix            [256][]func()
-	steps             *[]func()
+	alt               [256][]func()
+	interruptSteps    [7][]func()
+	steps             []func()
 	stepIndex         int
 	handlingInterrupt bool
+	traceRing         []TraceEntry
+	traceIndex        int
+	instructions      uint64
+	instructionPC     uint16
+	interrupts        uint64
+	lastInterrupt     uint8
+	callStack         []Frame
+	pending           pendingCall
+	log               *logging.Logger
 	Mooneye           bool
+
+	// OnExecute is called with the address and length of each instruction as it starts
+	OnExecute func(pc uint16, length int)
 }
 
 // NewDispatch returns a Dispatch instance bringing the CPU and memory together
 func NewDispatch(cpu *CPU, memory *mem.Memory) *Dispatch {
-	initialSteps := []func(){}
 	dispatch := &Dispatch{
-		cpu:    cpu,
-		memory: memory,
-		steps:  &initialSteps,
+		cpu:       cpu,
+		memory:    memory,
+		steps:     []func(){},
+		traceRing: make([]TraceEntry, DefaultTraceLength),
+		log:       logging.Default().With("cpu"),
 	}
 	dispatch.initialize(cpu, memory)
+	dispatch.initializeTables()
 	return dispatch
 }
 
-// TestA returns the value of register a for test purposes
-func (d *Dispatch) TestA() uint8 {
-	return d.cpu.a
+// SetLogger changes where executed instructions are logged when CPU debugging is enabled
+func (d *Dispatch) SetLogger(logger *logging.Logger) {
+	d.log = logger
+}
+
+// Instructions returns the number of instructions executed so far
+func (d *Dispatch) Instructions() uint64 {
+	return d.instructions
+}
+
+// InstructionPC returns the address of the instruction that is executing, or that last executed
+// when the CPU is at an instruction boundary
+func (d *Dispatch) InstructionPC() uint16 {
+	return d.instructionPC
+}
+
+// Interrupts returns the number of interrupts dispatched so far and the IF bit of the most recent one
+func (d *Dispatch) Interrupts() (uint64, uint8) {
+	return d.interrupts, d.lastInterrupt
+}
+
+// InstructionBoundary returns true if the CPU has finished an instruction and will fetch the next one
+// from PC on the following machine cycle
+func (d *Dispatch) InstructionBoundary() bool {
+	return d.stepIndex == len(d.steps)
 }
 
 // Start the CPU again on button press
@@ -38,6 +77,27 @@ func (d *Dispatch) Start() {
 	d.cpu.stopped = false
 }
 
+// initializeTables builds the steps of conditional instructions that aren't taken and of interrupts
+// once, so that dispatching them never allocates
+func (d *Dispatch) initializeTables() {
+	for opcode, md := range instructionMetadata {
+		if md != nil && md.AltMachineCycles > 0 {
+			d.alt[opcode] = d.normal[opcode][:md.AltMachineCycles:md.AltMachineCycles]
+		}
+	}
+	// An interrupt takes 5 machine cycles plus one more to leave HALT, or just one to leave HALT when
+	// interrupts are disabled
+	handler := d.handleInterrupt()
+	for _, length := range []int{1, 5, 6} {
+		steps := make([]func(), length)
+		for i := range steps {
+			steps[i] = nop
+		}
+		steps[length-1] = handler
+		d.interruptSteps[length] = steps
+	}
+}
+
 func nop() {
 	// Do nothing
 }
@@ -109,7 +169,7 @@ func (d *Dispatch) initialize(cpu *CPU, mem *mem.Memory) {
 	d.normal[0x0f] = []func(){cpu.rrca}
 
 	// STOP 0  [] 1 [4]
-	d.normal[0x10] = []func(){cpu.stop}
+	d.normal[0x10] = []func(){cpu.stop(mem)}
 
 	// LD DE d16 [] 3 [12]
 	d.normal[0x11] = []func(){d.readParamA, d.readParamB, cpu.ldDEU16}
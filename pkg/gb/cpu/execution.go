@@ -11,42 +11,49 @@ func (d *Dispatch) handleInterrupt() func() {
 		if cpu.ime {
 			interrupts := memory.IE & memory.IF & 0x1f
 			cpu.ime = false
+			d.pending = pendingCall{active: true, caller: cpu.pc, ret: cpu.pc, sp: cpu.sp, interrupt: true}
 
 			switch {
 			case interrupts&bit0 > 0:
 				// 0040 Vertical Blank Interrupt Start Address
 				cpu.rst(0x0040)()
 				memory.IF &^= bit0
+				d.lastInterrupt = bit0
 			case interrupts&bit1 > 0:
 				// 0048 LCDC Status Interrupt Start Address
 				cpu.rst(0x0048)()
 				memory.IF &^= bit1
+				d.lastInterrupt = bit1
 			case interrupts&bit2 > 0:
 				// 0050 Timer OverflowInterrupt Start Address
 				cpu.rst(0x0050)()
 				memory.IF &^= bit2
+				d.lastInterrupt = bit2
 			case interrupts&bit3 > 0:
 				// 0058 Serial Transfer Completion Interrupt Start Address
 				cpu.rst(0x0058)()
 				memory.IF &^= bit3
+				d.lastInterrupt = bit3
 			case interrupts&bit4 > 0:
 				// 0060 High-to-Low of P10-P13 Interrupt Start Address
 				cpu.rst(0x0060)()
 				memory.IF &^= bit4
+				d.lastInterrupt = bit4
 			}
 
 			// Now push the PC
 			cpu.push(memory, &cpu.m8b)()
 			cpu.push(memory, &cpu.m8a)()
+			d.interrupts++
 		}
 	}
 }
 
-func (d *Dispatch) checkInterrupts() *[]func() {
+func (d *Dispatch) checkInterrupts() []func() {
 	cpu := d.cpu
 	memory := d.memory
 	var length int
-	interrupts := memory.IE & memory.IF & 0x1f
+	interrupts := memory.IE & memory.PendingInterrupts() & 0x1f
 	if interrupts > 0 {
 		if cpu.halted {
 			cpu.halted = false
@@ -59,52 +66,28 @@ func (d *Dispatch) checkInterrupts() *[]func() {
 	if length == 0 {
 		return nil
 	}
-	steps := make([]func(), length)
-	for i := range steps {
-		steps[i] = nop
-	}
-	steps[length-1] = d.handleInterrupt()
-	return &steps
+	return d.interruptSteps[length]
 }
 
+// useAltMachineCycles returns true if a conditional JR, JP, CALL or RET isn't taken, which makes it
+// take fewer machine cycles
 func (d *Dispatch) useAltMachineCycles(instruction uint8) bool {
 	cpu := d.cpu
-	switch instruction {
-
-	// CALL 3 [24 12] [] 0xc4 NZ a16 196 false 6 3 <nil>
-	// JP 3 [16 12] [] 0xc2 NZ a16 194 false 4 3 <nil>
-	// JR 2 [12 8] [] 0x20 NZ r8 32 false 3 2 <nil>
-	// RET 1 [20 8] [] 0xc0 NZ  192 false 5 2 <nil>
-	case 0xc4, 0xc2, 0x20, 0xc0:
+	switch conditions[instruction] {
+	case ifNZ:
 		return cpu.zf()
-
-	// CALL 3 [24 12] [] 0xcc Z a16 204 false 6 3 <nil>
-	// JP 3 [16 12] [] 0xca Z a16 202 false 4 3 <nil>
-	// JR 2 [12 8] [] 0x28 Z r8 40 false 3 2 <nil>
-	// RET 1 [20 8] [] 0xc8 Z  200 false 5 2 <nil>
-	case 0xcc, 0xca, 0x28, 0xc8:
+	case ifZ:
 		return !cpu.zf()
-
-	// CALL 3 [24 12] [] 0xd4 NC a16 212 false 6 3 <nil>
-	// JP 3 [16 12] [] 0xd2 NC a16 210 false 4 3 <nil>
-	// JR 2 [12 8] [] 0x30 NC r8 48 false 3 2 <nil>
-	// RET 1 [20 8] [] 0xd0 NC  208 false 5 2 <nil>
-	case 0xd4, 0xd2, 0x30, 0xd0:
+	case ifNC:
 		return cpu.cf()
-
-	// CALL 3 [24 12] [] 0xdc C a16 220 false 6 3 <nil>
-	// JP 3 [16 12] [] 0xda C a16 218 false 4 3 <nil>
-	// JR 2 [12 8] [] 0x38 C r8 56 false 3 2 <nil>
-	// RET 1 [20 8] [] 0xd8 C  216 false 5 2 <nil>
-	case 0xdc, 0xda, 0x38, 0xd8:
+	case ifC:
 		return !cpu.cf()
-
 	}
 	return false
 }
 
 // Every instruction is implemented as a list of steps that take one machine cycle each
-func (d *Dispatch) peek() *[]func() {
+func (d *Dispatch) peek() []func() {
 	cpu := d.cpu
 	memory := d.memory
 	instructionByte := memory.Read(cpu.pc)
@@ -114,14 +97,23 @@ func (d *Dispatch) peek() *[]func() {
 		d.Mooneye = true
 	}
 	md := instructionMetadata[instructionByte]
-	if md.Addr == "" {
-		panic(fmt.Sprintf("Unknown instruction opcode: %v", md))
+	if md == nil {
+		panic(fmt.Sprintf("Unknown instruction opcode: 0x%02x", instructionByte))
 	}
 	if instructionByte == 0xcb {
 		instructionByte = memory.Read(cpu.pc + 1)
 		md = prefixedInstructionMetadata[instructionByte]
 	}
 	pc := cpu.pc
+	d.trace(pc, md)
+	d.instructions++
+	d.instructionPC = pc
+	if d.OnExecute != nil {
+		d.OnExecute(pc, md.Length)
+	}
+	if !md.Prefixed && calls[md.Dispatch] {
+		d.pending = pendingCall{active: true, caller: pc, ret: pc + uint16(md.Length), sp: cpu.sp}
+	}
 	var steps []func()
 	var value string
 	if md.Prefixed {
@@ -150,12 +142,12 @@ func (d *Dispatch) peek() *[]func() {
 		cpu.m8a = 0
 		cpu.m8b = 0
 
-		// Get the steps associated with this instruction
-		steps = d.normal[md.Dispatch]
-
-		// Check for instructions that need to use the shorter alt machine cycle count
+		// Get the steps associated with this instruction, using the shorter alt machine cycle count
+		// for conditional instructions that aren't taken
 		if d.useAltMachineCycles(md.Dispatch) {
-			steps = steps[:md.AltMachineCycles]
+			steps = d.alt[md.Dispatch]
+		} else {
+			steps = d.normal[md.Dispatch]
 		}
 
 		// Finally increment PC
@@ -166,17 +158,17 @@ func (d *Dispatch) peek() *[]func() {
 		}
 	}
 	if cpu.debugCPU {
-		fmt.Printf("0x%04x: [%02x] %-12s | %-4s | a:%02x b:%02x c:%02x d:%02x e:%02x f:%02x h:%02x l:%02x sp:%04x\n",
+		d.log.Debugf("0x%04x: [%02x] %-12s | %-4s | a:%02x b:%02x c:%02x d:%02x e:%02x f:%02x h:%02x l:%02x sp:%04x",
 			pc, md.Dispatch, fmt.Sprintf("%s %s %s", md.Mnemonic, md.Operand1, md.Operand2), value, cpu.a, cpu.b, cpu.c, cpu.d, cpu.e, cpu.f, cpu.h, cpu.l, cpu.sp)
 	}
-	return &steps
+	return steps
 }
 
 // ExecuteMachineCycle runs the CPU for one machine cycle
 func (d *Dispatch) ExecuteMachineCycle() {
 	cpu := d.cpu
-	if d.stepIndex == len(*d.steps) {
-		var steps *[]func()
+	if d.stepIndex == len(d.steps) {
+		var steps []func()
 		if !d.handlingInterrupt {
 			steps = d.checkInterrupts()
 			if cpu.halted || cpu.stopped {
@@ -194,7 +186,10 @@ func (d *Dispatch) ExecuteMachineCycle() {
 		d.stepIndex = 0
 		d.steps = steps
 	}
-	step := (*d.steps)[d.stepIndex]
+	step := d.steps[d.stepIndex]
 	step()
 	d.stepIndex++
+	if d.stepIndex == len(d.steps) {
+		d.settleCallStack()
+	}
 }
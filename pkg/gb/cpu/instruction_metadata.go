@@ -22,6 +22,24 @@ type metadata struct {
 	Func             func(*CPU, *mem.Memory, string, string, uint8, uint16) map[string]bool
 }
 
+// condition is the flag test that decides whether a conditional jump, call or return is taken
+type condition uint8
+
+const (
+	always condition = iota
+	ifNZ
+	ifZ
+	ifNC
+	ifC
+)
+
+// conditions and calls are built from the metadata once at init so that dispatching an instruction
+// only indexes flat arrays
+var (
+	conditions [256]condition
+	calls      [256]bool
+)
+
 type metadataContainer struct {
 	Unprefixed map[string]*metadata `json:"unprefixed"`
 	Cbprefixed map[string]*metadata `json:"cbprefixed"`
@@ -97,6 +115,25 @@ func init() {
 	}
 	initInstructionArray(metadataContainer.Unprefixed, &instructionMetadata, false)
 	initInstructionArray(metadataContainer.Cbprefixed, &prefixedInstructionMetadata, true)
+	for opcode, md := range instructionMetadata {
+		if md == nil {
+			continue
+		}
+		calls[opcode] = IsCall(uint8(opcode))
+		if md.AltMachineCycles == 0 {
+			continue
+		}
+		switch md.Operand1 {
+		case "NZ":
+			conditions[opcode] = ifNZ
+		case "Z":
+			conditions[opcode] = ifZ
+		case "NC":
+			conditions[opcode] = ifNC
+		case "C":
+			conditions[opcode] = ifC
+		}
+	}
 }
 
 var metadataJSON = `
@@ -0,0 +1,34 @@
+package cpu
+
+import (
+	"testing"
+)
+
+func TestDispatchTables(t *testing.T) {
+	// Every conditional JR, JP, CALL and RET has a condition
+	for _, test := range []struct {
+		opcode    uint8
+		condition condition
+	}{
+		{0x20, ifNZ}, {0x28, ifZ}, {0x30, ifNC}, {0x38, ifC},
+		{0xc2, ifNZ}, {0xca, ifZ}, {0xd2, ifNC}, {0xda, ifC},
+		{0xc4, ifNZ}, {0xcc, ifZ}, {0xd4, ifNC}, {0xdc, ifC},
+		{0xc0, ifNZ}, {0xc8, ifZ}, {0xd0, ifNC}, {0xd8, ifC},
+		{0x18, always}, {0xc3, always}, {0xcd, always}, {0xc9, always},
+	} {
+		if c := conditions[test.opcode]; c != test.condition {
+			t.Errorf("expected condition %d for 0x%02x but got %d", test.condition, test.opcode, c)
+		}
+	}
+	if !calls[0xcd] || !calls[0xff] || calls[0xc3] {
+		t.Error("expected CALL and RST to be the only calls")
+	}
+
+	d := NewDispatch(NewCPU(false), nil)
+	if len(d.alt[0x20]) != 2 || len(d.normal[0x20]) != 3 {
+		t.Errorf("expected JR NZ to take 2 machine cycles when not taken and 3 when taken")
+	}
+	if len(d.interruptSteps[5]) != 5 || len(d.interruptSteps[6]) != 6 {
+		t.Errorf("expected interrupts to take 5 machine cycles and 6 from HALT")
+	}
+}
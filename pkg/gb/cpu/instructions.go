@@ -1037,8 +1037,16 @@ func (cpu *CPU) scf() {
 	cpu.setCf(true)
 }
 
-func (cpu *CPU) stop() {
-	cpu.stopped = true
+func (cpu *CPU) stop(mem *mem.Memory) func() {
+	return func() {
+		// STOP resets the divider, whether or not it stops the CPU
+		mem.ResetDivider()
+		// A CGB switches speed instead of stopping if the switch was prepared using KEY1
+		if mem.SwitchSpeed() {
+			return
+		}
+		cpu.stopped = true
+	}
 }
 
 func (cpu *CPU) sbcM(mem *mem.Memory) func() {
@@ -0,0 +1,42 @@
+package cpu
+
+// State is a snapshot of the CPU between instructions, for save states
+type State struct {
+	Registers Registers
+	// HaltBug is true when HALT was executed with an interrupt pending but interrupts disabled, so
+	// the next byte is read twice
+	HaltBug bool
+	// HandlingInterrupt is true when an interrupt has just been dispatched, so that one instruction
+	// of its handler runs before interrupts are checked again
+	HandlingInterrupt bool
+	Instructions      uint64
+	Interrupts        uint64
+	LastInterrupt     uint8
+}
+
+// Snapshot returns the state of the CPU, which must be at an instruction boundary
+func (d *Dispatch) Snapshot() State {
+	return State{
+		Registers:         d.Registers(),
+		HaltBug:           d.cpu.haltbug,
+		HandlingInterrupt: d.handlingInterrupt,
+		Instructions:      d.instructions,
+		Interrupts:        d.interrupts,
+		LastInterrupt:     d.lastInterrupt,
+	}
+}
+
+// Restore returns the CPU to a state taken by Snapshot, ready to fetch the next instruction. The
+// call stack kept for the debuggers starts again empty.
+func (d *Dispatch) Restore(s State) {
+	d.SetRegisters(s.Registers)
+	d.cpu.haltbug = s.HaltBug
+	d.handlingInterrupt = s.HandlingInterrupt
+	d.instructions = s.Instructions
+	d.interrupts = s.Interrupts
+	d.lastInterrupt = s.LastInterrupt
+	d.steps = nil
+	d.stepIndex = 0
+	d.callStack = nil
+	d.pending = pendingCall{}
+}
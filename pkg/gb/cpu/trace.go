@@ -0,0 +1,105 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// DefaultTraceLength is the number of recently executed instructions kept unless configured otherwise
+const DefaultTraceLength = 64
+
+// Registers is a snapshot of the CPU registers
+type Registers struct {
+	A, F, B, C, D, E, H, L uint8
+	SP, PC                 uint16
+	IME, Halted, Stopped   bool
+}
+
+func (r Registers) String() string {
+	return fmt.Sprintf("a:%02x f:%02x b:%02x c:%02x d:%02x e:%02x h:%02x l:%02x sp:%04x pc:%04x ime:%t halted:%t stopped:%t",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC, r.IME, r.Halted, r.Stopped)
+}
+
+// TraceEntry records an executed instruction and the registers before it ran
+type TraceEntry struct {
+	Opcode    uint8
+	Prefixed  bool
+	Mnemonic  string
+	Registers Registers
+}
+
+func (t TraceEntry) String() string {
+	return fmt.Sprintf("0x%04x: [%02x] %-12s | %s", t.Registers.PC, t.Opcode, t.Mnemonic, t.Registers)
+}
+
+// Registers returns a snapshot of the CPU registers
+func (d *Dispatch) Registers() Registers {
+	cpu := d.cpu
+	return Registers{
+		A:       cpu.a,
+		F:       cpu.f,
+		B:       cpu.b,
+		C:       cpu.c,
+		D:       cpu.d,
+		E:       cpu.e,
+		H:       cpu.h,
+		L:       cpu.l,
+		SP:      cpu.sp,
+		PC:      cpu.pc,
+		IME:     cpu.ime,
+		Halted:  cpu.halted,
+		Stopped: cpu.stopped,
+	}
+}
+
+// SetRegisters overwrites the CPU registers
+func (d *Dispatch) SetRegisters(r Registers) {
+	cpu := d.cpu
+	cpu.a = r.A
+	cpu.f = r.F & 0xf0
+	cpu.b = r.B
+	cpu.c = r.C
+	cpu.d = r.D
+	cpu.e = r.E
+	cpu.h = r.H
+	cpu.l = r.L
+	cpu.sp = r.SP
+	cpu.pc = r.PC
+	cpu.ime = r.IME
+	cpu.halted = r.Halted
+	cpu.stopped = r.Stopped
+}
+
+// SetTraceLength changes how many recently executed instructions are kept, discarding the current
+// trace. A length of zero turns tracing off.
+func (d *Dispatch) SetTraceLength(length int) {
+	d.traceRing = make([]TraceEntry, length)
+	d.traceIndex = 0
+}
+
+func (d *Dispatch) trace(pc uint16, md *metadata) {
+	if len(d.traceRing) == 0 {
+		return
+	}
+	registers := d.Registers()
+	registers.PC = pc
+	d.traceRing[d.traceIndex%len(d.traceRing)] = TraceEntry{
+		Opcode:    md.Dispatch,
+		Prefixed:  md.Prefixed,
+		Mnemonic:  md.Mnemonic,
+		Registers: registers,
+	}
+	d.traceIndex++
+}
+
+// Trace returns the most recently executed instructions, oldest first
+func (d *Dispatch) Trace() []TraceEntry {
+	count := d.traceIndex
+	if count > len(d.traceRing) {
+		count = len(d.traceRing)
+	}
+	entries := make([]TraceEntry, 0, count)
+	for i := d.traceIndex - count; i < d.traceIndex; i++ {
+		entries = append(entries, d.traceRing[i%len(d.traceRing)])
+	}
+	return entries
+}
@@ -0,0 +1,64 @@
+package gb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+)
+
+// recoverCrash writes a dump of the machine state when the emulator panics and then panics again
+// so that the usual stack trace is still shown
+func (gb *Gameboy) recoverCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	filename := timestampedFilename(gb.opts.CrashDumpDir, "tetromino-crash", "txt")
+	f, err := os.Create(filename)
+	if err != nil {
+		gb.log.Errorf("Failed to write crash dump: %v", err)
+		panic(r)
+	}
+	defer f.Close()
+	gb.writeCrashDump(f, r, stack)
+	gb.log.Errorf("Crashed with %v, writing crash dump to %s", r, filename)
+	panic(r)
+}
+
+func (gb *Gameboy) writeCrashDump(w io.Writer, r interface{}, stack []byte) {
+	fmt.Fprintf(w, "Panic: %v\n", r)
+	fmt.Fprintf(w, "ROM: %s\n", gb.opts.RomFilename)
+	fmt.Fprintf(w, "Frame: %d\n\n", gb.frame)
+	fmt.Fprintf(w, "Registers:\n%s\n\n", gb.dispatch.Registers())
+	fmt.Fprintf(w, "Recent instructions:\n")
+	gb.WriteTrace(w)
+	fmt.Fprintf(w, "\nIO registers:\n")
+	gb.dumpMemory(w, 0xff00, 0xff80)
+	fmt.Fprintf(w, "IE: %02x\n", gb.memory.IE)
+	for _, region := range []struct {
+		name       string
+		start, end int
+	}{
+		{"Video RAM", 0x8000, 0xa000},
+		{"Cartridge RAM", 0xa000, 0xc000},
+		{"Internal RAM", 0xc000, 0xe000},
+		{"OAM", 0xfe00, 0xfea0},
+		{"High RAM", 0xff80, 0xffff},
+	} {
+		fmt.Fprintf(w, "\n%s:\n", region.name)
+		gb.dumpMemory(w, region.start, region.end)
+	}
+	fmt.Fprintf(w, "\nStack trace:\n%s", stack)
+}
+
+func (gb *Gameboy) dumpMemory(w io.Writer, start, end int) {
+	for addr := start; addr < end; addr += 16 {
+		fmt.Fprintf(w, "%04x:", addr)
+		for i := addr; i < addr+16 && i < end; i++ {
+			fmt.Fprintf(w, " %02x", gb.memory.Read(uint16(i)))
+		}
+		fmt.Fprintln(w)
+	}
+}
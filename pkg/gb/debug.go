@@ -0,0 +1,301 @@
+package gb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+)
+
+// Registers returns a snapshot of the CPU registers
+func (gb *Gameboy) Registers() cpu.Registers {
+	return gb.dispatch.Registers()
+}
+
+// SetRegisters overwrites the CPU registers
+func (gb *Gameboy) SetRegisters(r cpu.Registers) {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	gb.dispatch.SetRegisters(r)
+}
+
+// TimerState returns the timer's registers and internal state
+func (gb *Gameboy) TimerState() timer.State {
+	return gb.timer.Snapshot()
+}
+
+// SetTimerState replaces the timer's registers and internal state
+func (gb *Gameboy) SetTimerState(s timer.State) {
+	gb.timer.Restore(s)
+}
+
+// PeekMemory reads a byte from the Gameboy's address space without notifying memory hooks
+func (gb *Gameboy) PeekMemory(addr uint16) uint8 {
+	return gb.memory.Peek(addr)
+}
+
+// Disassemble decodes the instruction at an address
+func (gb *Gameboy) Disassemble(addr uint16) cpu.Instruction {
+	return cpu.Disassemble(gb.memory.Peek, addr)
+}
+
+// SetBreakpoint stops Continue before the instruction at an address is executed
+func (gb *Gameboy) SetBreakpoint(addr uint16) {
+	if gb.breakpoints == nil {
+		gb.breakpoints = map[uint16]*expr.Expr{}
+	}
+	gb.breakpoints[addr] = nil
+}
+
+// SetConditionalBreakpoint stops Continue before the instruction at an address is executed but only
+// if a condition such as "A==0x3C && [0xC0A0]>5" is true at that point
+func (gb *Gameboy) SetConditionalBreakpoint(addr uint16, condition string) error {
+	e, err := gb.symbols.Parse(condition)
+	if err != nil {
+		return err
+	}
+	gb.SetBreakpoint(addr)
+	gb.breakpoints[addr] = e
+	return nil
+}
+
+// BreakpointCondition returns the condition for a breakpoint, which is empty if it always stops
+func (gb *Gameboy) BreakpointCondition(addr uint16) string {
+	if e := gb.breakpoints[addr]; e != nil {
+		return e.String()
+	}
+	return ""
+}
+
+// ClearBreakpoint removes a breakpoint, returning false if there was no breakpoint at the address
+func (gb *Gameboy) ClearBreakpoint(addr uint16) bool {
+	if _, ok := gb.breakpoints[addr]; !ok {
+		return false
+	}
+	delete(gb.breakpoints, addr)
+	return true
+}
+
+// Breakpoints lists the breakpoint addresses in ascending order
+func (gb *Gameboy) Breakpoints() []uint16 {
+	addrs := make([]uint16, 0, len(gb.breakpoints))
+	for addr := range gb.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Step runs the Gameboy until the CPU has executed one complete instruction. A halted CPU runs for
+// a single machine cycle instead so that the caller can see whether it has woken up.
+func (gb *Gameboy) Step() {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	gb.step()
+}
+
+func (gb *Gameboy) step() {
+	start := gb.dispatch.Instructions()
+	for {
+		gb.runMachineCycle()
+		if gb.dispatch.InstructionBoundary() && (gb.dispatch.Instructions() != start || gb.dispatch.Registers().Halted) {
+			return
+		}
+	}
+}
+
+// Continue runs the Gameboy until it is about to execute an instruction at a breakpoint address,
+// until an interrupt or bank switch that the debugger is watching for happens, or until the context
+// is done. It returns true if the debugger stopped execution and StopReason explains why.
+func (gb *Gameboy) Continue(ctx context.Context) bool {
+	return gb.continueUntil(ctx, nil)
+}
+
+// StepOver runs the Gameboy until a CALL or RST at PC returns, or steps a single instruction if PC
+// holds any other instruction. Like Continue it stops early at breakpoints and returns false only
+// if the context is done first.
+func (gb *Gameboy) StepOver(ctx context.Context) bool {
+	r := gb.Registers()
+	instruction := gb.Disassemble(r.PC)
+	if !cpu.IsCall(instruction.Bytes[0]) {
+		gb.Step()
+		return true
+	}
+	depth := gb.dispatch.CallDepth()
+	ret := r.PC + uint16(len(instruction.Bytes))
+	return gb.continueUntil(ctx, func() bool {
+		if gb.dispatch.CallDepth() <= depth && gb.dispatch.Registers().PC == ret {
+			gb.stopReason = fmt.Sprintf("Stepped over to 0x%04x", ret)
+			return true
+		}
+		return false
+	})
+}
+
+// StepOut runs the Gameboy until the current routine returns to its caller. Like Continue it stops
+// early at breakpoints and returns false only if the context is done first.
+func (gb *Gameboy) StepOut(ctx context.Context) (bool, error) {
+	depth := gb.dispatch.CallDepth()
+	if depth == 0 {
+		return false, fmt.Errorf("no call to step out of")
+	}
+	return gb.continueUntil(ctx, func() bool {
+		if gb.dispatch.CallDepth() < depth {
+			gb.stopReason = fmt.Sprintf("Stepped out to 0x%04x", gb.dispatch.Registers().PC)
+			return true
+		}
+		return false
+	}), nil
+}
+
+// CallStack returns the shadow call stack with the innermost call last
+func (gb *Gameboy) CallStack() []cpu.Frame {
+	return gb.dispatch.CallStack()
+}
+
+// continueUntil implements Continue, additionally stopping when done returns true at the start of
+// an instruction
+func (gb *Gameboy) continueUntil(ctx context.Context, done func() bool) bool {
+	defer gb.recoverCrash()
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	gb.stopReason = ""
+	instructions := gb.dispatch.Instructions()
+	interrupts, _ := gb.dispatch.Interrupts()
+	bankSwitches := gb.memory.BankSwitches()
+	// Only stop when the LCD reaches the scanline, rather than immediately when continuing from it
+	onScanline := gb.atScanline()
+	for {
+		// Check the context once per frame so that checking for breakpoints stays cheap
+		frame := gb.frame
+		for frame == gb.frame {
+			gb.runMachineCycle()
+			if !gb.dispatch.InstructionBoundary() {
+				continue
+			}
+			count, last := gb.dispatch.Interrupts()
+			interrupted := count != interrupts
+			interrupts = count
+			if interrupted && gb.breakOnInterrupts&last != 0 {
+				gb.stop(fmt.Sprintf("%s interrupt", Interrupt(last)))
+				return true
+			}
+			if gb.breakOnBankSwitch && gb.memory.BankSwitches() != bankSwitches {
+				rom0, romX, ram := gb.memory.Banks()
+				gb.stop(fmt.Sprintf("Bank switch to ROM %d/%d and RAM %d", rom0, romX, ram))
+				return true
+			}
+			bankSwitches = gb.memory.BankSwitches()
+			if gb.breakOnScanline != nil {
+				reached := gb.atScanline()
+				if reached && !onScanline {
+					gb.stop(fmt.Sprintf("Reached %s", gb.breakOnScanline))
+					return true
+				}
+				onScanline = reached
+			}
+			// A halted CPU sits at the same boundary so only check breakpoints when PC moves on
+			if interrupted || gb.dispatch.Instructions() != instructions {
+				instructions = gb.dispatch.Instructions()
+				if done != nil && done() {
+					return true
+				}
+				if gb.atBreakpoint() {
+					gb.stop(fmt.Sprintf("Breakpoint at 0x%04x", gb.dispatch.Registers().PC))
+					return true
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+	}
+}
+
+// stop records why Continue stopped and dumps the trace if requested
+func (gb *Gameboy) stop(reason string) {
+	gb.stopReason = reason
+	gb.publish(Event{Kind: BreakpointEvent, Reason: reason})
+	if gb.opts.DumpTraceOnBreak {
+		filename, err := gb.DumpTrace()
+		if err != nil {
+			gb.stopReason = fmt.Sprintf("%s (failed to write trace: %v)", reason, err)
+			return
+		}
+		gb.stopReason = fmt.Sprintf("%s (trace written to %s)", reason, filename)
+	}
+}
+
+// StopReason describes why Continue last stopped execution
+func (gb *Gameboy) StopReason() string {
+	return gb.stopReason
+}
+
+// SetBreakOnInterrupt makes Continue stop when an interrupt is dispatched, before the first
+// instruction of its handler
+func (gb *Gameboy) SetBreakOnInterrupt(interrupt Interrupt, enabled bool) {
+	if enabled {
+		gb.breakOnInterrupts |= uint8(interrupt)
+	} else {
+		gb.breakOnInterrupts &^= uint8(interrupt)
+	}
+}
+
+// SetBreakOnBankSwitch makes Continue stop after any write that changes the selected ROM or RAM bank
+func (gb *Gameboy) SetBreakOnBankSwitch(enabled bool) {
+	gb.breakOnBankSwitch = enabled
+}
+
+// ScanlineBreak makes Continue stop when the LCD reaches a line, and optionally a mode within it
+type ScanlineBreak struct {
+	Line uint8
+	// Mode is the STAT mode from 0 to 3, or -1 to stop as soon as LY reaches the line
+	Mode int
+}
+
+func (s ScanlineBreak) String() string {
+	if s.Mode < 0 {
+		return fmt.Sprintf("scanline %d", s.Line)
+	}
+	return fmt.Sprintf("scanline %d in mode %d", s.Line, s.Mode)
+}
+
+// SetBreakOnScanline makes Continue stop when LY reaches a line, and when mode is between 0 and 3
+// only once the LCD has also entered that mode. A nil break turns it off.
+func (gb *Gameboy) SetBreakOnScanline(s *ScanlineBreak) error {
+	if s != nil && (s.Line > 153 || s.Mode < -1 || s.Mode > 3) {
+		return fmt.Errorf("bad %s: expected a line from 0 to 153 and a mode from 0 to 3", s)
+	}
+	gb.breakOnScanline = nil
+	if s != nil {
+		scanline := *s
+		gb.breakOnScanline = &scanline
+	}
+	return nil
+}
+
+// BreakOnScanline returns the scanline that Continue stops at, or nil if there is none
+func (gb *Gameboy) BreakOnScanline() *ScanlineBreak {
+	return gb.breakOnScanline
+}
+
+func (gb *Gameboy) atScanline() bool {
+	s := gb.breakOnScanline
+	if s == nil || !gb.lcd.Enabled() || gb.memory.LY != s.Line {
+		return false
+	}
+	return s.Mode < 0 || int(gb.memory.STAT&0x03) == s.Mode
+}
+
+func (gb *Gameboy) atBreakpoint() bool {
+	if len(gb.breakpoints) == 0 {
+		return false
+	}
+	condition, ok := gb.breakpoints[gb.dispatch.Registers().PC]
+	return ok && (condition == nil || condition.True(gb))
+}
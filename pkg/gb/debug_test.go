@@ -0,0 +1,88 @@
+package gb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+func TestStepOverAndOut(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := map[uint16][]uint8{
+		0xc000: {0xcd, 0x10, 0xc0}, // CALL $c010
+		0xc003: {0x00},             // NOP
+		0xc010: {0xcd, 0x20, 0xc0}, // CALL $c020
+		0xc013: {0xc9},             // RET
+		0xc020: {0x00},             // NOP
+		0xc021: {0xc9},             // RET
+	}
+	for addr, bytes := range program {
+		for i, b := range bytes {
+			gameboy.WriteMemory(addr+uint16(i), b)
+		}
+	}
+	start := func() {
+		gameboy.SetRegisters(cpu.Registers{PC: 0xc000, SP: 0xdffe})
+	}
+	expectPC := func(pc uint16, depth int) {
+		t.Helper()
+		if r := gameboy.Registers(); r.PC != pc || len(gameboy.CallStack()) != depth {
+			t.Errorf("expected PC 0x%04x at depth %d but got 0x%04x at depth %d", pc, depth, r.PC, len(gameboy.CallStack()))
+		}
+	}
+	ctx := context.Background()
+
+	start()
+	gameboy.StepOver(ctx)
+	expectPC(0xc003, 0)
+
+	start()
+	gameboy.Step()
+	gameboy.Step()
+	expectPC(0xc020, 2)
+	frame := gameboy.CallStack()[1]
+	if frame.Caller != 0xc010 || frame.Target != 0xc020 || frame.Return != 0xc013 || frame.Interrupt {
+		t.Errorf("unexpected frame %v", frame)
+	}
+	if _, err := gameboy.StepOut(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectPC(0xc013, 1)
+	gameboy.StepOut(ctx)
+	expectPC(0xc003, 0)
+	if _, err := gameboy.StepOut(ctx); err == nil {
+		t.Errorf("expected an error stepping out of the top level")
+	}
+}
+
+func TestBreakOnScanline(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0xff40, 0x80)
+	if err := gameboy.SetBreakOnScanline(&ScanlineBreak{Line: 154, Mode: -1}); err == nil {
+		t.Errorf("expected an error for a line past the end of V-Blank")
+	}
+	// Continuing from a scanline runs on to the same line of the next frame
+	for i, s := range []ScanlineBreak{{Line: 100, Mode: 0}, {Line: 100, Mode: 0}, {Line: 2, Mode: -1}} {
+		if err := gameboy.SetBreakOnScanline(&s); err != nil {
+			t.Fatal(err)
+		}
+		frame := gameboy.FrameCount()
+		if !gameboy.Continue(context.Background()) {
+			t.Fatalf("expected to stop at %s", s)
+		}
+		ly, mode := gameboy.PeekMemory(0xff44), gameboy.PeekMemory(0xff41)&0x03
+		if ly != s.Line || (s.Mode >= 0 && int(mode) != s.Mode) {
+			t.Errorf("expected to stop at %s but LY is %d in mode %d", s, ly, mode)
+		}
+		if i > 0 && gameboy.FrameCount() != frame+1 {
+			t.Errorf("expected to stop in the next frame but ran from frame %d to %d", frame, gameboy.FrameCount())
+		}
+	}
+}
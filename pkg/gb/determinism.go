@@ -0,0 +1,99 @@
+package gb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+// StateHash returns a hash of the CPU registers, the address space and cartridge RAM which changes
+// whenever the state of the emulated Gameboy does
+func (gb *Gameboy) StateHash() uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, gb.dispatch.Registers())
+	binary.Write(h, binary.LittleEndian, gb.dispatch.Instructions())
+	binary.Write(h, binary.LittleEndian, int64(gb.frame))
+	binary.Write(h, binary.LittleEndian, int64(gb.mtick))
+	var space [0x10000]byte
+	for addr := range space {
+		space[addr] = gb.memory.Peek(uint16(addr))
+	}
+	h.Write(space[:])
+	h.Write(gb.memory.BatteryRAM())
+	return h.Sum64()
+}
+
+// Divergence describes the first point at which two runs of the same ROM and inputs differed
+type Divergence struct {
+	// Frame is the first frame whose state hash differed, which is exact when checking every frame
+	Frame     int
+	Hashes    [2]uint64
+	Registers [2]cpu.Registers
+	// Addresses lists up to maxDifferences addresses whose contents differed
+	Addresses []uint16
+}
+
+const maxDifferences = 16
+
+func (d Divergence) String() string {
+	s := fmt.Sprintf("Runs diverged at frame %d (state hashes 0x%016x and 0x%016x)\n", d.Frame, d.Hashes[0], d.Hashes[1])
+	s += fmt.Sprintf("  first:  %s\n  second: %s", d.Registers[0], d.Registers[1])
+	for _, addr := range d.Addresses {
+		s += fmt.Sprintf("\n  0x%04x differs", addr)
+	}
+	return s
+}
+
+// CheckDeterminism creates two Gameboys with the same options and runs them in lockstep for a
+// number of frames, comparing their state hashes every interval frames. The setup function, which
+// may be nil, is called on each Gameboy before it runs, e.g. to attach the same scripted inputs.
+// It returns nil if the runs never diverged.
+func CheckDeterminism(opts Options, frames, interval int, setup func(*Gameboy) error) (*Divergence, error) {
+	if interval < 1 {
+		return nil, fmt.Errorf("bad interval %d: expected at least one frame", interval)
+	}
+	var runs [2]*Gameboy
+	for i := range runs {
+		gameboy, err := NewGameboy(opts)
+		if err != nil {
+			return nil, err
+		}
+		if setup != nil {
+			if err := setup(gameboy); err != nil {
+				return nil, err
+			}
+		}
+		runs[i] = gameboy
+	}
+	for frame := 0; frame < frames; {
+		n := interval
+		if frame+n > frames {
+			n = frames - frame
+		}
+		for _, gameboy := range runs {
+			gameboy.RunFrames(n)
+		}
+		frame += n
+		first, second := runs[0].StateHash(), runs[1].StateHash()
+		if first != second {
+			return diverged(runs, frame, first, second), nil
+		}
+	}
+	return nil, nil
+}
+
+func diverged(runs [2]*Gameboy, frame int, first, second uint64) *Divergence {
+	d := &Divergence{
+		Frame:     frame,
+		Hashes:    [2]uint64{first, second},
+		Registers: [2]cpu.Registers{runs[0].Registers(), runs[1].Registers()},
+	}
+	for addr := 0; addr < 0x10000 && len(d.Addresses) < maxDifferences; addr++ {
+		if runs[0].PeekMemory(uint16(addr)) != runs[1].PeekMemory(uint16(addr)) {
+			d.Addresses = append(d.Addresses, uint16(addr))
+		}
+	}
+	return d
+}
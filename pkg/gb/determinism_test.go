@@ -0,0 +1,44 @@
+package gb
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCheckDeterminism(t *testing.T) {
+	opts := Options{RomFilename: "testdata/blargg/cpu_instrs/individual/01-special.gb"}
+	divergence, err := CheckDeterminism(opts, 30, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if divergence != nil {
+		t.Fatalf("expected identical runs but got %v", divergence)
+	}
+
+	// Disturb the second run only
+	runs := 0
+	divergence, err = CheckDeterminism(opts, 30, 1, func(gameboy *Gameboy) error {
+		runs++
+		if runs == 2 {
+			gameboy.OnFrame(func(*image.RGBA) {
+				// The frame count advances once the frame hooks have run
+				if gameboy.FrameCount()+1 == 12 {
+					gameboy.WriteMemory(0xdf00, 0x42)
+				}
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if divergence == nil {
+		t.Fatal("expected the runs to diverge")
+	}
+	if divergence.Frame != 12 {
+		t.Errorf("expected divergence at frame 12 but got %d", divergence.Frame)
+	}
+	if len(divergence.Addresses) == 0 || divergence.Addresses[0] != 0xdf00 {
+		t.Errorf("expected 0xdf00 to differ but got %v", divergence.Addresses)
+	}
+}
@@ -0,0 +1,172 @@
+package gb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind identifies something that happens in the emulator that tools can subscribe to
+type EventKind int
+
+const (
+	// VBlankEvent is published as the LCD enters V-Blank
+	VBlankEvent EventKind = iota
+	// FrameEvent is published once a frame is complete, before it is displayed
+	FrameEvent
+	// SerialEvent is published when a byte has been transferred over the serial port
+	SerialEvent
+	// AudioEvent is published when a batch of samples is ready for the speakers
+	AudioEvent
+	// BreakpointEvent is published when the debugger stops at a breakpoint, watch or other condition
+	BreakpointEvent
+	// SaveEvent is published when the battery save is written to disk
+	SaveEvent
+)
+
+var eventNames = []string{"vblank", "frame", "serial", "audio", "breakpoint", "save"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventNames) {
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+	return eventNames[k]
+}
+
+// ParseEventKind returns the kind of event with a name such as "frame" or "serial"
+func ParseEventKind(name string) (EventKind, error) {
+	for i, n := range eventNames {
+		if strings.EqualFold(n, name) {
+			return EventKind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event %q: expected one of %s", name, strings.Join(eventNames, ", "))
+}
+
+// Event is something that happened in the emulator. Only the fields for its kind are set.
+type Event struct {
+	Kind EventKind
+	// Frame is the number of frames run when the event happened
+	Frame int
+	// Sent and Received are the bytes shifted out and in by a serial transfer
+	Sent, Received uint8
+	// Samples holds a batch of interleaved left and right audio samples. The slice is reused for
+	// the next batch so it must be copied to be kept.
+	Samples []float32
+	// Reason describes why the debugger stopped
+	Reason string
+	// Filename is the file that was written
+	Filename string
+}
+
+// subscriber receives the events of some kinds
+type subscriber struct {
+	kinds   uint32
+	deliver func(Event)
+}
+
+// eventBus delivers events to subscribers. Publishing is a single atomic load when nobody is
+// subscribed to the kind of event so that the emulator isn't slowed down by events nobody wants.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[int]subscriber
+	next        int
+	kinds       uint32 // Updated atomically
+}
+
+func kindMask(kinds []EventKind) uint32 {
+	if len(kinds) == 0 {
+		return ^uint32(0)
+	}
+	var mask uint32
+	for _, kind := range kinds {
+		mask |= 1 << uint(kind)
+	}
+	return mask
+}
+
+func (b *eventBus) subscribe(deliver func(Event), kinds []EventKind) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = map[int]subscriber{}
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = subscriber{kinds: kindMask(kinds), deliver: deliver}
+	b.updateKinds()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			delete(b.subscribers, id)
+			b.updateKinds()
+		})
+	}
+}
+
+func (b *eventBus) updateKinds() {
+	var kinds uint32
+	for _, s := range b.subscribers {
+		kinds |= s.kinds
+	}
+	atomic.StoreUint32(&b.kinds, kinds)
+}
+
+// wants returns true if anyone is subscribed to a kind of event
+func (b *eventBus) wants(kind EventKind) bool {
+	return atomic.LoadUint32(&b.kinds)&(1<<uint(kind)) != 0
+}
+
+func (b *eventBus) publish(e Event) {
+	if !b.wants(e.Kind) {
+		return
+	}
+	b.mutex.Lock()
+	var deliver []func(Event)
+	for _, s := range b.subscribers {
+		if s.kinds&(1<<uint(e.Kind)) != 0 {
+			deliver = append(deliver, s.deliver)
+		}
+	}
+	b.mutex.Unlock()
+	for _, d := range deliver {
+		d(e)
+	}
+}
+
+// Subscribe calls a function with each event of the given kinds, or of every kind when none are
+// given. The function is called on the goroutine running the emulator, or the one saving for save
+// events, so it must return quickly. Calling the returned function unsubscribes.
+func (gb *Gameboy) Subscribe(fn func(Event), kinds ...EventKind) (unsubscribe func()) {
+	return gb.events.subscribe(fn, kinds)
+}
+
+// Events returns a channel that receives events of the given kinds, or of every kind when none are
+// given. Events are dropped rather than holding up the emulator when the channel's buffer is full.
+// Audio samples are copied so that they can be kept. Calling the returned function unsubscribes but
+// leaves the channel open.
+func (gb *Gameboy) Events(buffer int, kinds ...EventKind) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	unsubscribe := gb.events.subscribe(func(e Event) {
+		if e.Samples != nil {
+			e.Samples = append([]float32(nil), e.Samples...)
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	}, kinds)
+	return events, unsubscribe
+}
+
+// publish sends an event to its subscribers, stamped with the current frame
+func (gb *Gameboy) publish(e Event) {
+	if !gb.events.wants(e.Kind) {
+		return
+	}
+	e.Frame = gb.frame
+	gb.events.publish(e)
+}
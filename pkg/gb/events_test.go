@@ -0,0 +1,90 @@
+package gb
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {
+			0x3e, 0x42, // LD A,$42
+			0xe0, 0x01, // LDH (SB),A
+			0x3e, 0x81, // LD A,$81
+			0xe0, 0x02, // LDH (SC),A
+			0x18, 0xfe, // JR -2
+		},
+		0x0147: {0x03, 0x00, 0x01},
+	})
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[EventKind]int{}
+	var serial Event
+	unsubscribe := gameboy.Subscribe(func(e Event) {
+		counts[e.Kind]++
+		if e.Kind == SerialEvent {
+			serial = e
+		}
+	}, VBlankEvent, FrameEvent, SerialEvent)
+	audio, stopAudio := gameboy.Events(1000, AudioEvent)
+	defer stopAudio()
+	gameboy.RunFrames(2)
+	if counts[VBlankEvent] != 2 || counts[FrameEvent] != 2 {
+		t.Errorf("expected 2 V-Blanks and frames but got %v", counts)
+	}
+	// The cable is unplugged so 0xff is shifted in
+	if counts[SerialEvent] != 1 || serial.Sent != 0x42 || serial.Received != 0xff || serial.Frame != 0 {
+		t.Errorf("expected one transfer of 0x42 in the first frame but got %d %+v", counts[SerialEvent], serial)
+	}
+	if len(audio) == 0 {
+		t.Error("expected batches of samples without speakers")
+	} else if e := <-audio; len(e.Samples) != 128 {
+		// Batches of 64 samples for each of the left and right channels
+		t.Errorf("expected a batch of 128 samples but got %d", len(e.Samples))
+	}
+
+	// Nothing more arrives once unsubscribed
+	unsubscribe()
+	unsubscribe()
+	gameboy.RunFrames(1)
+	if counts[FrameEvent] != 2 {
+		t.Errorf("expected no more frames after unsubscribing but got %d", counts[FrameEvent])
+	}
+
+	var stops, saves []Event
+	gameboy.Subscribe(func(e Event) { stops = append(stops, e) }, BreakpointEvent)
+	gameboy.Subscribe(func(e Event) { saves = append(saves, e) }, SaveEvent)
+	if err := gameboy.SetBreakOnScanline(&ScanlineBreak{Line: 10, Mode: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if !gameboy.Continue(context.Background()) {
+		t.Fatal("expected to stop at the scanline")
+	}
+	if len(stops) != 1 || stops[0].Reason != gameboy.StopReason() {
+		t.Errorf("expected a breakpoint event for %q but got %+v", gameboy.StopReason(), stops)
+	}
+	if err := gameboy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(saves) != 1 || !strings.HasSuffix(saves[0].Filename, ".sav") {
+		t.Errorf("expected the battery save to be reported but got %+v", saves)
+	}
+}
+
+func TestParseEventKind(t *testing.T) {
+	for kind := VBlankEvent; kind <= SaveEvent; kind++ {
+		parsed, err := ParseEventKind(kind.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != kind {
+			t.Errorf("expected %v but got %v", kind, parsed)
+		}
+	}
+	if _, err := ParseEventKind("reset"); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
@@ -0,0 +1,365 @@
+// Package expr parses and evaluates simple expressions over CPU registers and memory, such as
+// "A==0x3C && [0xC0A0]>5", for conditional breakpoints and watches
+package expr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+// Env is the machine state that an expression is evaluated against
+type Env interface {
+	Registers() cpu.Registers
+	PeekMemory(addr uint16) uint8
+}
+
+// Expr is a parsed expression
+type Expr struct {
+	source string
+	root   node
+}
+
+// Symbols maps names from a symbol file to addresses
+type Symbols map[string]uint16
+
+// LoadSymbols reads a symbol file in the format written by RGBDS and understood by BGB, with one
+// "bank:address name" entry per line and comments starting with a semicolon
+func LoadSymbols(r io.Reader) (Symbols, error) {
+	symbols := Symbols{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		location := strings.SplitN(fields[0], ":", 2)
+		if len(fields) != 2 || len(location) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"bank:address name\" but got %q", line, scanner.Text())
+		}
+		addr, err := strconv.ParseUint(location[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad address %q", line, location[1])
+		}
+		symbols[fields[1]] = uint16(addr)
+	}
+	return symbols, scanner.Err()
+}
+
+// Parse an expression. Registers are named A, F, B, C, D, E, H, L, AF, BC, DE, HL, SP and PC,
+// [addr] reads a byte of memory and numbers may be decimal or hex with a 0x or $ prefix. The
+// operators are ||, &&, ==, !=, <, <=, >, >=, +, -, &, | and !.
+func Parse(s string) (*Expr, error) {
+	return Symbols(nil).Parse(s)
+}
+
+// Parse an expression in which symbol names stand for their addresses
+func (symbols Symbols) Parse(s string) (*Expr, error) {
+	p := &parser{tokens: tokenize(s), symbols: symbols}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("bad expression %q: %v", s, err)
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("bad expression %q: unexpected %q", s, p.tokens[p.pos])
+	}
+	return &Expr{source: s, root: root}, nil
+}
+
+// ParseWatch parses an expression to watch, where a bare address or symbol reads the byte of memory
+// at that address rather than standing for the address itself
+func (symbols Symbols) ParseWatch(s string) (*Expr, error) {
+	e, err := symbols.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := e.root.(number); ok {
+		e.root = memory{addr: e.root}
+	}
+	return e, nil
+}
+
+// Eval returns the value of the expression
+func (e *Expr) Eval(env Env) int {
+	return e.root.eval(env, env.Registers())
+}
+
+// True returns true if the expression evaluates to a value other than zero
+func (e *Expr) True(env Env) bool {
+	return e.Eval(env) != 0
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+type node interface {
+	eval(env Env, r cpu.Registers) int
+}
+
+type number int
+
+func (n number) eval(Env, cpu.Registers) int {
+	return int(n)
+}
+
+type register string
+
+func (name register) eval(_ Env, r cpu.Registers) int {
+	switch name {
+	case "A":
+		return int(r.A)
+	case "F":
+		return int(r.F)
+	case "B":
+		return int(r.B)
+	case "C":
+		return int(r.C)
+	case "D":
+		return int(r.D)
+	case "E":
+		return int(r.E)
+	case "H":
+		return int(r.H)
+	case "L":
+		return int(r.L)
+	case "AF":
+		return int(r.A)<<8 | int(r.F)
+	case "BC":
+		return int(r.B)<<8 | int(r.C)
+	case "DE":
+		return int(r.D)<<8 | int(r.E)
+	case "HL":
+		return int(r.H)<<8 | int(r.L)
+	case "SP":
+		return int(r.SP)
+	default:
+		return int(r.PC)
+	}
+}
+
+var registers = map[string]bool{
+	"A": true, "F": true, "B": true, "C": true, "D": true, "E": true, "H": true, "L": true,
+	"AF": true, "BC": true, "DE": true, "HL": true, "SP": true, "PC": true,
+}
+
+type memory struct {
+	addr node
+}
+
+func (m memory) eval(env Env, r cpu.Registers) int {
+	return int(env.PeekMemory(uint16(m.addr.eval(env, r))))
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(env Env, r cpu.Registers) int {
+	return boolean(n.operand.eval(env, r) == 0)
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (b binary) eval(env Env, r cpu.Registers) int {
+	left := b.left.eval(env, r)
+	// Short circuit the logical operators
+	switch b.op {
+	case "&&":
+		return boolean(left != 0 && b.right.eval(env, r) != 0)
+	case "||":
+		return boolean(left != 0 || b.right.eval(env, r) != 0)
+	}
+	right := b.right.eval(env, r)
+	switch b.op {
+	case "==":
+		return boolean(left == right)
+	case "!=":
+		return boolean(left != right)
+	case "<":
+		return boolean(left < right)
+	case "<=":
+		return boolean(left <= right)
+	case ">":
+		return boolean(left > right)
+	case ">=":
+		return boolean(left >= right)
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "&":
+		return left & right
+	default:
+		return left | right
+	}
+}
+
+func boolean(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// tokenize splits an expression into operators, brackets and words
+func tokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case isWord(c) || c == '$':
+			j := i + 1
+			for j < len(s) && isWord(rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			if i+1 < len(s) {
+				switch s[i : i+2] {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, s[i:i+2])
+					i += 2
+					continue
+				}
+			}
+			tokens = append(tokens, s[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+// isWord returns true for characters that can appear in numbers, registers and symbol names
+func isWord(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+type parser struct {
+	tokens  []string
+	pos     int
+	symbols Symbols
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *parser) parseBinary(ops []string, operand func() (node, error)) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, candidate := range ops {
+			if op == candidate {
+				matched = true
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary([]string{"&&"}, p.parseComparison)
+}
+
+func (p *parser) parseComparison() (node, error) {
+	return p.parseBinary([]string{"==", "!=", "<", "<=", ">", ">="}, p.parseSum)
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.parseBinary([]string{"+", "-", "&", "|"}, p.parseUnary)
+}
+
+func (p *parser) parseUnary() (node, error) {
+	token := p.next()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	case "!":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	case "(", "[":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing := ")"
+		if token == "[" {
+			closing = "]"
+		}
+		if p.next() != closing {
+			return nil, fmt.Errorf("expected %q", closing)
+		}
+		if token == "[" {
+			return memory{addr: inner}, nil
+		}
+		return inner, nil
+	}
+	if name := strings.ToUpper(token); registers[name] {
+		return register(name), nil
+	}
+	if addr, ok := p.symbols[token]; ok {
+		return number(addr), nil
+	}
+	return parseNumber(token)
+}
+
+func parseNumber(token string) (node, error) {
+	var value uint64
+	var err error
+	switch {
+	case strings.HasPrefix(token, "0x"), strings.HasPrefix(token, "0X"):
+		value, err = strconv.ParseUint(token[2:], 16, 16)
+	case strings.HasPrefix(token, "$"):
+		value, err = strconv.ParseUint(token[1:], 16, 16)
+	default:
+		value, err = strconv.ParseUint(token, 10, 16)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return number(value), nil
+}
@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+type env struct {
+	registers cpu.Registers
+	memory    map[uint16]uint8
+}
+
+func (e env) Registers() cpu.Registers {
+	return e.registers
+}
+
+func (e env) PeekMemory(addr uint16) uint8 {
+	return e.memory[addr]
+}
+
+func TestEval(t *testing.T) {
+	e := env{
+		registers: cpu.Registers{A: 0x3c, H: 0xc0, L: 0xa0, PC: 0x0150},
+		memory:    map[uint16]uint8{0xc0a0: 7, 0xc0a1: 0xc0},
+	}
+	for _, tc := range []struct {
+		expression string
+		expected   int
+	}{
+		{"A==0x3C && [0xC0A0]>5", 1},
+		{"a == $3c && [$c0a0] > 7", 0},
+		{"[HL]", 7},
+		{"[HL+1] == [0xc0a1]", 1},
+		{"PC >= 0x150 && PC < 0x200", 1},
+		{"!(A == 60)", 0},
+		{"A & 0x0f | 0x40", 0x4c},
+		{"HL - 0xc000", 0xa0},
+		{"B == 1 || C == 0", 1},
+	} {
+		expr, err := Parse(tc.expression)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expression, err)
+			continue
+		}
+		if actual := expr.Eval(e); actual != tc.expected {
+			t.Errorf("%s: expected %d but got %d", tc.expression, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expression := range []string{"", "A ==", "[0xc000", "Q > 1", "A == 1)", "0x10000"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	symbols, err := LoadSymbols(strings.NewReader(`; File generated by rgblink
+00:0150 Main
+00:c0a0 wPlayer.x ; comment
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := env{memory: map[uint16]uint8{0xc0a0: 9}}
+	for _, tc := range []struct {
+		expression string
+		watch      bool
+		expected   int
+	}{
+		{"Main", false, 0x150},
+		{"[wPlayer.x] == 9", false, 1},
+		{"wPlayer.x", true, 9},
+		{"0xc0a0", true, 9},
+		{"[wPlayer.x] + 1", true, 10},
+	} {
+		parse := symbols.Parse
+		if tc.watch {
+			parse = symbols.ParseWatch
+		}
+		expr, err := parse(tc.expression)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expression, err)
+			continue
+		}
+		if actual := expr.Eval(e); actual != tc.expected {
+			t.Errorf("%s: expected %d but got %d", tc.expression, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package gb
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+)
+
+// Frame returns a copy of the picture shown by the display, which is left unchanged as later frames
+// are run. Its bounds start at 0,0 and have the size returned by ScreenSize.
+func (gb *Gameboy) Frame() image.Image {
+	visible := gb.lcd.Visible()
+	frame := image.NewRGBA(image.Rect(0, 0, visible.Rect.Dx(), visible.Rect.Dy()))
+	draw.Draw(frame, frame.Rect, visible, visible.Rect.Min, draw.Src)
+	return frame
+}
+
+// FrameRGBA copies the picture shown by the display into buf as rows of 8-bit RGBA pixels with no
+// padding, growing it if it is too small, and returns it. Passing the same buffer every frame avoids
+// allocating one for each frame.
+func (gb *Gameboy) FrameRGBA(buf []byte) []byte {
+	visible := gb.lcd.Visible()
+	width, height := visible.Rect.Dx(), visible.Rect.Dy()
+	if cap(buf) < width*height*4 {
+		buf = make([]byte, width*height*4)
+	}
+	buf = buf[:width*height*4]
+	for y := 0; y < height; y++ {
+		start := visible.PixOffset(visible.Rect.Min.X, visible.Rect.Min.Y+y)
+		copy(buf[y*width*4:(y+1)*width*4], visible.Pix[start:start+width*4])
+	}
+	return buf
+}
+
+// FrameHash returns the SHA-1 of the raw RGBA pixels of the picture shown by the display as hex, which
+// identifies a frame such as the result screen of a test ROM
+func (gb *Gameboy) FrameHash() string {
+	return fmt.Sprintf("%x", sha1.Sum(gb.FrameRGBA(nil)))
+}
+
+// Screenshot writes the picture shown by the display to a PNG file
+func (gb *Gameboy) Screenshot(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, gb.lcd.Visible()); err != nil {
+		// Don't leave a truncated file behind
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	return f.Close()
+}
+
+// TrackChanges starts finding which parts of the picture shown by the display change from frame to
+// frame, which are then returned by Changes
+func (gb *Gameboy) TrackChanges() {
+	gb.lcd.TrackChanges()
+}
+
+// Changes returns the rectangles of the picture that changed in the last frame, in the coordinates of
+// Frame and aligned to 8x8 blocks, or nil unless TrackChanges was called. The whole picture has
+// changed in the first frame and nothing has changed while the screen stays the same. The slice is
+// reused every frame so frame hooks and callers between frames must copy it to keep it.
+func (gb *Gameboy) Changes() []image.Rectangle {
+	return gb.lcd.Changes()
+}
@@ -0,0 +1,152 @@
+package gb
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+)
+
+func TestFrameBufferReused(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})
+	for _, opts := range []Options{{RomFilename: rom}, {RomFilename: rom, SGB: true}} {
+		gameboy, err := NewGameboy(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var frames []*image.RGBA
+		gameboy.OnFrame(func(frame *image.RGBA) {
+			frames = append(frames, frame)
+		})
+		gameboy.RunFrames(3)
+		if len(frames) != 3 {
+			t.Fatalf("expected 3 frames but got %d", len(frames))
+		}
+		if frames[0] != frames[1] || frames[1] != frames[2] {
+			t.Errorf("expected every frame to be rendered into the same buffer with SGB %v", opts.SGB)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})
+	for _, opts := range []Options{{RomFilename: rom}, {RomFilename: rom, SGB: true}} {
+		gameboy, err := NewGameboy(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gameboy.WriteMemory(0xff47, 0x00)
+		gameboy.RunFrames(2)
+		width, height := gameboy.ScreenSize()
+		first := gameboy.Frame().(*image.RGBA)
+		if first.Rect != image.Rect(0, 0, width, height) {
+			t.Errorf("expected a %dx%d frame but got %v with SGB %v", width, height, first.Rect, opts.SGB)
+		}
+		rgba := gameboy.FrameRGBA(nil)
+		if string(rgba) != string(first.Pix) {
+			t.Errorf("expected the raw pixels to match the frame with SGB %v", opts.SGB)
+		}
+		saved := string(first.Pix)
+		gameboy.WriteMemory(0xff47, 0xff)
+		gameboy.RunFrames(1)
+		if string(first.Pix) != saved {
+			t.Errorf("expected the frame to be left unchanged by the next frame with SGB %v", opts.SGB)
+		}
+		if string(gameboy.FrameRGBA(rgba)) == saved || string(rgba) == saved {
+			t.Errorf("expected the next frame to be copied into the buffer passed with SGB %v", opts.SGB)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.Changes() != nil {
+		t.Error("expected no changes before tracking them")
+	}
+	gameboy.TrackChanges()
+	gameboy.RunFrames(1)
+	if changes := gameboy.Changes(); len(changes) != 1 || changes[0] != image.Rect(0, 0, 160, 144) {
+		t.Errorf("expected the first frame to change everywhere but got %v", changes)
+	}
+	gameboy.RunFrames(1)
+	if changes := gameboy.Changes(); len(changes) != 0 {
+		t.Errorf("expected a static screen to have no changes but got %v", changes)
+	}
+
+	// Tile 0 fills the background so changing its top row changes the top row of every block
+	gameboy.WriteMemory(0x8000, 0xff)
+	gameboy.RunFrames(1)
+	if changes := gameboy.Changes(); len(changes) != 1 || changes[0] != image.Rect(0, 0, 160, 144) {
+		t.Errorf("expected every block to change but got %v", changes)
+	}
+}
+
+// recordingDisplay keeps the colour of the top-left pixel of each frame it is given
+type recordingDisplay struct {
+	pixels []color.RGBA
+}
+
+func (d *recordingDisplay) DisplayFrame(frame *image.RGBA) {
+	d.pixels = append(d.pixels, frame.RGBAAt(0, 0))
+}
+
+func TestOverlay(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	display := &recordingDisplay{}
+	gameboy.RegisterDisplay(display)
+	var hooked []color.RGBA
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		hooked = append(hooked, frame.RGBAAt(0, 0))
+	})
+	red := color.RGBA{0xff, 0x00, 0x00, 0xff}
+	gameboy.Overlay().Box(image.Rect(0, 0, 4, 4), red)
+	gameboy.RunFrames(2)
+	if display.pixels[0] != red {
+		t.Errorf("expected the box to be displayed but got %v", display.pixels[0])
+	}
+	if display.pixels[1] == red {
+		t.Error("expected the box to be drawn over one frame only")
+	}
+	if hooked[0] == red || gameboy.Frame().(*image.RGBA).RGBAAt(0, 0) == red {
+		t.Error("expected the box to be left out of the frame seen by hooks and screenshots")
+	}
+}
+
+type testSpeakers struct{}
+
+func (testSpeakers) Buffer() *audio.RingBuffer {
+	return audio.NewRingBuffer(1024)
+}
+
+func TestHeadless(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RegisterSpeakers(testSpeakers{})
+	gameboy.RegisterSpeakers(nil)
+	gameboy.RegisterDisplay(nil)
+	frames := 0
+	gameboy.OnFrame(func(*image.RGBA) {
+		frames++
+	})
+	gameboy.RunFrames(5)
+	if frames != 5 {
+		t.Errorf("expected 5 frames but got %d", frames)
+	}
+}
@@ -2,16 +2,29 @@ package gb
 
 import (
 	"context"
+	"crypto/sha1"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/scottyw/tetromino/pkg/gb/audio"
+	"github.com/scottyw/tetromino/pkg/gb/cheat"
 	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/expr"
 	"github.com/scottyw/tetromino/pkg/gb/lcd"
 	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gb/overlay"
+	"github.com/scottyw/tetromino/pkg/gb/patch"
+	"github.com/scottyw/tetromino/pkg/gb/sgb"
 	"github.com/scottyw/tetromino/pkg/gb/timer"
+	"github.com/scottyw/tetromino/pkg/logging"
 )
 
 // Button represents a direction pad or button control
@@ -36,86 +49,415 @@ const (
 	Select = iota
 )
 
+var buttonNames = map[string]Button{
+	"up":     Up,
+	"down":   Down,
+	"left":   Left,
+	"right":  Right,
+	"a":      A,
+	"b":      B,
+	"start":  Start,
+	"select": Select,
+}
+
+// ParseButton returns the button with a name such as "up", "a" or "start"
+func ParseButton(name string) (Button, error) {
+	button, ok := buttonNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown button %q", name)
+	}
+	return button, nil
+}
+
 // Action  represents emulator controls
 type Action int
 
 const (
 	// TakeScreenshot of the current LCD
 	TakeScreenshot = iota
+	// StartFastForward runs the emulator faster than a real Gameboy
+	StartFastForward = iota
+	// StopFastForward returns the emulator to normal speed
+	StopFastForward = iota
+	// DumpTrace writes the most recently executed instructions to a file
+	DumpTrace = iota
+	// StartRewind steps back through the game in real time while Run is running, when
+	// Options.RewindBuffer is set
+	StartRewind = iota
+	// StopRewind carries on playing from where rewinding stopped
+	StopRewind = iota
+)
+
+// notificationFrames is how long messages about emulator actions are shown for, about two seconds
+const notificationFrames = 120
+
+// Interrupt identifies one of the interrupts by its bit in the IF and IE registers
+type Interrupt uint8
+
+const (
+	// VBlankInterrupt is requested when the LCD enters vertical blank
+	VBlankInterrupt Interrupt = 1 << iota
+	// LCDStatInterrupt is requested by the conditions selected in the STAT register
+	LCDStatInterrupt
+	// TimerInterrupt is requested when TIMA overflows
+	TimerInterrupt
+	// SerialInterrupt is requested when a serial transfer completes
+	SerialInterrupt
+	// JoypadInterrupt is requested when a button is pressed
+	JoypadInterrupt
 )
 
+func (i Interrupt) String() string {
+	switch i {
+	case VBlankInterrupt:
+		return "VBlank"
+	case LCDStatInterrupt:
+		return "LCD STAT"
+	case TimerInterrupt:
+		return "Timer"
+	case SerialInterrupt:
+		return "Serial"
+	case JoypadInterrupt:
+		return "Joypad"
+	}
+	return fmt.Sprintf("Interrupt(%02x)", uint8(i))
+}
+
+// ParseInterrupt returns the interrupt with a name such as "vblank", "stat", "timer", "serial" or "joypad"
+func ParseInterrupt(name string) (Interrupt, error) {
+	switch strings.ToLower(name) {
+	case "vblank":
+		return VBlankInterrupt, nil
+	case "stat", "lcd":
+		return LCDStatInterrupt, nil
+	case "timer":
+		return TimerInterrupt, nil
+	case "serial":
+		return SerialInterrupt, nil
+	case "joypad":
+		return JoypadInterrupt, nil
+	}
+	return 0, fmt.Errorf("unknown interrupt %q", name)
+}
+
 // Options control emulator behaviour
 type Options struct {
-	RomFilename string
-	DebugCPU    bool
-	DebugLCD    bool
-	SBWriter    io.Writer
+	RomFilename      string
+	DebugCPU         bool
+	DebugLCD         bool
+	SBWriter         io.Writer
+	FastForwardSpeed int
+	Cheats           []string
+	CheatFilename    string
+	CrashDumpDir     string
+	SaveFilename     string
+	// SaveDir holds battery saves named after the ROM when SaveFilename is not set, rather than
+	// keeping them alongside the ROM
+	SaveDir          string
+	TraceLength      int
+	TraceDumpDir     string
+	DumpTraceOnBreak bool
+	CoverageFilename string
+	SymbolFilename   string
+	// IOLog receives a line for each write to a hardware register named in IOLogRegisters, which
+	// may contain patterns such as "NR*" and matches every register when empty
+	IOLog          io.Writer
+	IOLogRegisters []string
+	// Colours replaces the four shades of grey, from lightest to darkest, used to display frames
+	Colours *[4]color.RGBA
+	// Logger receives log lines from every subsystem, which default to stderr at the Info level.
+	// DebugCPU sets the level of the "cpu" subsystem to Debug so that instructions are logged.
+	Logger *logging.Logger
+	// ForceDMG runs games that support the Game Boy Color as they would run on the original Game Boy
+	ForceDMG bool
+	// ColourCorrection adjusts the colours of games running in CGB mode, which look oversaturated
+	// on modern displays when they are shown raw
+	ColourCorrection lcd.ColourCorrection
+	// CompatPalette runs games made for the original Game Boy on a Game Boy Color, which colours them
+	// with the palette its boot ROM picks for the title when "auto" or with the palette picked by
+	// holding buttons during boot when e.g. "up+a". It takes precedence over Colours.
+	CompatPalette string
+	// SGB runs games on a Super Game Boy, which draws a border around the screen and colours it using
+	// palettes sent by games that support it. It takes precedence over ForceDMG and CompatPalette.
+	SGB bool
+	// Infrared faces the Game Boy Color's infrared port at another device, such as an ir.Loopback or
+	// another emulator connected with ir.Dial. Games that don't run in CGB mode can't use it.
+	Infrared mem.InfraredPort
+	// Serial connects the serial port to another device over a link cable. Without it the cable is
+	// unplugged so games see 0xff from transfers they clock themselves.
+	Serial mem.SerialPort
+	// RenderWorkers draws each frame when it ends using this many goroutines instead of drawing each
+	// line as it is reached, which is faster on hosts with several cores but less accurate for games
+	// that change tiles or sprites in the middle of a frame
+	RenderWorkers int
+	// Pace runs frames at the rate of a real Gameboy using the clock, for when there are no speakers
+	// to keep the emulator to that rate by playing its audio
+	Pace bool
+	// Rom holds the ROM itself, such as one loaded by a web page, instead of reading RomFilename
+	Rom []byte
+	// PatchFilename is an IPS or BPS patch, such as a translation, that is applied to the ROM before
+	// it boots. When it is not set, a patch named after RomFilename with an .ips or .bps extension is
+	// applied if there is one.
+	PatchFilename string
+	// SaveBackups is the number of earlier copies of the battery save kept in a backups directory
+	// alongside it. The save is copied there the first time it changes in each session.
+	SaveBackups int
+	// BootIntro scrolls the logo down and plays the chime before the game starts, as the original
+	// Game Boy's boot ROM does, instead of starting the game straight away
+	BootIntro bool
+	// RewindBuffer is the number of megabytes of compressed snapshots kept so that the game can be
+	// rewound, or 0 to keep none. A snapshot of a few kilobytes is kept every other frame.
+	RewindBuffer int
+	// AccuratePPU draws each line a pixel at a time as the Game Boy does, so that mode 3 takes longer
+	// when the background is scrolled or sprites and the window are on the line and registers changed
+	// part way through a line take effect from that pixel. It is slower and takes precedence over
+	// RenderWorkers.
+	AccuratePPU bool
 }
 
 // Gameboy represents the Gameboy itself
 type Gameboy struct {
-	dispatch *cpu.Dispatch
-	memory   *mem.Memory
-	timer    *timer.Timer
-	lcd      *lcd.LCD
-	audio    *audio.Audio
-	opts     Options
-	frame    int
-}
-
-// NewGameboy returns a new Gameboy
-func NewGameboy(opts Options) *Gameboy {
-	var rom []byte
-	if opts.RomFilename == "" {
+	dispatch          *cpu.Dispatch
+	memory            *mem.Memory
+	timer             *timer.Timer
+	lcd               *lcd.LCD
+	audio             *audio.Audio
+	superGameboy      *sgb.SGB
+	cheats            *cheat.Engine
+	opts              Options
+	frame             int
+	mtick             int
+	breakpoints       map[uint16]*expr.Expr
+	breakOnInterrupts uint8
+	breakOnBankSwitch bool
+	breakOnScanline   *ScanlineBreak
+	stopReason        string
+	romHash           string
+	symbolFilename    string
+	coverage          [][0x4000]bool
+	symbols           expr.Symbols
+	log               *logging.Logger
+	watches           []*watch
+	running           sync.Mutex
+	cgb               bool
+	// secondCPUCycle is true when the CPU has run the first of its two machine cycles at double speed
+	secondCPUCycle bool
+	timing         frameTiming
+	pacer          pacer
+	renderTime     time.Duration
+	// interceptButtons receives the buttons pressed by frontends when it is set
+	interceptButtons func(player int, button Button, pressed bool)
+	executeHooks     []func(pc uint16)
+	// backedUp is true once the battery save has been backed up this session
+	backedUp bool
+	// intro is set while the boot intro plays
+	intro  *bootIntro
+	events eventBus
+	input  inputState
+	// overlay is drawn over each frame before it is displayed
+	overlay *overlay.Layer
+	// startPalette holds the colours the game started with once SetCompatPalette has changed them
+	startPalette *compatPalette
+	// rewind keeps snapshots for rewinding when Options.RewindBuffer is set
+	rewind *rewindBuffer
+}
+
+// NewGameboy returns a new Gameboy, or an error if the ROM can't be read or has no valid cartridge
+// header. The ROM is taken from Options.Rom when it is set so that no filesystem is needed.
+func NewGameboy(opts Options) (*Gameboy, error) {
+	rom := opts.Rom
+	if rom == nil && opts.RomFilename == "" {
 		rom = make([]byte, 0x8000)
-	} else {
-		rom = readRomFile(opts.RomFilename)
+	} else if rom == nil {
+		var err error
+		rom, err = readRomFile(opts.RomFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = logging.Default()
 	}
+	if patchFilename := findPatch(opts); patchFilename != "" {
+		var err error
+		rom, err = patch.ApplyFile(rom, patchFilename)
+		if err != nil {
+			return nil, err
+		}
+		logger.With("gb").Infof("Applied the patch at %s", patchFilename)
+	}
+	if opts.DebugCPU {
+		logger.SetLevel("cpu", logging.Debug)
+	}
+	header, err := mem.ParseHeader(rom)
+	if err != nil {
+		return nil, err
+	}
+	dmg := opts.ForceDMG || opts.SGB
+	cgb := !dmg && header.CGB
+	compat := !cgb && !dmg && opts.CompatPalette != ""
 	c := cpu.NewCPU(opts.DebugCPU)
+	switch {
+	case opts.SGB:
+		c = cpu.NewSGBCPU(opts.DebugCPU)
+	case cgb || compat:
+		c = cpu.NewCGBCPU(opts.DebugCPU)
+	}
 	timer := timer.NewTimer()
 	audio := audio.NewAudio()
-	memory := mem.NewMemory(rom, opts.SBWriter, timer, audio)
+	if opts.FastForwardSpeed < 2 {
+		opts.FastForwardSpeed = 4
+	}
+	memory, err := mem.NewMemory(rom, opts.SBWriter, timer, audio)
+	if err != nil {
+		return nil, err
+	}
+	memory.SerialPort = opts.Serial
+	if cgb {
+		memory.EnableCGB()
+		memory.InfraredPort = opts.Infrared
+	}
 	dispatch := cpu.NewDispatch(c, memory)
+	dispatch.SetLogger(logger.With("cpu"))
+	if opts.TraceLength > 0 {
+		dispatch.SetTraceLength(opts.TraceLength)
+	}
 	lcd := lcd.NewLCD(memory, opts.DebugLCD)
-	return &Gameboy{
-		dispatch: dispatch,
-		memory:   memory,
-		timer:    timer,
-		lcd:      lcd,
-		audio:    audio,
-		opts:     opts,
+	lcd.SetLogger(logger.With("lcd"))
+	lcd.SetParallel(opts.RenderWorkers)
+	lcd.SetAccurate(opts.AccuratePPU)
+	layer := &overlay.Layer{}
+	lcd.SetOverlay(layer)
+	if cgb {
+		lcd.SetColourCorrection(opts.ColourCorrection)
+	}
+	if compat {
+		palette, err := chooseCompatPalette(opts.CompatPalette, rom)
+		if err != nil {
+			return nil, err
+		}
+		lcd.SetPalettes(palette.bg, palette.obj0, palette.obj1)
+	} else if opts.Colours != nil {
+		lcd.SetColours(*opts.Colours)
+	}
+	var superGameboy *sgb.SGB
+	if opts.SGB {
+		superGameboy = sgb.New(memory, header.SGB)
+		memory.JoypadPort = superGameboy
+		// The debug display shows the whole background instead
+		if !opts.DebugLCD {
+			lcd.SetCompositor(superGameboy)
+		}
+	}
+	cheats, err := loadCheats(opts.Cheats, opts.CheatFilename)
+	if err != nil {
+		return nil, err
+	}
+	memory.ROMPatch = cheats
+	gameboy := &Gameboy{
+		dispatch:     dispatch,
+		memory:       memory,
+		timer:        timer,
+		lcd:          lcd,
+		audio:        audio,
+		superGameboy: superGameboy,
+		cheats:       cheats,
+		opts:         opts,
+		romHash:      fmt.Sprintf("%x", sha1.Sum(rom)),
+		log:          logger.With("gb"),
+		cgb:          cgb,
+		overlay:      layer,
+	}
+	lcd.AddVBlankHook(gameboy.sampleWatches)
+	lcd.AddVBlankHook(func() { gameboy.publish(Event{Kind: VBlankEvent}) })
+	lcd.AddFrameHook(func(*image.RGBA) { gameboy.publish(Event{Kind: FrameEvent}) })
+	memory.PollInput = gameboy.pollInput
+	memory.OnSerial = func(sent, received uint8) {
+		gameboy.publish(Event{Kind: SerialEvent, Sent: sent, Received: received})
+	}
+	audio.OnBatch(func() bool { return gameboy.events.wants(AudioEvent) }, func(samples []float32) {
+		gameboy.publish(Event{Kind: AudioEvent, Samples: samples})
+	})
+	if opts.BootIntro {
+		gameboy.startBootIntro(rom)
 	}
+	if opts.RewindBuffer > 0 {
+		gameboy.rewind = newRewindBuffer(opts.RewindBuffer)
+	}
+	if opts.CoverageFilename != "" {
+		gameboy.EnableCoverage()
+	}
+	if opts.IOLog != nil {
+		ioLog, err := newIOLog(gameboy, opts.IOLog, opts.IOLogRegisters)
+		if err != nil {
+			return nil, err
+		}
+		memory.AddHooks(ioLog)
+	}
+	if opts.SymbolFilename != "" {
+		err := gameboy.LoadSymbols(opts.SymbolFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = gameboy.loadBatteryRAM()
+	if err != nil {
+		return nil, err
+	}
+	return gameboy, nil
+}
+
+func loadCheats(codes []string, cheatFilename string) (*cheat.Engine, error) {
+	cheats := cheat.NewEngine()
+	if cheatFilename != "" {
+		err := cheats.Load(cheatFilename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read the cheat file at \"%s\" (%v)", cheatFilename, err)
+		}
+	}
+	for _, code := range codes {
+		_, err := cheats.Add(code, "")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to add cheat (%v)", err)
+		}
+	}
+	return cheats, nil
 }
 
-func readRomFile(romFilename string) []byte {
-	var rom []byte
-	if romFilename == "" {
-		panic(fmt.Sprintf("No ROM file specified"))
+// findPatch returns the patch to apply to the ROM, which is either the one given or one found alongside
+// the ROM file
+func findPatch(opts Options) string {
+	if opts.PatchFilename != "" || opts.RomFilename == "" {
+		return opts.PatchFilename
+	}
+	base := strings.TrimSuffix(opts.RomFilename, filepath.Ext(opts.RomFilename))
+	for _, ext := range []string{".ips", ".bps"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
 	}
+	return ""
+}
+
+func readRomFile(romFilename string) ([]byte, error) {
 	rom, err := ioutil.ReadFile(romFilename)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read the ROM file at \"%s\" (%v)", romFilename, err))
+		return nil, fmt.Errorf("Failed to read the ROM file at \"%s\" (%v)", romFilename, err)
 	}
-	return rom
+	return rom, nil
 }
 
 func (gb *Gameboy) runFrame() {
-	// The Game Boy clock runs at 4.194304MHz
-	// Each loop iteration below represents one machine cycle
-	// One machine cycle is 4 clock cycles
-	// Each LCD frame is 17556 machine cycles
-	for mtick := 0; mtick < 17556; mtick++ {
-		gb.dispatch.ExecuteMachineCycle()
-		gb.memory.ExecuteMachineCycle()
-		gb.lcd.EndMachineCycle()
-		gb.audio.EndMachineCycle()
-		timerInterruptRequested := gb.timer.EndMachineCycle()
-		if timerInterruptRequested {
-			gb.memory.IF |= 0x04
-		}
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	gb.pollInput()
+	start := time.Now()
+	waited := gb.audio.Waited()
+	for !gb.runMachineCycle() {
 	}
-	gb.lcd.FrameEnd()
-	gb.frame++
+	gb.recordRewind()
+	gb.timing.record(time.Since(start), gb.renderTime, gb.audio.Waited()-waited)
 
 	// The emulator can run a frame much faster than a real Gameboy when running on a modern computer.
 	// There is no need to sleep now between frames however, because the audio subsystem consumes
@@ -128,134 +470,323 @@ func (gb *Gameboy) runFrame() {
 
 }
 
+// runMachineCycle runs every subsystem for one machine cycle and returns true if a frame completed
+func (gb *Gameboy) runMachineCycle() bool {
+	// The Game Boy clock runs at 4.194304MHz
+	// Each call represents one machine cycle
+	// One machine cycle is 4 clock cycles
+	// Each LCD frame is 17556 machine cycles
+	gb.dispatch.ExecuteMachineCycle()
+	gb.memory.ExecuteMachineCycle()
+	if gb.memory.DoubleSpeed() {
+		// At double speed the CPU, DMA and timer run two machine cycles for each one of the LCD and
+		// audio, so every other call stops here
+		gb.secondCPUCycle = !gb.secondCPUCycle
+		if gb.secondCPUCycle {
+			gb.endTimerMachineCycle()
+			return false
+		}
+	}
+	gb.lcd.EndMachineCycle()
+	gb.audio.EndMachineCycle()
+	gb.endTimerMachineCycle()
+	gb.mtick++
+	if gb.mtick < 17556 {
+		return false
+	}
+	gb.mtick = 0
+	gb.memory.RunClock(17556)
+	gb.cheats.ApplyRAM(gb.memory)
+	if !gb.lcd.Enabled() {
+		// There is no V-Blank while the LCD is off
+		gb.sampleWatches()
+	}
+	renderStart := time.Now()
+	gb.lcd.FrameEnd()
+	gb.renderTime = time.Since(renderStart)
+	if gb.intro != nil {
+		gb.stepBootIntro()
+	}
+	gb.frame++
+	return true
+}
+
+func (gb *Gameboy) endTimerMachineCycle() {
+	timerInterruptRequested := gb.timer.EndMachineCycle()
+	if timerInterruptRequested {
+		gb.memory.IF |= 0x04
+	}
+	if gb.timer.FrameSequencerStep() {
+		gb.audio.StepFrameSequencer()
+	}
+}
+
+// CGB returns true if the Gameboy is running as a Game Boy Color
+func (gb *Gameboy) CGB() bool {
+	return gb.cgb
+}
+
+// Cartridge returns the header of the cartridge, as patched, that the memory bank controller was chosen from
+func (gb *Gameboy) Cartridge() mem.Header {
+	return gb.memory.Header()
+}
+
 // Run the Gameboy
 func (gb *Gameboy) Run(ctx context.Context) {
+	defer gb.recoverCrash()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
+			if gb.rewinding() {
+				gb.stepRewind()
+				continue
+			}
+			if gb.rewind != nil {
+				gb.rewind.paced = false
+			}
 			gb.runFrame()
+			if gb.opts.Pace {
+				gb.timing.addSleep(gb.pacer.wait())
+			}
 		}
 	}
 }
 
-// Time the Gameboy as it runs
-func (gb *Gameboy) Time(ctx context.Context) {
-	for {
-		// There are just under 60 frames per second (59.7275) so let's time in blocks of 60 frames
-		// On a real Gameboy this would take 1 second
-		t0 := time.Now()
-		for i := 0; i < 60; i++ {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				gb.runFrame()
-			}
-		}
-		t1 := time.Now()
-		fmt.Println("=========> ", t1.Sub(t0))
+// RunFrames runs the Gameboy for a number of frames as fast as possible, returning once they have
+// been drawn. Together with OnFrame it drives the Gameboy without a display or speakers.
+func (gb *Gameboy) RunFrames(frames int) {
+	defer gb.recoverCrash()
+	for i := 0; i < frames; i++ {
+		gb.runFrame()
 	}
 }
 
 // ButtonAction turns UI key presses into emulator button presses corresponding to the Gameboy controls
 func (gb *Gameboy) ButtonAction(button Button, pressed bool) {
+	gb.PlayerButtonAction(0, button, pressed)
+}
+
+// PlayerButtonAction presses buttons on one of the 4 controllers that a Super Game Boy can read once a
+// game requests multiplayer. Controller 0 is the Gameboy's own buttons.
+func (gb *Gameboy) PlayerButtonAction(player int, button Button, pressed bool) {
+	if gb.interceptButtons != nil {
+		gb.interceptButtons(player, button, pressed)
+		return
+	}
+	gb.PressButton(player, button, pressed)
+}
+
+// InterceptButtons sends the buttons pressed by frontends to a function instead of the controllers,
+// such as to pass them to another computer first. The function presses buttons with PressButton.
+func (gb *Gameboy) InterceptButtons(intercept func(player int, button Button, pressed bool)) {
+	gb.interceptButtons = intercept
+}
+
+// PressButton presses or releases a button on one of the controllers, bypassing InterceptButtons
+func (gb *Gameboy) PressButton(player int, button Button, pressed bool) {
+	if player < 0 || player >= len(gb.input.held) || button < Up || button > Select {
+		return
+	}
 
 	// Start the CPU in case it was stopped waiting for input
 	gb.dispatch.Start()
 
-	// Bit 3 - P13 Input Down  or Start    (0=Pressed) (Read Only)
-	// Bit 2 - P12 Input Up    or Select   (0=Pressed) (Read Only)
-	// Bit 1 - P11 Input Left  or Button B (0=Pressed) (Read Only)
-	// Bit 0 - P10 Input Right or Button A (0=Pressed) (Read Only)
+	gb.input.Lock()
+	defer gb.input.Unlock()
+	gb.input.held[player] = gb.input.held[player].With(button, pressed)
+	gb.applyInput()
+}
 
-	switch button {
+// HasAccelerometer returns true if the cart is played by tilting it, as MBC7 carts such as Kirby Tilt
+// 'n' Tumble are
+func (gb *Gameboy) HasAccelerometer() bool {
+	return gb.memory.HasAccelerometer()
+}
 
-	// FIXME it shouldn't be possible to press left and right at once or up and down at once
+// Tilt sets how far a cart with an accelerometer is tilted, from -1 to 1, with positive x tilting it
+// right and positive y tilting it down towards the player
+func (gb *Gameboy) Tilt(x, y float64) {
+	gb.memory.Tilt(x, y)
+}
 
-	case Start:
-		if pressed {
-			gb.memory.ButtonInput &^= 0x8
-		} else {
-			gb.memory.ButtonInput |= 0x8
+// EmulatorAction turns UI key presses into actions controlling the emulator itself
+func (gb *Gameboy) EmulatorAction(action Action) {
+	switch action {
+	case TakeScreenshot:
+		filename := timestampedFilename("", "tetromino", "png")
+		gb.log.Infof("Writing screenshot to %s", filename)
+		if err := gb.Screenshot(filename); err != nil {
+			gb.log.Errorf("Failed to write screenshot: %v", err)
+			return
 		}
-
-	case Select:
-		if pressed {
-			gb.memory.ButtonInput &^= 0x4
-		} else {
-			gb.memory.ButtonInput |= 0x4
+		gb.notify("Screenshot saved")
+	case StartFastForward:
+		gb.SetSpeed(gb.opts.FastForwardSpeed)
+		gb.notify(fmt.Sprintf("Fast-forward x%d", gb.opts.FastForwardSpeed))
+	case StopFastForward:
+		gb.SetSpeed(1)
+	case StartRewind:
+		if gb.rewind == nil {
+			gb.notify("Rewind is off")
+			return
 		}
-
-	case B:
-		if pressed {
-			gb.memory.ButtonInput &^= 0x2
-		} else {
-			gb.memory.ButtonInput |= 0x2
+		gb.setRewinding(true)
+		gb.notify("Rewind")
+	case StopRewind:
+		gb.setRewinding(false)
+	case DumpTrace:
+		filename, err := gb.DumpTrace()
+		if err != nil {
+			gb.log.Errorf("Failed to write trace: %v", err)
+			return
 		}
+		gb.log.Infof("Writing trace to %s", filename)
+		gb.notify("Trace saved")
+	}
+}
 
-	case A:
-		if pressed {
-			gb.memory.ButtonInput &^= 0x1
-		} else {
-			gb.memory.ButtonInput |= 0x1
-		}
+// notify shows a message on the screen for a couple of seconds
+func (gb *Gameboy) notify(text string) {
+	gb.overlay.Message(text, color.White, notificationFrames)
+}
 
-	case Down:
-		if pressed {
-			gb.memory.DirectionInput &^= 0x8
-		} else {
-			gb.memory.DirectionInput |= 0x8
-		}
+// Overlay returns the layer of text and boxes drawn over each frame before it is displayed, which
+// isn't part of the frames seen by frame hooks or screenshots
+func (gb *Gameboy) Overlay() *overlay.Layer {
+	return gb.overlay
+}
 
-	case Up:
-		if pressed {
-			gb.memory.DirectionInput &^= 0x4
-		} else {
-			gb.memory.DirectionInput |= 0x4
-		}
+// timestampedFilename returns a filename in a directory made from a prefix and the current time
+func timestampedFilename(dir, prefix, ext string) string {
+	t := time.Now()
+	return filepath.Join(dir, fmt.Sprintf("%s-%d%02d%02d-%02d%02d%02d.%s", prefix,
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), ext))
+}
 
-	case Left:
-		if pressed {
-			gb.memory.DirectionInput &^= 0x2
-		} else {
-			gb.memory.DirectionInput |= 0x2
-		}
+// SetSpeed runs the emulator at a multiple of the speed of a real Gameboy while keeping audio at the correct pitch
+func (gb *Gameboy) SetSpeed(speed int) {
+	gb.audio.SetSpeed(speed)
+	gb.pacer.setSpeed(speed)
+}
 
-	case Right:
-		if pressed {
-			gb.memory.DirectionInput &^= 0x1
-		} else {
-			gb.memory.DirectionInput |= 0x1
-		}
-	}
+// Speed returns the multiple of the speed of a real Gameboy that the emulator runs at, which may be
+// called from any goroutine
+func (gb *Gameboy) Speed() int {
+	return gb.pacer.getSpeed()
 }
 
-// EmulatorAction turns UI key presses into actions controlling the emulator itself
-func (gb *Gameboy) EmulatorAction(action Action) {
-	switch action {
-	case TakeScreenshot:
-		t := time.Now()
-		filename := fmt.Sprintf("tetromino-%d%02d%02d-%02d%02d%02d.png",
-			t.Year(), t.Month(), t.Day(),
-			t.Hour(), t.Minute(), t.Second())
-		fmt.Println("Writing screenshot to", filename)
-		gb.lcd.Screenshot(filename)
+// AddCheat adds a GameShark or Game Genie code to the running Gameboy
+func (gb *Gameboy) AddCheat(code, description string) error {
+	_, err := gb.cheats.Add(code, description)
+	return err
+}
+
+// RemoveCheat removes a cheat code, returning false if it was not active
+func (gb *Gameboy) RemoveCheat(code string) bool {
+	return gb.cheats.Remove(code)
+}
+
+// EnableCheat enables or disables a cheat code without removing it
+func (gb *Gameboy) EnableCheat(code string, enabled bool) bool {
+	return gb.cheats.Enable(code, enabled)
+}
+
+// Cheats lists the cheat codes known to the Gameboy
+func (gb *Gameboy) Cheats() []cheat.Cheat {
+	return gb.cheats.Cheats()
+}
+
+// StartCheatSearch snapshots RAM and returns a search that narrows down candidate addresses
+// each time it is compared with a later snapshot
+func (gb *Gameboy) StartCheatSearch() *cheat.Search {
+	return cheat.NewSearch(gb.memory)
+}
+
+// ReadMemory reads a byte from the Gameboy's address space
+func (gb *Gameboy) ReadMemory(addr uint16) uint8 {
+	return gb.memory.Read(addr)
+}
+
+// WriteMemory writes a byte to the Gameboy's address space
+func (gb *Gameboy) WriteMemory(addr uint16, value uint8) {
+	gb.memory.Write(addr, value)
+}
+
+// AddMemoryHooks registers hooks that observe every memory read and write
+func (gb *Gameboy) AddMemoryHooks(hooks mem.Hooks) {
+	gb.memory.AddHooks(hooks)
+}
+
+// OnFrame registers a function that is called with each completed frame before it is displayed. The
+// frame buffer is reused for every frame so hooks must copy it to keep it.
+func (gb *Gameboy) OnFrame(hook func(*image.RGBA)) {
+	gb.lcd.AddFrameHook(hook)
+}
+
+// OnScanline registers a function that is called as each visible line enters H-Blank
+func (gb *Gameboy) OnScanline(hook func(ly uint8)) {
+	gb.lcd.AddScanlineHook(hook)
+}
+
+// OnExecute registers a function that is called with the address of each instruction as it starts
+func (gb *Gameboy) OnExecute(hook func(pc uint16)) {
+	gb.executeHooks = append(gb.executeHooks, hook)
+	gb.dispatch.OnExecute = gb.execute
+}
+
+func (gb *Gameboy) execute(pc uint16, length int) {
+	if gb.coverage != nil {
+		gb.cover(pc, length)
+	}
+	for _, hook := range gb.executeHooks {
+		hook(pc)
 	}
 }
 
+// Instructions returns the number of CPU instructions executed since the Gameboy was started
+func (gb *Gameboy) Instructions() uint64 {
+	return gb.dispatch.Instructions()
+}
+
+// FrameCount returns the number of frames run since the Gameboy was started
+func (gb *Gameboy) FrameCount() int {
+	return gb.frame
+}
+
+// Logger returns the logger that the Gameboy's subsystems write to
+func (gb *Gameboy) Logger() *logging.Logger {
+	return gb.log
+}
+
 // Debug enabled for the UI
 func (gb *Gameboy) Debug() bool {
 	return gb.opts.DebugLCD
 }
 
-// RegisterDisplay registers a real-world display implementation with the LCD subsystem
+// ScreenSize returns the width and height of the picture shown by the display
+func (gb *Gameboy) ScreenSize() (int, int) {
+	switch {
+	case gb.opts.DebugLCD:
+		return 256, 256
+	case gb.opts.SGB:
+		return sgb.Width, sgb.Height
+	}
+	return 160, 144
+}
+
+// RegisterDisplay registers a real-world display implementation with the LCD subsystem. A Gameboy
+// starts with no display, or can be given a nil one, and runs headless: frames are still drawn and
+// passed to the functions registered with OnFrame, which is how tools and servers consume them.
 func (gb *Gameboy) RegisterDisplay(display lcd.Display) {
 	gb.lcd.RegisterDisplay(display)
 }
 
-// RegisterSpeakers registers a real-world audio implementation with the audio subsystem
+// RegisterSpeakers registers a real-world audio implementation with the audio subsystem, or removes
+// it when speakers is nil. Without speakers nothing throttles the emulator, so Run only keeps to the
+// speed of a real Gameboy when Options.Pace is set.
 func (gb *Gameboy) RegisterSpeakers(speakers audio.Speakers) {
 	gb.audio.RegisterSpeakers(speakers)
 }
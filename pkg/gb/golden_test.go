@@ -0,0 +1,102 @@
+package gb
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGoldens = flag.Bool("update", false, "Regenerate the golden frames and the test result screenshots")
+
+// testResultFilename returns where to write the screenshot of a test ROM: the testresults directory
+// that the README links to when run with -update, and a temporary directory otherwise so that running
+// the tests leaves the tree unchanged
+func testResultFilename(t *testing.T, filename string) string {
+	name := strings.Replace(filename, "/", "_", -1) + ".png"
+	if *updateGoldens {
+		return filepath.Join("testresults", name)
+	}
+	dir, err := ioutil.TempDir("", "tetromino-testresults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, name)
+}
+
+// runGoldenTest runs a ROM headless for a number of frames and compares the final frame against
+// a stored golden image, reporting how many pixels differ
+func runGoldenTest(t *testing.T, filename string, frames int) {
+	// The golden frames were captured on the original Game Boy although the blargg ROMs support the CGB
+	gameboy, err := NewGameboy(Options{RomFilename: "testdata/" + filename, ForceDMG: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(frames)
+	actual := gameboy.lcd.Frame().SubImage(image.Rect(0, 0, 160, 144))
+	goldenFilename := fmt.Sprintf("testdata/golden/%s-%d.png", strings.Replace(filename, "/", "_", -1), frames)
+	if *updateGoldens {
+		writeGolden(t, goldenFilename, actual)
+		return
+	}
+	f, err := os.Open(goldenFilename)
+	if err != nil {
+		t.Fatalf("Missing golden frame (run with -update to create it): %v", err)
+	}
+	defer f.Close()
+	expected, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff int
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			if expected.At(x, y) != actual.At(x, y) {
+				diff++
+			}
+		}
+	}
+	if diff > 0 {
+		actualFilename := strings.TrimSuffix(goldenFilename, ".png") + ".actual.png"
+		writeGolden(t, actualFilename, actual)
+		t.Errorf("%d pixels differ from %s (actual frame written to %s)", diff, goldenFilename, actualFilename)
+	}
+}
+
+func writeGolden(t *testing.T, filename string, frame image.Image) {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = png.Encode(f, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoldenCPUInstrs(t *testing.T) {
+	runGoldenTest(t, "blargg/cpu_instrs/individual/01-special.gb", 300)
+}
+
+func TestGoldenInstrTiming(t *testing.T) {
+	runGoldenTest(t, "blargg/instr_timing/instr_timing.gb", 120)
+}
+
+func TestGoldenHaltBug(t *testing.T) {
+	runGoldenTest(t, "blargg/halt_bug.gb", 120)
+}
+
+func TestGoldenMooneyeMemOAM(t *testing.T) {
+	runGoldenTest(t, "mooneye-gb_hwtests/acceptance/bits/mem_oam.gb", 60)
+}
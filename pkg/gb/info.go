@@ -0,0 +1,29 @@
+package gb
+
+import (
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+)
+
+// RomInfo describes a ROM file without running it
+type RomInfo struct {
+	mem.Header
+	Size int
+	// SHA1 is the hash used to key debugger sessions
+	SHA1 string
+}
+
+// ReadRomInfo reads the cartridge header and hash of a ROM file
+func ReadRomInfo(romFilename string) (RomInfo, error) {
+	rom, err := readRomFile(romFilename)
+	if err != nil {
+		return RomInfo{}, err
+	}
+	header, err := mem.ParseHeader(rom)
+	if err != nil {
+		return RomInfo{}, err
+	}
+	return RomInfo{Header: header, Size: len(rom), SHA1: fmt.Sprintf("%x", sha1.Sum(rom))}, nil
+}
@@ -0,0 +1,62 @@
+package gb
+
+import "testing"
+
+func TestReadRomInfo(t *testing.T) {
+	info, err := ReadRomInfo("testdata/blargg/cpu_instrs/individual/01-special.gb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CartridgeType != 0x01 || info.ROMBanks != 2 || info.RAMBanks != 0 || info.Battery || !info.Supported {
+		t.Errorf("unexpected cartridge details: %+v", info.Header)
+	}
+	if !info.ChecksumValid {
+		t.Errorf("expected a valid header checksum but got 0x%02x", info.Checksum)
+	}
+	if info.Size != 0x8000 || info.SHA1 != "30def8804393401f7ea68a6a34d783966c69a6cf" {
+		t.Errorf("unexpected size %d or hash %s", info.Size, info.SHA1)
+	}
+}
+
+func TestCartridge(t *testing.T) {
+	gameboy := newMBC5(t, 0x1e)
+	cartridge := gameboy.Cartridge()
+	if cartridge.Mapper() != "MBC5" || !cartridge.Rumble || cartridge.Timer || cartridge.ROMBanks != 512 || cartridge.RAMBanks != 16 || cartridge.RAMSize != 0x20000 {
+		t.Errorf("unexpected cartridge details: %#v", cartridge)
+	}
+	expected := " (MBC5, 512 ROM banks, 16 RAM banks, battery, rumble, bad header checksum)"
+	if summary := cartridge.String(); summary != expected {
+		t.Errorf("expected %q but got %q", expected, summary)
+	}
+
+	rom := writeRom(t, map[uint16][]byte{0x0147: {0x10, 0x00, 0x01}})
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cartridge := gameboy.Cartridge(); cartridge.Mapper() != "MBC3" || !cartridge.Timer || cartridge.RAMBanks != 1 || cartridge.RAMSize != 0x800 {
+		t.Errorf("unexpected cartridge details: %#v", cartridge)
+	}
+}
+
+func TestNewGameboyErrors(t *testing.T) {
+	for _, opts := range []Options{
+		{RomFilename: "testdata/missing.gb"},
+		{Rom: []byte{0x00, 0xc3, 0x50, 0x01}},
+		{Rom: make([]byte, 0x150)},
+	} {
+		if gameboy, err := NewGameboy(opts); err == nil || gameboy != nil {
+			t.Errorf("expected an error for a ROM file %q of %d bytes", opts.RomFilename, len(opts.Rom))
+		}
+	}
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0100:], []byte{0x18, 0xfe}) // JR -2
+	gameboy, err := NewGameboy(Options{Rom: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(1)
+	if gameboy.FrameCount() != 1 {
+		t.Errorf("expected 1 frame but got %d", gameboy.FrameCount())
+	}
+}
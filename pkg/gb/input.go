@@ -0,0 +1,122 @@
+package gb
+
+import "sync"
+
+// Buttons is a set of held buttons, with bit n set while Button n is held
+type Buttons uint8
+
+// Has returns true if a button is in the set
+func (b Buttons) Has(button Button) bool {
+	return b&(1<<uint(button)) != 0
+}
+
+// With returns the set with a button pressed or released
+func (b Buttons) With(button Button, pressed bool) Buttons {
+	if pressed {
+		return b | 1<<uint(button)
+	}
+	return b &^ (1 << uint(button))
+}
+
+// joyp returns the input lines of JOYP for the direction pad and for the buttons, where 0 is pressed
+func (b Buttons) joyp() (directions, buttons uint8) {
+	// Bit 3 - P13 Input Down  or Start    (0=Pressed) (Read Only)
+	// Bit 2 - P12 Input Up    or Select   (0=Pressed) (Read Only)
+	// Bit 1 - P11 Input Left  or Button B (0=Pressed) (Read Only)
+	// Bit 0 - P10 Input Right or Button A (0=Pressed) (Read Only)
+	directions, buttons = 0x0f, 0x0f
+	for i, button := range [4]Button{Right, Left, Up, Down} {
+		if b.Has(button) {
+			directions &^= 1 << uint(i)
+		}
+	}
+	for i, button := range [4]Button{A, B, Select, Start} {
+		if b.Has(button) {
+			buttons &^= 1 << uint(i)
+		}
+	}
+	return directions, buttons
+}
+
+// InputSource supplies the buttons held on the controllers, such as by a keyboard, a gamepad, another
+// computer or a recording. The Gameboy polls its sources whenever the game reads JOYP and at the start
+// of each frame so Buttons is called by the goroutine running the Gameboy, alongside whatever changes
+// the buttons, and mustn't call the Gameboy's input methods.
+type InputSource interface {
+	// Buttons returns the buttons held on one of the 4 controllers, where controller 0 is the
+	// Gameboy's own buttons and the others are only read by a Super Game Boy
+	Buttons(player int) Buttons
+}
+
+// InputSourceFunc lets a function be used as an InputSource
+type InputSourceFunc func(player int) Buttons
+
+// Buttons calls the function
+func (f InputSourceFunc) Buttons(player int) Buttons {
+	return f(player)
+}
+
+// inputState combines the buttons of the input sources with those pressed with PressButton
+type inputState struct {
+	sync.Mutex
+	// sources are pointers so that each can be found again to remove it
+	sources []*InputSource
+	// held are the buttons pressed with PressButton
+	held [4]Buttons
+	// combined are the buttons held by anything the last time JOYP was brought up to date
+	combined [4]Buttons
+}
+
+// AddInputSource adds a source of buttons, which are combined with the buttons of the other sources
+// and those pressed with PressButton so that a button is held while anything holds it. Sources drive
+// the controllers directly, as PressButton does, so InterceptButtons doesn't see them. The returned
+// function removes the source again.
+func (gb *Gameboy) AddInputSource(source InputSource) (remove func()) {
+	entry := &source
+	gb.input.Lock()
+	defer gb.input.Unlock()
+	gb.input.sources = append(gb.input.sources, entry)
+	gb.applyInput()
+	return func() {
+		gb.input.Lock()
+		defer gb.input.Unlock()
+		for i, s := range gb.input.sources {
+			if s == entry {
+				gb.input.sources = append(gb.input.sources[:i:i], gb.input.sources[i+1:]...)
+				gb.applyInput()
+				return
+			}
+		}
+	}
+}
+
+// pollInput brings the input lines of JOYP up to date with the input sources
+func (gb *Gameboy) pollInput() {
+	gb.input.Lock()
+	defer gb.input.Unlock()
+	if len(gb.input.sources) > 0 {
+		gb.applyInput()
+	}
+}
+
+// applyInput sets the input lines of JOYP for each controller from the buttons that anything holds,
+// starting the CPU in case it was stopped waiting for a button to be pressed. The input lock must be
+// held.
+func (gb *Gameboy) applyInput() {
+	// FIXME it shouldn't be possible to press left and right at once or up and down at once
+	pressed := false
+	for player, buttons := range gb.input.held {
+		for _, source := range gb.input.sources {
+			buttons |= (*source).Buttons(player)
+		}
+		if buttons&^gb.input.combined[player] != 0 {
+			pressed = true
+		}
+		gb.input.combined[player] = buttons
+		gb.memory.DirectionInput[player], gb.memory.ButtonInput[player] = buttons.joyp()
+	}
+	if pressed {
+		gb.dispatch.Start()
+	}
+	gb.memory.InputChanged()
+}
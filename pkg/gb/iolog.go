@@ -0,0 +1,57 @@
+package gb
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+)
+
+// ioLog writes a line for every write to a selected hardware register
+type ioLog struct {
+	gb        *Gameboy
+	w         io.Writer
+	registers map[uint16]string
+}
+
+// newIOLog returns a log of writes to the registers whose names match any of the patterns, such as
+// "LCDC" or "NR*", or to every register if there are no patterns
+func newIOLog(gb *Gameboy, w io.Writer, patterns []string) (*ioLog, error) {
+	l := &ioLog{gb: gb, w: w, registers: map[uint16]string{}}
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToUpper(strings.TrimSpace(pattern))
+		var matched bool
+		for addr, name := range mem.RegisterNames {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("bad register pattern %q: %v", pattern, err)
+			}
+			if ok {
+				l.registers[addr] = name
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no register matches %q", pattern)
+		}
+	}
+	return l, nil
+}
+
+func (l *ioLog) OnRead(addr uint16, value byte) {}
+
+func (l *ioLog) OnWrite(addr uint16, value byte) {
+	if addr < 0xff00 {
+		return
+	}
+	name, ok := l.registers[addr]
+	if !ok {
+		return
+	}
+	fmt.Fprintf(l.w, "frame %d pc 0x%04x: %-4s (0x%04x) = 0x%02x\n", l.gb.frame, l.gb.dispatch.InstructionPC(), name, addr, value)
+}
@@ -0,0 +1,37 @@
+package gb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+func TestIOLog(t *testing.T) {
+	var log bytes.Buffer
+	gameboy, err := NewGameboy(Options{IOLog: &log, IOLogRegisters: []string{"lcdc", "NR1*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := []uint8{
+		0x3e, 0x91, // LD A,$91
+		0xe0, 0x40, // LDH ($ff40),A
+		0xe0, 0x42, // LDH ($ff42),A
+		0xe0, 0x11, // LDH ($ff11),A
+	}
+	for i, b := range program {
+		gameboy.WriteMemory(0xc000+uint16(i), b)
+	}
+	gameboy.SetRegisters(cpu.Registers{PC: 0xc000, SP: 0xdffe})
+	for i := 0; i < 4; i++ {
+		gameboy.Step()
+	}
+	expected := "frame 0 pc 0xc002: LCDC (0xff40) = 0x91\nframe 0 pc 0xc006: NR11 (0xff11) = 0x91\n"
+	if log.String() != expected {
+		t.Errorf("expected log:\n%s\nbut got:\n%s", expected, log.String())
+	}
+	if _, err := NewGameboy(Options{IOLog: &log, IOLogRegisters: []string{"LDCD"}}); err == nil || !strings.Contains(err.Error(), "LDCD") {
+		t.Errorf("expected an error for an unknown register but got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package ir connects the infrared port of a Game Boy Color to its own LED or to the infrared port of
+// another emulator, which games such as Pokémon Gold and Silver use for Mystery Gift
+package ir
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// Loopback is an infrared port that senses the light from its own LED, like a Game Boy Color held up
+// to a mirror
+type Loopback struct {
+	led bool
+}
+
+// SetLED switches the LED on or off
+func (l *Loopback) SetLED(on bool) {
+	l.led = on
+}
+
+// Receiving returns true while the LED is on
+func (l *Loopback) Receiving() bool {
+	return l.led
+}
+
+// Port is one end of an infrared link between two Game Boy Colors, either in the same process or in
+// two emulators connected over the network
+type Port struct {
+	led   bool
+	other *Port
+	conn  net.Conn
+
+	mutex sync.Mutex
+	light bool
+}
+
+// Pair returns two ports facing each other
+func Pair() (*Port, *Port) {
+	a, b := &Port{}, &Port{}
+	a.other, b.other = b, a
+	return a, b
+}
+
+// Listen waits for another emulator to connect with Dial and returns a port facing it
+func Listen(addr string) (*Port, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	return accept(ln)
+}
+
+func accept(ln net.Listener) (*Port, error) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return connect(conn), nil
+}
+
+// Dial connects to another emulator that is waiting in Listen and returns a port facing it
+func Dial(addr string) (*Port, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return connect(conn), nil
+}
+
+// connect sends a byte each time the LED changes and receives the other emulator's changes in the
+// background
+func connect(conn net.Conn) *Port {
+	p := &Port{conn: conn}
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				// Light stops arriving when the other emulator goes away
+				p.receive(false)
+				return
+			}
+			p.receive(buf[0] != 0)
+		}
+	}()
+	return p
+}
+
+// SetLED switches the LED on or off, shining it at the other end
+func (p *Port) SetLED(on bool) {
+	if on == p.led {
+		return
+	}
+	p.led = on
+	if p.other != nil {
+		p.other.receive(on)
+	}
+	if p.conn != nil {
+		var b byte
+		if on {
+			b = 1
+		}
+		if _, err := p.conn.Write([]byte{b}); err != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+	}
+}
+
+// Receiving returns true while the LED at the other end is on
+func (p *Port) Receiving() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.light
+}
+
+func (p *Port) receive(on bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.light = on
+}
+
+// Close disconnects from the other emulator
+func (p *Port) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
@@ -0,0 +1,83 @@
+package ir
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoopback(t *testing.T) {
+	var l Loopback
+	l.SetLED(true)
+	if !l.Receiving() {
+		t.Error("expected to receive light from the LED")
+	}
+	l.SetLED(false)
+	if l.Receiving() {
+		t.Error("expected no light with the LED off")
+	}
+}
+
+func TestPair(t *testing.T) {
+	a, b := Pair()
+	a.SetLED(true)
+	if !b.Receiving() {
+		t.Error("expected b to receive light from a")
+	}
+	if a.Receiving() {
+		t.Error("expected a not to receive its own light")
+	}
+	a.SetLED(false)
+	if b.Receiving() {
+		t.Error("expected no light with the LED off")
+	}
+}
+
+// eventually waits for light sent over the network to arrive
+func eventually(p *Port, want bool) bool {
+	for i := 0; i < 100; i++ {
+		if p.Receiving() == want {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestNetwork(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan *Port)
+	go func() {
+		p, err := accept(ln)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- p
+	}()
+	a, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b := <-accepted
+	if b == nil {
+		return
+	}
+
+	a.SetLED(true)
+	if !eventually(b, true) {
+		t.Error("expected b to receive light from a")
+	}
+	b.SetLED(true)
+	if !eventually(a, true) {
+		t.Error("expected a to receive light from b")
+	}
+	b.Close()
+	if !eventually(a, false) {
+		t.Error("expected the light to stop when b disconnects")
+	}
+}
@@ -0,0 +1,95 @@
+package gb
+
+import "testing"
+
+func TestJoypadInterrupt(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: writeRom(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // JP $0150
+		0x0150: {
+			0x3e, 0x10, // LD A,$10
+			0xe0, 0x00, // LDH ($00),A
+			0x3e, 0x10, // LD A,$10
+			0xe0, 0xff, // LDH ($FF),A
+			0xaf,       // XOR A
+			0xe0, 0x0f, // LDH ($0F),A
+			0xf3,       // DI
+			0x76,       // HALT
+			0x00,       // NOP
+			0x3e, 0x42, // LD A,$42
+			0xea, 0x00, 0xc0, // LD ($C000),A
+			0x18, 0xfe, // JR -2
+		},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(2)
+	// The directions aren't selected so pressing one doesn't wake the CPU
+	gameboy.ButtonAction(Up, true)
+	gameboy.RunFrames(1)
+	if gameboy.PeekMemory(0xff0f)&0x10 != 0 || gameboy.PeekMemory(0xc000) == 0x42 {
+		t.Fatal("expected no joypad interrupt for a key that isn't selected")
+	}
+	gameboy.ButtonAction(A, true)
+	gameboy.RunFrames(1)
+	if gameboy.PeekMemory(0xff0f)&0x10 == 0 {
+		t.Error("expected a joypad interrupt for a key that is selected")
+	}
+	if gameboy.PeekMemory(0xc000) != 0x42 {
+		t.Error("expected the joypad interrupt to end the HALT")
+	}
+
+	// Selecting the directions while Up is held also pulls a line low
+	gameboy.WriteMemory(0xff0f, 0x00)
+	gameboy.WriteMemory(0xff00, 0x20)
+	if gameboy.PeekMemory(0xff0f)&0x10 == 0 {
+		t.Error("expected a joypad interrupt when selecting a group with a key held")
+	}
+}
+
+func TestInputSources(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Select the buttons rather than the direction pad in JOYP
+	gameboy.WriteMemory(0xff00, 0x10)
+	var first, second Buttons
+	removeFirst := gameboy.AddInputSource(InputSourceFunc(func(player int) Buttons { return first }))
+	gameboy.AddInputSource(InputSourceFunc(func(player int) Buttons {
+		if player != 0 {
+			return 0
+		}
+		return second
+	}))
+	first = first.With(A, true)
+	second = second.With(Start, true).With(Up, true)
+	if buttons := gameboy.PeekMemory(0xff00) & 0xf; buttons != 0x6 {
+		t.Errorf("expected A and Start from the sources but got %x", buttons)
+	}
+	gameboy.PressButton(0, B, true)
+	second = second.With(Start, false)
+	if buttons := gameboy.PeekMemory(0xff00) & 0xf; buttons != 0xc {
+		t.Errorf("expected A from a source and B from the player but got %x", buttons)
+	}
+	gameboy.WriteMemory(0xff00, 0x20)
+	if directions := gameboy.PeekMemory(0xff00) & 0xf; directions != 0xb {
+		t.Errorf("expected up from a source but got %x", directions)
+	}
+	removeFirst()
+	gameboy.WriteMemory(0xff00, 0x10)
+	if buttons := gameboy.PeekMemory(0xff00) & 0xf; buttons != 0xd {
+		t.Errorf("expected A to be released with its source but got %x", buttons)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	var b Buttons
+	b = b.With(Down, true).With(Select, true).With(A, true).With(A, false)
+	if !b.Has(Down) || !b.Has(Select) || b.Has(A) {
+		t.Errorf("expected down and select but got %08b", b)
+	}
+	if directions, buttons := b.joyp(); directions != 0x7 || buttons != 0xb {
+		t.Errorf("expected JOYP lines 7 and b but got %x and %x", directions, buttons)
+	}
+}
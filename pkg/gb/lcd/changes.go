@@ -0,0 +1,99 @@
+package lcd
+
+import (
+	"bytes"
+	"image"
+)
+
+// changeTracker finds the 8x8 blocks of the visible picture that changed since the previous frame,
+// so that frontends streaming frames can skip the parts of the screen that stayed the same
+type changeTracker struct {
+	previous []byte
+	changes  []image.Rectangle
+}
+
+// update compares a frame with the previous one and keeps a copy of its pixels for the next frame
+func (c *changeTracker) update(frame *image.RGBA) {
+	width, height := frame.Rect.Dx(), frame.Rect.Dy()
+	c.changes = c.changes[:0]
+	if len(c.previous) != width*height*4 {
+		// The first frame is all new
+		c.previous = make([]byte, width*height*4)
+		c.copyRows(frame, 0, width, 0, height)
+		c.changes = append(c.changes, image.Rect(0, 0, width, height))
+		return
+	}
+	for y := 0; y < height; y += 8 {
+		bottom := min(y+8, height)
+		start := -1
+		for x := 0; x <= width; x += 8 {
+			right := min(x+8, width)
+			changed := x < width && c.blockChanged(frame, x, right, y, bottom)
+			if changed {
+				c.copyRows(frame, x, right, y, bottom)
+				if start < 0 {
+					start = x
+				}
+			} else if start >= 0 {
+				c.add(image.Rect(start, y, x, bottom))
+				start = -1
+			}
+		}
+	}
+}
+
+// add a run of changed blocks, extending the rectangle above it when they span the same columns
+func (c *changeTracker) add(r image.Rectangle) {
+	for i := range c.changes {
+		above := &c.changes[i]
+		if above.Max.Y == r.Min.Y && above.Min.X == r.Min.X && above.Max.X == r.Max.X {
+			above.Max.Y = r.Max.Y
+			return
+		}
+	}
+	c.changes = append(c.changes, r)
+}
+
+func (c *changeTracker) blockChanged(frame *image.RGBA, left, right, top, bottom int) bool {
+	width := frame.Rect.Dx()
+	for y := top; y < bottom; y++ {
+		offset := frame.PixOffset(frame.Rect.Min.X+left, frame.Rect.Min.Y+y)
+		previous := (y*width + left) * 4
+		if !bytes.Equal(frame.Pix[offset:offset+(right-left)*4], c.previous[previous:previous+(right-left)*4]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *changeTracker) copyRows(frame *image.RGBA, left, right, top, bottom int) {
+	width := frame.Rect.Dx()
+	for y := top; y < bottom; y++ {
+		offset := frame.PixOffset(frame.Rect.Min.X+left, frame.Rect.Min.Y+y)
+		previous := (y*width + left) * 4
+		copy(c.previous[previous:previous+(right-left)*4], frame.Pix[offset:offset+(right-left)*4])
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// TrackChanges starts finding which parts of the visible picture change from frame to frame
+func (lcd *LCD) TrackChanges() {
+	if lcd.changes == nil {
+		lcd.changes = &changeTracker{}
+	}
+}
+
+// Changes returns the rectangles of the visible picture that changed in the last frame, relative to the
+// top-left corner of the picture and aligned to 8x8 blocks. Every frame reuses the same slice.
+func (lcd *LCD) Changes() []image.Rectangle {
+	if lcd.changes == nil {
+		return nil
+	}
+	return lcd.changes.changes
+}
@@ -0,0 +1,82 @@
+package lcd
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// ColourCorrection chooses how the RGB555 colours in CGB palette RAM are shown, since the raw colours
+// look much more saturated on a modern display than they did on the Game Boy Color's LCD
+type ColourCorrection int
+
+const (
+	// RawColours scales each 5-bit component to 8 bits without correcting it
+	RawColours ColourCorrection = iota
+	// CGBColours approximates the Game Boy Color's LCD, which bleeds the components into each other
+	// and never reaches full brightness
+	CGBColours
+	// GBAColours brightens and washes out the colours as a Game Boy Advance does when it runs Game Boy
+	// Color games, to make up for its darker screen
+	GBAColours
+)
+
+var colourCorrectionNames = []string{"raw", "cgb", "gba"}
+
+func (c ColourCorrection) String() string {
+	if c < 0 || int(c) >= len(colourCorrectionNames) {
+		return fmt.Sprintf("ColourCorrection(%d)", int(c))
+	}
+	return colourCorrectionNames[c]
+}
+
+// ParseColourCorrection returns the colour correction named "raw", "cgb" or "gba"
+func ParseColourCorrection(name string) (ColourCorrection, error) {
+	for i, n := range colourCorrectionNames {
+		if strings.EqualFold(name, n) {
+			return ColourCorrection(i), nil
+		}
+	}
+	return RawColours, fmt.Errorf("unknown colour correction %q, expected \"raw\", \"cgb\" or \"gba\"", name)
+}
+
+// SetColourCorrection changes how the colours of games running in CGB mode are shown
+func (lcd *LCD) SetColourCorrection(correction ColourCorrection) {
+	if correction == RawColours {
+		lcd.cgbColours = nil
+		return
+	}
+	table := new([0x8000]color.RGBA)
+	for rgb := range table {
+		table[rgb] = correctColour(correction, uint16(rgb))
+	}
+	lcd.cgbColours = table
+}
+
+// correctColour converts an RGB555 colour using a colour correction
+func correctColour(correction ColourCorrection, rgb uint16) color.RGBA {
+	r, g, b := rgb&0x1f, rgb>>5&0x1f, rgb>>10&0x1f
+	switch correction {
+	case CGBColours:
+		// Each component is a weighted mix of all three, which reaches at most 248
+		return color.RGBA{
+			uint8((r*13 + g*2 + b) >> 1),
+			uint8((g*3 + b) << 1),
+			uint8((r*3 + g*2 + b*11) >> 1),
+			0xff,
+		}
+	case GBAColours:
+		lift := func(c uint16) float64 {
+			return math.Pow(float64(c)/31, 1/1.5)
+		}
+		rf, gf, bf := lift(r), lift(g), lift(b)
+		// Mixing each component a quarter of the way to the luma desaturates the colour
+		luma := 0.299*rf + 0.587*gf + 0.114*bf
+		wash := func(c float64) uint8 {
+			return uint8(math.Round((c*0.75 + luma*0.25) * 255))
+		}
+		return color.RGBA{wash(rf), wash(gf), wash(bf), 0xff}
+	}
+	return color.RGBA{scale5(rgb), scale5(rgb >> 5), scale5(rgb >> 10), 0xff}
+}
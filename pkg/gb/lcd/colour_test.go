@@ -0,0 +1,60 @@
+package lcd
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColourCorrection(t *testing.T) {
+	for _, correction := range []ColourCorrection{RawColours, CGBColours, GBAColours} {
+		parsed, err := ParseColourCorrection(correction.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != correction {
+			t.Errorf("expected %v but got %v", correction, parsed)
+		}
+	}
+	if _, err := ParseColourCorrection("vivid"); err == nil {
+		t.Error("expected an error for an unknown colour correction")
+	}
+}
+
+func TestCorrectColour(t *testing.T) {
+	for _, test := range []struct {
+		correction ColourCorrection
+		rgb        uint16
+		expected   color.RGBA
+	}{
+		{RawColours, 0x7fff, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{RawColours, 0x001f, color.RGBA{0xff, 0x00, 0x00, 0xff}},
+		{CGBColours, 0x0000, color.RGBA{0x00, 0x00, 0x00, 0xff}},
+		{CGBColours, 0x7fff, color.RGBA{0xf8, 0xf8, 0xf8, 0xff}},
+		{CGBColours, 0x001f, color.RGBA{0xc9, 0x00, 0x2e, 0xff}},
+		{GBAColours, 0x0000, color.RGBA{0x00, 0x00, 0x00, 0xff}},
+		{GBAColours, 0x7fff, color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	} {
+		if actual := correctColour(test.correction, test.rgb); actual != test.expected {
+			t.Errorf("%v %04x: expected %v but got %v", test.correction, test.rgb, test.expected, actual)
+		}
+	}
+
+	// Pure red is washed out towards grey but stays red
+	red := correctColour(GBAColours, 0x001f)
+	if red.G == 0 || red.B == 0 || red.R <= red.G {
+		t.Errorf("expected a washed out red but got %v", red)
+	}
+}
+
+func TestSetColourCorrection(t *testing.T) {
+	lcd, memory := newLCD(t)
+	memory.BGPaletteRAM[2] = 0x1f
+	lcd.SetColourCorrection(CGBColours)
+	if actual := lcd.cgbColour(&memory.BGPaletteRAM, 0, 1); actual != (color.RGBA{0xc9, 0x00, 0x2e, 0xff}) {
+		t.Errorf("expected the corrected red but got %v", actual)
+	}
+	lcd.SetColourCorrection(RawColours)
+	if actual := lcd.cgbColour(&memory.BGPaletteRAM, 0, 1); actual != (color.RGBA{0xff, 0x00, 0x00, 0xff}) {
+		t.Errorf("expected the raw red but got %v", actual)
+	}
+}
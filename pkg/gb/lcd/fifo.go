@@ -0,0 +1,255 @@
+package lcd
+
+// mode3Start is the dot of each line at which the pixel FIFO starts drawing, after the OAM scan
+const mode3Start = 81
+
+// pixelFIFO draws a line a dot at a time as the Game Boy's PPU does. The fetcher takes 6 dots to read
+// a tile of the background or window and pushes its 8 pixels once the FIFO is empty, while the FIFO
+// shifts out one pixel each dot. The first tile fetched on each line is thrown away, pixels are
+// dropped for SCX, the window restarts the fetcher and sprites stall the FIFO while they are fetched,
+// so that mode 3 lasts as long as it does on the hardware.
+type pixelFIFO struct {
+	drawing bool
+	// x is the position of the next pixel shifted out, which starts left of the screen for the tile
+	// that is thrown away, and discard is the number of pixels still to drop for SCX
+	x       int
+	discard int
+	// pixels holds the tile pushed by the fetcher, with queued of its pixels still to shift out
+	pixels [8]uint8
+	queued int
+	// fetchState counts the dots of the tile being fetched, up to 6 when it is ready to push, and
+	// fetchX is the tile's column. The first tile is thrown away until discarded is set.
+	fetchState int
+	fetchX     uint8
+	discarded  bool
+	// window is true once the fetcher has switched to the window on this line
+	window bool
+	// stall is the number of dots left fetching a sprite before the FIFO shifts out pixels again
+	stall int
+	// spriteX holds the X positions of the sprites on the line in the order they are fetched, with
+	// nextSprite the next one to fetch. The first sprite at X=0 waits up to 5 more dots for SCX.
+	spriteX     [spritesPerLine]uint8
+	sprites     int
+	nextSprite  int
+	leftPenalty int
+}
+
+// SetAccurate draws each line with a pixel FIFO instead of all at once at H-Blank, which is slower
+// but changes the length of mode 3 with SCX, the window and sprites and uses registers changed part
+// way through a line. The debug display is always drawn a line at a time.
+func (lcd *LCD) SetAccurate(accurate bool) {
+	lcd.accurateFIFO = accurate
+}
+
+func (lcd *LCD) accurate() bool {
+	return lcd.accurateFIFO && !lcd.debug
+}
+
+// drawDots runs the 4 dots of a machine cycle
+func (lcd *LCD) drawDots(x int) {
+	for dot := x * 4; dot < x*4+4; dot++ {
+		if dot == mode3Start {
+			// LCD data transfer period starts
+			lcd.memory.STAT = (lcd.memory.STAT & 0xfc) | 0x03
+			lcd.startLine()
+		}
+		if lcd.fifo.drawing && lcd.drawDot() {
+			lcd.fifo.drawing = false
+			if lcd.fifo.window {
+				lcd.windowLine++
+			}
+			lcd.startHBlank()
+		}
+	}
+}
+
+// startLine picks the sprites on the line and readies the fetcher
+func (lcd *LCD) startLine() {
+	y := lcd.memory.LY
+	large := lcd.largeSprites()
+	lcd.updateSprites(y, large)
+	height := 8
+	if large {
+		height = 16
+	}
+	selected, count := lcd.selectSprites(y, height)
+	lcd.sortSprites(&selected, count)
+	scx := int(lcd.memory.SCX % 8)
+	f := &lcd.fifo
+	*f = pixelFIFO{
+		drawing:     true,
+		x:           -8,
+		discard:     scx,
+		sprites:     count,
+		leftPenalty: scx,
+	}
+	if f.leftPenalty > 5 {
+		f.leftPenalty = 5
+	}
+	for i, sprite := range selected[:count] {
+		f.spriteX[i] = lcd.oam[sprite*4+1]
+	}
+}
+
+// drawDot runs the fetcher and FIFO for a dot, returning true once the line has been drawn
+func (lcd *LCD) drawDot() bool {
+	f := &lcd.fifo
+	if f.stall > 0 {
+		f.stall--
+		return false
+	}
+	if f.discard == 0 && lcd.spriteDue() {
+		// The sprite is fetched once the fetcher has nearly finished a tile, with pixels left to
+		// shift out when it is done
+		if f.fetchState < 5 || f.queued == 0 {
+			lcd.advanceFetcher()
+			return false
+		}
+		f.stall = 6 - 1
+		if f.spriteX[f.nextSprite] == 0 {
+			f.stall += f.leftPenalty
+			f.leftPenalty = 0
+		}
+		f.nextSprite++
+		return false
+	}
+	if wx := int(lcd.memory.WX); !f.window && f.x+7 == wx && lcd.windowOnLine(lcd.memory.LY) {
+		// The window replaces the background from here, with pixels left of the screen when WX is
+		// less than 7
+		f.window = true
+		f.discarded = true
+		f.queued = 0
+		f.fetchState = 0
+		f.fetchX = 0
+	}
+	if f.queued > 0 {
+		pixel := f.pixels[8-f.queued]
+		f.queued--
+		switch {
+		case f.x == 0 && f.discard > 0:
+			f.discard--
+		case f.x >= 0:
+			lcd.drawPixel(f.x, pixel)
+			f.x++
+		default:
+			f.x++
+		}
+	}
+	lcd.advanceFetcher()
+	return f.x == 160
+}
+
+// spriteDue returns true when the FIFO has reached the next sprite, skipping sprites it has passed
+// such as while sprites were hidden
+func (lcd *LCD) spriteDue() bool {
+	f := &lcd.fifo
+	if !lcd.spriteDisplayEnable() {
+		return false
+	}
+	for f.nextSprite < f.sprites && int(f.spriteX[f.nextSprite]) < f.x+8 {
+		f.nextSprite++
+	}
+	return f.nextSprite < f.sprites && int(f.spriteX[f.nextSprite]) == f.x+8
+}
+
+// advanceFetcher runs the fetcher for a dot, pushing the tile it has read once the FIFO is empty
+func (lcd *LCD) advanceFetcher() {
+	f := &lcd.fifo
+	switch {
+	case f.fetchState < 6:
+		f.fetchState++
+	case f.queued > 0:
+	case !f.discarded:
+		f.pixels = [8]uint8{}
+		f.queued = 8
+		f.discarded = true
+		f.fetchState = 0
+	default:
+		lcd.fetchTile()
+		f.fetchState = 0
+	}
+}
+
+// fetchTile reads the next tile of the background or window into the FIFO, with the CGB attributes of
+// each pixel alongside its colour index
+func (lcd *LCD) fetchTile() {
+	f := &lcd.fifo
+	m := lcd.memory
+	mapAddr := uint16(0x9800)
+	var column, y uint8
+	if f.window {
+		if lcd.highWindowTileMapDisplaySelect() {
+			mapAddr = 0x9c00
+		}
+		column, y = f.fetchX, lcd.windowLine
+	} else {
+		if lcd.highBgTileMapDisplaySelect() {
+			mapAddr = 0x9c00
+		}
+		column, y = (m.SCX/8+f.fetchX)&31, m.LY+m.SCY
+	}
+	addr := mapAddr + uint16(y/8)*32 + uint16(column&31)
+	tileByte := lcd.readVideoRAM(0, addr)
+	tileNumber := uint16(tileByte)
+	if !lcd.lowTileDataSelect() {
+		tileNumber = uint16(256 + int(int8(tileByte)))
+	}
+	var attributes uint8
+	if m.CGB() {
+		attributes = lcd.readVideoRAM(1, addr)
+	}
+	tileRow := y % 8
+	if attributes&0x40 != 0 {
+		tileRow = 7 - tileRow
+	}
+	tile := lcd.readTile(attributes>>3&1, tileNumber)
+	for i := range f.pixels {
+		tileColumn := i
+		if attributes&0x20 != 0 {
+			tileColumn = 7 - i
+		}
+		f.pixels[i] = tile[tileRow][tileColumn] | attributes&0x07<<2 | attributes&0x80
+	}
+	f.queued = 8
+	f.fetchX++
+}
+
+// drawPixel mixes a pixel shifted out of the FIFO with the sprites using the registers as they are
+// now, with the same priorities as pixelShade and renderCGBPixel
+func (lcd *LCD) drawPixel(x int, bgPixel uint8) {
+	m := lcd.memory
+	y := m.LY
+	row := lcd.frame.Pix[int(y)*lcd.frame.Stride:]
+	var sprite uint8
+	if lcd.spriteDisplayEnable() {
+		sprite = lcd.sprites[y][x]
+	}
+	if m.CGB() {
+		bgPriority := lcd.bgDisplayEnable() && bgPixel&3 != 0 && (bgPixel&0x80 != 0 || sprite&0x80 != 0)
+		if sprite&3 != 0 && !bgPriority {
+			setPixel(row, x, lcd.cgbColour(&m.OBJPaletteRAM, sprite>>2&7, sprite&3))
+		} else {
+			setPixel(row, x, lcd.cgbColour(&m.BGPaletteRAM, bgPixel>>2&7, bgPixel&3))
+		}
+		return
+	}
+	bgShown := lcd.bgDisplayEnable()
+	if !bgShown {
+		bgPixel = 0
+	}
+	var pixel uint8
+	colours := lcd.colours
+	switch {
+	case sprite != 0 && (!spriteBehindBg(sprite) || bgPixel == 0):
+		palette := m.OBP0
+		if sprite&4 != 0 {
+			palette = m.OBP1
+		}
+		pixel = shade(palette, sprite&3)
+		colours = lcd.objColours[sprite>>2&1]
+	case bgShown:
+		pixel = shade(m.BGP, bgPixel)
+	}
+	lcd.shades[y][x] = pixel
+	setPixel(row, x, colours[pixel])
+}
@@ -2,13 +2,14 @@ package lcd
 
 import (
 	"encoding/gob"
-	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"image/draw"
 	"os"
 
 	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gb/overlay"
+	"github.com/scottyw/tetromino/pkg/logging"
 )
 
 const (
@@ -57,41 +58,115 @@ type Display interface {
 	DisplayFrame(*image.RGBA)
 }
 
+// Compositor builds the frame that is displayed from the shade of each pixel on the LCD, such as to
+// colour it and draw a Super Game Boy border around it, returning the bounds of the picture within it
+type Compositor interface {
+	Compose(shades *[144][160]uint8) (*image.RGBA, image.Rectangle)
+}
+
 // LCD represents the LCD display of the Gameboy
 type LCD struct {
 	display        Display
 	memory         *mem.Memory
-	videoRAM       *[0x2000]byte
+	videoRAM       *[2][0x2000]byte
 	oam            *[0xa0]byte
-	tileCache      [384]*[8][8]uint8
-	previousBg     [32][32]uint16
-	previousWindow [32][32]uint16
+	tiles          [2][384][8][8]uint8
+	staleTiles     [2][384]bool
+	tileVersions   [2][384]uint32
+	previousBg     [32][32]uint64
+	previousWindow [32][32]uint64
 	bg             [256][256]uint8
 	window         [256][256]uint8
 	sprites        [144][160]uint8
+	shades         [144][160]uint8
 	frame          *image.RGBA
-	tick           int
-	debug          bool
+	output         *image.RGBA
+	outputBounds   image.Rectangle
+	compositor     Compositor
+	colours        []color.RGBA
+	objColours     [2][]color.RGBA
+	// cgbColours maps RGB555 colours to corrected ones when there is colour correction
+	cgbColours    *[0x8000]color.RGBA
+	log           *logging.Logger
+	tick          int
+	windowLine    uint8
+	windowShown   bool
+	workers       int
+	lines         [144]lineState
+	accurateFIFO  bool
+	fifo          pixelFIFO
+	debug         bool
+	frameHooks    []func(*image.RGBA)
+	scanlineHooks []func(uint8)
+	vblankHooks   []func()
+	changes       *changeTracker
+	overlay       *overlay.Layer
+	// overlaid holds a copy of the frame with the overlay drawn over it for the display
+	overlaid *image.RGBA
 }
 
 // NewLCD returns the configured LCD
 func NewLCD(memory *mem.Memory, debug bool) *LCD {
 	lcd := LCD{
-		videoRAM: &memory.VideoRAM,
-		oam:      &memory.OAM,
-		memory:   memory,
-		frame:    image.NewRGBA(image.Rect(0, 0, 256, 256)),
-		debug:    debug,
+		videoRAM:   &memory.VideoRAM,
+		oam:        &memory.OAM,
+		memory:     memory,
+		frame:      image.NewRGBA(image.Rect(0, 0, 256, 256)),
+		colours:    gray,
+		objColours: [2][]color.RGBA{gray, gray},
+		log:        logging.Default().With("lcd"),
+		debug:      debug,
+	}
+	for bank := range lcd.staleTiles {
+		for tileNumber := range lcd.staleTiles[bank] {
+			lcd.staleTiles[bank][tileNumber] = true
+		}
 	}
 	memory.WriteNotification = &lcd
 	return &lcd
 }
 
+// SetOverlay composites a layer of text and boxes over each frame after the frame hooks have seen it
+func (lcd *LCD) SetOverlay(layer *overlay.Layer) {
+	lcd.overlay = layer
+}
+
+// SetCompositor replaces each frame with one built by a compositor before it is passed to the frame
+// hooks and the display
+func (lcd *LCD) SetCompositor(compositor Compositor) {
+	lcd.compositor = compositor
+}
+
+// SetLogger changes where the LCD logs failures
+func (lcd *LCD) SetLogger(logger *logging.Logger) {
+	lcd.log = logger
+}
+
+// SetColours replaces the four shades of grey, from lightest to darkest, used to display frames
+func (lcd *LCD) SetColours(colours [4]color.RGBA) {
+	lcd.SetPalettes(colours, colours, colours)
+}
+
+// SetPalettes gives the background and window, the OBP0 sprites and the OBP1 sprites their own four
+// colours, as a Game Boy Color does for games made for the original Game Boy
+func (lcd *LCD) SetPalettes(bg, obj0, obj1 [4]color.RGBA) {
+	lcd.colours = bg[:]
+	lcd.objColours = [2][]color.RGBA{obj0[:], obj1[:]}
+}
+
+// Palettes returns the colours of the background and window, the OBP0 sprites and the OBP1 sprites
+func (lcd *LCD) Palettes() (bg, obj0, obj1 [4]color.RGBA) {
+	copy(bg[:], lcd.colours)
+	copy(obj0[:], lcd.objColours[0])
+	copy(obj1[:], lcd.objColours[1])
+	return bg, obj0, obj1
+}
+
 // WriteToVideoRAM implements memory write notification§
-func (lcd *LCD) WriteToVideoRAM(addr uint16) {
+func (lcd *LCD) WriteToVideoRAM(bank int, addr uint16) {
 	if addr < 0x9800 {
 		tileNumber := (addr - 0x8000) / 16
-		lcd.tileCache[tileNumber] = nil
+		lcd.staleTiles[bank][tileNumber] = true
 	}
 }
 
@@ -124,25 +199,39 @@ func (lcd *LCD) EndMachineCycle() {
 		if lcd.memory.STAT&0x10 > 0 {
 			lcd.memory.IF |= 0x02
 		}
+		for _, hook := range lcd.vblankHooks {
+			hook()
+		}
 	case x == 0 && lcd.memory.LY < 144:
+		// The window starts again from its top line each frame
+		if lcd.memory.LY == 0 {
+			lcd.windowLine = 0
+		}
 		// OAM period starts
 		lcd.memory.STAT = (lcd.memory.STAT & 0xfc) | 0x02
 		// Is LCD STAT interrupt enabled?
+		if lcd.memory.STAT&0x20 > 0 && !(lcd.accurate() && lcd.memory.LY > 0) {
+			lcd.memory.IF |= 0x02
+		}
+	case x == 113 && lcd.memory.LY < 143 && lcd.accurate():
+		// The OAM interrupt for each line after the first comes a machine cycle before LY changes
 		if lcd.memory.STAT&0x20 > 0 {
 			lcd.memory.IF |= 0x02
 		}
+	case lcd.accurate() && lcd.memory.LY < 144:
+		// The pixel FIFO decides when H-Blank starts
+		lcd.drawDots(x)
 	case x == 20 && lcd.memory.LY < 144:
 		// LCD data transfer period starts
 		lcd.memory.STAT = (lcd.memory.STAT & 0xfc) | 0x03
 	case x == 63 && lcd.memory.LY < 144:
-		// H-Blank period starts
-		lcd.memory.STAT = (lcd.memory.STAT & 0xfc)
-		// Is LCD STAT interrupt enabled?
-		if lcd.memory.STAT&0x08 > 0 {
-			lcd.memory.IF |= 0x02
-		}
 		// Render LCD line
-		lcd.updateLcdLine(lcd.memory.LY)
+		if lcd.parallel() {
+			lcd.recordLine(lcd.memory.LY)
+		} else {
+			lcd.updateLcdLine(lcd.memory.LY)
+		}
+		lcd.startHBlank()
 	}
 
 	// Check coincidence flag
@@ -159,18 +248,31 @@ func (lcd *LCD) EndMachineCycle() {
 	}
 }
 
+// startHBlank switches to H-Blank once a line has been drawn
+func (lcd *LCD) startHBlank() {
+	lcd.memory.STAT = (lcd.memory.STAT & 0xfc)
+	// Is LCD STAT interrupt enabled?
+	if lcd.memory.STAT&0x08 > 0 {
+		lcd.memory.IF |= 0x02
+	}
+	for _, hook := range lcd.scanlineHooks {
+		hook(lcd.memory.LY)
+	}
+	lcd.memory.HBlankDMA()
+}
+
 // TakeSnapshot writes the current contents of LCD to a file
 func (lcd *LCD) TakeSnapshot() {
 	file, err := os.Create("snapshot.gob")
 	if err != nil {
-		fmt.Printf("Failed to save LCD snapshot: %v\n", err)
+		lcd.log.Errorf("Failed to save LCD snapshot: %v", err)
 		return
 	}
 	defer file.Close()
 	encoder := gob.NewEncoder(file)
 	err = encoder.Encode(lcd)
 	if err != nil {
-		fmt.Printf("Failed to encode LCD snapshot: %v\n", err)
+		lcd.log.Errorf("Failed to encode LCD snapshot: %v", err)
 		return
 	}
 }
@@ -179,74 +281,96 @@ func (lcd *LCD) TakeSnapshot() {
 func (lcd *LCD) LoadSnapshot(filename string) {
 	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("Failed to load LCD snapshot: %v\n", err)
+		lcd.log.Errorf("Failed to load LCD snapshot: %v", err)
 		return
 	}
 	defer file.Close()
 	decoder := gob.NewDecoder(file)
 	err = decoder.Decode(lcd)
 	if err != nil {
-		fmt.Printf("Failed to decode LCD snapshot: %v\n", err)
+		lcd.log.Errorf("Failed to decode LCD snapshot: %v", err)
 		return
 	}
 }
 
-func (lcd *LCD) readVideoRAM(memoryAddr uint16) byte {
-	return lcd.videoRAM[memoryAddr&0x7fff]
+func (lcd *LCD) readVideoRAM(bank uint8, memoryAddr uint16) byte {
+	return lcd.videoRAM[bank][memoryAddr&0x1fff]
 }
 
-func (lcd *LCD) readTile(tileNumber uint16) (*[8][8]uint8, bool) {
-	tile := lcd.tileCache[tileNumber]
-	if tile != nil {
-		return tile, true
+// readTile returns the colour index of each pixel of a tile, which is decoded once and then only
+// again after its bytes in VRAM change
+func (lcd *LCD) readTile(bank uint8, tileNumber uint16) *[8][8]uint8 {
+	tile := &lcd.tiles[bank][tileNumber]
+	if !lcd.staleTiles[bank][tileNumber] {
+		return tile
 	}
-	tile = &[8][8]uint8{}
 	startAddr := uint16(0x8000 + (tileNumber * 16))
 	for y := uint16(0); y < 8; y++ {
-		a := lcd.readVideoRAM(startAddr + y*2)
-		b := lcd.readVideoRAM(startAddr + y*2 + 1)
-		for x := uint16(0); x < 8; x++ {
-			tile[y][0] = (a&bit0)>>7 | (b&bit0)>>6
-			tile[y][1] = (a&bit1)>>6 | (b&bit1)>>5
-			tile[y][2] = (a&bit2)>>5 | (b&bit2)>>4
-			tile[y][3] = (a&bit3)>>4 | (b&bit3)>>3
-			tile[y][4] = (a&bit4)>>3 | (b&bit4)>>2
-			tile[y][5] = (a&bit5)>>2 | (b&bit5)>>1
-			tile[y][6] = (a&bit6)>>1 | (b & bit6)
-			tile[y][7] = (a & bit7) | (b&bit7)<<1
-		}
-	}
-	lcd.tileCache[tileNumber] = tile
-	return tile, false
+		a := lcd.readVideoRAM(bank, startAddr+y*2)
+		b := lcd.readVideoRAM(bank, startAddr+y*2+1)
+		tile[y][0] = (a&bit0)>>7 | (b&bit0)>>6
+		tile[y][1] = (a&bit1)>>6 | (b&bit1)>>5
+		tile[y][2] = (a&bit2)>>5 | (b&bit2)>>4
+		tile[y][3] = (a&bit3)>>4 | (b&bit3)>>3
+		tile[y][4] = (a&bit4)>>3 | (b&bit4)>>2
+		tile[y][5] = (a&bit5)>>2 | (b&bit5)>>1
+		tile[y][6] = (a&bit6)>>1 | (b & bit6)
+		tile[y][7] = (a & bit7) | (b&bit7)<<1
+	}
+	lcd.staleTiles[bank][tileNumber] = false
+	lcd.tileVersions[bank][tileNumber]++
+	return tile
 }
 
-func (lcd *LCD) updateTiles(lcdY uint8, offsetAddr uint16, layer *[256][256]uint8, previousTiles *[32][32]uint16) {
+// updateTiles renders a row of tiles into a layer, storing the colour index of each pixel in bits
+// 0-1 alongside the CGB palette in bits 2-4 and BG-to-OAM priority in bit 7
+func (lcd *LCD) updateTiles(lcdY uint8, offsetAddr uint16, layer *[256][256]uint8, previousTiles *[32][32]uint64) {
 	lowTileData := lcd.lowTileDataSelect()
+	cgb := lcd.memory.CGB()
 	tileY := lcdY / 8
 	for tileX := 0; tileX < 32; tileX++ {
 		var tileNumber uint16
 		tileAddr := 32*uint16(tileY) + uint16(tileX)
-		tileByte := lcd.readVideoRAM(offsetAddr + tileAddr)
+		tileByte := lcd.readVideoRAM(0, offsetAddr+tileAddr)
 		if !lowTileData {
 			tileNumber = uint16(256 + int(int8(tileByte)))
 		} else {
 			tileNumber = uint16(tileByte)
 		}
-		tile, cacheHit := lcd.readTile(tileNumber)
-		if !cacheHit || tileNumber != previousTiles[tileY][tileX] {
+		// The CGB keeps the attributes of each tile in the same place in bank 1
+		var attributes uint8
+		if cgb {
+			attributes = lcd.readVideoRAM(1, offsetAddr+tileAddr)
+		}
+		// Only redraw the tile when it or its attributes have changed since it was last drawn here
+		bank := attributes >> 3 & 1
+		tile := lcd.readTile(bank, tileNumber)
+		key := uint64(tileNumber) | uint64(attributes)<<16 | uint64(lcd.tileVersions[bank][tileNumber])<<32
+		if key != previousTiles[tileY][tileX] {
 			lcdX := uint8(tileX * 8)
+			layerY := tileY * 8
+			extra := attributes&0x07<<2 | attributes&0x80
 			for y := uint8(0); y < 8; y++ {
+				tileRow := y
+				if attributes&0x40 != 0 {
+					tileRow = 7 - y
+				}
 				for x := uint8(0); x < 8; x++ {
-					layer[lcdY+y][lcdX+x] = tile[y][x]
+					tileColumn := x
+					if attributes&0x20 != 0 {
+						tileColumn = 7 - x
+					}
+					layer[layerY+y][lcdX+x] = tile[tileRow][tileColumn] | extra
 				}
 			}
 		}
-		previousTiles[tileY][tileX] = tileNumber
+		previousTiles[tileY][tileX] = key
 	}
 }
 
 func (lcd *LCD) updateBG(lcdY, scy uint8) {
-	if !lcd.bgDisplayEnable() {
+	// The CGB always displays the background and uses LCDC bit 0 for priority instead
+	if !lcd.bgDisplayEnable() && !lcd.memory.CGB() {
 		return
 	}
 	var offsetAddr uint16
@@ -258,8 +382,22 @@ func (lcd *LCD) updateBG(lcdY, scy uint8) {
 	lcd.updateTiles(lcdY+scy, offsetAddr, &lcd.bg, &lcd.previousBg)
 }
 
+// windowOnLine returns true if the window is shown on a line. The original Game Boy blanks the window
+// along with the background when LCDC bit 0 is clear, while the CGB uses that bit for priority instead.
+func (lcd *LCD) windowOnLine(lcdY uint8) bool {
+	m := lcd.memory
+	if !lcd.windowDisplayEnable() || !lcd.bgDisplayEnable() && !m.CGB() {
+		return false
+	}
+	return lcdY < 144 && lcdY >= m.WY && m.WX < 167
+}
+
+// updateWindow draws the next line of the window if it is shown on a line. The window keeps its own
+// line counter, which only advances on lines where it is shown, so that a window hidden for some
+// lines carries on from where it stopped rather than skipping lines.
 func (lcd *LCD) updateWindow(lcdY uint8) {
-	if !lcd.windowDisplayEnable() {
+	lcd.windowShown = lcd.windowOnLine(lcdY)
+	if !lcd.windowShown {
 		return
 	}
 	var offsetAddr uint16
@@ -268,73 +406,110 @@ func (lcd *LCD) updateWindow(lcdY uint8) {
 	} else {
 		offsetAddr = 0x9800
 	}
-	lcd.updateTiles(lcdY, offsetAddr, &lcd.window, &lcd.previousWindow)
+	lcd.updateTiles(lcd.windowLine, offsetAddr, &lcd.window, &lcd.previousWindow)
 }
 
 func (lcd *LCD) readSpriteTile(tileNumber uint16, att uint8) *[8][8]uint8 {
-	tile, _ := lcd.readTile(uint16(tileNumber))
-	if spriteXFlip(att) {
-		new := [8][8]uint8{}
-		for y := 0; y < 8; y++ {
-			for x := 0; x < 8; x++ {
-				new[y][x] = tile[y][7-x]
-			}
-		}
-		tile = &new
-	}
-	if spriteYFlip(att) {
-		new := [8][8]uint8{}
-		for y := 0; y < 8; y++ {
-			new[y] = tile[7-y]
-		}
-		tile = &new
+	var bank uint8
+	if lcd.memory.CGB() && spritePalatteSet(att) {
+		bank = 1
 	}
-	return tile
+	return lcd.readTile(bank, tileNumber)
 }
 
-func (lcd *LCD) updateSprites(lcdY uint8) {
+// spritesPerLine is the number of sprites that can be drawn on each line
+const spritesPerLine = 10
+
+// updateSprites draws the sprites on a line into the sprite layer. Only the first ten sprites in OAM
+// that cover the line are drawn, even when some of them are off the sides of the screen. Where they
+// overlap the CGB draws the sprite earliest in OAM on top, while the original Game Boy draws the one
+// furthest left on top and only falls back to OAM order for sprites at the same X position.
+func (lcd *LCD) updateSprites(lcdY uint8, large bool) {
 	if lcdY >= 144 {
 		return
 	}
-	if lcd.largeSprites() {
-		panic(fmt.Sprintf("Large sprites are not supported"))
+	height := 8
+	if large {
+		height = 16
+	}
+	selected, count := lcd.selectSprites(lcdY, height)
+	cgb := lcd.memory.CGB()
+	if !cgb {
+		lcd.sortSprites(&selected, count)
 	}
 	lcd.sprites[lcdY] = [160]uint8{}
-	for sprite := 0; sprite < 40; sprite++ {
+	for _, sprite := range selected[:count] {
 		spriteAddr := sprite * 4
-		startY := lcd.oam[spriteAddr]
-		if startY == 0 || lcdY < startY-16 || lcdY >= startY-8 {
-			continue
-		}
 		startX := lcd.oam[spriteAddr+1]
 		if startX == 0 || startX >= 168 {
 			continue
 		}
-		tileNumber := lcd.oam[spriteAddr+2]
 		attributes := lcd.oam[spriteAddr+3]
-		tile := lcd.readSpriteTile(uint16(tileNumber), attributes)
-		spriteX := startX - 8
-		for tileX := uint8(0); tileX < 8; tileX++ {
-			lcdX := spriteX + tileX
-			if lcdX < 160 {
-				lcd.sprites[lcdY][lcdX] = tile[lcdY-startY+16][tileX]
+		tileRow := int(lcdY) + 16 - int(lcd.oam[spriteAddr])
+		if spriteYFlip(attributes) {
+			tileRow = height - 1 - tileRow
+		}
+		tileNumber := uint16(lcd.oam[spriteAddr+2])
+		if large {
+			// 8x16 sprites ignore bit 0 of the tile number and take their bottom half from the next tile
+			tileNumber = tileNumber&0xfe + uint16(tileRow/8)
+		}
+		tile := lcd.readSpriteTile(tileNumber, attributes)
+		for tileX := 0; tileX < 8; tileX++ {
+			lcdX := int(startX) - 8 + tileX
+			// Sprites are drawn in priority order so only fill pixels left transparent so far
+			if lcdX < 0 || lcdX >= 160 || lcd.sprites[lcdY][lcdX] != 0 {
+				continue
+			}
+			tileColumn := tileX
+			if spriteXFlip(attributes) {
+				tileColumn = 7 - tileColumn
+			}
+			pixel := tile[tileRow%8][tileColumn]
+			if pixel == 0 {
+				continue
+			}
+			// Remember the OBJ-to-BG priority and which palette applies alongside the colour index,
+			// which is one of eight CGB palettes or one of OBP0 and OBP1
+			if cgb {
+				lcd.sprites[lcdY][lcdX] = pixel | attributes&0x07<<2 | attributes&0x80
+			} else {
+				lcd.sprites[lcdY][lcdX] = pixel | attributes&0x10>>2 | attributes&0x80
 			}
 		}
 	}
 }
 
-func (lcd *LCD) renderPixel(x, y, scx, scy, wx, wy uint8, debug bool) color.RGBA {
-	if lcd.spriteDisplayEnable() {
-		if x < 160 && y < 144 {
-			pixel := lcd.sprites[y][x]
-			if pixel > 0 {
-				if debug {
-					return blue[pixel]
-				}
-				return gray[pixel]
-			}
+// selectSprites returns the first ten sprites in OAM that cover a line, in OAM order
+func (lcd *LCD) selectSprites(lcdY uint8, height int) ([spritesPerLine]int, int) {
+	var selected [spritesPerLine]int
+	count := 0
+	for sprite := 0; sprite < 40 && count < spritesPerLine; sprite++ {
+		if row := int(lcdY) + 16 - int(lcd.oam[sprite*4]); row >= 0 && row < height {
+			selected[count] = sprite
+			count++
 		}
 	}
+	return selected, count
+}
+
+// sortSprites orders selected sprites by X position. Insertion sort keeps OAM order for sprites at
+// the same X position.
+func (lcd *LCD) sortSprites(selected *[spritesPerLine]int, count int) {
+	for i := 1; i < count; i++ {
+		for j := i; j > 0 && lcd.oam[selected[j]*4+1] < lcd.oam[selected[j-1]*4+1]; j-- {
+			selected[j], selected[j-1] = selected[j-1], selected[j]
+		}
+	}
+}
+
+// pixelShade returns the shade of a pixel, from the palette registers, along with the colours used
+// to display it and the colours used when debugging
+func (lcd *LCD) pixelShade(x, y, scx, scy uint8, window *[256]uint8, windowX int) (uint8, []color.RGBA, []color.RGBA) {
+	var sprite uint8
+	if lcd.spriteDisplayEnable() && x < 160 && y < 144 {
+		sprite = lcd.sprites[y][x]
+	}
 
 	// Make tiles visible
 	// if (x+scx)%8 == 0 && (y+scy)%2 == 0 ||
@@ -342,50 +517,129 @@ func (lcd *LCD) renderPixel(x, y, scx, scy, wx, wy uint8, debug bool) color.RGBA
 	// 	return color.RGBA{0xff, 0, 0, 0xff}
 	// }
 
-	if lcd.windowDisplayEnable() {
-		// Use WX/WY to shift the visible pixels
-		if x >= wx && y >= wy {
-			pixel := lcd.window[y-wy][x-wx]
-			if debug {
-				return green[pixel]
-			}
-			return gray[pixel]
+	var bgPixel uint8
+	bgShown := false
+	debugColours := lcd.colours
+	if window != nil && int(x) >= windowX {
+		bgPixel, bgShown, debugColours = window[int(x)-windowX], true, green
+	} else if lcd.bgDisplayEnable() {
+		// Use SCX/SCY to shift the visible pixels
+		bgPixel, bgShown = lcd.bg[y+scy][x+scx], true
+		if x >= 160 || y >= 144 {
+			debugColours = red
 		}
 	}
-	if lcd.bgDisplayEnable() {
-		// Use SCX/SCY to shift the visible pixels
-		pixel := lcd.bg[y+scy][x+scx]
-		if debug && (x >= 160 || y >= 144) {
-			return red[pixel]
+	// Sprites behind the background are only drawn over its colour 0
+	if sprite != 0 && (!spriteBehindBg(sprite) || bgPixel == 0) {
+		palette := lcd.memory.OBP0
+		if sprite&4 != 0 {
+			palette = lcd.memory.OBP1
 		}
+		return shade(palette, sprite&3), lcd.objColours[sprite>>2&1], blue
+	}
+	if bgShown {
+		return shade(lcd.memory.BGP, bgPixel), lcd.colours, debugColours
+	}
+	return 0, lcd.colours, lcd.colours
+}
 
-		return gray[pixel]
+func (lcd *LCD) renderPixel(x, y, scx, scy uint8, window *[256]uint8, windowX int, debug bool) color.RGBA {
+	pixel, colours, debugColours := lcd.pixelShade(x, y, scx, scy, window, windowX)
+	if debug {
+		return debugColours[pixel]
 	}
-	return gray[0]
+	return colours[pixel]
 }
 
+// renderCGBPixel picks the colour of a pixel from the CGB palettes, giving the background and window
+// priority over sprites when the tile or sprite attributes ask for it and LCDC bit 0 is set
+func (lcd *LCD) renderCGBPixel(x, y, scx, scy uint8, window *[256]uint8, windowX int) color.RGBA {
+	var bgPixel uint8
+	if window != nil && int(x) >= windowX {
+		bgPixel = window[int(x)-windowX]
+	} else {
+		bgPixel = lcd.bg[y+scy][x+scx]
+	}
+	if lcd.spriteDisplayEnable() && x < 160 && y < 144 {
+		sprite := lcd.sprites[y][x]
+		bgPriority := lcd.bgDisplayEnable() && bgPixel&3 != 0 && (bgPixel&0x80 != 0 || sprite&0x80 != 0)
+		if sprite&3 != 0 && !bgPriority {
+			return lcd.cgbColour(&lcd.memory.OBJPaletteRAM, sprite>>2&7, sprite&3)
+		}
+	}
+	return lcd.cgbColour(&lcd.memory.BGPaletteRAM, bgPixel>>2&7, bgPixel&3)
+}
+
+// cgbColour reads a little-endian RGB555 colour from CGB palette RAM
+func (lcd *LCD) cgbColour(paletteRAM *[0x40]byte, palette, index uint8) color.RGBA {
+	offset := palette*8 + index*2
+	rgb := uint16(paletteRAM[offset]) | uint16(paletteRAM[offset+1])<<8
+	if lcd.cgbColours != nil {
+		return lcd.cgbColours[rgb&0x7fff]
+	}
+	return color.RGBA{scale5(rgb), scale5(rgb >> 5), scale5(rgb >> 10), 0xff}
+}
+
+// scale5 expands the 5-bit colour component in the low bits of a value to 8 bits
+func scale5(value uint16) uint8 {
+	c := uint8(value & 0x1f)
+	return c<<3 | c>>2
+}
+
+// shade maps a colour index to a shade using a palette register
+func shade(palette, index uint8) uint8 {
+	return palette >> (index * 2) & 3
+}
+
+// renderLine writes a line of pixels straight into the frame buffer, reading the layers that were
+// decoded from VRAM and OAM rather than going through memory reads
 func (lcd *LCD) renderLine(y, scy uint8) {
 	scx := lcd.memory.SCX
-	wx := lcd.memory.WX
-	wy := lcd.memory.WY
+	// WX holds the position of the window plus 7
+	windowX := int(lcd.memory.WX) - 7
+	var window *[256]uint8
+	if lcd.windowShown {
+		window = &lcd.window[lcd.windowLine]
+		lcd.windowLine++
+	}
+	row := lcd.frame.Pix[int(y)*lcd.frame.Stride:]
+	if lcd.memory.CGB() {
+		for x := 0; x < 160; x++ {
+			setPixel(row, x, lcd.renderCGBPixel(uint8(x), y, scx, scy, window, windowX))
+		}
+		return
+	}
 	if lcd.debug {
+		// The debug display has no window line counter and shows the window on every line below WY
+		window = nil
+		if wy := lcd.memory.WY; lcd.windowDisplayEnable() && y-scy >= wy && y-scy < 144 {
+			window = &lcd.window[y-scy-wy]
+		}
 		for x := 0; x < 256; x++ {
-			pixel := lcd.renderPixel(uint8(x)-scx, y-scy, scx, scy, wx, wy, true)
-			lcd.frame.SetRGBA(x, int(y), pixel)
+			setPixel(row, x, lcd.renderPixel(uint8(x)-scx, y-scy, scx, scy, window, windowX, true))
 		}
-	} else {
+	} else if y < 144 {
+		shades := &lcd.shades[y]
 		for x := 0; x < 160; x++ {
-			pixel := lcd.renderPixel(uint8(x), y, scx, scy, wx, wy, false)
-			lcd.frame.SetRGBA(x, int(y), pixel)
+			pixel, colours, _ := lcd.pixelShade(uint8(x), y, scx, scy, window, windowX)
+			// Remember the shade for a Super Game Boy to colour
+			shades[x] = pixel
+			setPixel(row, x, colours[pixel])
 		}
 	}
 }
 
+// setPixel writes a colour into a row of the frame buffer without the bounds checks of SetRGBA
+func setPixel(row []uint8, x int, c color.RGBA) {
+	p := row[x*4 : x*4+4 : x*4+4]
+	p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+}
+
 func (lcd *LCD) updateLcdLine(y uint8) {
 	scy := lcd.memory.SCY
 	lcd.updateBG(y, scy)
 	lcd.updateWindow(y)
-	lcd.updateSprites(y)
+	lcd.updateSprites(y, lcd.largeSprites())
 	lcd.renderLine(y, scy)
 }
 
@@ -396,25 +650,99 @@ func (lcd *LCD) FrameEnd() {
 			lcd.updateLcdLine(y)
 		}
 	}
+	if lcd.parallel() {
+		lcd.drawRecordedLines()
+	}
+	if lcd.compositor != nil {
+		lcd.output, lcd.outputBounds = lcd.compositor.Compose(&lcd.shades)
+	}
+	if lcd.changes != nil {
+		lcd.changes.update(lcd.Visible())
+	}
+	frame := lcd.Frame()
+	for _, hook := range lcd.frameHooks {
+		hook(frame)
+	}
 	if lcd.display != nil {
-		lcd.display.DisplayFrame(lcd.frame)
+		lcd.display.DisplayFrame(lcd.drawOverlay(frame))
+	}
+	if lcd.overlay != nil {
+		lcd.overlay.Next()
 	}
 }
 
-// Screenshot writes a screenshot to file
-func (lcd *LCD) Screenshot(filename string) {
-	f, err := os.Create(filename)
-	if err != nil {
-		fmt.Println(err)
+// ShowFrame passes the frame to the display again without running the frame hooks, such as once the
+// LCD has been returned to an earlier frame
+func (lcd *LCD) ShowFrame() {
+	if lcd.compositor != nil {
+		lcd.output, lcd.outputBounds = lcd.compositor.Compose(&lcd.shades)
 	}
-	defer f.Close()
-	err = png.Encode(f, lcd.frame)
-	if err != nil {
-		fmt.Println(err)
+	if lcd.display != nil {
+		lcd.display.DisplayFrame(lcd.drawOverlay(lcd.Frame()))
+	}
+	if lcd.overlay != nil {
+		lcd.overlay.Next()
+	}
+}
+
+// drawOverlay returns the frame with the overlay drawn over a copy of it, so that the frame buffer
+// itself, which screenshots and recordings use, shows the game alone
+func (lcd *LCD) drawOverlay(frame *image.RGBA) *image.RGBA {
+	if lcd.overlay == nil || lcd.overlay.Empty() {
+		return frame
+	}
+	if lcd.overlaid == nil || lcd.overlaid.Rect != frame.Rect {
+		lcd.overlaid = image.NewRGBA(frame.Rect)
+	}
+	draw.Draw(lcd.overlaid, frame.Rect, frame, frame.Rect.Min, draw.Src)
+	lcd.overlay.Draw(lcd.overlaid)
+	return lcd.overlaid
+}
+
+// AddFrameHook registers a function that is called with each completed frame before it is displayed.
+// The frame buffer is reused for every frame so hooks must copy it to keep it.
+func (lcd *LCD) AddFrameHook(hook func(*image.RGBA)) {
+	lcd.frameHooks = append(lcd.frameHooks, hook)
+}
+
+// AddScanlineHook registers a function that is called as each visible line enters H-Blank
+func (lcd *LCD) AddScanlineHook(hook func(uint8)) {
+	lcd.scanlineHooks = append(lcd.scanlineHooks, hook)
+}
+
+// AddVBlankHook registers a function that is called as the LCD enters V-Blank
+func (lcd *LCD) AddVBlankHook(hook func()) {
+	lcd.vblankHooks = append(lcd.vblankHooks, hook)
+}
+
+// Enabled returns true if the LCD is switched on
+func (lcd *LCD) Enabled() bool {
+	return lcd.lcdDisplayEnable()
+}
+
+// Visible returns the part of the frame buffer that is shown by the display without copying it. Only
+// the debug display shows the whole of the 256x256 background.
+func (lcd *LCD) Visible() *image.RGBA {
+	switch {
+	case lcd.output != nil:
+		return lcd.output.SubImage(lcd.outputBounds).(*image.RGBA)
+	case !lcd.debug:
+		return lcd.frame.SubImage(image.Rect(0, 0, 160, 144)).(*image.RGBA)
+	}
+	return lcd.frame
+}
+
+// Frame returns the frame buffer that the LCD renders into, or the latest frame from the compositor.
+// The same buffer is returned every frame and is overwritten as the next frame is rendered.
+func (lcd *LCD) Frame() *image.RGBA {
+	if lcd.output != nil {
+		return lcd.output
 	}
+	return lcd.frame
 }
 
-// RegisterDisplay associates real-world display output with the LCD subsystem
+// RegisterDisplay associates real-world display output with the LCD subsystem, or removes it when
+// display is nil
 func (lcd *LCD) RegisterDisplay(display Display) {
 	lcd.display = display
 }
@@ -0,0 +1,439 @@
+package lcd
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+)
+
+func newLCD(t *testing.T) (*LCD, *mem.Memory) {
+	memory, err := mem.NewMemory(make([]byte, 0x8000), nil, timer.NewTimer(), audio.NewAudio())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewLCD(memory, false), memory
+}
+
+func TestTileCache(t *testing.T) {
+	lcd, memory := newLCD(t)
+
+	// The top row of tile 1 is colours 0 to 3 on the left and 3 to 0 on the right
+	memory.Write(0x8010, 0x5a)
+	memory.Write(0x8011, 0x3c)
+	tile := lcd.readTile(0, 1)
+	if row := tile[0]; row != [8]uint8{0, 1, 2, 3, 3, 2, 1, 0} {
+		t.Errorf("unexpected top row %v", row)
+	}
+	version := lcd.tileVersions[0][1]
+
+	// Writing the same bytes or another tile's bytes keeps the decoded tile
+	memory.Write(0x8010, 0x5a)
+	memory.Write(0x8020, 0xff)
+	lcd.readTile(0, 1)
+	if lcd.tileVersions[0][1] != version {
+		t.Error("expected the tile not to be decoded again")
+	}
+
+	memory.Write(0x801f, 0xff)
+	tile = lcd.readTile(0, 1)
+	if lcd.tileVersions[0][1] == version {
+		t.Error("expected the tile to be decoded again after its bytes changed")
+	}
+	if row := tile[7]; row != [8]uint8{2, 2, 2, 2, 2, 2, 2, 2} {
+		t.Errorf("unexpected bottom row %v", row)
+	}
+}
+
+func TestWindowLineCounter(t *testing.T) {
+	lcd, memory := newLCD(t)
+	// Tile 1 is colour 3 except for its third row which is colour 1
+	for row := uint16(0); row < 8; row++ {
+		memory.Write(0x8010+row*2, 0xff)
+		if row != 2 {
+			memory.Write(0x8011+row*2, 0xff)
+		}
+	}
+	// The window map at 9C00 is filled with tile 1 and the background shows tile 0 in colour 0
+	for i := uint16(0); i < 0x400; i++ {
+		memory.Write(0x9c00+i, 0x01)
+	}
+	memory.LCDC = 0xf1
+	memory.BGP = 0xe4
+	memory.WX = 15
+	memory.WY = 0
+
+	lcd.updateLcdLine(0)
+	lcd.updateLcdLine(1)
+	if shade := lcd.shades[0][7]; shade != 0 {
+		t.Errorf("expected the background left of WX-7 but got shade %d", shade)
+	}
+	if shade := lcd.shades[1][8]; shade != 3 {
+		t.Errorf("expected the window from WX-7 but got shade %d", shade)
+	}
+
+	// Hiding the window for two lines pauses its line counter
+	memory.LCDC &^= 0x20
+	lcd.updateLcdLine(2)
+	lcd.updateLcdLine(3)
+	if shade := lcd.shades[2][8]; shade != 0 {
+		t.Errorf("expected the hidden window to show the background but got shade %d", shade)
+	}
+	memory.LCDC |= 0x20
+	lcd.updateLcdLine(4)
+	if shade := lcd.shades[4][8]; shade != 1 {
+		t.Errorf("expected the third line of the window but got shade %d", shade)
+	}
+
+	// Clearing LCDC bit 0 blanks the window along with the background and pauses its line counter
+	memory.LCDC &^= 0x01
+	lcd.updateLcdLine(5)
+	if shade := lcd.shades[5][8]; shade != 0 {
+		t.Errorf("expected the window to be blank but got shade %d", shade)
+	}
+	memory.LCDC |= 0x01
+	lcd.updateLcdLine(6)
+	if shade := lcd.shades[6][8]; shade != 3 {
+		t.Errorf("expected the fourth line of the window but got shade %d", shade)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	lcd, memory := newLCD(t)
+	// Tile 1 is colour 3 and tile 2 is colour 1
+	for row := uint16(0); row < 8; row++ {
+		memory.Write(0x8010+row*2, 0xff)
+		memory.Write(0x8011+row*2, 0xff)
+		memory.Write(0x8020+row*2, 0xff)
+	}
+	// The background has a column of tile 1 at x=24 and a row of tile 2 at y=80
+	for i := uint16(0); i < 32; i++ {
+		memory.Write(0x9800+i*32+3, 0x01)
+		if i != 3 {
+			memory.Write(0x9800+10*32+i, 0x02)
+		}
+	}
+	memory.LCDC = 0x91
+	memory.BGP = 0xe4
+
+	// A status bar keeps the top 16 lines still while the rest of the screen scrolls, with the
+	// registers changed in H-Blank as an LYC interrupt would
+	lcd.AddScanlineHook(func(ly uint8) {
+		if ly == 15 {
+			memory.SCX = 20
+			memory.SCY = 40
+		}
+	})
+	for i := 0; i < 154*114; i++ {
+		lcd.EndMachineCycle()
+	}
+	if shade := lcd.shades[15][24]; shade != 3 {
+		t.Errorf("expected the status bar to be unscrolled but got shade %d", shade)
+	}
+	if left, right := lcd.shades[16][4], lcd.shades[16][24]; left != 3 || right != 0 {
+		t.Errorf("expected the column 20 pixels left of where it was but got shades %d and %d", left, right)
+	}
+	if above, below := lcd.shades[40][50], lcd.shades[80][50]; above != 1 || below != 0 {
+		t.Errorf("expected the row 40 lines higher than where it was but got shades %d and %d", above, below)
+	}
+
+	// With SCY part way into a tile, tile 3 at the top left of the background shows its bottom row,
+	// the only one of colour 3, 3 lines higher
+	lcd, memory = newLCD(t)
+	memory.Write(0x803e, 0xff)
+	memory.Write(0x803f, 0xff)
+	memory.Write(0x9800, 0x03)
+	memory.LCDC = 0x91
+	memory.BGP = 0xe4
+	memory.SCY = 3
+	for y := uint8(0); y < 8; y++ {
+		lcd.updateLcdLine(y)
+	}
+	for y := 0; y < 8; y++ {
+		expected := uint8(0)
+		if y == 4 {
+			expected = 3
+		}
+		if shade := lcd.shades[y][0]; shade != expected {
+			t.Errorf("expected shade %d on line %d with SCY 3 but got %d", expected, y, shade)
+		}
+	}
+}
+
+func TestSprites(t *testing.T) {
+	lcd, memory := newLCD(t)
+	// Tiles 2, 3 and 4 are filled with colours 1, 2 and 3
+	for row := uint16(0); row < 8; row++ {
+		memory.Write(0x8020+row*2, 0xff)
+		memory.Write(0x8031+row*2, 0xff)
+		memory.Write(0x8040+row*2, 0xff)
+		memory.Write(0x8041+row*2, 0xff)
+	}
+	// The background is colour 0 apart from tile 2 at the start of line 100
+	memory.Write(0x9800+12*32, 0x02)
+	memory.LCDC = 0x93
+	memory.BGP = 0xe4
+	memory.OBP0 = 0xe4
+	sprite := func(n int, y, x, tile, attributes uint8) {
+		copy(memory.OAM[n*4:], []byte{y, x, tile, attributes})
+	}
+
+	// The sprite further left is on top even though it comes later in OAM
+	sprite(0, 16, 20, 2, 0x00)
+	sprite(1, 16, 16, 3, 0x00)
+	lcd.updateLcdLine(0)
+	if shade := lcd.shades[0][12]; shade != 2 {
+		t.Errorf("expected the sprite further left on top but got shade %d", shade)
+	}
+
+	// Only ten sprites are drawn on a line
+	for i := 0; i < 11; i++ {
+		sprite(2+i, 24, uint8(48+8*i), 4, 0x00)
+	}
+	lcd.updateLcdLine(8)
+	if shade := lcd.shades[8][112]; shade != 3 {
+		t.Errorf("expected the tenth sprite but got shade %d", shade)
+	}
+	if shade := lcd.shades[8][120]; shade != 0 {
+		t.Errorf("expected no eleventh sprite but got shade %d", shade)
+	}
+
+	// A flipped 8x16 sprite shows the tile after the one in OAM at the top
+	memory.LCDC |= 0x04
+	sprite(13, 56, 150, 3, 0x40)
+	lcd.updateLcdLine(40)
+	lcd.updateLcdLine(55)
+	if top, bottom := lcd.shades[40][142], lcd.shades[55][142]; top != 2 || bottom != 1 {
+		t.Errorf("expected shades 2 and 1 at the top and bottom of the sprite but got %d and %d", top, bottom)
+	}
+
+	// A sprite behind the background is only drawn over its colour 0
+	sprite(14, 116, 12, 4, 0x80)
+	lcd.updateLcdLine(100)
+	if behind, over := lcd.shades[100][4], lcd.shades[100][8]; behind != 1 || over != 3 {
+		t.Errorf("expected shades 1 and 3 for the sprite behind the background but got %d and %d", behind, over)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	sequential, sequentialMemory := newLCD(t)
+	parallel, parallelMemory := newLCD(t)
+	parallel.SetParallel(3)
+	for _, memory := range []*mem.Memory{sequentialMemory, parallelMemory} {
+		// Tiles 1 to 3 are stripes of each colour and tile 4 is a gradient
+		for row := uint16(0); row < 8; row++ {
+			memory.Write(0x8010+row*2, 0xaa)
+			memory.Write(0x8021+row*2, 0x55)
+			memory.Write(0x8030+row*2, 0xff)
+			memory.Write(0x8031+row*2, 0xff)
+			memory.Write(0x8040+row*2, uint8(row*37))
+			memory.Write(0x8041+row*2, uint8(row*91))
+		}
+		for i := uint16(0); i < 0x400; i++ {
+			memory.Write(0x9800+i, uint8(i%5))
+			memory.Write(0x9c00+i, uint8(4-i%5))
+		}
+		// A couple of sprites, one flipped and using OBP1
+		copy(memory.OAM[:], []byte{40, 20, 4, 0x00, 60, 90, 4, 0x70})
+		memory.LCDC = 0xf3
+		memory.BGP = 0xe4
+		memory.OBP0 = 0xd2
+		memory.OBP1 = 0x1b
+		memory.WX = 87
+		memory.WY = 50
+	}
+
+	for y := uint8(0); y < 144; y++ {
+		for _, memory := range []*mem.Memory{sequentialMemory, parallelMemory} {
+			// Scroll and hide the window part way down the screen
+			memory.SCX = y / 3
+			memory.SCY = y / 5
+			if y == 100 {
+				memory.LCDC &^= 0x20
+			}
+			if y == 110 {
+				memory.LCDC |= 0x20
+			}
+		}
+		sequential.updateLcdLine(y)
+		parallel.recordLine(y)
+	}
+	parallel.drawRecordedLines()
+
+	if sequential.shades != parallel.shades {
+		t.Error("expected the same shades from both renderers")
+	}
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			if s, p := sequential.frame.RGBAAt(x, y), parallel.frame.RGBAAt(x, y); s != p {
+				t.Fatalf("expected %v at %d,%d but got %v", s, x, y, p)
+			}
+		}
+	}
+}
+
+func TestParallelCGBPalettes(t *testing.T) {
+	sequential, sequentialMemory := newLCD(t)
+	parallel, parallelMemory := newLCD(t)
+	parallel.SetParallel(3)
+	for _, memory := range []*mem.Memory{sequentialMemory, parallelMemory} {
+		memory.EnableCGB()
+		memory.LCDC = 0x91
+	}
+
+	// Colour 0 of the first background palette changes on every line, as games with more colours
+	// than fit in the palettes do
+	for y := uint8(0); y < 144; y++ {
+		for _, memory := range []*mem.Memory{sequentialMemory, parallelMemory} {
+			memory.BGPaletteRAM[0] = y
+			memory.BGPaletteRAM[1] = y / 8
+		}
+		sequential.updateLcdLine(y)
+		parallel.recordLine(y)
+	}
+	parallel.drawRecordedLines()
+
+	for y := 0; y < 144; y++ {
+		if s, p := sequential.frame.RGBAAt(0, y), parallel.frame.RGBAAt(0, y); s != p {
+			t.Fatalf("expected %v on line %d but got %v", s, y, p)
+		}
+	}
+}
+
+func TestAccuratePPU(t *testing.T) {
+	sequential, sequentialMemory := newLCD(t)
+	accurate, accurateMemory := newLCD(t)
+	accurate.SetAccurate(true)
+	for _, memory := range []*mem.Memory{sequentialMemory, accurateMemory} {
+		// Tiles 1 to 3 are stripes of each colour and tile 4 is a gradient
+		for row := uint16(0); row < 8; row++ {
+			memory.Write(0x8010+row*2, 0xaa)
+			memory.Write(0x8021+row*2, 0x55)
+			memory.Write(0x8030+row*2, 0xff)
+			memory.Write(0x8031+row*2, 0xff)
+			memory.Write(0x8040+row*2, uint8(row*37))
+			memory.Write(0x8041+row*2, uint8(row*91))
+		}
+		for i := uint16(0); i < 0x400; i++ {
+			memory.Write(0x9800+i, uint8(i%5))
+			memory.Write(0x9c00+i, uint8(4-i%5))
+		}
+		// A couple of sprites, one flipped and using OBP1
+		copy(memory.OAM[:], []byte{40, 20, 4, 0x00, 60, 90, 4, 0x70})
+		memory.LCDC = 0xf3
+		memory.BGP = 0xe4
+		memory.OBP0 = 0xd2
+		memory.OBP1 = 0x1b
+		memory.WX = 87
+		memory.WY = 50
+	}
+
+	// Draw a frame a machine cycle at a time, counting the machine cycles of mode 3 on each line
+	var mode3 [144]int
+	for y := uint8(0); y < 144; y++ {
+		for _, memory := range []*mem.Memory{sequentialMemory, accurateMemory} {
+			memory.SCX = y / 3
+			memory.SCY = y / 5
+		}
+		sequential.updateLcdLine(y)
+		for i := 0; i < 114; i++ {
+			accurate.EndMachineCycle()
+			if accurateMemory.STAT&0x03 == 0x03 {
+				mode3[y]++
+			}
+		}
+	}
+
+	if sequential.shades != accurate.shades {
+		t.Error("expected the same shades from both renderers")
+	}
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			if s, a := sequential.frame.RGBAAt(x, y), accurate.frame.RGBAAt(x, y); s != a {
+				t.Fatalf("expected %v at %d,%d but got %v", s, x, y, a)
+			}
+		}
+	}
+
+	// Mode 3 takes longer when pixels are dropped for SCX and when sprites are fetched
+	if plain, scrolled := mode3[0], mode3[3]; scrolled <= plain {
+		t.Errorf("expected mode 3 to take longer with SCX 1 but got %d and %d machine cycles", plain, scrolled)
+	}
+	if plain, sprite := mode3[0], mode3[24]; sprite <= plain {
+		t.Errorf("expected mode 3 to take longer with a sprite but got %d and %d machine cycles", plain, sprite)
+	}
+}
+
+func TestAccuratePPUState(t *testing.T) {
+	lcd, memory := newLCD(t)
+	lcd.SetAccurate(true)
+	// Tile 1 is a gradient across the background, with a sprite of it on line 24
+	for row := uint16(0); row < 8; row++ {
+		memory.Write(0x8010+row*2, 0x5a)
+		memory.Write(0x8011+row*2, 0x3c)
+	}
+	for i := uint16(0); i < 0x400; i++ {
+		memory.Write(0x9800+i, 0x01)
+	}
+	copy(memory.OAM[:], []byte{40, 20, 1, 0x20})
+	memory.LCDC = 0x93
+	memory.BGP = 0xe4
+	memory.OBP0 = 0x1b
+	memory.SCX = 3
+
+	// Take a snapshot part way through mode 3 of line 24 and draw the rest of the frame
+	for i := 0; i < 24*114+40; i++ {
+		lcd.EndMachineCycle()
+	}
+	if memory.STAT&0x03 != 0x03 {
+		t.Fatalf("expected mode 3 but got mode %d", memory.STAT&0x03)
+	}
+	state := lcd.Snapshot()
+	for i := 0; i < 120*114; i++ {
+		lcd.EndMachineCycle()
+	}
+	expected := lcd.shades
+
+	// Drawing the rest of the frame again from the snapshot carries on from the same pixel
+	lcd.Restore(state)
+	for i := 0; i < 120*114; i++ {
+		lcd.EndMachineCycle()
+	}
+	if lcd.shades != expected {
+		t.Error("expected the same shades after restoring a snapshot taken in mode 3")
+	}
+}
+
+func TestChangeTracker(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 256, 256)).SubImage(image.Rect(48, 40, 208, 184)).(*image.RGBA)
+	c := &changeTracker{}
+	c.update(frame)
+	if len(c.changes) != 1 || c.changes[0] != image.Rect(0, 0, 160, 144) {
+		t.Errorf("expected the first frame to change everywhere but got %v", c.changes)
+	}
+	c.update(frame)
+	if len(c.changes) != 0 {
+		t.Errorf("expected no changes but got %v", c.changes)
+	}
+
+	// Two blocks side by side and the two below them make one rectangle, apart from a separate block
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	frame.SetRGBA(48+8, 40+0, red)
+	frame.SetRGBA(48+23, 40+0, red)
+	frame.SetRGBA(48+8, 40+15, red)
+	frame.SetRGBA(48+23, 40+15, red)
+	frame.SetRGBA(48+159, 40+143, red)
+	c.update(frame)
+	expected := []image.Rectangle{image.Rect(8, 0, 24, 16), image.Rect(152, 136, 160, 144)}
+	if !reflect.DeepEqual(c.changes, expected) {
+		t.Errorf("expected changes %v but got %v", expected, c.changes)
+	}
+	c.update(frame)
+	if len(c.changes) != 0 {
+		t.Errorf("expected no changes after the changed pixels were copied but got %v", c.changes)
+	}
+}
@@ -0,0 +1,168 @@
+package lcd
+
+import (
+	"sync"
+)
+
+// lineState holds the registers that affect how a line is drawn, recorded at its H-Blank so that the
+// line can be drawn later alongside the others
+type lineState struct {
+	recorded    bool
+	lcdc        uint8
+	scx         uint8
+	scy         uint8
+	wx          uint8
+	bgp         uint8
+	obp0        uint8
+	obp1        uint8
+	windowShown bool
+	windowLine  uint8
+	// bgPalettes and objPalettes hold the CGB palette RAM, which games can rewrite between lines
+	bgPalettes  [0x40]byte
+	objPalettes [0x40]byte
+}
+
+// SetParallel draws each frame at its end using a number of goroutines rather than drawing each line
+// at its H-Blank, when workers is more than 1. Each line is drawn with the registers it had at its
+// H-Blank, including the CGB palettes, but with the tiles and sprites in VRAM and OAM at the end of the
+// frame, so games that change them mid-frame are drawn less accurately. The debug display is always drawn line by line.
+func (lcd *LCD) SetParallel(workers int) {
+	lcd.workers = workers
+}
+
+func (lcd *LCD) parallel() bool {
+	return lcd.workers > 1 && !lcd.debug && !lcd.accurateFIFO
+}
+
+// recordLine remembers the registers for a line and advances the window line counter as if the line
+// had been drawn
+func (lcd *LCD) recordLine(y uint8) {
+	m := lcd.memory
+	windowShown := lcd.windowOnLine(y)
+	lcd.lines[y] = lineState{
+		recorded:    true,
+		lcdc:        m.LCDC,
+		scx:         m.SCX,
+		scy:         m.SCY,
+		wx:          m.WX,
+		bgp:         m.BGP,
+		obp0:        m.OBP0,
+		obp1:        m.OBP1,
+		windowShown: windowShown,
+		windowLine:  lcd.windowLine,
+	}
+	if m.CGB() {
+		lcd.lines[y].bgPalettes = m.BGPaletteRAM
+		lcd.lines[y].objPalettes = m.OBJPaletteRAM
+	}
+	if windowShown {
+		lcd.windowLine++
+	}
+}
+
+// drawRecordedLines draws every line recorded since the last frame, sharing them between the workers
+func (lcd *LCD) drawRecordedLines() {
+	// Decode every changed tile first so that the workers only read the tile cache
+	for bank := range lcd.staleTiles {
+		for tileNumber, stale := range lcd.staleTiles[bank] {
+			if stale {
+				lcd.readTile(uint8(bank), uint16(tileNumber))
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for worker := 0; worker < lcd.workers; worker++ {
+		wg.Add(1)
+		go func(first int) {
+			defer wg.Done()
+			for y := first; y < 144; y += lcd.workers {
+				if lcd.lines[y].recorded {
+					lcd.drawLine(uint8(y), &lcd.lines[y])
+					lcd.lines[y].recorded = false
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
+
+// drawLine draws a line using its recorded registers, reading the background and window straight
+// from their tile maps instead of the layers that are kept up to date line by line
+func (lcd *LCD) drawLine(y uint8, state *lineState) {
+	cgb := lcd.memory.CGB()
+	lcd.updateSprites(y, state.lcdc&0x04 != 0)
+	lowTileData := state.lcdc&0x10 != 0
+	bgMap := uint16(0x9800)
+	if state.lcdc&0x08 != 0 {
+		bgMap = 0x9c00
+	}
+	windowMap := uint16(0x9800)
+	if state.lcdc&0x40 != 0 {
+		windowMap = 0x9c00
+	}
+	windowX := int(state.wx) - 7
+	bgEnabled := state.lcdc&0x01 != 0
+	spritesEnabled := state.lcdc&0x02 != 0
+	row := lcd.frame.Pix[int(y)*lcd.frame.Stride:]
+	shades := &lcd.shades[y]
+	for x := 0; x < 160; x++ {
+		var bgPixel uint8
+		window := state.windowShown && x >= windowX
+		switch {
+		case window:
+			bgPixel = lcd.mapPixel(windowMap, uint8(x-windowX), state.windowLine, lowTileData, cgb)
+		case bgEnabled || cgb:
+			bgPixel = lcd.mapPixel(bgMap, uint8(x)+state.scx, y+state.scy, lowTileData, cgb)
+		}
+		sprite := lcd.sprites[y][x]
+		if cgb {
+			// The same priorities as renderCGBPixel
+			bgPriority := bgEnabled && bgPixel&3 != 0 && (bgPixel&0x80 != 0 || sprite&0x80 != 0)
+			if spritesEnabled && sprite&3 != 0 && !bgPriority {
+				setPixel(row, x, lcd.cgbColour(&state.objPalettes, sprite>>2&7, sprite&3))
+			} else {
+				setPixel(row, x, lcd.cgbColour(&state.bgPalettes, bgPixel>>2&7, bgPixel&3))
+			}
+			continue
+		}
+		// The same priorities as pixelShade
+		var pixel uint8
+		colours := lcd.colours
+		switch {
+		case spritesEnabled && sprite != 0 && (!spriteBehindBg(sprite) || bgPixel == 0):
+			palette := state.obp0
+			if sprite&4 != 0 {
+				palette = state.obp1
+			}
+			pixel = shade(palette, sprite&3)
+			colours = lcd.objColours[sprite>>2&1]
+		case window || bgEnabled:
+			pixel = shade(state.bgp, bgPixel)
+		}
+		shades[x] = pixel
+		setPixel(row, x, colours[pixel])
+	}
+}
+
+// mapPixel reads a pixel of the background or window from a tile map, with the CGB palette and
+// priority alongside the colour index like the layers drawn by updateTiles
+func (lcd *LCD) mapPixel(mapAddr uint16, x, y uint8, lowTileData, cgb bool) uint8 {
+	addr := mapAddr + uint16(y/8)*32 + uint16(x/8)
+	tileByte := lcd.readVideoRAM(0, addr)
+	tileNumber := uint16(tileByte)
+	if !lowTileData {
+		tileNumber = uint16(256 + int(int8(tileByte)))
+	}
+	var attributes uint8
+	if cgb {
+		attributes = lcd.readVideoRAM(1, addr)
+	}
+	tileRow, tileColumn := y%8, x%8
+	if attributes&0x40 != 0 {
+		tileRow = 7 - tileRow
+	}
+	if attributes&0x20 != 0 {
+		tileColumn = 7 - tileColumn
+	}
+	return lcd.tiles[attributes>>3&1][tileNumber][tileRow][tileColumn] | attributes&0x07<<2 | attributes&0x80
+}
@@ -0,0 +1,142 @@
+package lcd
+
+// State is a snapshot of the LCD, for save states. The tiles and layers decoded from VRAM aren't
+// included because they are decoded again from memory.
+type State struct {
+	Tick        int
+	WindowLine  uint8
+	WindowShown bool
+	// Lines holds the registers recorded for lines not yet drawn when drawing in parallel
+	Lines [144]LineState
+	// Pixels holds the 160x144 picture as RGBA and Shades the shade of each pixel, so that lines drawn
+	// earlier in the frame are still shown
+	Pixels []byte
+	Shades [144][160]uint8
+	// FIFO holds the fetcher and pixel FIFO part way through a line when drawing with SetAccurate
+	FIFO FIFOState
+}
+
+// FIFOState holds the fetcher and pixel FIFO
+type FIFOState struct {
+	Drawing                          bool
+	X, Discard                       int
+	Pixels                           [8]uint8
+	Queued, FetchState               int
+	FetchX                           uint8
+	Discarded, Window                bool
+	Stall                            int
+	SpriteX                          [spritesPerLine]uint8
+	Sprites, NextSprite, LeftPenalty int
+}
+
+// LineState holds the registers recorded for a line
+type LineState struct {
+	Recorded                            bool
+	LCDC, SCX, SCY, WX, BGP, OBP0, OBP1 uint8
+	WindowShown                         bool
+	WindowLine                          uint8
+	BGPalettes, OBJPalettes             [0x40]byte
+}
+
+// Snapshot returns the state of the LCD
+func (lcd *LCD) Snapshot() State {
+	s := State{
+		Tick:        lcd.tick,
+		WindowLine:  lcd.windowLine,
+		WindowShown: lcd.windowShown,
+		Pixels:      make([]byte, 0, 144*160*4),
+		Shades:      lcd.shades,
+		FIFO: FIFOState{
+			Drawing:     lcd.fifo.drawing,
+			X:           lcd.fifo.x,
+			Discard:     lcd.fifo.discard,
+			Pixels:      lcd.fifo.pixels,
+			Queued:      lcd.fifo.queued,
+			FetchState:  lcd.fifo.fetchState,
+			FetchX:      lcd.fifo.fetchX,
+			Discarded:   lcd.fifo.discarded,
+			Window:      lcd.fifo.window,
+			Stall:       lcd.fifo.stall,
+			SpriteX:     lcd.fifo.spriteX,
+			Sprites:     lcd.fifo.sprites,
+			NextSprite:  lcd.fifo.nextSprite,
+			LeftPenalty: lcd.fifo.leftPenalty,
+		},
+	}
+	for y, l := range lcd.lines {
+		s.Lines[y] = LineState{
+			Recorded:    l.recorded,
+			LCDC:        l.lcdc,
+			SCX:         l.scx,
+			SCY:         l.scy,
+			WX:          l.wx,
+			BGP:         l.bgp,
+			OBP0:        l.obp0,
+			OBP1:        l.obp1,
+			WindowShown: l.windowShown,
+			WindowLine:  l.windowLine,
+			BGPalettes:  l.bgPalettes,
+			OBJPalettes: l.objPalettes,
+		}
+	}
+	for y := 0; y < 144; y++ {
+		row := lcd.frame.Pix[y*lcd.frame.Stride:]
+		s.Pixels = append(s.Pixels, row[:160*4]...)
+	}
+	return s
+}
+
+// Restore returns the LCD to a state taken by Snapshot, after memory has been restored
+func (lcd *LCD) Restore(s State) {
+	lcd.tick = s.Tick
+	lcd.windowLine = s.WindowLine
+	lcd.windowShown = s.WindowShown
+	lcd.shades = s.Shades
+	for y, l := range s.Lines {
+		lcd.lines[y] = lineState{
+			recorded:    l.Recorded,
+			lcdc:        l.LCDC,
+			scx:         l.SCX,
+			scy:         l.SCY,
+			wx:          l.WX,
+			bgp:         l.BGP,
+			obp0:        l.OBP0,
+			obp1:        l.OBP1,
+			windowShown: l.WindowShown,
+			windowLine:  l.WindowLine,
+			bgPalettes:  l.BGPalettes,
+			objPalettes: l.OBJPalettes,
+		}
+	}
+	f := s.FIFO
+	lcd.fifo = pixelFIFO{
+		drawing:     f.Drawing,
+		x:           f.X,
+		discard:     f.Discard,
+		pixels:      f.Pixels,
+		queued:      f.Queued,
+		fetchState:  f.FetchState,
+		fetchX:      f.FetchX,
+		discarded:   f.Discarded,
+		window:      f.Window,
+		stall:       f.Stall,
+		spriteX:     f.SpriteX,
+		sprites:     f.Sprites,
+		nextSprite:  f.NextSprite,
+		leftPenalty: f.LeftPenalty,
+	}
+	if lcd.fifo.drawing {
+		// The sprites on the line being drawn are picked again from the restored OAM
+		lcd.updateSprites(lcd.memory.LY, lcd.largeSprites())
+	}
+	for y := 0; y < 144 && (y+1)*160*4 <= len(s.Pixels); y++ {
+		copy(lcd.frame.Pix[y*lcd.frame.Stride:], s.Pixels[y*160*4:(y+1)*160*4])
+	}
+	// VRAM changed without any write notifications so decode every tile again, which also gives each
+	// one a new version so that the layers are redrawn
+	for bank := range lcd.staleTiles {
+		for tileNumber := range lcd.staleTiles[bank] {
+			lcd.staleTiles[bank][tileNumber] = true
+		}
+	}
+}
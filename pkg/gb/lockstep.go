@@ -0,0 +1,133 @@
+package gb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+)
+
+// A reference trace has a line per instruction, logged before it runs, holding fields such as
+// "A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02" as written by
+// Gameboy Doctor compatible builds of SameBoy and other emulators. Register pairs such as "BC:0013"
+// and Tetromino's own lower case trace format are understood too. Fields that are missing from a
+// line, or that are not hex, are not compared.
+var traceField = regexp.MustCompile(`(?i)\b(af|bc|de|hl|sp|pc|pcmem|a|f|b|c|d|e|h|l)\s*[:=]\s*((?:0x)?[0-9a-f]+(?:,[0-9a-f]+)*)\b`)
+
+// maxHaltCycles bounds how long a halted CPU is run while waiting for the next traced instruction
+const maxHaltCycles = 1 << 20
+
+// TraceMismatch describes the first instruction where the emulator disagreed with a reference trace
+type TraceMismatch struct {
+	Line      int
+	Expected  string
+	Registers cpu.Registers
+	Memory    [4]uint8
+	// Differences lists each field that disagreed e.g. "A: expected 0x01 but got 0x00"
+	Differences []string
+	// Trace holds the instructions executed before the mismatch, oldest first
+	Trace []cpu.TraceEntry
+}
+
+func (m TraceMismatch) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diverged from the reference trace at line %d\n", m.Line)
+	fmt.Fprintf(&b, "  expected: %s\n", m.Expected)
+	fmt.Fprintf(&b, "  actual:   %s pcmem:%02x,%02x,%02x,%02x\n", m.Registers, m.Memory[0], m.Memory[1], m.Memory[2], m.Memory[3])
+	for _, difference := range m.Differences {
+		fmt.Fprintf(&b, "  %s\n", difference)
+	}
+	if len(m.Trace) > 0 {
+		fmt.Fprintf(&b, "Preceding instructions:\n")
+		for _, entry := range m.Trace {
+			fmt.Fprintf(&b, "  %s\n", entry)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// CompareTrace runs the Gameboy in lockstep with a reference trace, checking the registers before
+// each instruction against the next line of the trace. It returns the number of instructions that
+// matched and a description of the first mismatch, which is nil if the whole trace matched.
+func (gb *Gameboy) CompareTrace(reference io.Reader) (int, *TraceMismatch, error) {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	scanner := bufio.NewScanner(reference)
+	var line, matched int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		fields := traceField.FindAllStringSubmatch(text, -1)
+		if len(fields) == 0 {
+			continue
+		}
+		// The reference only logs instructions so run through any HALT until the CPU wakes up
+		for cycles := 0; gb.dispatch.Registers().Halted && cycles < maxHaltCycles; cycles++ {
+			gb.runMachineCycle()
+		}
+		if differences := gb.traceDifferences(fields); len(differences) > 0 {
+			r := gb.dispatch.Registers()
+			m := &TraceMismatch{
+				Line:        line,
+				Expected:    text,
+				Registers:   r,
+				Differences: differences,
+				Trace:       gb.dispatch.Trace(),
+			}
+			for i := range m.Memory {
+				m.Memory[i] = gb.memory.Peek(r.PC + uint16(i))
+			}
+			return matched, m, nil
+		}
+		matched++
+		gb.step()
+	}
+	if err := scanner.Err(); err != nil {
+		return matched, nil, fmt.Errorf("failed to read the reference trace: %v", err)
+	}
+	return matched, nil, nil
+}
+
+func (gb *Gameboy) traceDifferences(fields [][]string) []string {
+	r := gb.dispatch.Registers()
+	actual := map[string]uint16{
+		"a": uint16(r.A), "f": uint16(r.F), "b": uint16(r.B), "c": uint16(r.C),
+		"d": uint16(r.D), "e": uint16(r.E), "h": uint16(r.H), "l": uint16(r.L),
+		"af": uint16(r.A)<<8 | uint16(r.F), "bc": uint16(r.B)<<8 | uint16(r.C),
+		"de": uint16(r.D)<<8 | uint16(r.E), "hl": uint16(r.H)<<8 | uint16(r.L),
+		"sp": r.SP, "pc": r.PC,
+	}
+	var differences []string
+	for _, field := range fields {
+		name := strings.ToLower(field[1])
+		if name == "pcmem" {
+			for i, value := range strings.Split(field[2], ",") {
+				expected, err := strconv.ParseUint(value, 16, 8)
+				if err != nil {
+					continue
+				}
+				addr := r.PC + uint16(i)
+				if got := gb.memory.Peek(addr); uint8(expected) != got {
+					differences = append(differences, fmt.Sprintf("[0x%04x]: expected 0x%02x but got 0x%02x", addr, expected, got))
+				}
+			}
+			continue
+		}
+		expected, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(field[2]), "0x"), 16, 16)
+		if err != nil {
+			continue
+		}
+		if got := actual[name]; uint16(expected) != got {
+			width := 2
+			if len(name) == 2 {
+				width = 4
+			}
+			differences = append(differences, fmt.Sprintf("%s: expected 0x%0*x but got 0x%0*x", strings.ToUpper(name), width, expected, width, got))
+		}
+	}
+	return differences
+}
@@ -0,0 +1,51 @@
+package gb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompareTrace(t *testing.T) {
+	opts := Options{RomFilename: "testdata/blargg/cpu_instrs/individual/01-special.gb"}
+	reference, err := NewGameboy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for i := 0; i < 200; i++ {
+		r := reference.Registers()
+		lines = append(lines, fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+			r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC,
+			reference.PeekMemory(r.PC), reference.PeekMemory(r.PC+1), reference.PeekMemory(r.PC+2), reference.PeekMemory(r.PC+3)))
+		reference.Step()
+	}
+
+	gameboy, err := NewGameboy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, mismatch, err := gameboy.CompareTrace(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch != nil || matched != 200 {
+		t.Fatalf("expected 200 matching instructions but got %d and %v", matched, mismatch)
+	}
+
+	lines[149] = strings.Replace(lines[149], "A:", "A:5", 1)
+	gameboy, err = NewGameboy(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, mismatch, err = gameboy.CompareTrace(strings.NewReader("# comment\n" + strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch == nil {
+		t.Fatal("expected a mismatch")
+	}
+	if matched != 149 || mismatch.Line != 151 || len(mismatch.Differences) != 1 || !strings.HasPrefix(mismatch.Differences[0], "A: expected") {
+		t.Errorf("unexpected mismatch after %d instructions: %v", matched, mismatch)
+	}
+}
@@ -0,0 +1,54 @@
+package gb
+
+import "testing"
+
+// newMBC5 returns a Gameboy playing an 8MB MBC5 cart whose banks each start with their bank number
+func newMBC5(t *testing.T, cartType uint8) *Gameboy {
+	rom := make([]byte, 512*0x4000)
+	for bank := 0; bank < 512; bank++ {
+		rom[bank*0x4000], rom[bank*0x4000+1] = uint8(bank), uint8(bank>>8)
+	}
+	copy(rom[0x0147:], []byte{cartType, 0x08, 0x04})
+	gameboy, err := NewGameboy(Options{Rom: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gameboy
+}
+
+func TestMBC5ROMBanks(t *testing.T) {
+	gameboy := newMBC5(t, 0x19)
+	for _, bank := range []int{1, 0x42, 0x105, 0x1ff, 0} {
+		gameboy.WriteMemory(0x2000, uint8(bank))
+		gameboy.WriteMemory(0x3000, uint8(bank>>8))
+		if read := int(gameboy.ReadMemory(0x4001))<<8 | int(gameboy.ReadMemory(0x4000)); read != bank {
+			t.Errorf("expected bank 0x%x at 0x4000 but got 0x%x", bank, read)
+		}
+	}
+	if gameboy.ReadMemory(0x0000) != 0 {
+		t.Error("expected bank 0 at 0x0000")
+	}
+}
+
+func TestMBC5RAMBanks(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cartType uint8
+		shared   bool
+	}{
+		{"without rumble", 0x1b, false},
+		{"with rumble", 0x1e, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			gameboy := newMBC5(t, test.cartType)
+			gameboy.WriteMemory(0x0000, 0x0a)
+			gameboy.WriteMemory(0x4000, 0x03)
+			gameboy.WriteMemory(0xa000, 0x42)
+			// Bit 3 selects bank 11 or drives the motor of a cart with rumble
+			gameboy.WriteMemory(0x4000, 0x0b)
+			if shared := gameboy.ReadMemory(0xa000) == 0x42; shared != test.shared {
+				t.Errorf("expected bank 3 to stay selected after writing 0x0b: %v", test.shared)
+			}
+		})
+	}
+}
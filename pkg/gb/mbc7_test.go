@@ -0,0 +1,115 @@
+package gb
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// mbc7 drives the registers of an MBC7 cart
+type mbc7 struct {
+	t       *testing.T
+	gameboy *Gameboy
+}
+
+func newMBC7(t *testing.T) (mbc7, string) {
+	rom := writeRom(t, map[uint16][]byte{0x0147: {0x22, 0x00, 0x00}})
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0x0000, 0x0a)
+	gameboy.WriteMemory(0x4000, 0x40)
+	return mbc7{t, gameboy}, rom
+}
+
+// clock shifts bits into the EEPROM, most significant first, returning the bits on DO after each one
+func (c mbc7) clock(bits uint32, count int) uint32 {
+	var out uint32
+	for i := count - 1; i >= 0; i-- {
+		di := uint8(bits>>uint(i)&1) << 1
+		c.gameboy.WriteMemory(0xa080, 0x80|di)
+		c.gameboy.WriteMemory(0xa080, 0xc0|di)
+		out = out<<1 | uint32(c.gameboy.ReadMemory(0xa080)&1)
+	}
+	return out
+}
+
+// command sends a command to the EEPROM and lowers CS afterwards
+func (c mbc7) command(bits uint32, count int) uint32 {
+	out := c.clock(bits, count)
+	c.gameboy.WriteMemory(0xa080, 0x00)
+	return out
+}
+
+func (c mbc7) read(addr uint8) uint16 {
+	c.clock(0x600|uint32(addr), 11)
+	word := c.command(0, 16)
+	return uint16(word)
+}
+
+func TestMBC7EEPROM(t *testing.T) {
+	c, rom := newMBC7(t)
+	if word := c.read(0x05); word != 0xffff {
+		t.Errorf("expected an erased word but got 0x%04x", word)
+	}
+	// Writes are ignored until enabled with EWEN
+	c.command((0x500|0x05)<<16|0x1234, 27)
+	if word := c.read(0x05); word != 0xffff {
+		t.Errorf("expected the write to be ignored but got 0x%04x", word)
+	}
+	c.command(0x4c0, 11)
+	c.command((0x500|0x05)<<16|0x1234, 27)
+	c.command((0x500|0x06)<<16|0xabcd, 27)
+	if word := c.read(0x05); word != 0x1234 {
+		t.Errorf("expected 0x1234 but got 0x%04x", word)
+	}
+	// Reads carry on into the next word
+	c.clock(0x605, 11)
+	if words := c.command(0, 32); words != 0x1234abcd {
+		t.Errorf("expected a sequential read of 0x1234abcd but got 0x%08x", words)
+	}
+	c.command(0x700|0x06, 11)
+	if word := c.read(0x06); word != 0xffff {
+		t.Errorf("expected the word to be erased but got 0x%04x", word)
+	}
+
+	// The EEPROM is saved with little-endian words
+	if err := c.gameboy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	save, err := ioutil.ReadFile(filepath.Join(filepath.Dir(rom), "test.sav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save) != 0x100 || save[0x0a] != 0x34 || save[0x0b] != 0x12 {
+		t.Errorf("unexpected save of %d bytes", len(save))
+	}
+}
+
+func TestMBC7Accelerometer(t *testing.T) {
+	c, _ := newMBC7(t)
+	if !c.gameboy.HasAccelerometer() {
+		t.Fatal("expected an MBC7 cart to have an accelerometer")
+	}
+	read := func() (uint16, uint16) {
+		x := uint16(c.gameboy.ReadMemory(0xa020)) | uint16(c.gameboy.ReadMemory(0xa030))<<8
+		y := uint16(c.gameboy.ReadMemory(0xa040)) | uint16(c.gameboy.ReadMemory(0xa050))<<8
+		return x, y
+	}
+	c.gameboy.Tilt(1, -0.5)
+	c.gameboy.WriteMemory(0xa000, 0x55)
+	if x, y := read(); x != 0x8000 || y != 0x8000 {
+		t.Errorf("expected erased values but got 0x%04x and 0x%04x", x, y)
+	}
+	c.gameboy.WriteMemory(0xa010, 0xaa)
+	if x, y := read(); x != 0x81d0+0x70 || y != 0x81d0-0x38 {
+		t.Errorf("expected tilted values but got 0x%04x and 0x%04x", x, y)
+	}
+	// Values aren't latched again until erased
+	c.gameboy.Tilt(0, 0)
+	c.gameboy.WriteMemory(0xa010, 0xaa)
+	if x, _ := read(); x != 0x81d0+0x70 {
+		t.Errorf("expected the latched value to be kept but got 0x%04x", x)
+	}
+}
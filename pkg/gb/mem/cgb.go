@@ -0,0 +1,198 @@
+package mem
+
+// cgbState holds the registers that only exist on a Game Boy Color
+type cgbState struct {
+	enabled      bool
+	videoRAMBank uint8 // VBK
+	wramBank     uint8 // SVBK
+	speedSwitch  bool  // KEY1 bit 0
+	doubleSpeed  bool  // KEY1 bit 7
+	bcps         uint8
+	ocps         uint8
+	hdmaSource   uint16
+	hdmaDest     uint16
+	hdmaBlocks   int // 16 byte blocks left to copy
+	hdmaHBlank   bool
+	rp           uint8
+}
+
+func (c *cgbState) internalRAMBank() uint8 {
+	// SVBK selects bank 1-7 at D000-DFFF and bank 0 selects bank 1 too
+	if c.wramBank == 0 {
+		return 1
+	}
+	return c.wramBank
+}
+
+func isCGBRegister(addr uint16) bool {
+	switch addr {
+	case KEY1, VBK, HDMA1, HDMA2, HDMA3, HDMA4, HDMA5, RP, BCPS, BCPD, OCPS, OCPD, SVBK:
+		return true
+	}
+	return false
+}
+
+// EnableCGB switches on the Game Boy Color registers, VRAM and WRAM banking and color palettes
+func (m *Memory) EnableCGB() {
+	m.cgb.enabled = true
+	// The boot ROM leaves every palette white
+	for i := range m.BGPaletteRAM {
+		m.BGPaletteRAM[i] = 0xff
+		m.OBJPaletteRAM[i] = 0xff
+	}
+}
+
+// CGB returns true if the memory behaves as a Game Boy Color
+func (m *Memory) CGB() bool {
+	return m.cgb.enabled
+}
+
+// DoubleSpeed returns true if the CPU has switched to double speed mode
+func (m *Memory) DoubleSpeed() bool {
+	return m.cgb.doubleSpeed
+}
+
+// SwitchSpeed toggles double speed mode when STOP is executed if a switch was prepared using KEY1,
+// returning true if the speed changed
+func (m *Memory) SwitchSpeed() bool {
+	if !m.cgb.enabled || !m.cgb.speedSwitch {
+		return false
+	}
+	m.cgb.speedSwitch = false
+	m.cgb.doubleSpeed = !m.cgb.doubleSpeed
+	m.timer.SetDoubleSpeed(m.cgb.doubleSpeed)
+	return true
+}
+
+// HBlankDMA copies the next block of an H-Blank DMA transfer as the LCD enters H-Blank
+func (m *Memory) HBlankDMA() {
+	if m.cgb.hdmaHBlank && m.cgb.hdmaBlocks > 0 {
+		m.copyHDMABlock()
+		if m.cgb.hdmaBlocks == 0 {
+			m.cgb.hdmaHBlank = false
+		}
+	}
+}
+
+func (m *Memory) copyHDMABlock() {
+	for i := 0; i < 0x10; i++ {
+		m.write(0x8000+m.cgb.hdmaDest&0x1fff, m.Read(m.cgb.hdmaSource))
+		m.cgb.hdmaSource++
+		m.cgb.hdmaDest++
+	}
+	m.cgb.hdmaBlocks--
+}
+
+func (m *Memory) startHDMA(value uint8) {
+	if m.cgb.hdmaHBlank && value&0x80 == 0 {
+		// Writing zero to bit 7 stops an H-Blank transfer in progress
+		m.cgb.hdmaHBlank = false
+		return
+	}
+	m.cgb.hdmaBlocks = int(value&0x7f) + 1
+	if value&0x80 != 0 {
+		m.cgb.hdmaHBlank = true
+		return
+	}
+	// A general purpose transfer copies everything at once
+	for m.cgb.hdmaBlocks > 0 {
+		m.copyHDMABlock()
+	}
+}
+
+func (m *Memory) readHDMA5() uint8 {
+	switch {
+	case m.cgb.hdmaBlocks == 0:
+		return 0xff
+	case m.cgb.hdmaHBlank:
+		return uint8(m.cgb.hdmaBlocks - 1)
+	default:
+		// A stopped H-Blank transfer reports the blocks left with bit 7 set
+		return 0x80 | uint8(m.cgb.hdmaBlocks-1)
+	}
+}
+
+func (m *Memory) readRP() uint8 {
+	// Bit 1 reads 0 while light is received, but only when reading is enabled by bits 6 and 7
+	value := 0x3e | m.cgb.rp
+	if m.cgb.rp&0xc0 == 0xc0 && m.InfraredPort != nil && m.InfraredPort.Receiving() {
+		value &^= 0x02
+	}
+	return value
+}
+
+func (m *Memory) readCGBRegister(addr uint16) uint8 {
+	switch addr {
+	case KEY1:
+		value := uint8(0x7e)
+		if m.cgb.doubleSpeed {
+			value |= 0x80
+		}
+		if m.cgb.speedSwitch {
+			value |= 0x01
+		}
+		return value
+	case VBK:
+		return 0xfe | m.cgb.videoRAMBank
+	case HDMA5:
+		return m.readHDMA5()
+	case RP:
+		return m.readRP()
+	case BCPS:
+		return m.cgb.bcps | 0x40
+	case BCPD:
+		return m.BGPaletteRAM[m.cgb.bcps&0x3f]
+	case OCPS:
+		return m.cgb.ocps | 0x40
+	case OCPD:
+		return m.OBJPaletteRAM[m.cgb.ocps&0x3f]
+	case SVBK:
+		return 0xf8 | m.cgb.wramBank
+	}
+	// HDMA1-4 are write only
+	return 0xff
+}
+
+func (m *Memory) writeCGBRegister(addr uint16, value uint8) {
+	switch addr {
+	case KEY1:
+		m.cgb.speedSwitch = value&0x01 != 0
+	case VBK:
+		m.cgb.videoRAMBank = value & 0x01
+	case HDMA1:
+		m.cgb.hdmaSource = uint16(value)<<8 | m.cgb.hdmaSource&0x00ff
+	case HDMA2:
+		m.cgb.hdmaSource = m.cgb.hdmaSource&0xff00 | uint16(value&0xf0)
+	case HDMA3:
+		m.cgb.hdmaDest = uint16(value&0x1f)<<8 | m.cgb.hdmaDest&0x00ff
+	case HDMA4:
+		m.cgb.hdmaDest = m.cgb.hdmaDest&0xff00 | uint16(value&0xf0)
+	case HDMA5:
+		m.startHDMA(value)
+	case RP:
+		m.cgb.rp = value & 0xc1
+		if m.InfraredPort != nil {
+			m.InfraredPort.SetLED(value&0x01 != 0)
+		}
+	case BCPS:
+		m.cgb.bcps = value & 0xbf
+	case BCPD:
+		m.BGPaletteRAM[m.cgb.bcps&0x3f] = value
+		m.cgb.bcps = autoIncrement(m.cgb.bcps)
+	case OCPS:
+		m.cgb.ocps = value & 0xbf
+	case OCPD:
+		m.OBJPaletteRAM[m.cgb.ocps&0x3f] = value
+		m.cgb.ocps = autoIncrement(m.cgb.ocps)
+	case SVBK:
+		m.cgb.wramBank = value & 0x07
+	}
+}
+
+// autoIncrement advances a palette index register after a write if its bit 7 is set
+func autoIncrement(index uint8) uint8 {
+	if index&0x80 == 0 {
+		return index
+	}
+	return 0x80 | (index+1)&0x3f
+}
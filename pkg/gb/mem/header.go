@@ -0,0 +1,139 @@
+package mem
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Header holds the cartridge details from the header at 0x0100-0x014f of a ROM
+type Header struct {
+	Title         string
+	CartridgeType uint8
+	ROMBanks      int
+	RAMBanks      int
+	// RAMSize is the number of bytes of RAM that the cart really has, which can be less than a bank
+	RAMSize int
+	Battery bool
+	// Timer is true for MBC3 carts with a real-time clock and Rumble for MBC5 carts with a motor
+	Timer  bool
+	Rumble bool
+	// CGB is true if the game uses Game Boy Color features and CGBOnly if it won't run on a DMG
+	CGB     bool
+	CGBOnly bool
+	// SGB is true if the game sends packets to a Super Game Boy
+	SGB bool
+	// Supported is true if the emulator implements the cartridge's memory bank controller
+	Supported bool
+	// Checksum is the header checksum at 0x014d and ChecksumValid reports whether it matches
+	Checksum      uint8
+	ChecksumValid bool
+}
+
+// ParseHeader reads the cartridge header from a ROM
+func ParseHeader(rom []byte) (Header, error) {
+	if len(rom) < 0x0150 {
+		return Header{}, fmt.Errorf("ROM is too small to contain a cartridge header: 0x%04x bytes", len(rom))
+	}
+	cartType := rom[0x0147]
+	var checksum uint8
+	for _, b := range rom[0x0134:0x014d] {
+		checksum = checksum - b - 1
+	}
+	_, err := chooseUpdateFunc(cartType)
+	return Header{
+		Title:         title(rom[0x0134:0x0144]),
+		CartridgeType: cartType,
+		ROMBanks:      0x02 << rom[0x0148],
+		RAMBanks:      ramBanks(cartType, rom[0x0149]),
+		RAMSize:       batteryRAMSize(cartType, rom[0x0149]),
+		Battery:       hasBattery(cartType),
+		Timer:         cartType == 0x0f || cartType == 0x10,
+		Rumble:        cartType >= 0x1c && cartType <= 0x1e,
+		CGB:           rom[0x0143]&0x80 != 0,
+		CGBOnly:       rom[0x0143] == 0xc0,
+		SGB:           rom[0x0146] == 0x03 && rom[0x014b] == 0x33,
+		Supported:     err == nil,
+		Checksum:      rom[0x014d],
+		ChecksumValid: checksum == rom[0x014d],
+	}, nil
+}
+
+// Mapper returns the name of the cartridge's memory bank controller
+func (h Header) Mapper() string {
+	switch h.CartridgeType {
+	case 0x00, 0x08, 0x09:
+		return "ROM only"
+	case 0x01, 0x02, 0x03:
+		return "MBC1"
+	case 0x05, 0x06:
+		return "MBC2"
+	case 0x0b, 0x0c, 0x0d:
+		return "MMM01"
+	case 0x0f, 0x10, 0x11, 0x12, 0x13:
+		return "MBC3"
+	case 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e:
+		return "MBC5"
+	case 0x20:
+		return "MBC6"
+	case 0x22:
+		return "MBC7"
+	case 0xfc:
+		return "Pocket Camera"
+	case 0xfd:
+		return "TAMA5"
+	case 0xfe:
+		return "HuC3"
+	case 0xff:
+		return "HuC1"
+	}
+	return "unknown"
+}
+
+// String summarises the cartridge on one line, such as "POKEMON RED (MBC3, 64 ROM banks, 4 RAM banks,
+// battery, SGB)"
+func (h Header) String() string {
+	details := []string{h.Mapper()}
+	if !h.Supported {
+		details[0] += " not supported"
+	}
+	details = append(details, banks(h.ROMBanks, "ROM"))
+	if h.RAMBanks > 0 {
+		details = append(details, banks(h.RAMBanks, "RAM"))
+	}
+	for _, feature := range []struct {
+		name string
+		has  bool
+	}{
+		{"battery", h.Battery},
+		{"clock", h.Timer},
+		{"rumble", h.Rumble},
+		{"CGB only", h.CGBOnly},
+		{"CGB", h.CGB && !h.CGBOnly},
+		{"SGB", h.SGB},
+		{"bad header checksum", !h.ChecksumValid},
+	} {
+		if feature.has {
+			details = append(details, feature.name)
+		}
+	}
+	return fmt.Sprintf("%s (%s)", h.Title, strings.Join(details, ", "))
+}
+
+func banks(count int, memory string) string {
+	if count == 1 {
+		return "1 " + memory + " bank"
+	}
+	return fmt.Sprintf("%d %s banks", count, memory)
+}
+
+// title reads the zero-padded title, which newer carts shorten to make room for the CGB flag at 0x0143
+func title(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c == 0x00 || c >= 0x80 {
+			break
+		}
+		s.WriteByte(c)
+	}
+	return strings.TrimSpace(s.String())
+}
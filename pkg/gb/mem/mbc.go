@@ -1,17 +1,35 @@
 package mem
 
 import (
+	"bytes"
 	"fmt"
 )
 
 type mbc struct {
+	header Header
 	// ROM and RAM data and mask read from the cart
-	rom [][0x4000]byte
-	ram [][0x2000]byte
+	rom     [][0x4000]byte
+	ram     [][0x2000]byte
+	battery bool
+	// saveSize is the number of bytes of RAM in a battery save, which can be less than a whole bank
+	saveSize int
+	// rtc is the real-time clock of MBC3 carts with a timer, whose saves end with the clock's state
+	rtc *rtc
+	// mbc7 handles the accelerometer and EEPROM of MBC7 carts
+	mbc7 *mbc7
+	// mbc5 is true for MBC5 carts, whose ROM bank numbers have 9 bits
+	mbc5 bool
+	// rumble is true for MBC5 carts with a rumble motor, which is driven by bit 3 of the RAM bank
+	// number register rather than it selecting a bank
+	rumble bool
+	// multicart is true for MBC1 carts wired to hold several games, which only use 4 bits of the ROM
+	// bank number register so that each game fits in 16 banks
+	multicart bool
 
 	// Record of what as written between 0x0000 and 0x8000
 	enabledRegion uint8
 	romRegion     uint8
+	romHighRegion uint8
 	ramRegion     uint8
 	modeRegion    uint8
 
@@ -21,61 +39,100 @@ type mbc struct {
 	romBankX   int
 	ramBank    int
 	update     func(*mbc)
+
+	// Count of writes that changed the selected banks
+	bankSwitches uint64
 }
 
-func newMBC(rom []byte) *mbc {
-	// FIXME this is really for unit tests - need to be more graceful about this and missing ROM files in general
-	if rom == nil || len(rom) < 0x0148 {
-		return nil
-	}
-	cartType := rom[0x0147]
-	romSize := rom[0x0148]
-	ramSize := rom[0x0149]
-	return &mbc{
-		rom:      splitROMIntoPages(romSize, rom),
-		ram:      createRAM(cartType, ramSize),
+// newMBC chooses the memory bank controller and the sizes of ROM and RAM from the cartridge header
+func newMBC(rom []byte) (*mbc, error) {
+	header, err := ParseHeader(rom)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := splitROMIntoPages(header.ROMBanks, rom)
+	if err != nil {
+		return nil, err
+	}
+	update, err := chooseUpdateFunc(header.CartridgeType)
+	if err != nil {
+		return nil, err
+	}
+	m := &mbc{
+		header:   header,
+		rom:      pages,
+		ram:      createRAM(header.RAMBanks),
+		battery:  header.Battery,
+		saveSize: header.RAMSize,
 		romBank0: 0,
 		romBankX: 1,
-		update:   chooseUpdateFunc(cartType),
+		update:   update,
+	}
+	switch header.Mapper() {
+	case "MBC1":
+		m.multicart = isMulticart(pages)
+	case "MBC3":
+		if header.Timer {
+			m.rtc = &rtc{}
+		}
+	case "MBC5":
+		m.mbc5 = true
+		m.rumble = header.Rumble
+	case "MBC7":
+		m.mbc7 = newMBC7(&m.ram[0])
+	}
+	return m, nil
+}
+
+// isMulticart returns true for an 8Mbit MBC1 cart, such as Mortal Kombat I & II, whose second game
+// starts at bank 0x10 with its own copy of the Nintendo logo
+func isMulticart(pages [][0x4000]byte) bool {
+	if len(pages) != 64 {
+		return false
 	}
+	return bytes.Equal(pages[0x10][0x0104:0x0134], pages[0][0x0104:0x0134])
 }
 
-func splitROMIntoPages(romSize uint8, rom []byte) [][0x4000]byte {
+func splitROMIntoPages(romBanks int, rom []byte) ([][0x4000]byte, error) {
 	if len(rom)%0x4000 != 0 {
-		panic(fmt.Sprintf("ROM size must be a multiple of 32KB. Current size: 0x%02x", len(rom)))
+		return nil, fmt.Errorf("ROM size must be a multiple of 32KB. Current size: 0x%02x", len(rom))
 	}
 	pageCount := len(rom) / 0x4000
-	if pageCount != (0x02 << romSize) {
-		panic(fmt.Sprintf("Actual ROM size must match reported size: Actual=0x%04x Reported=0x%04x", pageCount, (0x02 << romSize)))
+	if pageCount != romBanks {
+		return nil, fmt.Errorf("Actual ROM size must match reported size: Actual=0x%04x Reported=0x%04x", pageCount, romBanks)
 	}
 	pages := make([][0x4000]byte, pageCount)
 	for i := 0; i < pageCount; i++ {
 		copy(pages[i][:], rom[i*0x4000:])
 	}
-	return pages
+	return pages, nil
 }
 
-func createRAM(cartType, ramSize uint8) [][0x2000]byte {
-	var ram [][0x2000]byte
+// ramBanks returns the number of 8KB banks of RAM in a cart from the RAM size in its header
+func ramBanks(cartType, ramSize uint8) int {
 	if cartType == 0x05 || cartType == 0x06 {
-		ram = make([][0x2000]byte, 1)
-	} else {
-		switch ramSize {
-		case 0x01:
-			ram = make([][0x2000]byte, 1)
-		case 0x02:
-			ram = make([][0x2000]byte, 1)
-		case 0x03:
-			ram = make([][0x2000]byte, 4)
-		case 0x04:
-			ram = make([][0x2000]byte, 16)
-		case 0x05:
-			ram = make([][0x2000]byte, 8)
-		default:
-			// This cart has no RAM but capture writes anyway to allow test ROM validation
-			ram = make([][0x2000]byte, 1)
-		}
+		// MBC2 has its own RAM whatever the header says
+		return 1
+	}
+	switch ramSize {
+	case 0x01, 0x02:
+		return 1
+	case 0x03:
+		return 4
+	case 0x04:
+		return 16
+	case 0x05:
+		return 8
 	}
+	return 0
+}
+
+func createRAM(banks int) [][0x2000]byte {
+	if banks == 0 {
+		// This cart has no RAM but capture writes anyway to allow test ROM validation
+		banks = 1
+	}
+	ram := make([][0x2000]byte, banks)
 	// Initialize it to 0xff
 	for i := 0; i < len(ram); i++ {
 		for j := 0; j < 0x2000; j++ {
@@ -85,20 +142,44 @@ func createRAM(cartType, ramSize uint8) [][0x2000]byte {
 	return ram
 }
 
-func chooseUpdateFunc(cartType uint8) func(*mbc) {
+// batteryRAMSize returns the size of the RAM that a cart really has, which is how much other
+// emulators such as VBA and SameBoy keep in a battery save
+func batteryRAMSize(cartType, ramSize uint8) int {
+	switch {
+	case cartType == 0x05 || cartType == 0x06:
+		// MBC2 has 512 half-bytes of RAM, which are saved a byte each
+		return 0x200
+	case cartType == 0x22:
+		// MBC7 has a 256-byte EEPROM
+		return 0x100
+	case ramSize == 0x01:
+		return 0x800
+	}
+	return ramBanks(cartType, ramSize) * 0x2000
+}
+
+func hasBattery(cartType uint8) bool {
+	switch cartType {
+	case 0x03, 0x06, 0x09, 0x0d, 0x0f, 0x10, 0x13, 0x1b, 0x1e, 0x20, 0x22, 0xff:
+		return true
+	}
+	return false
+}
+
+func chooseUpdateFunc(cartType uint8) (func(*mbc), error) {
 	switch cartType {
 	case 0x00:
 		// 00 - ROM ONLY
-		return func(_ *mbc) {}
+		return func(_ *mbc) {}, nil
 	case 0x01:
 		// 01 - ROM + MBC1
-		return updateMBC1
+		return updateMBC1, nil
 	case 0x02:
 		// 02 - ROM + MBC1 + RAM
-		return updateMBC1
+		return updateMBC1, nil
 	case 0x03:
 		// 03 - ROM + MBC1 + RAM + BATT
-		return updateMBC1
+		return updateMBC1, nil
 	case 0x05:
 		// 05 - ROM + MBC2
 	case 0x06:
@@ -113,35 +194,42 @@ func chooseUpdateFunc(cartType uint8) func(*mbc) {
 		// 0D - ROM + MMM01 + SRAM + BATT
 	case 0x0f:
 		// 0f - ROM + MBC3 + TIMER + BATT
-		return updateMBC3
+		return updateMBC3, nil
 	case 0x10:
 		// 10 - ROM + MBC3 + RAM + TIMER + BATT
-		return updateMBC3
+		return updateMBC3, nil
 	case 0x11:
 		// 11 - ROM + MBC3
-		return updateMBC3
+		return updateMBC3, nil
 	case 0x12:
 		// 12 - ROM + MBC3 + RAM
-		return updateMBC3
+		return updateMBC3, nil
 	case 0x13:
 		// 13 - ROM + MBC3 + RAM + BATT
-		return updateMBC3
+		return updateMBC3, nil
 	case 0x19:
 		// 19 - ROM + MBC5
+		return updateMBC5, nil
 	case 0x1a:
 		// 1A - ROM + MBC5 + RAM
+		return updateMBC5, nil
 	case 0x1b:
 		// 1B - ROM + MBC5 + RAM + BATT
+		return updateMBC5, nil
 	case 0x1c:
 		// 1C - ROM + MBC5 + RUMBLE
+		return updateMBC5, nil
 	case 0x1d:
 		// 1D - ROM + MBC5 + RUMBLE + SRAM
+		return updateMBC5, nil
 	case 0x1e:
 		// 1E - ROM + MBC5 + RUMBLE + SRAM + BATT
+		return updateMBC5, nil
 	case 0x20:
 		// 20 - ROM + MBC6 + RAM + BATT
 	case 0x22:
 		// 22 - ROM + MBC7 + RAM + BATT + ACCELEROMETER
+		return updateMBC7, nil
 	case 0xfc:
 		// FC - POCKET CAMERA
 	case 0xfd:
@@ -151,7 +239,7 @@ func chooseUpdateFunc(cartType uint8) func(*mbc) {
 	case 0xff:
 		// FF - Hudson on HuC-1 + RAM + BATTERY
 	}
-	panic(fmt.Sprintf("mbc does not support cart type 0x%02x", cartType))
+	return nil, fmt.Errorf("mbc does not support cart type 0x%02x", cartType)
 }
 
 func (m *mbc) read(addr uint16) uint8 {
@@ -164,6 +252,15 @@ func (m *mbc) read(addr uint16) uint8 {
 	case addr < 0xa000:
 		panic(fmt.Sprintf("mbc has no read mapping for address 0x%04x", addr))
 	case addr < 0xc000:
+		if m.mbc7 != nil {
+			if m.ramEnabled {
+				return m.mbc7.read(addr)
+			}
+			return 0xff
+		}
+		if m.ramEnabled && m.rtcSelected() {
+			return m.rtc.read(m.ramRegion)
+		}
 		if m.ramEnabled {
 			offset := addr - 0xa000
 			return m.ram[m.ramBank][offset]
@@ -178,14 +275,31 @@ func (m *mbc) write(addr uint16, value uint8) {
 	switch {
 	case addr < 0x2000:
 		m.enabledRegion = value
+	case addr < 0x3000:
+		m.romRegion = value
+	case addr < 0x4000 && m.mbc5:
+		// MBC5 takes the 9th bit of the ROM bank number from 0x3000-0x3fff
+		m.romHighRegion = value
 	case addr < 0x4000:
 		m.romRegion = value
 	case addr < 0x6000:
 		m.ramRegion = value
 	case addr < 0x8000:
+		// Writing 0 then 1 latches the clock of an MBC3 cart
+		if m.rtc != nil && m.modeRegion == 0x00 && value == 0x01 {
+			m.rtc.latch()
+		}
 		m.modeRegion = value
 	case addr < 0xa000:
 		panic(fmt.Sprintf("mbc has no write mapping for address 0x%04x", addr))
+	case addr < 0xc000 && m.mbc7 != nil:
+		if m.ramEnabled {
+			m.mbc7.write(addr, value)
+		}
+	case addr < 0xc000 && m.rtcSelected():
+		if m.ramEnabled {
+			m.rtc.write(m.ramRegion, value)
+		}
 	case addr < 0xc000:
 		offset := addr - 0xa000
 		if m.ramEnabled {
@@ -194,7 +308,16 @@ func (m *mbc) write(addr uint16, value uint8) {
 	default:
 		panic(fmt.Sprintf("mbc has no write mapping for address 0x%04x", addr))
 	}
+	romBank0, romBankX, ramBank := m.romBank0, m.romBankX, m.ramBank
 	m.update(m)
+	if m.romBank0 != romBank0 || m.romBankX != romBankX || m.ramBank != ramBank {
+		m.bankSwitches++
+	}
+}
+
+// rtcSelected returns true when an MBC3 cart maps a clock register rather than RAM to 0xa000-0xbfff
+func (m *mbc) rtcSelected() bool {
+	return m.rtc != nil && m.ramRegion >= rtcS && m.ramRegion <= rtcDH
 }
 
 func updateMBC1(m *mbc) {
@@ -202,20 +325,27 @@ func updateMBC1(m *mbc) {
 	// Check if RAM is enabled
 	m.ramEnabled = m.enabledRegion&0x0f == 0x0a
 
+	// The upper bits of the bank number come from the RAM bank register, which a multicart wires one
+	// bit lower than usual
+	upperShift, lowerMask := uint(5), uint8(0x1f)
+	if m.multicart {
+		upperShift, lowerMask = 4, 0x0f
+	}
+	upper := int(m.ramRegion&0x03) << upperShift
+
 	// Check ROM bank 0
 	if m.modeRegion&0x01 == 0 {
 		m.romBank0 = 0
 	} else {
-		m.romBank0 = int((m.ramRegion & 0x03) << 5)
-		m.romBank0 = m.romBank0 % len(m.rom)
+		m.romBank0 = upper % len(m.rom)
 	}
 
-	// Check ROM bank 1
-	m.romBankX = int((m.romRegion & 0x1f))
-	if m.romBankX == 0 {
+	// Check ROM bank 1, where writing 0 selects 1 even if a multicart ignores the bit that was set
+	m.romBankX = int(m.romRegion & lowerMask)
+	if m.romRegion&0x1f == 0 {
 		m.romBankX = 1
 	}
-	m.romBankX |= int((m.ramRegion & 0x03 << 5))
+	m.romBankX |= upper
 	m.romBankX = m.romBankX % len(m.rom)
 
 	// Check RAM bank
@@ -249,3 +379,23 @@ func updateMBC3(m *mbc) {
 	}
 
 }
+
+func updateMBC5(m *mbc) {
+
+	// Check if RAM is enabled
+	m.ramEnabled = m.enabledRegion&0x0f == 0x0a
+
+	// Check ROM bank 1, where unlike earlier MBCs bank 0 can be selected too
+	m.romBankX = int(m.romHighRegion&0x01)<<8 | int(m.romRegion)
+	m.romBankX = m.romBankX % len(m.rom)
+
+	// Check RAM bank, ignoring the bit that drives the motor of a cart with rumble
+	if m.ramEnabled {
+		m.ramBank = int(m.ramRegion & 0x0f)
+		if m.rumble {
+			m.ramBank &= 0x07
+		}
+		m.ramBank = m.ramBank % len(m.ram)
+	}
+
+}
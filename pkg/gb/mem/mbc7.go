@@ -0,0 +1,234 @@
+package mem
+
+// MBC7 carts, such as Kirby Tilt 'n' Tumble, have a two-axis accelerometer and a 93LC56 EEPROM instead
+// of RAM. Both are read and written through registers at A000-AFFF, selected by bits 4-7 of the address,
+// once RAM is enabled by writing 0x0a to 0000-1FFF and 0x40 to 4000-5FFF.
+type mbc7 struct {
+	eeprom eeprom
+
+	// The tilt of the cart from -1 to 1, with positive x tilting right and positive y tilting down
+	tiltX, tiltY float64
+	// The accelerometer's latched values, which are only latched again after being erased
+	erased bool
+	x, y   uint16
+}
+
+// The accelerometer reads accelCentre when level and moves by accelG for each g of tilt
+const (
+	accelCentre = 0x81d0
+	accelG      = 0x70
+)
+
+func newMBC7(ram *[0x2000]byte) *mbc7 {
+	return &mbc7{
+		eeprom: eeprom{data: ram, do: true},
+		x:      0x8000,
+		y:      0x8000,
+	}
+}
+
+func updateMBC7(m *mbc) {
+
+	// Check if the registers are enabled
+	m.ramEnabled = m.enabledRegion == 0x0a && m.ramRegion == 0x40
+
+	// Check ROM bank 1, which can also select bank 0
+	m.romBankX = int(m.romRegion&0x7f) % len(m.rom)
+
+}
+
+func (c *mbc7) read(addr uint16) uint8 {
+	if addr >= 0xb000 {
+		return 0xff
+	}
+	switch (addr >> 4) & 0x0f {
+	case 0x2:
+		return uint8(c.x)
+	case 0x3:
+		return uint8(c.x >> 8)
+	case 0x4:
+		return uint8(c.y)
+	case 0x5:
+		return uint8(c.y >> 8)
+	case 0x6:
+		// The accelerometer has no Z axis
+		return 0x00
+	case 0x8:
+		return c.eeprom.pins()
+	}
+	return 0xff
+}
+
+func (c *mbc7) write(addr uint16, value uint8) {
+	if addr >= 0xb000 {
+		return
+	}
+	switch (addr >> 4) & 0x0f {
+	case 0x0:
+		if value == 0x55 {
+			c.erased = true
+			c.x, c.y = 0x8000, 0x8000
+		}
+	case 0x1:
+		if value == 0xaa && c.erased {
+			c.erased = false
+			c.x = accelCentre + uint16(int16(c.tiltX*accelG))
+			c.y = accelCentre + uint16(int16(c.tiltY*accelG))
+		}
+	case 0x8:
+		c.eeprom.setPins(value)
+	}
+}
+
+// eeprom is a 93LC56 holding 128 16-bit words, which are stored little-endian in the first 256 bytes
+// of cart RAM as they are in the saves of other emulators. Commands are shifted in on DI a bit at a
+// time on each rising edge of CLK while CS is high: a start bit, a 2-bit opcode and an 8-bit address,
+// followed by 16 bits of data for writes. Reads shift the data out on DO after a dummy 0 bit.
+type eeprom struct {
+	data *[0x2000]byte
+
+	cs, clk, di, do bool
+	writeEnabled    bool
+
+	// The bits of a command shifted in so far, counting the start bit
+	command uint32
+	bits    int
+
+	// The word being shifted out by a read, the number of bits left and the address of the next word
+	out     uint16
+	reading int
+	next    uint8
+}
+
+// pins returns the state of CS, CLK, DI and DO in bits 7, 6, 1 and 0
+func (e *eeprom) pins() uint8 {
+	var value uint8
+	if e.cs {
+		value |= 0x80
+	}
+	if e.clk {
+		value |= 0x40
+	}
+	if e.di {
+		value |= 0x02
+	}
+	if e.do {
+		value |= 0x01
+	}
+	return value
+}
+
+// setPins sets CS, CLK and DI from bits 7, 6 and 1 and acts on a rising edge of CLK
+func (e *eeprom) setPins(value uint8) {
+	cs, clk, di := value&0x80 != 0, value&0x40 != 0, value&0x02 != 0
+	rising := clk && !e.clk
+	e.cs, e.clk, e.di = cs, clk, di
+	if !cs {
+		// Lowering CS abandons any command
+		e.bits = 0
+		e.reading = 0
+		return
+	}
+	if !rising {
+		return
+	}
+	if e.reading > 0 {
+		e.do = e.out&0x8000 != 0
+		e.out <<= 1
+		e.reading--
+		if e.reading == 0 {
+			// Reads carry on into the next word until CS is lowered
+			e.out = e.word(e.next)
+			e.reading = 16
+			e.next = (e.next + 1) & 0x7f
+		}
+		return
+	}
+	if e.bits == 0 && !di {
+		// Wait for the start bit
+		return
+	}
+	e.command = e.command<<1 | boolBit(di)
+	e.bits++
+	e.execute()
+}
+
+// execute acts on a command once enough bits have been shifted in
+func (e *eeprom) execute() {
+	if e.bits < 11 {
+		return
+	}
+	// The opcode and address are the first 11 bits and any data follows them
+	header := e.command >> uint(e.bits-11)
+	addr := uint8(header) & 0x7f
+	data := uint16(e.command)
+	switch (header >> 8) & 0x03 {
+	case 0x2:
+		// READ
+		e.out = e.word(addr)
+		e.reading = 16
+		e.next = (addr + 1) & 0x7f
+		e.do = false
+	case 0x1:
+		// WRITE, once the data has been shifted in
+		if e.bits < 27 {
+			return
+		}
+		if e.writeEnabled {
+			e.setWord(addr, data)
+		}
+		e.do = true
+	case 0x3:
+		// ERASE
+		if e.writeEnabled {
+			e.setWord(addr, 0xffff)
+		}
+		e.do = true
+	case 0x0:
+		switch (header >> 6) & 0x03 {
+		case 0x0:
+			// EWDS
+			e.writeEnabled = false
+		case 0x3:
+			// EWEN
+			e.writeEnabled = true
+		case 0x2:
+			// ERAL
+			if e.writeEnabled {
+				for addr := uint8(0); addr < 0x80; addr++ {
+					e.setWord(addr, 0xffff)
+				}
+			}
+			e.do = true
+		case 0x1:
+			// WRAL, once the data has been shifted in
+			if e.bits < 27 {
+				return
+			}
+			if e.writeEnabled {
+				for addr := uint8(0); addr < 0x80; addr++ {
+					e.setWord(addr, data)
+				}
+			}
+			e.do = true
+		}
+	}
+	e.command = 0
+	e.bits = 0
+}
+
+func (e *eeprom) word(addr uint8) uint16 {
+	return uint16(e.data[int(addr)*2]) | uint16(e.data[int(addr)*2+1])<<8
+}
+
+func (e *eeprom) setWord(addr uint8, value uint16) {
+	e.data[int(addr)*2] = uint8(value)
+	e.data[int(addr)*2+1] = uint8(value >> 8)
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
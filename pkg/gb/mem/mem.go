@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/scottyw/tetromino/pkg/gb/audio"
 	"github.com/scottyw/tetromino/pkg/gb/timer"
@@ -15,50 +16,122 @@ const (
 	// Register constants
 	//
 
-	JOYP = 0xFF00
-	SB   = 0xFF01
-	SC   = 0xFF02
-	DIV  = 0xFF04
-	TIMA = 0xFF05
-	TMA  = 0xFF06
-	TAC  = 0xFF07
-	IF   = 0xFF0F
-	NR10 = 0xFF10
-	NR11 = 0xFF11
-	NR12 = 0xFF12
-	NR13 = 0xFF13
-	NR14 = 0xFF14
-	NR21 = 0xFF16
-	NR22 = 0xFF17
-	NR23 = 0xFF18
-	NR24 = 0xFF19
-	NR30 = 0xFF1A
-	NR31 = 0xFF1B
-	NR32 = 0xFF1C
-	NR33 = 0xFF1D
-	NR34 = 0xFF1E
-	NR41 = 0xFF20
-	NR42 = 0xFF21
-	NR43 = 0xFF22
-	NR44 = 0xFF23
-	NR50 = 0xFF24
-	NR51 = 0xFF25
-	NR52 = 0xFF26
-	LCDC = 0xFF40
-	STAT = 0xFF41
-	SCY  = 0xFF42
-	SCX  = 0xFF43
-	LY   = 0xFF44
-	LYC  = 0xFF45
-	DMA  = 0xFF46
-	BGP  = 0xFF47
-	OBP0 = 0xFF48
-	OBP1 = 0xFF49
-	WY   = 0xFF4A
-	WX   = 0xFF4B
-	IE   = 0xFFFF
+	JOYP  = 0xFF00
+	SB    = 0xFF01
+	SC    = 0xFF02
+	DIV   = 0xFF04
+	TIMA  = 0xFF05
+	TMA   = 0xFF06
+	TAC   = 0xFF07
+	IF    = 0xFF0F
+	NR10  = 0xFF10
+	NR11  = 0xFF11
+	NR12  = 0xFF12
+	NR13  = 0xFF13
+	NR14  = 0xFF14
+	NR21  = 0xFF16
+	NR22  = 0xFF17
+	NR23  = 0xFF18
+	NR24  = 0xFF19
+	NR30  = 0xFF1A
+	NR31  = 0xFF1B
+	NR32  = 0xFF1C
+	NR33  = 0xFF1D
+	NR34  = 0xFF1E
+	NR41  = 0xFF20
+	NR42  = 0xFF21
+	NR43  = 0xFF22
+	NR44  = 0xFF23
+	NR50  = 0xFF24
+	NR51  = 0xFF25
+	NR52  = 0xFF26
+	LCDC  = 0xFF40
+	STAT  = 0xFF41
+	SCY   = 0xFF42
+	SCX   = 0xFF43
+	LY    = 0xFF44
+	LYC   = 0xFF45
+	DMA   = 0xFF46
+	BGP   = 0xFF47
+	OBP0  = 0xFF48
+	OBP1  = 0xFF49
+	WY    = 0xFF4A
+	WX    = 0xFF4B
+	KEY1  = 0xFF4D
+	VBK   = 0xFF4F
+	HDMA1 = 0xFF51
+	HDMA2 = 0xFF52
+	HDMA3 = 0xFF53
+	HDMA4 = 0xFF54
+	HDMA5 = 0xFF55
+	RP    = 0xFF56
+	BCPS  = 0xFF68
+	BCPD  = 0xFF69
+	OCPS  = 0xFF6A
+	OCPD  = 0xFF6B
+	SVBK  = 0xFF70
+	IE    = 0xFFFF
 )
 
+// RegisterNames maps the addresses of the hardware registers to their names
+var RegisterNames = map[uint16]string{
+	JOYP:  "JOYP",
+	SB:    "SB",
+	SC:    "SC",
+	DIV:   "DIV",
+	TIMA:  "TIMA",
+	TMA:   "TMA",
+	TAC:   "TAC",
+	IF:    "IF",
+	NR10:  "NR10",
+	NR11:  "NR11",
+	NR12:  "NR12",
+	NR13:  "NR13",
+	NR14:  "NR14",
+	NR21:  "NR21",
+	NR22:  "NR22",
+	NR23:  "NR23",
+	NR24:  "NR24",
+	NR30:  "NR30",
+	NR31:  "NR31",
+	NR32:  "NR32",
+	NR33:  "NR33",
+	NR34:  "NR34",
+	NR41:  "NR41",
+	NR42:  "NR42",
+	NR43:  "NR43",
+	NR44:  "NR44",
+	NR50:  "NR50",
+	NR51:  "NR51",
+	NR52:  "NR52",
+	LCDC:  "LCDC",
+	STAT:  "STAT",
+	SCY:   "SCY",
+	SCX:   "SCX",
+	LY:    "LY",
+	LYC:   "LYC",
+	DMA:   "DMA",
+	BGP:   "BGP",
+	OBP0:  "OBP0",
+	OBP1:  "OBP1",
+	WY:    "WY",
+	WX:    "WX",
+	KEY1:  "KEY1",
+	VBK:   "VBK",
+	HDMA1: "HDMA1",
+	HDMA2: "HDMA2",
+	HDMA3: "HDMA3",
+	HDMA4: "HDMA4",
+	HDMA5: "HDMA5",
+	RP:    "RP",
+	BCPS:  "BCPS",
+	BCPD:  "BCPD",
+	OCPS:  "OCPS",
+	OCPD:  "OCPD",
+	SVBK:  "SVBK",
+	IE:    "IE",
+}
+
 // Memory allows read and write access to memory
 type Memory struct {
 
@@ -81,33 +154,84 @@ type Memory struct {
 	OBP1 byte
 
 	// Implementation
-	mbc               *mbc
-	VideoRAM          [0x2000]byte
-	internalRAM       [0x2000]byte
+	mbc *mbc
+	// VideoRAM holds both banks of video RAM although a DMG only uses bank 0
+	VideoRAM [2][0x2000]byte
+	// internalRAM holds eight 4KB banks of work RAM although a DMG only uses banks 0 and 1
+	internalRAM [8][0x1000]byte
+	// BGPaletteRAM and OBJPaletteRAM hold eight palettes of four RGB555 colours each on a CGB
+	BGPaletteRAM      [0x40]byte
+	OBJPaletteRAM     [0x40]byte
 	OAM               [0xa0]byte
 	zeroPage          [0x8f]byte
 	WriteNotification WriteNotification
-	oamRunning        bool
-	oamCycle          uint16
-	oamBaseAddr       uint16
-	oamRead           uint8
-	DirectionInput    uint8 // JOYP
-	ButtonInput       uint8 // JOYP
-	timer             *timer.Timer
-	audio             *audio.Audio
-	sbWriter          io.Writer
+	ROMPatch          ROMPatch
+	JoypadPort        JoypadPort
+	InfraredPort      InfraredPort
+	SerialPort        SerialPort
+	// OnSerial is called with the bytes shifted out and in when a serial transfer completes
+	OnSerial func(sent, received uint8)
+	// PollInput is called before JOYP is read so that its input lines can be brought up to date
+	PollInput      func()
+	hooks          []Hooks
+	oamRunning     bool
+	oamCycle       uint16
+	oamBaseAddr    uint16
+	oamRead        uint8
+	DirectionInput [4]uint8 // JOYP for each controller attached through a Super Game Boy
+	ButtonInput    [4]uint8 // JOYP for each controller attached through a Super Game Boy
+	joypadLines    uint8    // The input lines of JOYP the last time they changed
+	timer          *timer.Timer
+	audio          *audio.Audio
+	sbWriter       io.Writer
+	cgb            cgbState
+	serial         serialState
 }
 
 // WriteNotification provides a mechanism to notify other subsystems about memory writes
 type WriteNotification interface {
-	WriteToVideoRAM(addr uint16)
+	WriteToVideoRAM(bank int, addr uint16)
+}
+
+// ROMPatch provides a mechanism to intercept values read from ROM
+type ROMPatch interface {
+	PatchROM(addr uint16, value uint8) uint8
+}
+
+// JoypadPort provides a mechanism for a Super Game Boy to receive packets written to JOYP and to
+// choose which controller is read
+type JoypadPort interface {
+	WriteJOYP(value uint8)
+	Player() int
+}
+
+// InfraredPort provides a mechanism for the Game Boy Color's infrared port to shine its LED at another
+// device and to sense light from it
+type InfraredPort interface {
+	SetLED(on bool)
+	Receiving() bool
+}
+
+// Hooks provides a mechanism for tools to observe memory reads and writes made by the CPU and DMA
+type Hooks interface {
+	OnRead(addr uint16, value byte)
+	OnWrite(addr uint16, value byte)
+}
+
+// AddHooks registers hooks that observe every memory read and write
+func (m *Memory) AddHooks(hooks Hooks) {
+	m.hooks = append(m.hooks, hooks)
 }
 
 // NewMemory creates the memory struct and initializes it with ROM contents and default values
-func NewMemory(rom []byte, sbWriter io.Writer, timer *timer.Timer, audio *audio.Audio) *Memory {
+func NewMemory(rom []byte, sbWriter io.Writer, timer *timer.Timer, audio *audio.Audio) (*Memory, error) {
 	if sbWriter == nil {
 		sbWriter = ioutil.Discard
 	}
+	mbc, err := newMBC(rom)
+	if err != nil {
+		return nil, err
+	}
 	return &Memory{
 
 		// HW register defaults
@@ -129,17 +253,30 @@ func NewMemory(rom []byte, sbWriter io.Writer, timer *timer.Timer, audio *audio.
 		OBP1: 0xff,
 
 		// Implementation
-		mbc:            newMBC(rom),
-		DirectionInput: 0x0f,
-		ButtonInput:    0x0f,
+		mbc:            mbc,
+		DirectionInput: [4]uint8{0x0f, 0x0f, 0x0f, 0x0f},
+		ButtonInput:    [4]uint8{0x0f, 0x0f, 0x0f, 0x0f},
+		joypadLines:    0x0f,
 		timer:          timer,
 		audio:          audio,
 		sbWriter:       sbWriter,
+	}, nil
+}
+
+// PendingInterrupts returns the interrupts that the CPU sees when it checks for them before an
+// instruction. The CPU checks at the end of the machine cycle that fetches the opcode, so it also
+// sees the timer interrupt requested as TIMA is reloaded at the end of that cycle, which reads of IF
+// during the cycle don't.
+func (m *Memory) PendingInterrupts() uint8 {
+	if m.timer.Reloading() {
+		return m.IF | 0x04
 	}
+	return m.IF
 }
 
-// ExecuteMachineCycle updates the OAM after a machine cycle
+// ExecuteMachineCycle updates the OAM and serial port after a machine cycle
 func (m *Memory) ExecuteMachineCycle() {
+	m.stepSerial()
 	if m.oamRunning {
 		if m.oamCycle == 0 {
 			// Setup
@@ -166,30 +303,125 @@ func (m *Memory) startOAM(value uint8) {
 }
 
 func (m *Memory) readJOYP() uint8 {
+	if m.PollInput != nil {
+		m.PollInput()
+	}
 	// Bit 5 - P15 Select Button Keys      (0=Select)
 	// Bit 4 - P14 Select Direction Keys   (0=Select)
+	player := 0
+	if m.JoypadPort != nil {
+		player = m.JoypadPort.Player()
+	}
 	if m.JOYP&0x10 == 0 {
-		return m.JOYP&0xf0 | m.DirectionInput&0x0f
+		return m.JOYP&0xf0 | m.DirectionInput[player]&0x0f
 	}
 	if m.JOYP&0x20 == 0 {
-		return m.JOYP&0xf0 | m.ButtonInput&0x0f
+		return m.JOYP&0xf0 | m.ButtonInput[player]&0x0f
+	}
+	if m.JoypadPort != nil {
+		// The low bits identify the controller being read once a game requests multiplayer
+		return m.JOYP&0xf0 | 0x0f - uint8(player)
 	}
 	return m.JOYP | 0x0f
 }
 
+// inputLines returns the state of the input lines of JOYP, where any pressed key that is selected
+// pulls its line low
+func (m *Memory) inputLines() uint8 {
+	player := 0
+	if m.JoypadPort != nil {
+		player = m.JoypadPort.Player()
+	}
+	lines := uint8(0x0f)
+	if m.JOYP&0x10 == 0 {
+		lines &= m.DirectionInput[player]
+	}
+	if m.JOYP&0x20 == 0 {
+		lines &= m.ButtonInput[player]
+	}
+	return lines & 0x0f
+}
+
+// ResetDivider resets the divider shared by the timer and the APU, as writing DIV or executing STOP does
+func (m *Memory) ResetDivider() {
+	m.timer.Reset()
+}
+
+// InputChanged requests the joypad interrupt when an input line of JOYP falls, either because a key
+// was pressed or because a group of keys with one held was selected. It's called whenever either changes.
+func (m *Memory) InputChanged() {
+	lines := m.inputLines()
+	if m.joypadLines&^lines != 0 {
+		m.IF |= 0x10
+	}
+	m.joypadLines = lines
+}
+
 // Read a byte from the chosen memory location
 func (m *Memory) Read(addr uint16) byte {
+	value := m.read(addr)
+	for _, hooks := range m.hooks {
+		hooks.OnRead(addr, value)
+	}
+	return value
+}
+
+// BankSwitches returns the number of cartridge writes so far that changed the selected ROM or RAM bank
+func (m *Memory) BankSwitches() uint64 {
+	return m.mbc.bankSwitches
+}
+
+// Banks returns the ROM banks mapped at 0000-3FFF and 4000-7FFF and the RAM bank mapped at A000-BFFF
+func (m *Memory) Banks() (int, int, int) {
+	return m.mbc.romBank0, m.mbc.romBankX, m.mbc.ramBank
+}
+
+// ROMBank returns the ROM bank mapped at an address, or false if the address is not in ROM
+func (m *Memory) ROMBank(addr uint16) (int, bool) {
+	switch {
+	case addr < 0x4000:
+		return m.mbc.romBank0, true
+	case addr < 0x8000:
+		return m.mbc.romBankX, true
+	}
+	return 0, false
+}
+
+// ROMBanks returns the number of 16KB ROM banks on the cartridge
+func (m *Memory) ROMBanks() int {
+	return len(m.mbc.rom)
+}
+
+// Peek reads a byte without notifying hooks, so that debuggers don't disturb what they observe
+func (m *Memory) Peek(addr uint16) byte {
+	return m.read(addr)
+}
+
+// internalRAMBank returns the bank and offset of a work RAM address, including the echo at E000-FDFF
+func (m *Memory) internalRAMBank(addr uint16) (uint8, uint16) {
+	if addr >= 0xe000 {
+		addr -= 0x2000
+	}
+	if addr < 0xd000 {
+		return 0, addr - 0xc000
+	}
+	return m.cgb.internalRAMBank(), addr - 0xd000
+}
+
+func (m *Memory) read(addr uint16) byte {
 	switch {
 	case addr < 0x8000:
+		if m.ROMPatch != nil {
+			return m.ROMPatch.PatchROM(addr, m.mbc.read(addr))
+		}
 		return m.mbc.read(addr)
 	case addr < 0xa000:
-		return m.VideoRAM[addr-0x8000]
+		return m.VideoRAM[m.cgb.videoRAMBank][addr-0x8000]
 	case addr < 0xc000:
 		return m.mbc.read(addr)
-	case addr < 0xe000:
-		return m.internalRAM[addr-0xc000]
 	case addr < 0xfe00:
-		return m.internalRAM[addr-0xe000]
+		bank, offset := m.internalRAMBank(addr)
+		return m.internalRAM[bank][offset]
 	case addr < 0xfea0:
 		if m.oamRunning {
 			return 0xff
@@ -289,6 +521,8 @@ func (m *Memory) Read(addr uint16) byte {
 		return m.WY
 	case addr == WX:
 		return m.WX
+	case m.cgb.enabled && isCGBRegister(addr):
+		return m.readCGBRegister(addr)
 	case addr < 0xff80:
 		// Default if a non-hardware register is read
 		return 0xff
@@ -303,35 +537,48 @@ func (m *Memory) Read(addr uint16) byte {
 
 // Write a byte to the chosen memory location
 func (m *Memory) Write(addr uint16, value byte) {
+	for _, hooks := range m.hooks {
+		hooks.OnWrite(addr, value)
+	}
+	m.write(addr, value)
+}
+
+func (m *Memory) write(addr uint16, value byte) {
 	switch {
 	case addr < 0x8000:
 		m.mbc.write(addr, value)
 	case addr < 0xa000:
-		if m.WriteNotification != nil {
-			m.WriteNotification.WriteToVideoRAM(addr)
+		bank := int(m.cgb.videoRAMBank)
+		// Writing the same value again leaves anything decoded from VRAM up to date
+		if m.WriteNotification != nil && m.VideoRAM[bank][addr-0x8000] != value {
+			m.WriteNotification.WriteToVideoRAM(bank, addr)
 		}
-		m.VideoRAM[addr-0x8000] = value
+		m.VideoRAM[bank][addr-0x8000] = value
 	case addr < 0xc000:
 		m.mbc.write(addr, value)
-	case addr < 0xe000:
-		m.internalRAM[addr-0xc000] = value
 	case addr < 0xfe00:
-		m.internalRAM[addr-0xe000] = value
+		bank, offset := m.internalRAMBank(addr)
+		m.internalRAM[bank][offset] = value
 	case addr < 0xfea0:
 		m.OAM[addr-0xfe00] = value
 	case addr < 0xff00:
 		// Unusable region
 	case addr == JOYP:
 		m.JOYP = value
+		if m.JoypadPort != nil {
+			m.JoypadPort.WriteJOYP(value)
+		}
+		m.InputChanged()
 	case addr == SB:
+		m.SB = value
 		_, err := m.sbWriter.Write([]byte{value})
 		if err != nil {
 			panic(fmt.Sprintf("Write to SB failed: %v", err))
 		}
 	case addr == SC:
-		// FIXME serial bus support
+		m.writeSC(value)
 	case addr == DIV:
-		m.timer.Reset()
+		m.ResetDivider()
 	case addr == TIMA:
 		m.timer.WriteTIMA(value)
 	case addr == TMA:
@@ -401,15 +648,17 @@ func (m *Memory) Write(addr uint16, value byte) {
 	case addr == DMA:
 		m.startOAM(value)
 	case addr == BGP:
-		// FIXME palette support
+		m.BGP = value
 	case addr == OBP0:
-		// FIXME sprite palette support
+		m.OBP0 = value
 	case addr == OBP1:
-		// FIXME sprite palette support
+		m.OBP1 = value
 	case addr == WY:
 		m.WY = value
 	case addr == WX:
 		m.WX = value
+	case m.cgb.enabled && isCGBRegister(addr):
+		m.writeCGBRegister(addr, value)
 	case addr < 0xff80:
 		// Do nothing if a non-hardware register is written
 	case addr < 0xffff:
@@ -425,3 +674,83 @@ func (m *Memory) Write(addr uint16, value byte) {
 func (m *Memory) CartRAM() [][0x2000]byte {
 	return m.mbc.ram
 }
+
+// HasBattery returns true if the cartridge RAM is battery-backed and so should be saved
+func (m *Memory) HasBattery() bool {
+	return m.mbc.battery
+}
+
+// HasAccelerometer returns true for MBC7 carts, which are played by tilting them
+func (m *Memory) HasAccelerometer() bool {
+	return m.mbc.mbc7 != nil
+}
+
+// Tilt sets how far an MBC7 cart is tilted, from -1 to 1, with positive x tilting it right and
+// positive y tilting it down towards the player
+func (m *Memory) Tilt(x, y float64) {
+	if m.mbc.mbc7 != nil {
+		m.mbc.mbc7.tiltX, m.mbc.mbc7.tiltY = clampTilt(x), clampTilt(y)
+	}
+}
+
+func clampTilt(v float64) float64 {
+	switch {
+	case v < -1:
+		return -1
+	case v > 1:
+		return 1
+	}
+	return v
+}
+
+// rtcFooterSize is the size of the real-time clock state at the end of the battery saves of MBC3 carts
+// with a timer, as written by VBA, BGB and SameBoy: the seconds, minutes, hours, low and high day
+// registers then the same latched registers as 32-bit values, followed by a 64-bit Unix timestamp.
+// Some emulators write a 32-bit timestamp instead.
+const rtcFooterSize = 48
+
+// BatteryRAM returns a copy of the cartridge RAM for saving, in the format used by other emulators
+// such as VBA and SameBoy so that saves can be moved between them
+func (m *Memory) BatteryRAM() []byte {
+	data := make([]byte, 0, m.mbc.saveSize+rtcFooterSize)
+	for _, bank := range m.mbc.ram {
+		data = append(data, bank[:]...)
+	}
+	data = data[:m.mbc.saveSize]
+	if m.mbc.rtc != nil {
+		data = append(data, m.mbc.rtc.footer(time.Now())...)
+	}
+	return data
+}
+
+// Header returns the cartridge header that the memory bank controller was chosen from
+func (m *Memory) Header() Header {
+	return m.mbc.header
+}
+
+// RunClock advances the real-time clock of an MBC3 cart by a number of machine cycles at normal speed
+func (m *Memory) RunClock(cycles int) {
+	if m.mbc.rtc != nil {
+		m.mbc.rtc.run(cycles)
+	}
+}
+
+// LoadBatteryRAM restores cartridge RAM from a save made by Tetromino or by another emulator, along
+// with any real-time clock state at its end
+func (m *Memory) LoadBatteryRAM(data []byte) error {
+	size := m.mbc.saveSize
+	footer := len(data) - size
+	switch {
+	case footer == 0:
+	case m.mbc.rtc != nil && (footer == rtcFooterSize || footer == rtcFooterSize-4):
+		m.mbc.rtc.load(data[size:], time.Now())
+	case len(data) == len(m.mbc.ram)*0x2000:
+		// Tetromino used to save whole banks of RAM even when the cart has less
+	default:
+		return fmt.Errorf("save size does not match cartridge RAM size: Actual=0x%04x Expected=0x%04x", len(data), size)
+	}
+	for i := 0; i < size; i++ {
+		m.mbc.ram[i/0x2000][i%0x2000] = data[i]
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package mem
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// cyclesPerSecond is the number of machine cycles in a second at normal speed
+const cyclesPerSecond = 1 << 20
+
+// RTC registers selected by writing to 0x4000-0x5fff
+const (
+	rtcS  = 0x08
+	rtcM  = 0x09
+	rtcH  = 0x0a
+	rtcDL = 0x0b
+	rtcDH = 0x0c
+)
+
+// rtcMasks are the bits used by each RTC register
+var rtcMasks = [5]uint8{0x3f, 0x3f, 0x1f, 0xff, 0xc1}
+
+// rtc is the real-time clock of MBC3 carts with a timer, which counts seconds, minutes, hours and up to
+// 511 days. The clock runs with the emulated time so that runs are repeatable, and catches up with the
+// time that passed while the game was off when a battery save is loaded.
+type rtc struct {
+	// regs are the seconds, minutes, hours, low and high day registers as the clock counts them
+	regs [5]uint8
+	// latched are the registers that the game reads, copied from regs when 0 then 1 is written to
+	// 0x6000-0x7fff
+	latched [5]uint8
+	// cycles counts the machine cycles towards the next second
+	cycles int
+}
+
+func (r *rtc) halted() bool {
+	return r.regs[4]&0x40 != 0
+}
+
+func (r *rtc) days() int {
+	return int(r.regs[4]&0x01)<<8 | int(r.regs[3])
+}
+
+func (r *rtc) setDays(days int) {
+	r.regs[3] = uint8(days)
+	r.regs[4] = r.regs[4]&^0x01 | uint8(days>>8)&0x01
+}
+
+// read returns a latched register
+func (r *rtc) read(reg uint8) uint8 {
+	return r.latched[reg-rtcS]
+}
+
+// write sets a register of the running clock
+func (r *rtc) write(reg, value uint8) {
+	r.regs[reg-rtcS] = value & rtcMasks[reg-rtcS]
+	if reg == rtcS {
+		// Writing the seconds restarts the second being counted
+		r.cycles = 0
+	}
+}
+
+func (r *rtc) latch() {
+	r.latched = r.regs
+}
+
+// run advances the clock by a number of machine cycles
+func (r *rtc) run(cycles int) {
+	if r.halted() {
+		return
+	}
+	r.cycles += cycles
+	for r.cycles >= cyclesPerSecond {
+		r.cycles -= cyclesPerSecond
+		r.tick()
+	}
+}
+
+// tick advances the clock by a second. Registers set beyond their usual range count up to the
+// limit of their bits and wrap to 0 without carrying, as the hardware does.
+func (r *rtc) tick() {
+	r.regs[0] = (r.regs[0] + 1) & 0x3f
+	if r.regs[0] != 60 {
+		return
+	}
+	r.regs[0] = 0
+	r.regs[1] = (r.regs[1] + 1) & 0x3f
+	if r.regs[1] != 60 {
+		return
+	}
+	r.regs[1] = 0
+	r.regs[2] = (r.regs[2] + 1) & 0x1f
+	if r.regs[2] != 24 {
+		return
+	}
+	r.regs[2] = 0
+	r.addDays(1)
+}
+
+// addDays advances the day counter, setting the carry bit when it overflows
+func (r *rtc) addDays(days int) {
+	days += r.days()
+	if days >= 512 {
+		r.regs[4] |= 0x80
+	}
+	r.setDays(days % 512)
+}
+
+// catchUp advances the clock by the seconds that passed while the emulator wasn't running
+func (r *rtc) catchUp(seconds int64) {
+	if r.halted() || seconds <= 0 {
+		return
+	}
+	// Tick through any registers that are out of range until they wrap
+	for ; seconds > 0 && (r.regs[0] >= 60 || r.regs[1] >= 60 || r.regs[2] >= 24); seconds-- {
+		r.tick()
+	}
+	total := int64(r.regs[0]) + 60*int64(r.regs[1]) + 3600*int64(r.regs[2]) + seconds
+	r.regs[0] = uint8(total % 60)
+	r.regs[1] = uint8(total / 60 % 60)
+	r.regs[2] = uint8(total / 3600 % 24)
+	// Once the day counter has overflowed only the days modulo 512 change it
+	days := total / 86400
+	if days >= 512 {
+		r.regs[4] |= 0x80
+		days %= 512
+	}
+	r.addDays(int(days))
+}
+
+// footer returns the state of the clock as saved at the end of a battery save, as described for
+// rtcFooterSize
+func (r *rtc) footer(now time.Time) []byte {
+	data := make([]byte, rtcFooterSize)
+	for i := 0; i < 5; i++ {
+		binary.LittleEndian.PutUint32(data[4*i:], uint32(r.regs[i]))
+		binary.LittleEndian.PutUint32(data[20+4*i:], uint32(r.latched[i]))
+	}
+	binary.LittleEndian.PutUint64(data[40:], uint64(now.Unix()))
+	return data
+}
+
+// load restores the state of the clock from the end of a battery save and catches up with the time
+// since the save was made
+func (r *rtc) load(data []byte, now time.Time) {
+	for i := 0; i < 5; i++ {
+		r.regs[i] = uint8(binary.LittleEndian.Uint32(data[4*i:])) & rtcMasks[i]
+		r.latched[i] = uint8(binary.LittleEndian.Uint32(data[20+4*i:])) & rtcMasks[i]
+	}
+	var saved int64
+	if len(data) == rtcFooterSize {
+		saved = int64(binary.LittleEndian.Uint64(data[40:]))
+	} else {
+		saved = int64(binary.LittleEndian.Uint32(data[40:]))
+	}
+	r.catchUp(now.Unix() - saved)
+}
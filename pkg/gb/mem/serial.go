@@ -0,0 +1,98 @@
+package mem
+
+// SerialPort provides a mechanism for the serial port to exchange bytes with another device over a
+// link cable. When the port is nil the cable is unplugged, so transfers using the internal clock shift
+// in 0xff and transfers waiting on an external clock never complete.
+type SerialPort interface {
+	// Exchange is called when a transfer is started using the internal clock and returns the byte
+	// that the other device shifts in while the byte written to SB is shifted out
+	Exchange(out uint8) uint8
+	// Clocked is polled while a transfer waits on an external clock and returns the byte shifted in
+	// once the other device has clocked it, or false while it hasn't yet
+	Clocked(out uint8) (uint8, bool)
+}
+
+// serialState tracks a transfer in progress
+type serialState struct {
+	active bool
+	bits   int   // Bits shifted so far
+	cycles int   // Machine cycles until the next bit is shifted
+	in     uint8 // The byte being shifted in from the other device
+	out    uint8 // The byte in SB when the transfer started
+}
+
+// The internal clock shifts one bit every 128 machine cycles (8192Hz) or every 4 machine cycles
+// (262144Hz) when a CGB sets the fast clock bit
+const (
+	serialCycles     = 128
+	serialFastCycles = 4
+)
+
+func (m *Memory) writeSC(value uint8) {
+	if m.cgb.enabled {
+		m.SC = value | 0x7c
+	} else {
+		m.SC = value | 0x7e
+	}
+	if m.SC&0x80 == 0 {
+		m.serial.active = false
+		return
+	}
+	m.serial = serialState{active: true, out: m.SB}
+	if m.SC&0x01 == 0 {
+		// The other device drives the clock
+		return
+	}
+	m.serial.in = 0xff
+	if m.SerialPort != nil {
+		m.serial.in = m.SerialPort.Exchange(m.SB)
+	}
+	m.serial.cycles = m.serialPeriod()
+}
+
+func (m *Memory) serialPeriod() int {
+	if m.cgb.enabled && m.SC&0x02 != 0 {
+		return serialFastCycles
+	}
+	return serialCycles
+}
+
+// stepSerial shifts bits of a transfer in progress through SB and requests the serial interrupt when
+// all eight have been shifted
+func (m *Memory) stepSerial() {
+	if !m.serial.active {
+		return
+	}
+	if m.SC&0x01 == 0 {
+		if m.SerialPort == nil {
+			return
+		}
+		in, ok := m.SerialPort.Clocked(m.SB)
+		if !ok {
+			return
+		}
+		m.SB = in
+		m.endSerial()
+		return
+	}
+	m.serial.cycles--
+	if m.serial.cycles > 0 {
+		return
+	}
+	m.serial.cycles = m.serialPeriod()
+	// Bits are shifted out and in most significant first
+	m.SB = m.SB<<1 | m.serial.in>>(7-m.serial.bits)&0x01
+	m.serial.bits++
+	if m.serial.bits == 8 {
+		m.endSerial()
+	}
+}
+
+func (m *Memory) endSerial() {
+	m.serial.active = false
+	m.SC &^= 0x80
+	m.IF |= 0x08
+	if m.OnSerial != nil {
+		m.OnSerial(m.serial.out, m.SB)
+	}
+}
@@ -0,0 +1,189 @@
+package mem
+
+import "fmt"
+
+// State is a snapshot of memory, the hardware registers it holds and the cartridge's bank controller,
+// for save states
+type State struct {
+	IE, IF, LCDC, LY, LYC, SCX, SCY, STAT, WX, WY, JOYP, SB, SC, BGP, OBP0, OBP1 uint8
+
+	VideoRAM      [2][0x2000]byte
+	InternalRAM   [8][0x1000]byte
+	BGPaletteRAM  [0x40]byte
+	OBJPaletteRAM [0x40]byte
+	OAM           [0xa0]byte
+	ZeroPage      [0x8f]byte
+	JoypadLines   uint8
+
+	// OAM DMA in progress
+	OAMRunning  bool
+	OAMCycle    uint16
+	OAMBaseAddr uint16
+	OAMRead     uint8
+
+	// CGB registers and HDMA in progress
+	VideoRAMBank uint8
+	WRAMBank     uint8
+	SpeedSwitch  bool
+	DoubleSpeed  bool
+	BCPS, OCPS   uint8
+	HDMASource   uint16
+	HDMADest     uint16
+	HDMABlocks   int
+	HDMAHBlank   bool
+	RP           uint8
+
+	// Serial transfer in progress
+	SerialActive bool
+	SerialBits   int
+	SerialCycles int
+	SerialIn     uint8
+	SerialOut    uint8
+
+	// Cartridge RAM and the values written to the bank controller
+	RAM                                                            [][0x2000]byte
+	EnabledRegion, ROMRegion, ROMHighRegion, RAMRegion, ModeRegion uint8
+	BankSwitches                                                   uint64
+
+	// Real-time clock of MBC3 carts with a timer
+	RTC        [5]uint8
+	RTCLatched [5]uint8
+	RTCCycles  int
+
+	// EEPROM and accelerometer of MBC7 carts
+	EEPROMPins         uint8
+	EEPROMWriteEnabled bool
+	EEPROMCommand      uint32
+	EEPROMBits         int
+	EEPROMOut          uint16
+	EEPROMReading      int
+	EEPROMNext         uint8
+	AccelErased        bool
+	AccelX, AccelY     uint16
+}
+
+// Snapshot returns the state of memory
+func (m *Memory) Snapshot() State {
+	s := State{
+		IE: m.IE, IF: m.IF, LCDC: m.LCDC, LY: m.LY, LYC: m.LYC, SCX: m.SCX, SCY: m.SCY, STAT: m.STAT,
+		WX: m.WX, WY: m.WY, JOYP: m.JOYP, SB: m.SB, SC: m.SC, BGP: m.BGP, OBP0: m.OBP0, OBP1: m.OBP1,
+
+		VideoRAM:      m.VideoRAM,
+		InternalRAM:   m.internalRAM,
+		BGPaletteRAM:  m.BGPaletteRAM,
+		OBJPaletteRAM: m.OBJPaletteRAM,
+		OAM:           m.OAM,
+		ZeroPage:      m.zeroPage,
+		JoypadLines:   m.joypadLines,
+
+		OAMRunning:  m.oamRunning,
+		OAMCycle:    m.oamCycle,
+		OAMBaseAddr: m.oamBaseAddr,
+		OAMRead:     m.oamRead,
+
+		VideoRAMBank: m.cgb.videoRAMBank,
+		WRAMBank:     m.cgb.wramBank,
+		SpeedSwitch:  m.cgb.speedSwitch,
+		DoubleSpeed:  m.cgb.doubleSpeed,
+		BCPS:         m.cgb.bcps,
+		OCPS:         m.cgb.ocps,
+		HDMASource:   m.cgb.hdmaSource,
+		HDMADest:     m.cgb.hdmaDest,
+		HDMABlocks:   m.cgb.hdmaBlocks,
+		HDMAHBlank:   m.cgb.hdmaHBlank,
+		RP:           m.cgb.rp,
+
+		SerialActive: m.serial.active,
+		SerialBits:   m.serial.bits,
+		SerialCycles: m.serial.cycles,
+		SerialIn:     m.serial.in,
+		SerialOut:    m.serial.out,
+
+		RAM:           append([][0x2000]byte(nil), m.mbc.ram...),
+		EnabledRegion: m.mbc.enabledRegion,
+		ROMRegion:     m.mbc.romRegion,
+		ROMHighRegion: m.mbc.romHighRegion,
+		RAMRegion:     m.mbc.ramRegion,
+		ModeRegion:    m.mbc.modeRegion,
+		BankSwitches:  m.mbc.bankSwitches,
+	}
+	if r := m.mbc.rtc; r != nil {
+		s.RTC, s.RTCLatched, s.RTCCycles = r.regs, r.latched, r.cycles
+	}
+	if c := m.mbc.mbc7; c != nil {
+		s.EEPROMPins = c.eeprom.pins()
+		s.EEPROMWriteEnabled = c.eeprom.writeEnabled
+		s.EEPROMCommand, s.EEPROMBits = c.eeprom.command, c.eeprom.bits
+		s.EEPROMOut, s.EEPROMReading, s.EEPROMNext = c.eeprom.out, c.eeprom.reading, c.eeprom.next
+		s.AccelErased, s.AccelX, s.AccelY = c.erased, c.x, c.y
+	}
+	return s
+}
+
+// Restore returns memory to a state taken by Snapshot. The state must come from the same cartridge so
+// that the RAM banks match, otherwise memory is left unchanged and an error is returned.
+func (m *Memory) Restore(s State) error {
+	if len(s.RAM) != len(m.mbc.ram) {
+		return fmt.Errorf("state has %d RAM banks but the cartridge has %d", len(s.RAM), len(m.mbc.ram))
+	}
+
+	m.IE, m.IF, m.LCDC, m.LY, m.LYC, m.SCX, m.SCY, m.STAT = s.IE, s.IF, s.LCDC, s.LY, s.LYC, s.SCX, s.SCY, s.STAT
+	m.WX, m.WY, m.JOYP, m.SB, m.SC, m.BGP, m.OBP0, m.OBP1 = s.WX, s.WY, s.JOYP, s.SB, s.SC, s.BGP, s.OBP0, s.OBP1
+
+	m.VideoRAM = s.VideoRAM
+	m.internalRAM = s.InternalRAM
+	m.BGPaletteRAM = s.BGPaletteRAM
+	m.OBJPaletteRAM = s.OBJPaletteRAM
+	m.OAM = s.OAM
+	m.zeroPage = s.ZeroPage
+	m.joypadLines = s.JoypadLines
+
+	m.oamRunning = s.OAMRunning
+	m.oamCycle = s.OAMCycle
+	m.oamBaseAddr = s.OAMBaseAddr
+	m.oamRead = s.OAMRead
+
+	m.cgb.videoRAMBank = s.VideoRAMBank
+	m.cgb.wramBank = s.WRAMBank
+	m.cgb.speedSwitch = s.SpeedSwitch
+	m.cgb.doubleSpeed = s.DoubleSpeed
+	m.cgb.bcps = s.BCPS
+	m.cgb.ocps = s.OCPS
+	m.cgb.hdmaSource = s.HDMASource
+	m.cgb.hdmaDest = s.HDMADest
+	m.cgb.hdmaBlocks = s.HDMABlocks
+	m.cgb.hdmaHBlank = s.HDMAHBlank
+	m.cgb.rp = s.RP
+
+	m.serial = serialState{
+		active: s.SerialActive,
+		bits:   s.SerialBits,
+		cycles: s.SerialCycles,
+		in:     s.SerialIn,
+		out:    s.SerialOut,
+	}
+
+	// Copy into the existing banks because the MBC7 EEPROM points at the first one
+	copy(m.mbc.ram, s.RAM)
+	m.mbc.enabledRegion = s.EnabledRegion
+	m.mbc.romRegion = s.ROMRegion
+	m.mbc.romHighRegion = s.ROMHighRegion
+	m.mbc.ramRegion = s.RAMRegion
+	m.mbc.modeRegion = s.ModeRegion
+	m.mbc.update(m.mbc)
+	m.mbc.bankSwitches = s.BankSwitches
+	if r := m.mbc.rtc; r != nil {
+		r.regs, r.latched, r.cycles = s.RTC, s.RTCLatched, s.RTCCycles
+	}
+	if c := m.mbc.mbc7; c != nil {
+		c.eeprom.cs = s.EEPROMPins&0x80 != 0
+		c.eeprom.clk = s.EEPROMPins&0x40 != 0
+		c.eeprom.di = s.EEPROMPins&0x02 != 0
+		c.eeprom.do = s.EEPROMPins&0x01 != 0
+		c.eeprom.writeEnabled = s.EEPROMWriteEnabled
+		c.eeprom.command, c.eeprom.bits = s.EEPROMCommand, s.EEPROMBits
+		c.eeprom.out, c.eeprom.reading, c.eeprom.next = s.EEPROMOut, s.EEPROMReading, s.EEPROMNext
+		c.erased, c.x, c.y = s.AccelErased, s.AccelX, s.AccelY
+	}
+	return nil
+}
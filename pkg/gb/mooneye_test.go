@@ -1,45 +1,39 @@
 package gb
 
 import (
-	"context"
-	"fmt"
 	"strings"
 	"testing"
-	"time"
 )
 
 func runMooneyeTest(t *testing.T, filename string) {
+	runMooneyeTestPPU(t, filename, false)
+}
+
+// runMooneyeTestPPU runs a test ROM, drawing with the pixel FIFO when accuratePPU is set
+func runMooneyeTestPPU(t *testing.T, filename string, accuratePPU bool) {
 	opts := Options{
 		RomFilename: "testdata/mooneye-gb_hwtests/" + filename,
+		AccuratePPU: accuratePPU,
+	}
+	// Allow up to 2 minutes of emulated time
+	gameboy, result, err := RunMooneyeTest(opts, 2*60*60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filename
+	if accuratePPU {
+		name = strings.Replace(name, ".gb", "-accurate.gb", 1)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	gameboy := NewGameboy(opts)
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if gameboy.dispatch.Mooneye {
-					cancel()
-					return
-				}
-			}
-			time.Sleep(10 * time.Millisecond)
-		}
-	}()
-	gameboy.Run(ctx)
-	<-ctx.Done()
-	screenshotFilename := fmt.Sprintf("testresults/%s.png", strings.Replace(filename, "/", "_", -1))
-	gameboy.lcd.Screenshot(screenshotFilename)
-	if gameboy.dispatch.TestA() != 0 || !gameboy.dispatch.Mooneye {
-		t.Errorf("Test ROM failed: %s", filename)
+	screenshotFilename := testResultFilename(t, name)
+	if err := gameboy.Screenshot(screenshotFilename); err != nil {
+		t.Error(err)
+	}
+	if !result.Passed {
+		t.Errorf("Test ROM failed: %s: %s", filename, result.Output)
 		// fmt.Printf("| :boom: fail | %s | [pic](pkg/gb/%s) |\n", filename, screenshotFilename)
 	} else {
 		// fmt.Printf("| :green_heart: pass | %s | [pic](pkg/gb/%s) |\n", filename, screenshotFilename)
 	}
-
 }
 
 func TestMooneye00(t *testing.T) { runMooneyeTest(t, "acceptance/add_sp_e_timing.gb") }
@@ -120,6 +114,24 @@ func TestMooneye41(t *testing.T) { runMooneyeTest(t, "acceptance/pop_timing.gb")
 
 // func TestMooneye47(t *testing.T) { runMooneyeTest(t, "acceptance/ppu/intr_2_mode3_timing.gb") }
 
+// The pixel FIFO passes some of the PPU timing tests
+
+func TestMooneye42Accurate(t *testing.T) {
+	runMooneyeTestPPU(t, "acceptance/ppu/hblank_ly_scx_timing-GS.gb", true)
+}
+
+func TestMooneye44Accurate(t *testing.T) {
+	runMooneyeTestPPU(t, "acceptance/ppu/intr_2_0_timing.gb", true)
+}
+
+func TestMooneye45Accurate(t *testing.T) {
+	runMooneyeTestPPU(t, "acceptance/ppu/intr_2_mode0_timing.gb", true)
+}
+
+func TestMooneye47Accurate(t *testing.T) {
+	runMooneyeTestPPU(t, "acceptance/ppu/intr_2_mode3_timing.gb", true)
+}
+
 // func TestMooneye48(t *testing.T) { runMooneyeTest(t, "acceptance/ppu/intr_2_oam_ok_timing.gb") }
 
 // func TestMooneye49(t *testing.T) { runMooneyeTest(t, "acceptance/ppu/lcdon_timing-dmgABCmgbS.gb") }
@@ -176,7 +188,7 @@ func TestMooneye74(t *testing.T) { runMooneyeTest(t, "acceptance/timer/tma_write
 
 func TestMooneye75(t *testing.T) { runMooneyeTest(t, "emulator-only/mbc1/bits_ram_en.gb") }
 
-// func TestMooneye76(t *testing.T) { runMooneyeTest(t, "emulator-only/mbc1/multicart_rom_8Mb.gb") }
+func TestMooneye76(t *testing.T) { runMooneyeTest(t, "emulator-only/mbc1/multicart_rom_8Mb.gb") }
 
 func TestMooneye77(t *testing.T) { runMooneyeTest(t, "emulator-only/mbc1/ram_256Kb.gb") }
 
@@ -0,0 +1,158 @@
+// Package overlay draws text and boxes over frames, either straight onto a frame or through a Layer
+// that collects drawings from scripts, notifications and the rest of the emulator and composites them
+// over each frame before it reaches the display
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// CharWidth and LineHeight are the size of each character of text
+const (
+	CharWidth  = 7
+	LineHeight = 13
+)
+
+// messageBackground is drawn behind messages so that they can be read over any game
+var messageBackground = color.RGBA{0x00, 0x00, 0x00, 0xa0}
+
+// Text draws a string with its top-left corner at x, y
+func Text(frame *image.RGBA, x, y int, text string, c color.Color) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{
+		Dst:  frame,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y+face.Ascent),
+	}
+	d.DrawString(text)
+}
+
+// ShadowText draws a string with a black shadow below and to the right of it, which keeps it
+// readable over light and dark pictures alike
+func ShadowText(frame *image.RGBA, x, y int, text string, c color.Color) {
+	Text(frame, x+1, y+1, text, color.Black)
+	Text(frame, x, y, text, c)
+}
+
+// Box fills a rectangle, blending it with the picture underneath when the colour is translucent
+func Box(frame *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(frame, r, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+// TextWidth returns the width of a string in pixels
+func TextWidth(text string) int {
+	return len(text) * CharWidth
+}
+
+// shape is something drawn by a layer
+type shape struct {
+	x, y   int
+	text   string
+	shadow bool
+	box    image.Rectangle
+	colour color.Color
+}
+
+func (s shape) draw(frame *image.RGBA) {
+	switch {
+	case s.text == "":
+		Box(frame, s.box, s.colour)
+	case s.shadow:
+		ShadowText(frame, s.x, s.y, s.text, s.colour)
+	default:
+		Text(frame, s.x, s.y, s.text, s.colour)
+	}
+}
+
+// message is text shown in the top-left corner for a number of frames
+type message struct {
+	text   string
+	colour color.Color
+	frames int
+}
+
+// Layer collects text and boxes to draw over frames. Text and boxes are drawn over the next frame
+// only, as scripts that draw every frame expect, while messages stay up for a number of frames. It is
+// safe to draw on a layer from any goroutine.
+type Layer struct {
+	mutex    sync.Mutex
+	shapes   []shape
+	messages []message
+}
+
+// Text draws a string with its top-left corner at x, y over the next frame
+func (l *Layer) Text(x, y int, text string, c color.Color) {
+	l.add(shape{x: x, y: y, text: text, colour: c})
+}
+
+// ShadowText draws a string with a shadow with its top-left corner at x, y over the next frame
+func (l *Layer) ShadowText(x, y int, text string, c color.Color) {
+	l.add(shape{x: x, y: y, text: text, shadow: true, colour: c})
+}
+
+// Box fills a rectangle over the next frame, blending it with the picture when the colour is
+// translucent
+func (l *Layer) Box(r image.Rectangle, c color.Color) {
+	l.add(shape{box: r, colour: c})
+}
+
+func (l *Layer) add(s shape) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.shapes = append(l.shapes, s)
+}
+
+// Message shows text in the top-left corner of the screen for a number of frames, below any other
+// messages that are still shown
+func (l *Layer) Message(text string, c color.Color, frames int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, message{text: text, colour: c, frames: frames})
+}
+
+// Empty returns true if there is nothing to draw over the next frame
+func (l *Layer) Empty() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.shapes) == 0 && len(l.messages) == 0
+}
+
+// Draw composites the text, boxes and messages over a frame, with messages on top
+func (l *Layer) Draw(frame *image.RGBA) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, s := range l.shapes {
+		s.draw(frame)
+	}
+	y := frame.Rect.Min.Y + 1
+	for _, m := range l.messages {
+		x := frame.Rect.Min.X + 1
+		Box(frame, image.Rect(x-1, y-1, x+TextWidth(m.text)+1, y+LineHeight), messageBackground)
+		Text(frame, x, y, m.text, m.colour)
+		y += LineHeight + 1
+	}
+}
+
+// Next clears the text and boxes drawn over the last frame and counts down the frames that each
+// message is shown for
+func (l *Layer) Next() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.shapes = l.shapes[:0]
+	shown := l.messages[:0]
+	for _, m := range l.messages {
+		m.frames--
+		if m.frames > 0 {
+			shown = append(shown, m)
+		}
+	}
+	l.messages = shown
+}
@@ -0,0 +1,52 @@
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBox(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	Box(frame, image.Rect(0, 0, 8, 8), color.White)
+	Box(frame, image.Rect(0, 0, 4, 4), color.RGBA{0x00, 0x00, 0x00, 0x80})
+	if c := frame.RGBAAt(0, 0); c != (color.RGBA{0x7f, 0x7f, 0x7f, 0xff}) {
+		t.Errorf("expected a translucent box to be blended but got %v", c)
+	}
+	if c := frame.RGBAAt(4, 4); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("expected the picture outside the box to be left alone but got %v", c)
+	}
+}
+
+func TestLayer(t *testing.T) {
+	var layer Layer
+	if !layer.Empty() {
+		t.Fatal("expected a new layer to be empty")
+	}
+	red := color.RGBA{0xff, 0x00, 0x00, 0xff}
+	layer.Box(image.Rect(150, 130, 160, 144), red)
+	layer.Message("Saved", color.White, 2)
+	frame := image.NewRGBA(image.Rect(0, 0, 160, 144))
+	layer.Draw(frame)
+	if c := frame.RGBAAt(155, 140); c != red {
+		t.Errorf("expected the box to be drawn but got %v", c)
+	}
+	if c := frame.RGBAAt(0, 0); c == (color.RGBA{}) {
+		t.Error("expected the message to be drawn in the top-left corner")
+	}
+
+	// Boxes last for one frame and messages for as many as they were given
+	layer.Next()
+	frame = image.NewRGBA(image.Rect(0, 0, 160, 144))
+	layer.Draw(frame)
+	if c := frame.RGBAAt(155, 140); c == red {
+		t.Error("expected the box to be cleared after a frame")
+	}
+	if layer.Empty() {
+		t.Error("expected the message to still be shown")
+	}
+	layer.Next()
+	if !layer.Empty() {
+		t.Error("expected the message to be gone after two frames")
+	}
+}
@@ -0,0 +1,84 @@
+package gb
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// spinTime is how long before a deadline the pacer stops sleeping and spins instead, since sleeping
+// can overshoot by a millisecond or more on some OSes
+const spinTime = 2 * time.Millisecond
+
+// maxDebt is the furthest that frames may fall behind, such as after a GC pause or a slow frame,
+// before the pacer gives up catching up on the rest
+const maxDebt = 3 * 16742706 * time.Nanosecond
+
+// catchUp is the fraction of the normal time between frames that is kept while catching up, so that
+// frames run a little faster until they are back on time rather than in a burst
+const catchUp = 0.75
+
+// frameTime returns the time a real Gameboy takes to draw a number of frames. Each frame is 70224
+// clock cycles at 4.194304MHz, about 59.7275 frames per second, and 1e9/4194304 is 1953125/8192 so
+// the time is exact rather than adding up the rounding of a per-frame duration.
+func frameTime(frames int64) time.Duration {
+	return time.Duration(frames * 70224 * 1953125 / 8192)
+}
+
+// pacer keeps frames to the rate of a real Gameboy using the clock, for when there are no speakers
+// to do so. Each deadline is measured from when pacing started rather than from the previous frame
+// so that small errors don't accumulate over long sessions. Frames that fall behind catch up
+// gradually, and only on a few frames' worth of debt.
+type pacer struct {
+	start  time.Time
+	last   time.Time
+	frames int64
+	speed  int32 // Updated atomically
+	paced  int32
+}
+
+// setSpeed changes the multiple of the speed of a real Gameboy that frames are paced at
+func (p *pacer) setSpeed(speed int) {
+	atomic.StoreInt32(&p.speed, int32(speed))
+}
+
+// getSpeed returns the multiple of the speed of a real Gameboy that frames are paced at
+func (p *pacer) getSpeed() int {
+	if speed := atomic.LoadInt32(&p.speed); speed > 1 {
+		return int(speed)
+	}
+	return 1
+}
+
+// wait returns at the time that the next frame is due and returns how long it waited
+func (p *pacer) wait() time.Duration {
+	now := time.Now()
+	speed := atomic.LoadInt32(&p.speed)
+	if speed < 1 {
+		speed = 1
+	}
+	if p.frames == 0 || speed != p.paced {
+		// Start again from now whenever the speed changes
+		p.start = now
+		p.frames = 0
+		p.paced = speed
+	}
+	p.frames++
+	deadline := p.start.Add(frameTime(p.frames) / time.Duration(speed))
+	if debt := now.Sub(deadline); debt > maxDebt/time.Duration(speed) {
+		// Forget about frames too far behind to be worth catching up on
+		p.start = p.start.Add(debt - maxDebt/time.Duration(speed))
+		deadline = p.start.Add(frameTime(p.frames) / time.Duration(speed))
+	}
+	if earliest := p.last.Add(time.Duration(float64(frameTime(1)) * catchUp / float64(speed))); deadline.Before(earliest) {
+		deadline = earliest
+	}
+	if d := deadline.Sub(now) - spinTime; d > 0 {
+		time.Sleep(d)
+	}
+	for time.Now().Before(deadline) {
+		runtime.Gosched()
+	}
+	p.last = time.Now()
+	return p.last.Sub(now)
+}
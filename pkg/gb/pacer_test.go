@@ -0,0 +1,54 @@
+package gb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameTime(t *testing.T) {
+	// 4194304 clock cycles are exactly 1 second
+	if d := frameTime(4194304 / 16); d != time.Second*70224/16 {
+		t.Errorf("unexpected time %v", d)
+	}
+	if d := frameTime(1); d != 16742706*time.Nanosecond {
+		t.Errorf("unexpected time %v", d)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	var p pacer
+	p.setSpeed(2)
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		p.wait()
+	}
+	// 12 frames at double speed take as long as 6 at normal speed
+	elapsed := time.Since(start)
+	if expected := frameTime(6); elapsed < expected || elapsed > expected+50*time.Millisecond {
+		t.Errorf("expected about %v but took %v", expected, elapsed)
+	}
+}
+
+func TestPacerCatchUp(t *testing.T) {
+	var p pacer
+	p.setSpeed(1)
+	p.wait()
+	// A pause of 20 frames only leaves the pacer 3 frames behind
+	time.Sleep(frameTime(20))
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		p.wait()
+	}
+	// Catching up runs frames faster than normal but not all at once
+	elapsed := time.Since(start)
+	if minimum := frameTime(3) * 3 / 4; elapsed < minimum || elapsed > frameTime(3) {
+		t.Errorf("expected 4 frames to take between %v and %v but took %v", minimum, frameTime(3), elapsed)
+	}
+	for i := 0; i < 12; i++ {
+		p.wait()
+	}
+	// Back on time, having made up the 3 frames of debt
+	if elapsed := time.Since(start); elapsed < frameTime(12) || elapsed > frameTime(12)+30*time.Millisecond {
+		t.Errorf("expected 16 frames to take about %v but took %v", frameTime(12), elapsed)
+	}
+}
@@ -0,0 +1,205 @@
+// Package patch applies IPS and BPS patches, such as translations and ROM hacks, to a ROM image
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+)
+
+var (
+	ipsHeader = []byte("PATCH")
+	ipsFooter = []byte("EOF")
+	bpsHeader = []byte("BPS1")
+)
+
+// errTruncated is returned for patches that end before their last record
+var errTruncated = errors.New("patch is truncated")
+
+// Apply returns a copy of the ROM with the patch applied, detecting whether the patch is in the IPS or
+// BPS format from its header. The ROM is left unchanged.
+func Apply(rom, patch []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(patch, ipsHeader):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsHeader):
+		return applyBPS(rom, patch)
+	}
+	return nil, fmt.Errorf("patch is neither IPS nor BPS")
+}
+
+// ApplyFile applies a patch read from a file
+func ApplyFile(rom []byte, filename string) ([]byte, error) {
+	patch, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the patch file at \"%s\" (%v)", filename, err)
+	}
+	patched, err := Apply(rom, patch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply the patch file at \"%s\" (%v)", filename, err)
+	}
+	return patched, nil
+}
+
+// applyIPS writes each record of an IPS patch over the ROM, growing it for records beyond its end.
+// A record is a 3-byte offset and 2-byte size followed by the data, or by a 2-byte count and a byte
+// to repeat when the size is 0. An optional 3-byte size after the EOF marker truncates the ROM.
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	target := append([]byte(nil), rom...)
+	p := patch[len(ipsHeader):]
+	for {
+		if len(p) < 3 {
+			return nil, errTruncated
+		}
+		if bytes.Equal(p[:3], ipsFooter) {
+			p = p[3:]
+			break
+		}
+		if len(p) < 5 {
+			return nil, errTruncated
+		}
+		offset := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		size := int(binary.BigEndian.Uint16(p[3:5]))
+		p = p[5:]
+		var data []byte
+		if size == 0 {
+			if len(p) < 3 {
+				return nil, errTruncated
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p[:2])))
+			p = p[3:]
+		} else {
+			if len(p) < size {
+				return nil, errTruncated
+			}
+			data = p[:size]
+			p = p[size:]
+		}
+		if end := offset + len(data); end > len(target) {
+			target = append(target, make([]byte, end-len(target))...)
+		}
+		copy(target[offset:], data)
+	}
+	if len(p) >= 3 {
+		size := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if size < len(target) {
+			target = target[:size]
+		}
+	}
+	return target, nil
+}
+
+// bpsReader decodes the numbers and bytes of a BPS patch
+type bpsReader struct {
+	patch []byte
+	pos   int
+	end   int
+	err   error
+}
+
+func (r *bpsReader) byte() byte {
+	if r.pos >= r.end {
+		r.err = errTruncated
+		return 0
+	}
+	b := r.patch[r.pos]
+	r.pos++
+	return b
+}
+
+// number decodes a variable-length number where each byte holds 7 bits, least significant first,
+// and the top bit marks the last byte
+func (r *bpsReader) number() int {
+	value, shift := 0, 1
+	for r.err == nil {
+		b := r.byte()
+		value += int(b&0x7f) * shift
+		if b&0x80 != 0 || shift > 1<<28 {
+			break
+		}
+		shift <<= 7
+		value += shift
+	}
+	return value
+}
+
+// applyBPS builds the target from a BPS patch, which copies runs of bytes from the source, from the
+// patch or from earlier in the target. CRC32s of the source, target and patch are checked.
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < len(bpsHeader)+12 {
+		return nil, errTruncated
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("patch is corrupt")
+	}
+	if crc32.ChecksumIEEE(rom) != binary.LittleEndian.Uint32(footer[:4]) {
+		return nil, fmt.Errorf("patch is for a different ROM")
+	}
+	r := &bpsReader{patch: patch, pos: len(bpsHeader), end: len(patch) - 12}
+	sourceSize := r.number()
+	targetSize := r.number()
+	metadataSize := r.number()
+	r.pos += metadataSize
+	if r.err != nil || sourceSize != len(rom) || r.pos > r.end {
+		return nil, fmt.Errorf("patch header is invalid")
+	}
+	target := make([]byte, targetSize)
+	var out, sourceOffset, targetOffset int
+	for r.err == nil && r.pos < r.end {
+		command := r.number()
+		length := command>>2 + 1
+		if out+length > targetSize {
+			return nil, fmt.Errorf("patch writes beyond the end of the target")
+		}
+		switch command & 3 {
+		case 0: // SourceRead
+			if out+length > len(rom) {
+				return nil, fmt.Errorf("patch reads beyond the end of the ROM")
+			}
+			copy(target[out:], rom[out:out+length])
+		case 1: // TargetRead
+			if r.pos+length > r.end {
+				return nil, errTruncated
+			}
+			copy(target[out:], patch[r.pos:r.pos+length])
+			r.pos += length
+		case 2: // SourceCopy
+			sourceOffset += relativeOffset(r.number())
+			if sourceOffset < 0 || sourceOffset+length > len(rom) {
+				return nil, fmt.Errorf("patch reads beyond the end of the ROM")
+			}
+			copy(target[out:], rom[sourceOffset:sourceOffset+length])
+			sourceOffset += length
+		case 3: // TargetCopy
+			targetOffset += relativeOffset(r.number())
+			if targetOffset < 0 || targetOffset >= out {
+				return nil, fmt.Errorf("patch copies from beyond the target written so far")
+			}
+			// Byte by byte, since the copy may overlap the bytes it writes to repeat a pattern
+			for i := 0; i < length; i++ {
+				target[out+i] = target[targetOffset+i]
+			}
+			targetOffset += length
+		}
+		out += length
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if out != targetSize || crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(footer[4:8]) {
+		return nil, fmt.Errorf("patched ROM does not match the patch checksum")
+	}
+	return target, nil
+}
+
+// relativeOffset decodes an offset whose lowest bit is its sign
+func relativeOffset(n int) int {
+	if n&1 != 0 {
+		return -(n >> 1)
+	}
+	return n >> 1
+}
@@ -0,0 +1,110 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestIPS(t *testing.T) {
+	rom := []byte("0123456789")
+	patch := []byte("PATCH")
+	patch = append(patch, 0, 0, 2, 0, 3, 'a', 'b', 'c') // "abc" at 2
+	patch = append(patch, 0, 0, 8, 0, 0, 0, 4, 'z')     // "zzzz" at 8, growing the ROM
+	patch = append(patch, 'E', 'O', 'F')
+	patched, err := Apply(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patched) != "01abc567zzzz" {
+		t.Errorf("unexpected patched ROM %q", patched)
+	}
+	if string(rom) != "0123456789" {
+		t.Errorf("expected the ROM to be left unchanged but got %q", rom)
+	}
+
+	// A size after EOF truncates the ROM
+	patched, err = Apply(rom, append([]byte("PATCHEOF"), 0, 0, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patched) != "0123" {
+		t.Errorf("expected a truncated ROM but got %q", patched)
+	}
+
+	for _, bad := range [][]byte{[]byte("PATCH"), []byte("PATCH\x00\x00\x02\x00\x03ab"), []byte("NOTAPATCH")} {
+		if _, err := Apply(rom, bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+// bpsNumber encodes a number in the variable-length format of BPS
+func bpsNumber(n int) []byte {
+	var b []byte
+	for {
+		x := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(b, x|0x80)
+		}
+		b = append(b, x)
+		n--
+	}
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func bps(source, target []byte, actions ...[]byte) []byte {
+	patch := []byte("BPS1")
+	patch = append(patch, bpsNumber(len(source))...)
+	patch = append(patch, bpsNumber(len(target))...)
+	patch = append(patch, bpsNumber(0)...)
+	for _, action := range actions {
+		patch = append(patch, action...)
+	}
+	patch = appendUint32(patch, crc32.ChecksumIEEE(source))
+	patch = appendUint32(patch, crc32.ChecksumIEEE(target))
+	return appendUint32(patch, crc32.ChecksumIEEE(patch))
+}
+
+func command(action, length int) []byte {
+	return bpsNumber((length-1)<<2 | action)
+}
+
+func TestBPS(t *testing.T) {
+	source := []byte("0123456789")
+	target := []byte("0123xyxyxy6701")
+	patch := bps(source, target,
+		command(0, 4),                               // SourceRead "0123"
+		append(command(1, 2), 'x', 'y'),             // TargetRead "xy"
+		append(command(3, 4), bpsNumber(4<<1)...),   // TargetCopy "xyxy" from 4, overlapping itself
+		append(command(2, 2), bpsNumber(6<<1)...),   // SourceCopy "67" from 6
+		append(command(2, 2), bpsNumber(8<<1|1)...), // SourceCopy "01" from 0
+	)
+	patched, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, target) {
+		t.Errorf("expected %q but got %q", target, patched)
+	}
+
+	if _, err := Apply([]byte("9876543210"), patch); err == nil {
+		t.Error("expected an error for a patch made for another ROM")
+	}
+	corrupt := append([]byte(nil), patch...)
+	corrupt[10] ^= 0xff
+	if _, err := Apply(source, corrupt); err == nil {
+		t.Error("expected an error for a corrupt patch")
+	}
+	wrong := bps(source, []byte("0123456789"), command(0, 4))
+	if _, err := Apply(source, wrong); err == nil {
+		t.Error("expected an error for a patch that doesn't build its target")
+	}
+}
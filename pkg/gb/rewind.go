@@ -0,0 +1,198 @@
+package gb
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"sync/atomic"
+)
+
+// rewindInterval is the number of frames between the snapshots kept for rewinding. Holding the rewind
+// key steps back one snapshot at a time, which is shown for as long as the frames took to play.
+const rewindInterval = 2
+
+// rewindEntry is a compressed snapshot of the machine taken at the end of a frame
+type rewindEntry struct {
+	frame int
+	data  []byte
+}
+
+// rewindBuffer keeps compressed snapshots in a ring, dropping the oldest once they take up more than
+// the budget. It is only used while the running lock is held, apart from the rewinding flag.
+type rewindBuffer struct {
+	entries []rewindEntry
+	start   int
+	count   int
+	size    int
+	budget  int
+	// encoded and compressor are reused for each snapshot
+	encoded    bytes.Buffer
+	compressor *flate.Writer
+	// rewinding is 1 while the rewind key is held and is updated atomically
+	rewinding int32
+	// pacer shows the snapshots at the speed the game was played while the rewind key is held, and
+	// paced is true once it has started
+	pacer pacer
+	paced bool
+}
+
+func newRewindBuffer(megabytes int) *rewindBuffer {
+	compressor, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &rewindBuffer{
+		entries:    make([]rewindEntry, 64),
+		budget:     megabytes << 20,
+		compressor: compressor,
+	}
+}
+
+func (r *rewindBuffer) newest() rewindEntry {
+	return r.entries[(r.start+r.count-1)%len(r.entries)]
+}
+
+// push adds a snapshot, growing the ring when it is full and then dropping the oldest snapshots until
+// the rest fit in the budget. The newest snapshot is always kept.
+func (r *rewindBuffer) push(entry rewindEntry) {
+	if r.count == len(r.entries) {
+		grown := make([]rewindEntry, 2*len(r.entries))
+		for i := 0; i < r.count; i++ {
+			grown[i] = r.entries[(r.start+i)%len(r.entries)]
+		}
+		r.entries = grown
+		r.start = 0
+	}
+	r.entries[(r.start+r.count)%len(r.entries)] = entry
+	r.count++
+	r.size += len(entry.data)
+	for r.size > r.budget && r.count > 1 {
+		r.size -= len(r.entries[r.start].data)
+		r.entries[r.start] = rewindEntry{}
+		r.start = (r.start + 1) % len(r.entries)
+		r.count--
+	}
+}
+
+// dropNewest forgets the newest snapshot, such as one from after the frame that was rewound to
+func (r *rewindBuffer) dropNewest() {
+	i := (r.start + r.count - 1) % len(r.entries)
+	r.size -= len(r.entries[i].data)
+	r.entries[i] = rewindEntry{}
+	r.count--
+}
+
+func (r *rewindBuffer) clear() {
+	for r.count > 0 {
+		r.dropNewest()
+	}
+}
+
+func (r *rewindBuffer) compress(state machineState) ([]byte, error) {
+	r.encoded.Reset()
+	if err := gob.NewEncoder(&r.encoded).Encode(&state); err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	r.compressor.Reset(&compressed)
+	if _, err := r.compressor.Write(r.encoded.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := r.compressor.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+func (r *rewindBuffer) decompress(data []byte) (machineState, error) {
+	var state machineState
+	decompressor := flate.NewReader(bytes.NewReader(data))
+	defer decompressor.Close()
+	err := gob.NewDecoder(decompressor).Decode(&state)
+	return state, err
+}
+
+// recordRewind keeps a snapshot for rewinding every few frames. Snapshots are only taken when the frame
+// ends between instructions, which it usually does because games wait for V-Blank, so that keeping
+// them never changes how the game runs. The running lock must be held.
+func (gb *Gameboy) recordRewind() {
+	r := gb.rewind
+	if r == nil || gb.intro != nil || !gb.dispatch.InstructionBoundary() {
+		return
+	}
+	if r.count > 0 && gb.frame-r.newest().frame < rewindInterval {
+		return
+	}
+	data, err := r.compress(gb.snapshot())
+	if err != nil {
+		gb.log.Errorf("Failed to keep a snapshot for rewinding: %v", err)
+		return
+	}
+	r.push(rewindEntry{frame: gb.frame, data: data})
+}
+
+// Rewind returns the Gameboy to the newest snapshot kept at least a number of frames ago, or to the
+// oldest one if they don't go back that far, and returns the number of frames that it went back.
+// Snapshots are only kept when Options.RewindBuffer is set.
+func (gb *Gameboy) Rewind(frames int) int {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	return gb.rewindFrames(frames)
+}
+
+func (gb *Gameboy) rewindFrames(frames int) int {
+	r := gb.rewind
+	if r == nil || r.count == 0 {
+		return 0
+	}
+	target := gb.frame - frames
+	for r.count > 1 && r.newest().frame > target {
+		r.dropNewest()
+	}
+	entry := r.newest()
+	if entry.frame >= gb.frame {
+		return 0
+	}
+	state, err := r.decompress(entry.data)
+	if err == nil {
+		rewound := gb.frame - entry.frame
+		if err = gb.restore(state); err == nil {
+			gb.lcd.ShowFrame()
+			return rewound
+		}
+	}
+	gb.log.Errorf("Failed to rewind: %v", err)
+	return 0
+}
+
+// setRewinding starts or stops stepping back through the snapshots while the rewind key is held
+func (gb *Gameboy) setRewinding(rewinding bool) {
+	if gb.rewind == nil {
+		return
+	}
+	var value int32
+	if rewinding {
+		value = 1
+	}
+	atomic.StoreInt32(&gb.rewind.rewinding, value)
+}
+
+// rewinding returns true while the rewind key is held
+func (gb *Gameboy) rewinding() bool {
+	return gb.rewind != nil && atomic.LoadInt32(&gb.rewind.rewinding) != 0
+}
+
+// stepRewind goes back one snapshot and shows it for as long as its frames took to play
+func (gb *Gameboy) stepRewind() {
+	r := gb.rewind
+	if !r.paced {
+		r.pacer = pacer{}
+		r.paced = true
+	}
+	gb.running.Lock()
+	rewound := gb.rewindFrames(1)
+	gb.running.Unlock()
+	if rewound < 1 {
+		rewound = 1
+	}
+	for i := 0; i < rewound; i++ {
+		r.pacer.wait()
+	}
+}
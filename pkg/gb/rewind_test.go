@@ -0,0 +1,66 @@
+package gb
+
+import "testing"
+
+func TestRewind(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: "testdata/blargg/cpu_instrs/individual/01-special.gb", RewindBuffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[int]uint64{}
+	for i := 0; i < 60; i++ {
+		gameboy.RunFrames(1)
+		hashes[gameboy.FrameCount()] = gameboy.StateHash()
+	}
+	rewound := gameboy.Rewind(5)
+	if rewound < 5 || gameboy.FrameCount() != 60-rewound {
+		t.Fatalf("expected to rewind at least 5 frames from frame 60 but rewound %d to frame %d", rewound, gameboy.FrameCount())
+	}
+	if gameboy.StateHash() != hashes[gameboy.FrameCount()] {
+		t.Errorf("expected the state at frame %d", gameboy.FrameCount())
+	}
+	gameboy.RunFrames(rewound)
+	if gameboy.StateHash() != hashes[60] {
+		t.Error("expected the same state at frame 60 after playing the rewound frames again")
+	}
+
+	// Rewinding past the oldest snapshot stops there
+	if rewound := gameboy.Rewind(1000); rewound == 0 || rewound >= 60 {
+		t.Errorf("expected to rewind to the oldest snapshot but rewound %d frames", rewound)
+	}
+	if rewound := gameboy.Rewind(1000); rewound != 0 {
+		t.Errorf("expected no older snapshots but rewound %d frames", rewound)
+	}
+
+	off, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	off.RunFrames(10)
+	if rewound := off.Rewind(5); rewound != 0 || off.FrameCount() != 10 {
+		t.Errorf("expected no rewinding without a rewind buffer but rewound %d frames", rewound)
+	}
+}
+
+func TestRewindBuffer(t *testing.T) {
+	r := newRewindBuffer(1)
+	for frame := 0; frame < 200; frame++ {
+		r.push(rewindEntry{frame: frame, data: make([]byte, 10<<10)})
+	}
+	// Only 102 snapshots of 10KB fit in 1MB
+	if r.count != 102 || r.size > r.budget || r.entries[r.start].frame != 98 || r.newest().frame != 199 {
+		t.Errorf("expected snapshots from frame 98 to 199 but got %d from frame %d to %d", r.count, r.entries[r.start].frame, r.newest().frame)
+	}
+	r.dropNewest()
+	if r.count != 101 || r.newest().frame != 198 {
+		t.Errorf("expected frame 198 to be the newest but got %d", r.newest().frame)
+	}
+	r.push(rewindEntry{frame: 0, data: make([]byte, 2<<20)})
+	if r.count != 1 || r.newest().frame != 0 {
+		t.Errorf("expected a snapshot bigger than the budget to replace the others but got %d", r.count)
+	}
+	r.clear()
+	if r.count != 0 || r.size != 0 {
+		t.Errorf("expected no snapshots but got %d", r.count)
+	}
+}
@@ -0,0 +1,75 @@
+package gb
+
+import (
+	"testing"
+	"time"
+)
+
+// readRTC latches the clock of an MBC3 cart and reads its seconds, minutes, hours, low and high day
+// registers
+func readRTC(gameboy *Gameboy) [5]uint8 {
+	gameboy.WriteMemory(0x6000, 0x00)
+	gameboy.WriteMemory(0x6000, 0x01)
+	var regs [5]uint8
+	for i := range regs {
+		gameboy.WriteMemory(0x4000, uint8(0x08+i))
+		regs[i] = gameboy.ReadMemory(0xa000)
+	}
+	return regs
+}
+
+func TestRTC(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+		0x0147: {0x10, 0x00, 0x03},
+	})
+	// The save was made 3 days, 2 hours, 5 minutes and 7 seconds ago
+	saved := time.Now().Add(-(74*time.Hour + 5*time.Minute + 7*time.Second))
+	writeSave(t, rom, 0x8000, rtcFooter([5]uint8{}, saved, 48))
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0x0000, 0x0a)
+	regs := readRTC(gameboy)
+	if regs[3] != 3 || regs[2] != 2 || regs[1] != 5 || regs[0] < 7 || regs[0] > 8 {
+		t.Fatalf("expected the clock to catch up with the time since the save but got %v", regs)
+	}
+
+	// The clock counts the emulated time and reads only change when it is latched again
+	gameboy.WriteMemory(0x4000, 0x08)
+	gameboy.WriteMemory(0xa000, 0)
+	gameboy.RunFrames(120)
+	if seconds := gameboy.ReadMemory(0xa000); seconds != regs[0] {
+		t.Errorf("expected the latched seconds %d but got %d", regs[0], seconds)
+	}
+	if regs := readRTC(gameboy); regs[0] != 2 {
+		t.Errorf("expected 2 seconds after 120 frames but got %d", regs[0])
+	}
+
+	// Halting the clock stops it
+	gameboy.WriteMemory(0x4000, 0x0c)
+	gameboy.WriteMemory(0xa000, 0x40)
+	gameboy.RunFrames(120)
+	if regs := readRTC(gameboy); regs[0] != 2 || regs[4] != 0x40 {
+		t.Errorf("expected the halted clock to stay at 2 seconds but got %v", regs)
+	}
+
+	// The day counter overflows after 511 days, setting the carry bit
+	for i, value := range []uint8{59, 59, 23, 0xff, 0x01} {
+		gameboy.WriteMemory(0x4000, uint8(0x08+i))
+		gameboy.WriteMemory(0xa000, value)
+	}
+	gameboy.RunFrames(60)
+	if regs := readRTC(gameboy); regs != [5]uint8{0, 0, 0, 0, 0x80} {
+		t.Errorf("expected the day counter to overflow but got %v", regs)
+	}
+
+	// Registers beyond their range count up to the limit of their bits without carrying
+	gameboy.WriteMemory(0x4000, 0x08)
+	gameboy.WriteMemory(0xa000, 63)
+	gameboy.RunFrames(60)
+	if regs := readRTC(gameboy); regs[0] != 0 || regs[1] != 0 {
+		t.Errorf("expected the seconds to wrap without carrying but got %v", regs)
+	}
+}
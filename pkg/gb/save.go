@@ -0,0 +1,151 @@
+package gb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// saveFilename returns the battery save filename, which defaults to the ROM filename with a .sav extension
+// in the save directory or, if there is no save directory, alongside the ROM
+func saveFilename(opts Options) string {
+	if opts.SaveFilename != "" {
+		return opts.SaveFilename
+	}
+	if opts.RomFilename == "" {
+		return ""
+	}
+	filename := strings.TrimSuffix(opts.RomFilename, filepath.Ext(opts.RomFilename)) + ".sav"
+	if opts.SaveDir != "" {
+		return filepath.Join(opts.SaveDir, filepath.Base(filename))
+	}
+	return filename
+}
+
+func (gb *Gameboy) loadBatteryRAM() error {
+	filename := saveFilename(gb.opts)
+	if filename == "" || !gb.memory.HasBattery() {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read the save file at \"%s\" (%v)", filename, err)
+	}
+	err = gb.memory.LoadBatteryRAM(data)
+	if err != nil {
+		return fmt.Errorf("Failed to load the save file at \"%s\" (%v)", filename, err)
+	}
+	return nil
+}
+
+// writeFileAtomically writes to a temporary file and renames it so that a partially written file
+// never replaces a good one
+func writeFileAtomically(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// backupSave copies the save file into a backups directory alongside it the first time it changes in a
+// session, then deletes all but the newest copies so that there are at most SaveBackups of them
+func (gb *Gameboy) backupSave(filename string, data []byte) error {
+	if gb.opts.SaveBackups < 1 || gb.backedUp {
+		return nil
+	}
+	previous, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		// There is nothing to lose yet
+		gb.backedUp = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(previous, data) {
+		return nil
+	}
+	dir := filepath.Join(filepath.Dir(filename), "backups")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if err := writeFileAtomically(timestampedFilename(dir, prefix, "sav"), previous); err != nil {
+		return err
+	}
+	gb.backedUp = true
+	return pruneBackups(dir, prefix, gb.opts.SaveBackups)
+}
+
+// pruneBackups deletes the oldest backups of a save file until only a number of them are left
+func pruneBackups(dir, prefix string, keep int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backup := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + `-\d{8}-\d{6}\.sav$`)
+	var backups []string
+	for _, file := range files {
+		if backup.MatchString(file.Name()) {
+			backups = append(backups, file.Name())
+		}
+	}
+	// The timestamps sort in the order that the backups were made
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Flush writes persistent state such as battery-backed cartridge RAM to disk. It waits for any
+// running frame to complete so that the state written is consistent.
+func (gb *Gameboy) Flush() error {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	filename := saveFilename(gb.opts)
+	if filename == "" || !gb.memory.HasBattery() {
+		return nil
+	}
+	data := gb.memory.BatteryRAM()
+	if err := gb.backupSave(filename, data); err != nil {
+		return fmt.Errorf("Failed to back up the save file at \"%s\" (%v)", filename, err)
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err == nil {
+		err = writeFileAtomically(filename, data)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write the save file at \"%s\" (%v)", filename, err)
+	}
+	gb.publish(Event{Kind: SaveEvent, Filename: filename})
+	return nil
+}
+
+// Close flushes persistent state and should be called once the Gameboy has stopped running
+func (gb *Gameboy) Close() error {
+	err := gb.Flush()
+	if err != nil {
+		return err
+	}
+	if gb.opts.CoverageFilename != "" {
+		err := gb.SaveCoverage(gb.opts.CoverageFilename)
+		if err != nil {
+			return fmt.Errorf("Failed to write the coverage file at \"%s\" (%v)", gb.opts.CoverageFilename, err)
+		}
+	}
+	return nil
+}
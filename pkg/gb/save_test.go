@@ -0,0 +1,227 @@
+package gb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSave writes a battery save for a ROM, numbering each byte of RAM by its offset
+func writeSave(t *testing.T, rom string, ramSize int, footer []byte) []byte {
+	t.Helper()
+	data := make([]byte, ramSize)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+	data = append(data, footer...)
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(rom), "test.sav"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rtcFooter returns the clock state at the end of a battery save, with each of the seconds, minutes,
+// hours, low and high day registers followed by the same latched registers and the time it was saved
+func rtcFooter(regs [5]uint8, saved time.Time, size int) []byte {
+	footer := make([]byte, size)
+	for i, reg := range regs {
+		footer[4*i], footer[20+4*i] = reg, reg
+	}
+	binary.LittleEndian.PutUint32(footer[40:], uint32(saved.Unix()))
+	return footer
+}
+
+func TestBatterySaveInterop(t *testing.T) {
+	footer := rtcFooter([5]uint8{10, 20, 3, 5, 0}, time.Now(), 48)
+	tests := []struct {
+		name     string
+		cartType uint8
+		ramSize  uint8
+		saved    int
+		footer   []byte
+		written  int
+	}{
+		{"MBC1 with 2KB of RAM", 0x03, 0x01, 0x800, nil, 0x800},
+		{"MBC1 with 32KB of RAM", 0x03, 0x03, 0x8000, nil, 0x8000},
+		{"MBC3 with a clock", 0x10, 0x03, 0x8000, footer, 0x8000 + 48},
+		{"MBC3 with a clock and a 32-bit timestamp", 0x10, 0x03, 0x8000, footer[:44], 0x8000 + 48},
+		{"MBC3 with a clock and no RAM", 0x0f, 0x00, 0, footer, 48},
+		{"whole bank saved by older versions", 0x03, 0x01, 0x2000, nil, 0x800},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rom := writeRom(t, map[uint16][]byte{0x0147: {test.cartType, 0x00, test.ramSize}})
+			data := writeSave(t, rom, test.saved, test.footer)
+			gameboy, err := NewGameboy(Options{RomFilename: rom})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.saved > 0 {
+				// Enable RAM and check the byte at offset 0x7ff
+				gameboy.WriteMemory(0x0000, 0x0a)
+				if b := gameboy.ReadMemory(0xa7ff); b != 0xff {
+					t.Errorf("expected 0xff from the save but got 0x%02x", b)
+				}
+				gameboy.WriteMemory(0xa000, 0x42)
+			}
+			if err := gameboy.Close(); err != nil {
+				t.Fatal(err)
+			}
+			written, err := ioutil.ReadFile(filepath.Join(filepath.Dir(rom), "test.sav"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(written) != test.written {
+				t.Fatalf("expected a save of 0x%x bytes but got 0x%x", test.written, len(written))
+			}
+			if test.saved > 0 && (written[0] != 0x42 || written[1] != 0x01) {
+				t.Errorf("unexpected RAM % x", written[:2])
+			}
+			if !bytes.HasSuffix(data, test.footer) {
+				t.Fatal("expected the save to end with the clock state")
+			}
+			if test.footer != nil {
+				// The clock may have ticked a second while loading
+				clock := written[test.saved:]
+				if clock[0] < 10 || clock[0] > 11 || clock[4] != 20 || clock[8] != 3 || clock[12] != 5 {
+					t.Errorf("expected the clock to be kept but got % x", clock)
+				}
+			}
+		})
+	}
+}
+
+func TestBatterySaveWrongSize(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{0x0147: {0x03, 0x00, 0x01}})
+	writeSave(t, rom, 0x801, nil)
+	if _, err := NewGameboy(Options{RomFilename: rom}); err == nil {
+		t.Error("expected an error for a save of the wrong size")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})
+	// Write 0x42 over the first byte of the title
+	ips := append([]byte("PATCH"), 0x00, 0x01, 0x34, 0x00, 0x01, 0x42, 'E', 'O', 'F')
+	explicit := filepath.Join(filepath.Dir(rom), "translation.ips")
+	if err := ioutil.WriteFile(explicit, ips, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.PeekMemory(0x0134) != 0x00 {
+		t.Error("expected a patch with another name not to be applied")
+	}
+	gameboy, err = NewGameboy(Options{RomFilename: rom, PatchFilename: explicit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.PeekMemory(0x0134) != 0x42 {
+		t.Error("expected the patch given to be applied")
+	}
+
+	// A patch named after the ROM is found without being given
+	if err := os.Rename(explicit, strings.TrimSuffix(rom, ".gb")+".ips"); err != nil {
+		t.Fatal(err)
+	}
+	gameboy, err = NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameboy.PeekMemory(0x0134) != 0x42 {
+		t.Error("expected the patch alongside the ROM to be applied")
+	}
+	if _, err := NewGameboy(Options{RomFilename: rom, PatchFilename: rom + ".missing"}); err == nil {
+		t.Error("expected an error for a missing patch")
+	}
+}
+
+func TestSaveBackups(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{0x0147: {0x03, 0x00, 0x01}})
+	data := writeSave(t, rom, 0x800, nil)
+	backups := filepath.Join(filepath.Dir(rom), "backups")
+
+	// An unchanged save isn't backed up
+	gameboy, err := NewGameboy(Options{RomFilename: rom, SaveBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backups); !os.IsNotExist(err) {
+		t.Fatalf("expected no backups of an unchanged save but got %v", err)
+	}
+
+	gameboy, err = NewGameboy(Options{RomFilename: rom, SaveBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0x0000, 0x0a)
+	gameboy.WriteMemory(0xa000, 0x42)
+	if err := gameboy.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0xa000, 0x43)
+	if err := gameboy.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasPrefix(files[0].Name(), "test-") {
+		t.Fatalf("expected one backup for the session but got %v", files)
+	}
+	backup, err := ioutil.ReadFile(filepath.Join(backups, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(backup, data) {
+		t.Error("expected the backup to hold the save from before the session")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tetromino-backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	names := []string{
+		"test-20240101-120000.sav",
+		"test-20230101-120000.sav",
+		"test-20250101-120000.sav",
+		"test-dx-20200101-120000.sav",
+		"notes.txt",
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneBackups(dir, "test", 2); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, file := range files {
+		left = append(left, file.Name())
+	}
+	expected := []string{"notes.txt", "test-20240101-120000.sav", "test-20250101-120000.sav", "test-dx-20200101-120000.sav"}
+	if strings.Join(left, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v but got %v", expected, left)
+	}
+}
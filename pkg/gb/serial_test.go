@@ -0,0 +1,103 @@
+package gb
+
+import "testing"
+
+type fakeSerial struct {
+	out    []uint8
+	in     uint8
+	clocks bool
+}
+
+func (s *fakeSerial) Exchange(out uint8) uint8 {
+	s.out = append(s.out, out)
+	return s.in
+}
+
+func (s *fakeSerial) Clocked(out uint8) (uint8, bool) {
+	if !s.clocks {
+		return 0, false
+	}
+	s.out = append(s.out, out)
+	return s.in, true
+}
+
+func serialRom(t *testing.T, sc uint8) string {
+	return writeRom(t, map[uint16][]byte{
+		0x0100: {0xc3, 0x50, 0x01}, // JP $0150
+		0x0150: {
+			0x3e, 0x5a, // LD A,$5A
+			0xe0, 0x01, // LDH ($01),A
+			0x3e, 0x08, // LD A,$08
+			0xe0, 0xff, // LDH ($FF),A
+			0xaf,       // XOR A
+			0xe0, 0x0f, // LDH ($0F),A
+			0x3e, sc, // LD A,sc
+			0xe0, 0x02, // LDH ($02),A
+			0xf3,       // DI
+			0x76,       // HALT
+			0x00,       // NOP
+			0xf0, 0x01, // LDH A,($01)
+			0xea, 0x00, 0xc0, // LD ($C000),A
+			0x18, 0xfe, // JR -2
+		},
+	})
+}
+
+func TestSerialInternalClock(t *testing.T) {
+	port := &fakeSerial{in: 0xa5}
+	gameboy, err := NewGameboy(Options{RomFilename: serialRom(t, 0x81), Serial: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(2)
+	if len(port.out) != 1 || port.out[0] != 0x5a {
+		t.Errorf("expected 0x5a to be shifted out but got %x", port.out)
+	}
+	if gameboy.PeekMemory(0xc000) != 0xa5 {
+		t.Errorf("expected 0xa5 to be shifted in but got 0x%02x", gameboy.PeekMemory(0xc000))
+	}
+	if gameboy.PeekMemory(0xff02)&0x80 != 0 {
+		t.Error("expected the transfer to be complete")
+	}
+}
+
+func TestSerialUnplugged(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: serialRom(t, 0x81)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(2)
+	if gameboy.PeekMemory(0xc000) != 0xff {
+		t.Errorf("expected 0xff to be shifted in but got 0x%02x", gameboy.PeekMemory(0xc000))
+	}
+
+	// Nothing drives an external clock so the transfer never completes
+	gameboy, err = NewGameboy(Options{RomFilename: serialRom(t, 0x80)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(10)
+	if gameboy.PeekMemory(0xff02)&0x80 == 0 || gameboy.PeekMemory(0xff0f)&0x08 != 0 {
+		t.Error("expected the transfer to wait for an external clock")
+	}
+}
+
+func TestSerialExternalClock(t *testing.T) {
+	port := &fakeSerial{in: 0x3c}
+	gameboy, err := NewGameboy(Options{RomFilename: serialRom(t, 0x80), Serial: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(2)
+	if gameboy.PeekMemory(0xff02)&0x80 == 0 {
+		t.Fatal("expected the transfer to wait for the other device")
+	}
+	port.clocks = true
+	gameboy.RunFrames(1)
+	if len(port.out) != 1 || port.out[0] != 0x5a {
+		t.Errorf("expected 0x5a to be shifted out but got %x", port.out)
+	}
+	if gameboy.PeekMemory(0xc000) != 0x3c {
+		t.Errorf("expected 0x3c to be shifted in but got 0x%02x", gameboy.PeekMemory(0xc000))
+	}
+}
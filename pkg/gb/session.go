@@ -0,0 +1,125 @@
+package gb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Session is the debugger state that is saved between runs of the emulator
+type Session struct {
+	Breakpoints       []SessionBreakpoint `json:"breakpoints,omitempty"`
+	BreakOnInterrupts uint8               `json:"breakOnInterrupts,omitempty"`
+	BreakOnBankSwitch bool                `json:"breakOnBankSwitch,omitempty"`
+	BreakOnScanline   *ScanlineBreak      `json:"breakOnScanline,omitempty"`
+	Watches           []string            `json:"watches,omitempty"`
+	SymbolFilename    string              `json:"symbolFilename,omitempty"`
+}
+
+// SessionBreakpoint is a breakpoint saved in a session
+type SessionBreakpoint struct {
+	Addr      uint16 `json:"addr"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// ROMHash identifies the loaded ROM by the SHA-1 of its contents
+func (gb *Gameboy) ROMHash() string {
+	return gb.romHash
+}
+
+// Session returns the current debugger state
+func (gb *Gameboy) Session() Session {
+	s := Session{
+		BreakOnInterrupts: gb.breakOnInterrupts,
+		BreakOnBankSwitch: gb.breakOnBankSwitch,
+		SymbolFilename:    gb.symbolFilename,
+	}
+	if gb.breakOnScanline != nil {
+		scanline := *gb.breakOnScanline
+		s.BreakOnScanline = &scanline
+	}
+	for _, addr := range gb.Breakpoints() {
+		s.Breakpoints = append(s.Breakpoints, SessionBreakpoint{Addr: addr, Condition: gb.BreakpointCondition(addr)})
+	}
+	for _, w := range gb.Watches() {
+		s.Watches = append(s.Watches, w.Expression)
+	}
+	return s
+}
+
+// RestoreSession adds the breakpoints and watches from a session to the current debugger state,
+// loading its symbol file first unless one is already loaded. Anything that no longer parses is
+// skipped and reported in the returned error once the rest of the session has been restored.
+func (gb *Gameboy) RestoreSession(s Session) error {
+	var failures []error
+	if s.SymbolFilename != "" && gb.symbolFilename == "" {
+		if err := gb.LoadSymbols(s.SymbolFilename); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	for _, b := range s.Breakpoints {
+		if b.Condition == "" {
+			gb.SetBreakpoint(b.Addr)
+		} else if err := gb.SetConditionalBreakpoint(b.Addr, b.Condition); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	gb.breakOnInterrupts |= s.BreakOnInterrupts
+	gb.breakOnBankSwitch = gb.breakOnBankSwitch || s.BreakOnBankSwitch
+	if s.BreakOnScanline != nil {
+		if err := gb.SetBreakOnScanline(s.BreakOnScanline); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	for _, expression := range s.Watches {
+		if err := gb.AddWatch(expression); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%d parts of the session could not be restored, the first being: %v", len(failures), failures[0])
+	}
+	return nil
+}
+
+// sessionFilename returns the file in a directory that holds the session for the loaded ROM
+func (gb *Gameboy) sessionFilename(dir string) string {
+	return filepath.Join(dir, gb.romHash+".json")
+}
+
+// LoadSession restores the session saved in a directory for the loaded ROM, if there is one
+func (gb *Gameboy) LoadSession(dir string) error {
+	filename := gb.sessionFilename(dir)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read the session file at \"%s\" (%v)", filename, err)
+	}
+	var s Session
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("Failed to read the session file at \"%s\" (%v)", filename, err)
+	}
+	return gb.RestoreSession(s)
+}
+
+// SaveSession writes the current debugger state to a directory, keyed by the hash of the loaded ROM
+func (gb *Gameboy) SaveSession(dir string) error {
+	filename := gb.sessionFilename(dir)
+	data, err := json.MarshalIndent(gb.Session(), "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = writeFileAtomically(filename, data)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write the session file at \"%s\" (%v)", filename, err)
+	}
+	return nil
+}
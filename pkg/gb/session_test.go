@@ -0,0 +1,60 @@
+package gb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	symbols := filepath.Join(dir, "game.sym")
+	err = ioutil.WriteFile(symbols, []byte("00:c0a0 wPlayerX\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gameboy, err := NewGameboy(Options{SymbolFilename: symbols})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.SetBreakpoint(0x0150)
+	if err := gameboy.SetConditionalBreakpoint(0x0200, "[wPlayerX] > 5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.AddWatch("wPlayerX"); err != nil {
+		t.Fatal(err)
+	}
+	gameboy.SetBreakOnInterrupt(TimerInterrupt, true)
+	gameboy.SetBreakOnScanline(&ScanlineBreak{Line: 90, Mode: 0})
+	if err := gameboy.SaveSession(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The symbol file comes from the session so the condition and watch can use its names
+	restored, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadSession(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gameboy.Session(), restored.Session()) {
+		t.Errorf("expected the restored session %+v to match %+v", restored.Session(), gameboy.Session())
+	}
+
+	// A different ROM has no session
+	other, err := NewGameboy(Options{RomFilename: "testdata/blargg/cpu_instrs/individual/01-special.gb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadSession(dir); err != nil || len(other.Breakpoints()) != 0 {
+		t.Errorf("expected no session for a different ROM but got %v and %v", other.Breakpoints(), err)
+	}
+}
@@ -0,0 +1,79 @@
+package sgb
+
+import (
+	"image"
+	"image/color"
+)
+
+// Size of the picture on a television, with the Game Boy screen in the middle of the border
+const (
+	Width   = 256
+	Height  = 224
+	screenX = 48
+	screenY = 40
+)
+
+// Compose draws the border with the Game Boy screen inside it, colouring each 8x8 cell of the screen
+// with its attribute palette. The frame is 256x256 to suit the display, of which the top 256x224 is
+// used.
+func (s *SGB) Compose(shades *[144][160]uint8) (*image.RGBA, image.Rectangle) {
+	if s.frame == nil {
+		s.frame = image.NewRGBA(image.Rect(0, 0, 256, 256))
+	}
+	backdrop := colour(s.palettes[0][0])
+	for ty, row := range s.borderMap {
+		for tx, entry := range row {
+			tile := s.borderTiles[entry&0xff]
+			palette := int(entry>>10&0x07) - 4
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					tileX, tileY := x, y
+					if entry&0x4000 != 0 {
+						tileX = 7 - x
+					}
+					if entry&0x8000 != 0 {
+						tileY = 7 - y
+					}
+					c := backdrop
+					if index := tile[tileY][tileX]; index != 0 && palette >= 0 {
+						c = colour(s.borderPalettes[palette][index])
+					}
+					s.frame.SetRGBA(tx*8+x, ty*8+y, c)
+				}
+			}
+		}
+	}
+	if s.mask != maskFreeze {
+		for y := 0; y < 144; y++ {
+			for x := 0; x < 160; x++ {
+				var c color.RGBA
+				switch s.mask {
+				case maskBlack:
+					c = color.RGBA{0, 0, 0, 0xff}
+				case maskColour0:
+					c = backdrop
+				default:
+					c = colour(s.palettes[s.attributes[y/8][x/8]][shades[y][x]&3])
+				}
+				s.screen[y][x] = c
+			}
+		}
+	}
+	for y := 0; y < 144; y++ {
+		for x := 0; x < 160; x++ {
+			s.frame.SetRGBA(screenX+x, screenY+y, s.screen[y][x])
+		}
+	}
+	return s.frame, image.Rect(0, 0, Width, Height)
+}
+
+// colour converts an RGB555 colour
+func colour(rgb uint16) color.RGBA {
+	return color.RGBA{scale5(rgb), scale5(rgb >> 5), scale5(rgb >> 10), 0xff}
+}
+
+// scale5 expands the 5-bit colour component in the low bits of a value to 8 bits
+func scale5(value uint16) uint8 {
+	c := uint8(value & 0x1f)
+	return c<<3 | c>>2
+}
@@ -0,0 +1,390 @@
+// Package sgb emulates the Super Game Boy, which receives command packets that a game sends through
+// the joypad register and uses them to colour the screen and draw a border around it
+package sgb
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+)
+
+// Commands in the first byte of a packet, alongside the number of packets in the low 3 bits
+const (
+	pal01   = 0x00
+	pal23   = 0x01
+	pal03   = 0x02
+	pal12   = 0x03
+	attrBlk = 0x04
+	attrLin = 0x05
+	attrDiv = 0x06
+	attrChr = 0x07
+	palSet  = 0x0a
+	palTrn  = 0x0b
+	mltReq  = 0x11
+	chrTrn  = 0x13
+	pctTrn  = 0x14
+	attrTrn = 0x15
+	attrSet = 0x16
+	maskEn  = 0x17
+)
+
+// Screen masks set by MASK_EN
+const (
+	maskNone = iota
+	maskFreeze
+	maskBlack
+	maskColour0
+)
+
+// SGB holds the state of the Super Game Boy
+type SGB struct {
+	memory *mem.Memory
+	// enabled is false for games without Super Game Boy support in their header, whose packets are ignored
+	enabled bool
+
+	// Packet transfer
+	receiving bool
+	ready     bool
+	bits      int
+	packet    [16]byte
+	packets   [][16]byte
+	joyp      uint8
+
+	// Multiplayer
+	players int
+	player  int
+
+	// Colours are RGB555
+	palettes       [4][4]uint16
+	systemPalettes [512][4]uint16
+	attributes     [18][20]uint8
+	attributeFiles [45][90]byte
+	mask           int
+	borderTiles    [256][8][8]uint8
+	borderMap      [28][32]uint16
+	borderPalettes [4][16]uint16
+
+	// Rendering
+	frame  *image.RGBA
+	screen [144][160]color.RGBA
+}
+
+// defaultPalette is the palette 1-A that the Super Game Boy shows until a game picks another
+var defaultPalette = [4]uint16{rgb555(0xf8, 0xe8, 0xc8), rgb555(0xd8, 0x90, 0x48), rgb555(0xa8, 0x28, 0x20), rgb555(0x30, 0x18, 0x50)}
+
+// New returns a Super Game Boy that reads VRAM transfers from memory and only accepts packets if
+// enabled
+func New(memory *mem.Memory, enabled bool) *SGB {
+	s := &SGB{memory: memory, enabled: enabled, players: 1, joyp: 0x30}
+	for i := range s.palettes {
+		s.palettes[i] = defaultPalette
+	}
+	return s
+}
+
+func rgb555(r, g, b uint8) uint16 {
+	return uint16(r>>3) | uint16(g>>3)<<5 | uint16(b>>3)<<10
+}
+
+// WriteJOYP receives packets one bit at a time from writes to P14 and P15 of the JOYP register
+func (s *SGB) WriteJOYP(value uint8) {
+	previous := s.joyp
+	s.joyp = value & 0x30
+	switch s.joyp {
+	case 0x00:
+		// Both lines low resets the transfer of a packet
+		s.receiving = true
+		s.ready = false
+		s.bits = 0
+		s.packet = [16]byte{}
+	case 0x30:
+		s.ready = true
+		// Each time P15 goes high again the next controller is selected
+		if !s.receiving && previous&0x20 == 0 && s.players > 1 {
+			s.player = (s.player + 1) % s.players
+		}
+	default:
+		if !s.receiving || !s.ready {
+			return
+		}
+		s.ready = false
+		if s.bits == 128 {
+			// The packet ends with a zero stop bit
+			s.receiving = false
+			s.receive(s.packet)
+			return
+		}
+		if s.joyp == 0x10 {
+			s.packet[s.bits/8] |= 1 << (s.bits % 8)
+		}
+		s.bits++
+	}
+}
+
+// Player returns the controller being read, which is always the first until a game requests
+// multiplayer
+func (s *SGB) Player() int {
+	return s.player
+}
+
+// receive gathers packets until the command in the first one is complete
+func (s *SGB) receive(packet [16]byte) {
+	if !s.enabled {
+		return
+	}
+	s.packets = append(s.packets, packet)
+	length := int(s.packets[0][0] & 0x07)
+	if length == 0 {
+		length = 1
+	}
+	if len(s.packets) < length {
+		return
+	}
+	data := make([]byte, 0, length*16)
+	for _, p := range s.packets {
+		data = append(data, p[:]...)
+	}
+	s.packets = nil
+	s.command(data[0]>>3, data)
+}
+
+func (s *SGB) command(command uint8, data []byte) {
+	switch command {
+	case pal01:
+		s.setPalettes(0, 1, data)
+	case pal23:
+		s.setPalettes(2, 3, data)
+	case pal03:
+		s.setPalettes(0, 3, data)
+	case pal12:
+		s.setPalettes(1, 2, data)
+	case attrBlk:
+		s.attrBlk(data)
+	case attrLin:
+		s.attrLin(data)
+	case attrDiv:
+		s.attrDiv(data)
+	case attrChr:
+		s.attrChr(data)
+	case palSet:
+		s.palSet(data)
+	case palTrn:
+		vram := s.transfer()
+		for i := range s.systemPalettes {
+			for c := range s.systemPalettes[i] {
+				s.systemPalettes[i][c] = word(vram, i*8+c*2)
+			}
+		}
+	case mltReq:
+		s.players = [4]int{1, 2, 1, 4}[data[1]&0x03]
+		s.player = 0
+	case chrTrn:
+		vram := s.transfer()
+		first := int(data[1]&0x01) * 128
+		for t := 0; t < 128; t++ {
+			s.borderTiles[first+t] = snesTile(vram[t*32 : t*32+32])
+		}
+	case pctTrn:
+		vram := s.transfer()
+		for y := range s.borderMap {
+			for x := range s.borderMap[y] {
+				s.borderMap[y][x] = word(vram, (y*32+x)*2)
+			}
+		}
+		for p := range s.borderPalettes {
+			for c := range s.borderPalettes[p] {
+				s.borderPalettes[p][c] = word(vram, 0x800+p*32+c*2)
+			}
+		}
+	case attrTrn:
+		vram := s.transfer()
+		for i := range s.attributeFiles {
+			copy(s.attributeFiles[i][:], vram[i*90:])
+		}
+	case attrSet:
+		s.applyAttributeFile(int(data[1] & 0x3f))
+		if data[1]&0x40 != 0 {
+			s.mask = maskNone
+		}
+	case maskEn:
+		s.mask = int(data[1] & 0x03)
+	}
+}
+
+// setPalettes reads colour 0, which every palette shares, followed by three colours for each of two
+// palettes
+func (s *SGB) setPalettes(a, b int, data []byte) {
+	colour0 := word(data, 1)
+	for i := range s.palettes {
+		s.palettes[i][0] = colour0
+	}
+	for c := 1; c < 4; c++ {
+		s.palettes[a][c] = word(data, 1+c*2)
+		s.palettes[b][c] = word(data, 7+c*2)
+	}
+}
+
+func (s *SGB) palSet(data []byte) {
+	for i := range s.palettes {
+		s.palettes[i] = s.systemPalettes[word(data, 1+i*2)&0x1ff]
+	}
+	// Colour 0 of palette 0 is shared by every palette
+	for i := range s.palettes {
+		s.palettes[i][0] = s.palettes[0][0]
+	}
+	if data[9]&0x80 != 0 {
+		s.applyAttributeFile(int(data[9] & 0x3f))
+	}
+	if data[9]&0x40 != 0 {
+		s.mask = maskNone
+	}
+}
+
+// attrBlk colours the cells inside, outside and on the edge of rectangles
+func (s *SGB) attrBlk(data []byte) {
+	sets := int(data[1] & 0x1f)
+	for i := 0; i < sets && 2+i*6+5 < len(data); i++ {
+		set := data[2+i*6:]
+		control := set[0] & 0x07
+		inside, border, outside := set[1]&0x03, set[1]>>2&0x03, set[1]>>4&0x03
+		// The edge takes the colour of the inside or outside if only one of them is set
+		if control == 0x01 {
+			control, border = 0x03, inside
+		} else if control == 0x04 {
+			control, border = 0x06, outside
+		}
+		x1, y1, x2, y2 := int(set[2]&0x1f), int(set[3]&0x1f), int(set[4]&0x1f), int(set[5]&0x1f)
+		for y := range s.attributes {
+			for x := range s.attributes[y] {
+				switch {
+				case x > x1 && x < x2 && y > y1 && y < y2:
+					if control&0x01 != 0 {
+						s.attributes[y][x] = inside
+					}
+				case x >= x1 && x <= x2 && y >= y1 && y <= y2:
+					if control&0x02 != 0 {
+						s.attributes[y][x] = border
+					}
+				default:
+					if control&0x04 != 0 {
+						s.attributes[y][x] = outside
+					}
+				}
+			}
+		}
+	}
+}
+
+// attrLin colours whole rows or columns
+func (s *SGB) attrLin(data []byte) {
+	lines := int(data[1])
+	for i := 0; i < lines && 2+i < len(data); i++ {
+		line := data[2+i]
+		n, palette := int(line&0x1f), line>>5&0x03
+		if line&0x80 != 0 {
+			if n < 18 {
+				for x := range s.attributes[n] {
+					s.attributes[n][x] = palette
+				}
+			}
+		} else if n < 20 {
+			for y := range s.attributes {
+				s.attributes[y][n] = palette
+			}
+		}
+	}
+}
+
+// attrDiv divides the screen into two halves and the line between them
+func (s *SGB) attrDiv(data []byte) {
+	after, before, on := data[1]&0x03, data[1]>>2&0x03, data[1]>>4&0x03
+	horizontal := data[1]&0x40 != 0
+	n := int(data[2] & 0x1f)
+	for y := range s.attributes {
+		for x := range s.attributes[y] {
+			position := x
+			if horizontal {
+				position = y
+			}
+			switch {
+			case position < n:
+				s.attributes[y][x] = before
+			case position == n:
+				s.attributes[y][x] = on
+			default:
+				s.attributes[y][x] = after
+			}
+		}
+	}
+}
+
+// attrChr colours cells one by one from a starting cell, two bits per cell
+func (s *SGB) attrChr(data []byte) {
+	x, y := int(data[1]), int(data[2])
+	count := int(word(data, 3))
+	vertical := data[5] != 0
+	for i := 0; i < count && 6+i/4 < len(data) && x < 20 && y < 18; i++ {
+		s.attributes[y][x] = data[6+i/4] >> (6 - uint(i%4)*2) & 0x03
+		if vertical {
+			y++
+			if y == 18 {
+				y, x = 0, x+1
+			}
+		} else {
+			x++
+			if x == 20 {
+				x, y = 0, y+1
+			}
+		}
+	}
+}
+
+// applyAttributeFile colours every cell using one of the attribute files from ATTR_TRN
+func (s *SGB) applyAttributeFile(n int) {
+	if n >= len(s.attributeFiles) {
+		return
+	}
+	file := s.attributeFiles[n]
+	for i := 0; i < 20*18; i++ {
+		s.attributes[i/20][i%20] = file[i/4] >> (6 - uint(i%4)*2) & 0x03
+	}
+}
+
+// transfer reads the 4KB that a game shows on screen for a VRAM transfer, as the first 256 tiles of
+// the background map
+func (s *SGB) transfer() []byte {
+	m := s.memory
+	mapAddr := uint16(0x1800)
+	if m.LCDC&0x08 != 0 {
+		mapAddr = 0x1c00
+	}
+	data := make([]byte, 0, 0x1000)
+	for i := uint16(0); i < 256; i++ {
+		tileNumber := m.VideoRAM[0][mapAddr+i/20*32+i%20]
+		tileAddr := uint16(tileNumber) * 16
+		if m.LCDC&0x10 == 0 {
+			tileAddr = uint16(0x1000 + int(int8(tileNumber))*16)
+		}
+		data = append(data, m.VideoRAM[0][tileAddr:tileAddr+16]...)
+	}
+	return data
+}
+
+// snesTile decodes a tile with four bitplanes, stored as pairs of bitplanes a row at a time
+func snesTile(data []byte) [8][8]uint8 {
+	var tile [8][8]uint8
+	for y := 0; y < 8; y++ {
+		planes := [4]byte{data[y*2], data[y*2+1], data[16+y*2], data[16+y*2+1]}
+		for x := 0; x < 8; x++ {
+			for p, plane := range planes {
+				tile[y][x] |= (plane >> (7 - uint(x)) & 1) << uint(p)
+			}
+		}
+	}
+	return tile
+}
+
+func word(data []byte, offset int) uint16 {
+	return uint16(data[offset]) | uint16(data[offset+1])<<8
+}
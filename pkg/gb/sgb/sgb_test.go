@@ -0,0 +1,166 @@
+package sgb
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+)
+
+func newSGB(t *testing.T) *SGB {
+	memory, err := mem.NewMemory(make([]byte, 0x8000), nil, timer.NewTimer(), audio.NewAudio())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(memory, true)
+}
+
+// send writes a packet to JOYP one bit at a time
+func send(s *SGB, data ...byte) {
+	var packet [16]byte
+	copy(packet[:], data)
+	s.WriteJOYP(0x00)
+	s.WriteJOYP(0x30)
+	for i := 0; i < 128; i++ {
+		if packet[i/8]>>(i%8)&1 != 0 {
+			s.WriteJOYP(0x10)
+		} else {
+			s.WriteJOYP(0x20)
+		}
+		s.WriteJOYP(0x30)
+	}
+	s.WriteJOYP(0x20)
+	s.WriteJOYP(0x30)
+}
+
+func fill(shade uint8) *[144][160]uint8 {
+	var shades [144][160]uint8
+	for y := range shades {
+		for x := range shades[y] {
+			shades[y][x] = shade
+		}
+	}
+	return &shades
+}
+
+var (
+	red   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	green = color.RGBA{0x00, 0xff, 0x00, 0xff}
+	blue  = color.RGBA{0x00, 0x00, 0xff, 0xff}
+)
+
+func TestPalettesAndAttributes(t *testing.T) {
+	s := newSGB(t)
+	// PAL01 with colour 0 blue, palette 0 colour 1 red and palette 1 colour 1 green
+	send(s, pal01<<3|1, 0x00, 0x7c, 0x1f, 0x00, 0, 0, 0, 0, 0xe0, 0x03)
+	// ATTR_BLK giving palette 1 to the inside and edge of cells 2,2 to 4,4
+	send(s, attrBlk<<3|1, 1, 0x01, 0x01, 2, 2, 4, 4)
+
+	frame, bounds := s.Compose(fill(1))
+	if bounds.Dx() != Width || bounds.Dy() != Height {
+		t.Errorf("expected %dx%d but got %v", Width, Height, bounds)
+	}
+	if c := frame.RGBAAt(screenX, screenY); c != red {
+		t.Errorf("expected palette 0 outside the block but got %v", c)
+	}
+	if c := frame.RGBAAt(screenX+16, screenY+16); c != green {
+		t.Errorf("expected palette 1 on the edge of the block but got %v", c)
+	}
+	if c := frame.RGBAAt(screenX+39, screenY+39); c != green {
+		t.Errorf("expected palette 1 inside the block but got %v", c)
+	}
+	if c := frame.RGBAAt(0, 0); c != blue {
+		t.Errorf("expected the empty border to show colour 0 but got %v", c)
+	}
+
+	// MASK_EN black
+	send(s, maskEn<<3|1, 2)
+	frame, _ = s.Compose(fill(1))
+	if c := frame.RGBAAt(screenX, screenY); c != (color.RGBA{0, 0, 0, 0xff}) {
+		t.Errorf("expected a black screen but got %v", c)
+	}
+}
+
+func TestIgnoredWithoutSupport(t *testing.T) {
+	s := newSGB(t)
+	s.enabled = false
+	send(s, pal01<<3|1, 0x00, 0x7c, 0x1f, 0x00)
+	if s.palettes[0] != defaultPalette {
+		t.Errorf("expected the default palette but got %v", s.palettes[0])
+	}
+}
+
+func TestBorder(t *testing.T) {
+	s := newSGB(t)
+	memory := s.memory
+	memory.LCDC = 0x91
+	// Show tiles 0-255 in order so that the screen shows the first 4KB of VRAM
+	for i := 0; i < 256; i++ {
+		memory.VideoRAM[0][0x1800+i/20*32+i%20] = uint8(i)
+	}
+	// The first row of the first tile is colour 1 on the left half and colour 2 on the right
+	memory.VideoRAM[0][0] = 0xf0
+	memory.VideoRAM[0][1] = 0x0f
+	send(s, chrTrn<<3|1, 0)
+
+	for i := 0; i < 0x1000; i++ {
+		memory.VideoRAM[0][i] = 0
+	}
+	// Use the first tile at 0,0 with palette 4 flipped horizontally
+	memory.VideoRAM[0][1] = 0x50
+	// Palette 4 has colour 1 red and colour 2 green
+	memory.VideoRAM[0][0x802] = 0x1f
+	memory.VideoRAM[0][0x804] = 0xe0
+	memory.VideoRAM[0][0x805] = 0x03
+	send(s, pctTrn<<3|1)
+
+	frame, _ := s.Compose(fill(0))
+	if c := frame.RGBAAt(0, 0); c != green {
+		t.Errorf("expected colour 2 on the left of the flipped tile but got %v", c)
+	}
+	if c := frame.RGBAAt(7, 0); c != red {
+		t.Errorf("expected colour 1 on the right of the flipped tile but got %v", c)
+	}
+}
+
+func TestMultiplayer(t *testing.T) {
+	s := newSGB(t)
+	memory := s.memory
+	memory.JoypadPort = s
+	// Hold A on controller 1 and B on controller 2
+	memory.ButtonInput[0] = 0x0e
+	memory.ButtonInput[1] = 0x0d
+
+	if id := memory.Read(0xff00) & 0x0f; id != 0x0f {
+		t.Errorf("expected controller 1 but got 0x%x", id)
+	}
+	send(s, mltReq<<3|1, 1)
+	memory.Write(0xff00, 0x10)
+	memory.Write(0xff00, 0x30)
+	if id := memory.Read(0xff00) & 0x0f; id != 0x0e {
+		t.Errorf("expected controller 2 but got 0x%x", id)
+	}
+	memory.Write(0xff00, 0x10)
+	if buttons := memory.Read(0xff00) & 0x0f; buttons != 0x0d {
+		t.Errorf("expected B on controller 2 but got 0x%x", buttons)
+	}
+	memory.Write(0xff00, 0x30)
+	if s.Player() != 0 {
+		t.Errorf("expected controller 1 again but got controller %d", s.Player()+1)
+	}
+	memory.Write(0xff00, 0x10)
+	if buttons := memory.Read(0xff00) & 0x0f; buttons != 0x0e {
+		t.Errorf("expected A on controller 1 but got 0x%x", buttons)
+	}
+
+	send(s, mltReq<<3|1, 3)
+	for player := 1; player <= 4; player++ {
+		memory.Write(0xff00, 0x10)
+		memory.Write(0xff00, 0x30)
+		if s.Player() != player%4 {
+			t.Errorf("expected controller %d but got controller %d", player%4+1, s.Player()+1)
+		}
+	}
+}
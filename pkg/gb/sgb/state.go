@@ -0,0 +1,69 @@
+package sgb
+
+import "image/color"
+
+// State is a snapshot of the Super Game Boy, for save states
+type State struct {
+	Receiving      bool
+	Ready          bool
+	Bits           int
+	Packet         [16]byte
+	Packets        [][16]byte
+	JOYP           uint8
+	Players        int
+	Player         int
+	Palettes       [4][4]uint16
+	SystemPalettes [512][4]uint16
+	Attributes     [18][20]uint8
+	AttributeFiles [45][90]byte
+	Mask           int
+	BorderTiles    [256][8][8]uint8
+	BorderMap      [28][32]uint16
+	BorderPalettes [4][16]uint16
+	// Screen holds the last picture shown, which stays on screen while it is frozen by MASK_EN
+	Screen [144][160]color.RGBA
+}
+
+// Snapshot returns the state of the Super Game Boy
+func (s *SGB) Snapshot() State {
+	return State{
+		Receiving:      s.receiving,
+		Ready:          s.ready,
+		Bits:           s.bits,
+		Packet:         s.packet,
+		Packets:        append([][16]byte(nil), s.packets...),
+		JOYP:           s.joyp,
+		Players:        s.players,
+		Player:         s.player,
+		Palettes:       s.palettes,
+		SystemPalettes: s.systemPalettes,
+		Attributes:     s.attributes,
+		AttributeFiles: s.attributeFiles,
+		Mask:           s.mask,
+		BorderTiles:    s.borderTiles,
+		BorderMap:      s.borderMap,
+		BorderPalettes: s.borderPalettes,
+		Screen:         s.screen,
+	}
+}
+
+// Restore returns the Super Game Boy to a state taken by Snapshot
+func (s *SGB) Restore(state State) {
+	s.receiving = state.Receiving
+	s.ready = state.Ready
+	s.bits = state.Bits
+	s.packet = state.Packet
+	s.packets = append([][16]byte(nil), state.Packets...)
+	s.joyp = state.JOYP
+	s.players = state.Players
+	s.player = state.Player
+	s.palettes = state.Palettes
+	s.systemPalettes = state.SystemPalettes
+	s.attributes = state.Attributes
+	s.attributeFiles = state.AttributeFiles
+	s.mask = state.Mask
+	s.borderTiles = state.BorderTiles
+	s.borderMap = state.BorderMap
+	s.borderPalettes = state.BorderPalettes
+	s.screen = state.Screen
+}
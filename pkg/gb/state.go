@@ -0,0 +1,316 @@
+package gb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/lcd"
+	"github.com/scottyw/tetromino/pkg/gb/mem"
+	"github.com/scottyw/tetromino/pkg/gb/sgb"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+)
+
+// Version is the version of the emulator recorded in save states, which is set when building a
+// release with -ldflags "-X github.com/scottyw/tetromino/pkg/gb.Version=1.0.0"
+var Version = "dev"
+
+// stateMagic starts every save state file, followed by the version of the file format. The format
+// changes whenever a field is removed from the machine state or changes meaning, but not when fields
+// are added because gob decodes a missing field as its zero value.
+const (
+	stateMagic  = "TETROMINO STATE\n"
+	stateFormat = 1
+)
+
+// StateHeader starts each save state file and describes it, so that save states can be listed and
+// previewed without loading them
+type StateHeader struct {
+	// Created is when the save state was made
+	Created time.Time
+	// ROMHash is the SHA-1 hash of the ROM, which must match the ROM that the state is loaded into
+	ROMHash string
+	// Version is the version of the emulator that made the save state
+	Version string
+	// Thumbnail is the screen at half size
+	Thumbnail *image.RGBA
+}
+
+// stateHeader describes the current state of the Gameboy
+func (gb *Gameboy) stateHeader() StateHeader {
+	return StateHeader{
+		Created:   time.Now(),
+		ROMHash:   gb.romHash,
+		Version:   Version,
+		Thumbnail: thumbnail(gb.Frame()),
+	}
+}
+
+// checkStateHeader returns an error if a save state was made by a different ROM
+func (gb *Gameboy) checkStateHeader(h StateHeader) error {
+	if h.ROMHash != gb.romHash {
+		return fmt.Errorf("the save state is for a different ROM (SHA-1 %s rather than %s)", h.ROMHash, gb.romHash)
+	}
+	return nil
+}
+
+// StateFilename returns the file for a numbered save state slot, next to the battery save, or an
+// empty string if the ROM wasn't loaded from a file
+func (gb *Gameboy) StateFilename(slot int) string {
+	filename := saveFilename(gb.opts)
+	if filename == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s.state%d", strings.TrimSuffix(filename, filepath.Ext(filename)), slot)
+}
+
+// thumbnail shrinks a frame to half its size, averaging each 2x2 block of pixels
+func thumbnail(frame image.Image) *image.RGBA {
+	bounds := frame.Bounds()
+	small := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+	for y := 0; y < small.Rect.Dy(); y++ {
+		for x := 0; x < small.Rect.Dx(); x++ {
+			var r, g, b uint32
+			for _, p := range [4]image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				pr, pg, pb, _ := frame.At(bounds.Min.X+2*x+p.X, bounds.Min.Y+2*y+p.Y).RGBA()
+				r, g, b = r+pr>>8, g+pg>>8, b+pb>>8
+			}
+			i := small.PixOffset(x, y)
+			small.Pix[i], small.Pix[i+1], small.Pix[i+2], small.Pix[i+3] = uint8(r/4), uint8(g/4), uint8(b/4), 0xff
+		}
+	}
+	return small
+}
+
+// writeStateHeader writes the magic string and format version followed by the header. Strings and
+// the PNG thumbnail are each preceded by their length.
+func writeStateHeader(w io.Writer, h StateHeader) error {
+	var thumbnail bytes.Buffer
+	if h.Thumbnail != nil {
+		if err := png.Encode(&thumbnail, h.Thumbnail); err != nil {
+			return err
+		}
+	}
+	var header bytes.Buffer
+	header.WriteString(stateMagic)
+	binary.Write(&header, binary.BigEndian, uint16(stateFormat))
+	binary.Write(&header, binary.BigEndian, h.Created.UnixNano())
+	for _, field := range [][]byte{[]byte(h.ROMHash), []byte(h.Version), thumbnail.Bytes()} {
+		binary.Write(&header, binary.BigEndian, uint32(len(field)))
+		header.Write(field)
+	}
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+// ReadStateHeader reads the header from the start of a save state, leaving the reader at the
+// machine state that follows it
+func ReadStateHeader(r io.Reader) (StateHeader, error) {
+	var h StateHeader
+	magic := make([]byte, len(stateMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != stateMagic {
+		return h, fmt.Errorf("not a save state")
+	}
+	var format uint16
+	var created int64
+	if err := binary.Read(r, binary.BigEndian, &format); err != nil {
+		return h, err
+	}
+	if format != stateFormat {
+		return h, fmt.Errorf("unsupported save state format %d: expected %d", format, stateFormat)
+	}
+	if err := binary.Read(r, binary.BigEndian, &created); err != nil {
+		return h, err
+	}
+	h.Created = time.Unix(0, created)
+	var fields [3][]byte
+	for i := range fields {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return h, err
+		}
+		// The thumbnail is the largest field at a few kilobytes
+		if length > 1<<20 {
+			return h, fmt.Errorf("save state header field of %d bytes is too long", length)
+		}
+		fields[i] = make([]byte, length)
+		if _, err := io.ReadFull(r, fields[i]); err != nil {
+			return h, err
+		}
+	}
+	h.ROMHash, h.Version = string(fields[0]), string(fields[1])
+	if len(fields[2]) > 0 {
+		thumbnail, err := png.Decode(bytes.NewReader(fields[2]))
+		if err != nil {
+			return h, fmt.Errorf("bad save state thumbnail: %v", err)
+		}
+		rgba, ok := thumbnail.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(thumbnail.Bounds())
+			draw.Draw(rgba, rgba.Rect, thumbnail, rgba.Rect.Min, draw.Src)
+		}
+		h.Thumbnail = rgba
+	}
+	return h, nil
+}
+
+// ReadStateHeaderFile reads the header of a save state file without loading the rest of it
+func ReadStateHeaderFile(filename string) (StateHeader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return StateHeader{}, err
+	}
+	defer f.Close()
+	h, err := ReadStateHeader(bufio.NewReader(f))
+	if err != nil {
+		return h, fmt.Errorf("Failed to read the save state at \"%s\" (%v)", filename, err)
+	}
+	return h, nil
+}
+
+// machineState is everything in the Gameboy that a save state restores, which follows the header gob
+// encoded. The ROM, options and anything attached by a frontend aren't included.
+type machineState struct {
+	CGB            bool
+	CPU            cpu.State
+	Memory         mem.State
+	Timer          timer.State
+	LCD            lcd.State
+	Audio          audio.State
+	SGB            *sgb.State
+	Frame          int
+	MTick          int
+	SecondCPUCycle bool
+}
+
+// snapshot runs on to the end of the current instruction, because the CPU can only be restored
+// between instructions, and returns the state of the machine. The running lock must be held.
+func (gb *Gameboy) snapshot() machineState {
+	for !gb.dispatch.InstructionBoundary() {
+		gb.runMachineCycle()
+	}
+	state := machineState{
+		CGB:            gb.cgb,
+		CPU:            gb.dispatch.Snapshot(),
+		Memory:         gb.memory.Snapshot(),
+		Timer:          gb.timer.Snapshot(),
+		LCD:            gb.lcd.Snapshot(),
+		Audio:          gb.audio.Snapshot(),
+		Frame:          gb.frame,
+		MTick:          gb.mtick,
+		SecondCPUCycle: gb.secondCPUCycle,
+	}
+	if gb.superGameboy != nil {
+		s := gb.superGameboy.Snapshot()
+		state.SGB = &s
+	}
+	return state
+}
+
+// restore returns the machine to a state returned by snapshot, leaving it unchanged if the state
+// doesn't fit this Gameboy. The running lock must be held.
+func (gb *Gameboy) restore(state machineState) error {
+	if state.CGB && !gb.cgb {
+		return fmt.Errorf("the save state is for a Game Boy Color")
+	}
+	if !state.CGB && gb.cgb {
+		return fmt.Errorf("the save state isn't for a Game Boy Color")
+	}
+	if err := gb.memory.Restore(state.Memory); err != nil {
+		return err
+	}
+	gb.dispatch.Restore(state.CPU)
+	gb.timer.Restore(state.Timer)
+	gb.lcd.Restore(state.LCD)
+	gb.audio.Restore(state.Audio)
+	if gb.superGameboy != nil && state.SGB != nil {
+		gb.superGameboy.Restore(*state.SGB)
+	}
+	gb.frame = state.Frame
+	gb.mtick = state.MTick
+	gb.secondCPUCycle = state.SecondCPUCycle
+	// The game carries on after its boot intro
+	gb.intro = nil
+	// The buttons held now replace those held when the state was saved
+	gb.input.Lock()
+	defer gb.input.Unlock()
+	gb.applyInput()
+	return nil
+}
+
+// SaveState writes a save state holding the whole machine, including the CPU registers, memory, timer,
+// LCD and APU, so that LoadState can return to this moment
+func (gb *Gameboy) SaveState(w io.Writer) error {
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	if gb.intro != nil {
+		return fmt.Errorf("can't save state during the boot intro")
+	}
+	state := gb.snapshot()
+	if err := writeStateHeader(w, gb.stateHeader()); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&state)
+}
+
+// LoadState returns the machine to a save state written by SaveState for the same ROM
+func (gb *Gameboy) LoadState(r io.Reader) error {
+	h, err := ReadStateHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := gb.checkStateHeader(h); err != nil {
+		return err
+	}
+	var state machineState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("bad save state: %v", err)
+	}
+	gb.running.Lock()
+	defer gb.running.Unlock()
+	if err := gb.restore(state); err != nil {
+		return err
+	}
+	// The snapshots kept for rewinding are from a different game now
+	if gb.rewind != nil {
+		gb.rewind.clear()
+	}
+	return nil
+}
+
+// SaveStateFile writes a save state to a file, such as one named by StateFilename
+func (gb *Gameboy) SaveStateFile(filename string) error {
+	var buf bytes.Buffer
+	if err := gb.SaveState(&buf); err != nil {
+		return fmt.Errorf("Failed to save state (%v)", err)
+	}
+	if err := writeFileAtomically(filename, buf.Bytes()); err != nil {
+		return fmt.Errorf("Failed to write the save state at \"%s\" (%v)", filename, err)
+	}
+	return nil
+}
+
+// LoadStateFile loads a save state from a file
+func (gb *Gameboy) LoadStateFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := gb.LoadState(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("Failed to load the save state at \"%s\" (%v)", filename, err)
+	}
+	return nil
+}
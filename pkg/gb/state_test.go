@@ -0,0 +1,156 @@
+package gb
+
+import (
+	"bytes"
+	"image"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStateHeader(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(1)
+	header := gameboy.stateHeader()
+	var buf bytes.Buffer
+	if err := writeStateHeader(&buf, header); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("machine state")
+	read, err := ReadStateHeader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Created.Equal(header.Created) || read.ROMHash != header.ROMHash || read.Version != Version {
+		t.Errorf("expected %+v but got %+v", header, read)
+	}
+	if read.Thumbnail.Rect != image.Rect(0, 0, 80, 72) || !bytes.Equal(read.Thumbnail.Pix, header.Thumbnail.Pix) {
+		t.Errorf("expected the 80x72 thumbnail to be kept but got %v", read.Thumbnail.Rect)
+	}
+	if buf.String() != "machine state" {
+		t.Errorf("expected the machine state to follow the header but got %q", buf.String())
+	}
+	if err := gameboy.checkStateHeader(read); err != nil {
+		t.Error(err)
+	}
+
+	other, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.checkStateHeader(read); err == nil {
+		t.Error("expected an error loading a save state made by a different ROM")
+	}
+	if _, err := ReadStateHeader(strings.NewReader("not a save state at all")); err == nil {
+		t.Error("expected an error for a file that isn't a save state")
+	}
+	if _, err := ReadStateHeaderFile(gameboy.StateFilename(1)); err == nil {
+		t.Error("expected an error for a missing save state")
+	}
+}
+
+func TestStateFilename(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: "", SaveDir: "saves"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename := gameboy.StateFilename(1); filename != "" {
+		t.Errorf("expected no save state file without a ROM file but got %s", filename)
+	}
+	rom := writeRom(t, nil)
+	gameboy, err = NewGameboy(Options{RomFilename: rom, SaveDir: "saves"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join("saves", strings.TrimSuffix(filepath.Base(rom), filepath.Ext(rom))+".state3")
+	if filename := gameboy.StateFilename(3); filename != expected {
+		t.Errorf("expected %s but got %s", expected, filename)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	gameboy, err := NewGameboy(Options{RomFilename: "testdata/blargg/dmg_sound/rom_singles/01-registers.gb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(40)
+	// Stop part way through a frame
+	for i := 0; i < 1000; i++ {
+		gameboy.Step()
+	}
+	var buf bytes.Buffer
+	if err := gameboy.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := gameboy.StateHash()
+	gameboy.RunFrames(60)
+	expected := gameboy.StateHash()
+	expectedAudio := gameboy.audio.Snapshot()
+	expectedFrame := image.NewRGBA(gameboy.Frame().Bounds())
+	copy(expectedFrame.Pix, gameboy.Frame().(*image.RGBA).Pix)
+
+	state := buf.Bytes()
+	if err := gameboy.LoadState(bytes.NewReader(state)); err != nil {
+		t.Fatal(err)
+	}
+	if hash := gameboy.StateHash(); hash != saved {
+		t.Errorf("expected state hash %x after loading but got %x", saved, hash)
+	}
+	gameboy.RunFrames(60)
+	if hash := gameboy.StateHash(); hash != expected {
+		t.Errorf("expected state hash %x after running on but got %x", expected, hash)
+	}
+	if !reflect.DeepEqual(gameboy.audio.Snapshot(), expectedAudio) {
+		t.Error("expected the same APU state after running on")
+	}
+	if !bytes.Equal(gameboy.Frame().(*image.RGBA).Pix, expectedFrame.Pix) {
+		t.Error("expected the same frame after running on")
+	}
+
+	other, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadState(bytes.NewReader(state)); err == nil {
+		t.Error("expected an error loading a save state made by a different ROM")
+	}
+	if err := gameboy.LoadState(bytes.NewReader(state[:len(state)-100])); err == nil {
+		t.Error("expected an error loading a truncated save state")
+	}
+	if hash := gameboy.StateHash(); hash != expected {
+		t.Error("expected a save state that fails to load to leave the Gameboy unchanged")
+	}
+}
+
+func TestSaveStateFile(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x3c, 0x18, 0xfd}, // INC A; JR -3
+	})
+	gameboy, err := NewGameboy(Options{RomFilename: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(5)
+	filename := gameboy.StateFilename(1)
+	if err := gameboy.SaveStateFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	registers := gameboy.dispatch.Registers()
+	gameboy.RunFrames(5)
+	if err := gameboy.LoadStateFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := gameboy.dispatch.Registers(); loaded != registers {
+		t.Errorf("expected registers %v but got %v", registers, loaded)
+	}
+	if _, err := ReadStateHeaderFile(filename); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,153 @@
+package gb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+)
+
+// TestResult reports the outcome of running a test ROM
+type TestResult struct {
+	Passed   bool
+	Output   string
+	Frames   int
+	TimedOut bool
+}
+
+// TestCondition decides when a ROM run by RunTest has passed, which is once every condition that is
+// set holds at the end of a frame
+type TestCondition struct {
+	// Serial holds once the serial output contains this text
+	Serial string
+	// Fail ends the run as a failure once the serial output contains this text
+	Fail string
+	// Memory holds while this expression is true, such as "[0xA000]==0"
+	Memory string
+	// FrameHash holds while the hash of the frame returned by FrameHash matches
+	FrameHash string
+}
+
+// RunTest runs a ROM headless until the condition holds, or fails it once maxFrames frames have run
+// or the timeout has passed. Serial output is also written to opts.SBWriter when it is set. The setup
+// function, which may be nil, is called on the Gameboy before it runs, e.g. to attach scripted inputs.
+func RunTest(opts Options, condition TestCondition, maxFrames int, timeout time.Duration, setup func(*Gameboy) error) (*Gameboy, TestResult, error) {
+	if condition.Serial == "" && condition.Memory == "" && condition.FrameHash == "" {
+		return nil, TestResult{}, fmt.Errorf("no condition for the test to pass was given")
+	}
+	sbWriter := &bytes.Buffer{}
+	if opts.SBWriter != nil {
+		opts.SBWriter = io.MultiWriter(sbWriter, opts.SBWriter)
+	} else {
+		opts.SBWriter = sbWriter
+	}
+	gameboy, err := NewGameboy(opts)
+	if err != nil {
+		return nil, TestResult{}, err
+	}
+	if setup != nil {
+		if err := setup(gameboy); err != nil {
+			return nil, TestResult{}, err
+		}
+	}
+	var memory *expr.Expr
+	if condition.Memory != "" {
+		memory, err = gameboy.symbols.Parse(condition.Memory)
+		if err != nil {
+			return nil, TestResult{}, err
+		}
+	}
+	start := time.Now()
+	var result TestResult
+	failed := false
+	for !failed && result.Frames < maxFrames {
+		if timeout > 0 && time.Since(start) > timeout {
+			result.TimedOut = true
+			break
+		}
+		gameboy.RunFrames(1)
+		result.Frames++
+		result.Output = sbWriter.String()
+		if condition.Fail != "" && strings.Contains(result.Output, condition.Fail) {
+			failed = true
+			continue
+		}
+		if condition.Serial != "" && !strings.Contains(result.Output, condition.Serial) {
+			continue
+		}
+		if memory != nil && !memory.True(gameboy) {
+			continue
+		}
+		if condition.FrameHash != "" && !strings.EqualFold(gameboy.FrameHash(), condition.FrameHash) {
+			continue
+		}
+		result.Passed = true
+		break
+	}
+	if !result.Passed && !failed && result.Frames >= maxFrames {
+		result.TimedOut = true
+	}
+	return gameboy, result, nil
+}
+
+// RunBlarggTest runs one of blargg's test ROMs headless until it reports "Passed" or "Failed",
+// either via the serial port or via cartridge RAM, or until maxFrames frames have run
+func RunBlarggTest(opts Options, maxFrames int) (*Gameboy, TestResult, error) {
+	sbWriter := &bytes.Buffer{}
+	opts.SBWriter = sbWriter
+	gameboy, err := NewGameboy(opts)
+	if err != nil {
+		return nil, TestResult{}, err
+	}
+	var result TestResult
+	for result.Frames < maxFrames {
+		// Check every 10 frames for a result
+		gameboy.RunFrames(10)
+		result.Frames += 10
+		result.Output = sbWriter.String()
+		ram := string(gameboy.memory.BatteryRAM()[:0x2000])
+		if strings.Contains(ram, "Passed") || strings.Contains(ram, "Failed") {
+			result.Output = blarggRAMOutput(ram)
+		}
+		if strings.Contains(result.Output, "Passed") {
+			result.Passed = true
+			break
+		}
+		if strings.Contains(result.Output, "Failed") {
+			break
+		}
+	}
+	return gameboy, result, nil
+}
+
+// Blargg ROMs without serial output write a signature at A001 and zero-terminated text at A004
+func blarggRAMOutput(ram string) string {
+	text := ram[4:]
+	if i := strings.IndexByte(text, 0); i >= 0 {
+		text = text[:i]
+	}
+	return text
+}
+
+// RunMooneyeTest runs one of the mooneye-gb test ROMs headless until it executes the LD B,B
+// breakpoint or until maxFrames frames have run. The test passes if the registers then hold the
+// Fibonacci values 3, 5, 8, 13, 21 and 34 in B, C, D, E, H and L and fails if they hold 0x42.
+func RunMooneyeTest(opts Options, maxFrames int) (*Gameboy, TestResult, error) {
+	gameboy, err := NewGameboy(opts)
+	if err != nil {
+		return nil, TestResult{}, err
+	}
+	var result TestResult
+	for result.Frames < maxFrames && !gameboy.dispatch.Mooneye {
+		gameboy.RunFrames(1)
+		result.Frames++
+	}
+	r := gameboy.dispatch.Registers()
+	result.Output = fmt.Sprintf("%s (breakpoint reached: %t)", r, gameboy.dispatch.Mooneye)
+	result.Passed = gameboy.dispatch.Mooneye &&
+		r.B == 3 && r.C == 5 && r.D == 8 && r.E == 13 && r.H == 21 && r.L == 34
+	return gameboy, result, nil
+}
@@ -0,0 +1,57 @@
+package gb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTest(t *testing.T) {
+	opts := Options{RomFilename: "testdata/blargg/cpu_instrs/individual/01-special.gb"}
+	var output strings.Builder
+	opts.SBWriter = &output
+	gameboy, result, err := RunTest(opts, TestCondition{Serial: "Passed", Fail: "Failed"}, 60*60, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Passed || result.TimedOut || !strings.Contains(output.String(), "Passed") {
+		t.Fatalf("expected the serial output to pass the test but got %q after %d frames", result.Output, result.Frames)
+	}
+
+	// The same frame is shown when the test passes the same way again
+	hash := gameboy.FrameHash()
+	_, again, err := RunTest(Options{RomFilename: opts.RomFilename}, TestCondition{FrameHash: strings.ToUpper(hash)}, 60*60, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Passed || again.Frames != result.Frames {
+		t.Errorf("expected the frame hash to pass at frame %d but got %v at frame %d", result.Frames, again.Passed, again.Frames)
+	}
+
+	_, result, err = RunTest(Options{RomFilename: opts.RomFilename}, TestCondition{Serial: "Passed", Fail: "01-special"}, 60*60, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed || result.TimedOut {
+		t.Errorf("expected the fail text to fail the test but got passed %v and timed out %v", result.Passed, result.TimedOut)
+	}
+}
+
+func TestRunTestTimeout(t *testing.T) {
+	rom := writeRom(t, map[uint16][]byte{
+		0x0100: {0x18, 0xfe}, // JR -2
+	})
+	_, result, err := RunTest(Options{RomFilename: rom}, TestCondition{Memory: "[0xC000]==0x42"}, 30, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Passed || !result.TimedOut || result.Frames != 30 {
+		t.Errorf("expected the test to time out after 30 frames but got passed %v after %d frames", result.Passed, result.Frames)
+	}
+	if _, _, err := RunTest(Options{RomFilename: rom}, TestCondition{}, 30, time.Minute, nil); err == nil {
+		t.Error("expected an error without a condition")
+	}
+	if _, _, err := RunTest(Options{RomFilename: rom}, TestCondition{Memory: "[0xC000]=="}, 30, time.Minute, nil); err == nil {
+		t.Error("expected an error for a bad memory condition")
+	}
+}
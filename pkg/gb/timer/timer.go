@@ -1,5 +1,7 @@
 package timer
 
+import "fmt"
+
 var counterBitMasks = []uint16{
 	uint16(1) << 9,
 	uint16(1) << 3,
@@ -7,7 +9,14 @@ var counterBitMasks = []uint16{
 	uint16(1) << 7,
 }
 
-// Timer stores the state of the internal timer
+// The APU's frame sequencer steps at 512Hz when this bit of the divider falls, or the next bit up in
+// double speed mode where the divider counts twice as fast
+const (
+	frameSequencerBit            = uint16(1) << 12
+	frameSequencerBitDoubleSpeed = uint16(1) << 13
+)
+
+// Timer stores the state of the internal timer, whose 16-bit divider is shared with the APU
 type Timer struct {
 	counter     uint16
 	tac         uint8
@@ -19,6 +28,9 @@ type Timer struct {
 	overflow    bool
 	endCycleA   uint16
 	endCycleB   uint16
+	doubleSpeed bool
+	// frameSequencerStep is set when the divider steps the APU's frame sequencer
+	frameSequencerStep bool
 }
 
 // NewTimer creates an initialized timer
@@ -30,11 +42,18 @@ func NewTimer() *Timer {
 
 // EndMachineCycle updates the timer after a machine cycle
 func (t *Timer) EndMachineCycle() bool {
-	t.counter += 4
+	t.setCounter(t.counter + 4)
+	var interrupt bool
 	if t.overflow && t.counter == t.endCycleA {
 		t.endCycleA = 0xffff
-		if !t.timaWrite {
+		if t.timaWrite {
+			// The reload was cancelled so TMA writes no longer reach TIMA
+			t.overflow = false
+			t.endCycleB = 0xffff
+		} else {
+			// The timer interrupt is requested as TIMA is reloaded, a cycle after it overflows
 			t.tima = t.tma
+			interrupt = true
 		}
 	}
 	t.timaWrite = false
@@ -47,7 +66,6 @@ func (t *Timer) EndMachineCycle() bool {
 	}
 	t.tmaWrite = false
 	// Check for a falling edge
-	var interrupt bool
 	enableBitSet := t.tac&0x04 > 0
 	counterBitSet := t.counter&counterBitMasks[t.tac&0x03] > 0
 	edgeSet := enableBitSet && counterBitSet
@@ -58,16 +76,49 @@ func (t *Timer) EndMachineCycle() bool {
 			t.overflow = true
 			t.endCycleA = t.counter + 4
 			t.endCycleB = t.counter + 8
-			interrupt = true
 		}
 	}
 	t.lastEdgeSet = edgeSet
 	return interrupt
 }
 
-// Reset the counter to zero, used when a value is written to DIV
+// Reset the counter to zero, used when a value is written to DIV and when STOP is executed. Resetting
+// the counter can make its bits fall early, which steps the APU's frame sequencer and, through the
+// falling edge checked at the end of the machine cycle, increments TIMA. A reload of TIMA that is
+// under way when the counter is reset still happens the same number of cycles later.
 func (t *Timer) Reset() {
-	t.counter = 0
+	if t.overflow {
+		if t.endCycleA != 0xffff {
+			t.endCycleA -= t.counter
+		}
+		t.endCycleB -= t.counter
+	}
+	t.setCounter(0)
+}
+
+// setCounter changes the divider and steps the APU's frame sequencer if its bit falls
+func (t *Timer) setCounter(counter uint16) {
+	bit := frameSequencerBit
+	if t.doubleSpeed {
+		bit = frameSequencerBitDoubleSpeed
+	}
+	if t.counter&bit != 0 && counter&bit == 0 {
+		t.frameSequencerStep = true
+	}
+	t.counter = counter
+}
+
+// SetDoubleSpeed chooses the bit of the divider that steps the APU's frame sequencer so that it keeps
+// stepping at 512Hz in double speed mode
+func (t *Timer) SetDoubleSpeed(doubleSpeed bool) {
+	t.doubleSpeed = doubleSpeed
+}
+
+// FrameSequencerStep returns true once each time the divider steps the APU's frame sequencer
+func (t *Timer) FrameSequencerStep() bool {
+	step := t.frameSequencerStep
+	t.frameSequencerStep = false
+	return step
 }
 
 // DIV returns the value of the DIV register
@@ -95,12 +146,21 @@ func (t *Timer) WriteTAC(value uint8) {
 	t.tac = value
 }
 
-// WriteTIMA returns the value of the TIMA register
+// WriteTIMA returns the value of the TIMA register. Writes in the cycle after TIMA overflows cancel
+// its reload from TMA and the timer interrupt, while writes in the cycle after that, as TIMA is
+// reloaded, are ignored.
 func (t *Timer) WriteTIMA(value uint8) {
-	if t.counter != t.endCycleB-4 {
-		t.tima = value
-		t.timaWrite = true
+	if t.overflow && t.counter == t.endCycleB-4 {
+		return
 	}
+	t.tima = value
+	t.timaWrite = true
+}
+
+// Reloading returns true in the cycle after TIMA overflows, at the end of which TIMA is reloaded from
+// TMA and the timer interrupt is requested
+func (t *Timer) Reloading() bool {
+	return t.overflow && t.counter+4 == t.endCycleA
 }
 
 // WriteTMA returns the value of the TMA register
@@ -111,3 +171,71 @@ func (t *Timer) WriteTMA(value uint8) {
 		t.tima = value
 	}
 }
+
+// State is a snapshot of the timer's registers and internal state, for save states and for
+// diagnosing timing bugs in the debuggers
+type State struct {
+	// Counter is the 16-bit divider whose upper byte is DIV
+	Counter uint16
+	TIMA    uint8
+	TMA     uint8
+	TAC     uint8
+	// Overflow is true from TIMA overflowing until the cycle after it is reloaded from TMA, which
+	// ends when Counter reaches ReloadCycle and ReloadedCycle respectively
+	Overflow      bool
+	ReloadCycle   uint16
+	ReloadedCycle uint16
+	// LastEdge is the state of the counter bit selected by TAC, whose falling edge increments TIMA
+	LastEdge bool
+	// TIMAWritten and TMAWritten are true in the cycle that TIMA or TMA is written
+	TIMAWritten bool
+	TMAWritten  bool
+	DoubleSpeed bool
+	// FrameSequencerStep is true when the divider has stepped the APU's frame sequencer this cycle
+	FrameSequencerStep bool
+}
+
+// Snapshot returns the state of the timer
+func (t *Timer) Snapshot() State {
+	return State{
+		Counter:            t.counter,
+		TIMA:               t.tima,
+		TMA:                t.tma,
+		TAC:                t.tac,
+		Overflow:           t.overflow,
+		ReloadCycle:        t.endCycleA,
+		ReloadedCycle:      t.endCycleB,
+		LastEdge:           t.lastEdgeSet,
+		TIMAWritten:        t.timaWrite,
+		TMAWritten:         t.tmaWrite,
+		DoubleSpeed:        t.doubleSpeed,
+		FrameSequencerStep: t.frameSequencerStep,
+	}
+}
+
+// Restore returns the timer to a state taken by Snapshot
+func (t *Timer) Restore(s State) {
+	t.counter = s.Counter
+	t.tima = s.TIMA
+	t.tma = s.TMA
+	t.tac = s.TAC
+	t.overflow = s.Overflow
+	t.endCycleA = s.ReloadCycle
+	t.endCycleB = s.ReloadedCycle
+	t.lastEdgeSet = s.LastEdge
+	t.timaWrite = s.TIMAWritten
+	t.tmaWrite = s.TMAWritten
+	t.doubleSpeed = s.DoubleSpeed
+	t.frameSequencerStep = s.FrameSequencerStep
+}
+
+func (s State) String() string {
+	text := fmt.Sprintf("DIV %02x (%04x)  TIMA %02x  TMA %02x  TAC %02x", s.Counter>>8, s.Counter, s.TIMA, s.TMA, s.TAC)
+	switch {
+	case s.Overflow && s.Counter+4 == s.ReloadCycle:
+		text += "  overflowed, reloading next cycle"
+	case s.Overflow:
+		text += "  reloaded from TMA"
+	}
+	return text
+}
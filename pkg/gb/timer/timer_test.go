@@ -252,15 +252,21 @@ func TestTIMAReload(t *testing.T) {
 	interrupt = timer.EndMachineCycle()
 	// Cycle A starts
 	assertTima(t, timer, 0x00)
-	if !interrupt {
-		t.Errorf("Timer interrupt should have occurred")
+	if interrupt {
+		t.Errorf("Timer interrupt should not have occurred until TIMA is reloaded")
+	}
+	if !timer.Reloading() {
+		t.Errorf("TIMA should be reloading")
 	}
 	// Cycle A ends and another tick sets TIMA correctly
 	interrupt = timer.EndMachineCycle()
 	// Cycle B starts
 	assertTima(t, timer, 0x23)
-	if interrupt {
-		t.Errorf("Timer interrupt should not have occurred")
+	if !interrupt {
+		t.Errorf("Timer interrupt should have occurred")
+	}
+	if timer.Reloading() {
+		t.Errorf("TIMA should have been reloaded")
 	}
 }
 
@@ -281,10 +287,10 @@ func TestTIMAReloadWithWriteA(t *testing.T) {
 	interrupt = timer.EndMachineCycle()
 	// Cycle A starts
 	assertTima(t, timer, 0x00)
-	if !interrupt {
-		t.Errorf("Timer interrupt should have occurred")
+	if interrupt {
+		t.Errorf("Timer interrupt should not have occurred until TIMA is reloaded")
 	}
-	// Write TIMA during cycle A
+	// Write TIMA during cycle A, which cancels the reload and the interrupt
 	timer.WriteTIMA(0x57)
 	// Cycle A ends and another tick after the write should retain the written value
 	interrupt = timer.EndMachineCycle()
@@ -311,15 +317,15 @@ func TestTIMAReloadWithWriteB(t *testing.T) {
 	interrupt = timer.EndMachineCycle()
 	// Cycle A starts
 	assertTima(t, timer, 0x00)
-	if !interrupt {
-		t.Errorf("Timer interrupt should have occurred")
+	if interrupt {
+		t.Errorf("Timer interrupt should not have occurred until TIMA is reloaded")
 	}
 	// Cycle A ends and another tick sets TIMA correctly
 	interrupt = timer.EndMachineCycle()
 	// Cycle B starts
 	assertTima(t, timer, 0x23)
-	if interrupt {
-		t.Errorf("Timer interrupt should not have occurred")
+	if !interrupt {
+		t.Errorf("Timer interrupt should have occurred")
 	}
 	// Write TIMA during cycle B
 	timer.WriteTIMA(0x57)
@@ -348,15 +354,15 @@ func TestTIMAReloadWithTMAWrite(t *testing.T) {
 	interrupt = timer.EndMachineCycle()
 	// Cycle A starts
 	assertTima(t, timer, 0x00)
-	if !interrupt {
-		t.Errorf("Timer interrupt should have occurred")
+	if interrupt {
+		t.Errorf("Timer interrupt should not have occurred until TIMA is reloaded")
 	}
 	// Cycle A ends and another tick sets TIMA correctly
 	interrupt = timer.EndMachineCycle()
 	// Cycle B starts
 	assertTima(t, timer, 0x23)
-	if interrupt {
-		t.Errorf("Timer interrupt should not have occurred")
+	if !interrupt {
+		t.Errorf("Timer interrupt should have occurred")
 	}
 	// Write TMA during cycle B
 	timer.WriteTMA(0x57)
@@ -367,3 +373,96 @@ func TestTIMAReloadWithTMAWrite(t *testing.T) {
 		t.Errorf("Timer interrupt should not have occurred")
 	}
 }
+
+func TestTIMAReloadWithDIVWrite(t *testing.T) {
+	// Setup
+	timer := NewTimer()
+	timer.WriteTAC(0x05)
+	timer.counter = 0
+	timer.tima = 0xff
+	timer.tma = 0x23
+	// Execute to the rollover
+	interrupt := mticks(timer, 4)
+	// Cycle A starts
+	assertTima(t, timer, 0x00)
+	if interrupt {
+		t.Errorf("Timer interrupt should not have occurred until TIMA is reloaded")
+	}
+	// Write DIV during cycle A, which leaves the reload due at the end of the cycle
+	timer.Reset()
+	interrupt = timer.EndMachineCycle()
+	// Cycle B starts
+	assertTima(t, timer, 0x23)
+	if !interrupt {
+		t.Errorf("Timer interrupt should have occurred")
+	}
+	// TIMA counts up from TMA without being reloaded again before it next overflows
+	previous := timer.TIMA()
+	for i := 0; i < 800; i++ {
+		if timer.EndMachineCycle() {
+			t.Fatalf("Timer interrupt should not have occurred after %d machine cycles", i+1)
+		}
+		if timer.TIMA() < previous {
+			t.Fatalf("TIMA went back from 0x%02x to 0x%02x after %d machine cycles", previous, timer.TIMA(), i+1)
+		}
+		previous = timer.TIMA()
+	}
+}
+
+func TestFrameSequencerStep(t *testing.T) {
+	timer := NewTimer()
+	timer.counter = 0
+	steps := 0
+	for i := 0; i < 4*2048; i++ {
+		timer.EndMachineCycle()
+		if timer.FrameSequencerStep() {
+			steps++
+		}
+	}
+	// The frame sequencer steps at 512Hz, once every 2048 machine cycles
+	if steps != 4 {
+		t.Errorf("expected 4 steps but got %d", steps)
+	}
+	// Resetting DIV while bit 12 is set steps it early
+	timer.counter = 0x1000
+	timer.Reset()
+	if !timer.FrameSequencerStep() {
+		t.Error("expected a step when resetting DIV with bit 12 set")
+	}
+	timer.Reset()
+	if timer.FrameSequencerStep() {
+		t.Error("expected no step when resetting DIV with bit 12 clear")
+	}
+	// Double speed uses bit 13 so that it still steps at 512Hz
+	timer.SetDoubleSpeed(true)
+	timer.counter = 0x1000
+	timer.Reset()
+	if timer.FrameSequencerStep() {
+		t.Error("expected no step at double speed when resetting DIV with bit 13 clear")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	timer := NewTimer()
+	timer.WriteTAC(0x05)
+	timer.counter = 0
+	timer.tima = 0xff
+	timer.tma = 0x23
+	// Take a snapshot in the cycle after TIMA overflows, while it is waiting to be reloaded
+	mticks(timer, 4)
+	state := timer.Snapshot()
+	if !state.Overflow || state.TIMA != 0x00 {
+		t.Errorf("expected a pending reload but got %s", state)
+	}
+	restored := NewTimer()
+	restored.Restore(state)
+	if restored.Snapshot() != state {
+		t.Errorf("expected %+v but got %+v", state, restored.Snapshot())
+	}
+	mticks(timer, 1)
+	mticks(restored, 1)
+	assertTima(t, restored, 0x23)
+	if restored.Snapshot() != timer.Snapshot() {
+		t.Errorf("expected the restored timer to run the same as the original")
+	}
+}
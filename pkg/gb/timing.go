@@ -0,0 +1,114 @@
+package gb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// timingWindow is the number of recent frames covered by frame timing statistics, about 10 seconds
+const timingWindow = 600
+
+// FrameStats summarises how long recent frames took to run, in wall-clock time
+type FrameStats struct {
+	Frames int
+	Min    time.Duration
+	Avg    time.Duration
+	P95    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+	// Emulation, Render and Sleep break down the average frame into running the hardware, drawing
+	// the frame and passing it to the hooks and display, and waiting for the speakers to play audio or
+	// for the pacer
+	Emulation time.Duration
+	Render    time.Duration
+	Sleep     time.Duration
+}
+
+// frameTiming keeps the timings of recent frames
+type frameTiming struct {
+	mu     sync.Mutex
+	total  [timingWindow]time.Duration
+	render [timingWindow]time.Duration
+	sleep  [timingWindow]time.Duration
+	next   int
+	count  int
+}
+
+func (ft *frameTiming) record(total, render, sleep time.Duration) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.total[ft.next] = total
+	ft.render[ft.next] = render
+	ft.sleep[ft.next] = sleep
+	ft.next = (ft.next + 1) % timingWindow
+	if ft.count < timingWindow {
+		ft.count++
+	}
+}
+
+// addSleep adds time spent waiting after the most recent frame to its timings
+func (ft *frameTiming) addSleep(sleep time.Duration) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.count == 0 {
+		return
+	}
+	last := (ft.next + timingWindow - 1) % timingWindow
+	ft.total[last] += sleep
+	ft.sleep[last] += sleep
+}
+
+func (ft *frameTiming) reset() {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.next = 0
+	ft.count = 0
+}
+
+func (ft *frameTiming) stats() FrameStats {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	stats := FrameStats{Frames: ft.count}
+	if ft.count == 0 {
+		return stats
+	}
+	totals := make([]time.Duration, ft.count)
+	copy(totals, ft.total[:ft.count])
+	sort.Slice(totals, func(i, j int) bool { return totals[i] < totals[j] })
+	var total, render, sleep time.Duration
+	for i := 0; i < ft.count; i++ {
+		total += ft.total[i]
+		render += ft.render[i]
+		sleep += ft.sleep[i]
+	}
+	n := time.Duration(ft.count)
+	stats.Min = totals[0]
+	stats.Max = totals[ft.count-1]
+	stats.Avg = total / n
+	stats.P95 = percentile(totals, 95)
+	stats.P99 = percentile(totals, 99)
+	stats.Render = render / n
+	stats.Sleep = sleep / n
+	stats.Emulation = stats.Avg - stats.Render - stats.Sleep
+	return stats
+}
+
+// percentile returns the smallest value that is at least as large as p percent of the sorted values
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// FrameStats returns timing statistics for the frames most recently run by Run or RunFrames
+func (gb *Gameboy) FrameStats() FrameStats {
+	return gb.timing.stats()
+}
+
+// ResetFrameStats forgets the timings of previous frames, such as to ignore frames run to warm up
+func (gb *Gameboy) ResetFrameStats() {
+	gb.timing.reset()
+}
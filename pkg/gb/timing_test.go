@@ -0,0 +1,57 @@
+package gb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrameStats(t *testing.T) {
+	var ft frameTiming
+	if stats := ft.stats(); stats.Frames != 0 {
+		t.Errorf("expected no frames but got %d", stats.Frames)
+	}
+	// Frames of 1ms to 100ms, each spending a tenth drawing and a fifth sleeping
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * time.Millisecond
+		ft.record(d, d/10, d/5)
+	}
+	stats := ft.stats()
+	expected := FrameStats{
+		Frames:    100,
+		Min:       time.Millisecond,
+		Avg:       50500 * time.Microsecond,
+		P95:       95 * time.Millisecond,
+		P99:       99 * time.Millisecond,
+		Max:       100 * time.Millisecond,
+		Emulation: 35350 * time.Microsecond,
+		Render:    5050 * time.Microsecond,
+		Sleep:     10100 * time.Microsecond,
+	}
+	if stats != expected {
+		t.Errorf("expected %+v but got %+v", expected, stats)
+	}
+
+	// Only the most recent frames are kept
+	for i := 0; i < timingWindow; i++ {
+		ft.record(time.Millisecond, 0, 0)
+	}
+	if stats := ft.stats(); stats.Frames != timingWindow || stats.Max != time.Millisecond {
+		t.Errorf("expected only the recent frames but got %+v", stats)
+	}
+}
+
+func TestFrameStatsRecorded(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(3)
+	stats := gameboy.FrameStats()
+	if stats.Frames != 3 || stats.Min <= 0 || stats.Render <= 0 || stats.Emulation <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	gameboy.ResetFrameStats()
+	if stats := gameboy.FrameStats(); stats.Frames != 0 {
+		t.Errorf("expected no frames after a reset but got %d", stats.Frames)
+	}
+}
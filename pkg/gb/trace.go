@@ -0,0 +1,33 @@
+package gb
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// WriteTrace writes the most recently executed instructions, oldest first
+func (gb *Gameboy) WriteTrace(w io.Writer) error {
+	for _, entry := range gb.dispatch.Trace() {
+		if _, err := fmt.Fprintln(w, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpTrace writes the most recently executed instructions to a new file in the trace dump
+// directory and returns its name
+func (gb *Gameboy) DumpTrace() (string, error) {
+	filename := timestampedFilename(gb.opts.TraceDumpDir, "tetromino-trace", "txt")
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	err = gb.WriteTrace(f)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return filename, f.Close()
+}
@@ -0,0 +1,60 @@
+package gb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/logging"
+)
+
+func TestDumpTrace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tetromino-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gameboy, err := NewGameboy(Options{TraceLength: 8, TraceDumpDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.RunFrames(1)
+	filename, err := gameboy.DumpTrace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 instructions in the trace but got %d", len(lines))
+	}
+	if !strings.Contains(lines[7], "NOP") {
+		t.Errorf("expected the empty ROM to execute NOPs but got %q", lines[7])
+	}
+}
+
+func TestDebugCPULogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.Warn, false)
+	gameboy, err := NewGameboy(Options{DebugCPU: true, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.Step()
+	gameboy.Step()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "DEBUG cpu: 0x0100: [00] NOP") {
+		t.Errorf("expected two instructions logged by the cpu subsystem but got:\n%s", buf.String())
+	}
+	logger.SetLevel("cpu", logging.Info)
+	buf.Reset()
+	gameboy.Step()
+	if buf.Len() != 0 {
+		t.Errorf("expected no logging at the info level but got %s", buf.String())
+	}
+}
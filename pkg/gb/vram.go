@@ -0,0 +1,66 @@
+package gb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tiles is the number of 8x8 tiles in video RAM
+const Tiles = 384
+
+// Palettes maps the names of the palette registers to their addresses
+var Palettes = map[string]uint16{
+	"bgp":  0xff47,
+	"obp0": 0xff48,
+	"obp1": 0xff49,
+}
+
+// TilePixel returns the colour index (0-3) of a pixel in a tile in video RAM
+func (gb *Gameboy) TilePixel(tile, x, y int) (uint8, error) {
+	addr, bit, err := tilePixelAddr(tile, x, y)
+	if err != nil {
+		return 0, err
+	}
+	low, high := gb.memory.Peek(addr), gb.memory.Peek(addr+1)
+	return (high>>bit&1)<<1 | low>>bit&1, nil
+}
+
+// SetTilePixel writes the colour index (0-3) of a pixel in a tile in video RAM. The change is
+// written through the memory bus so that it is visible to the LCD on the next scanline.
+func (gb *Gameboy) SetTilePixel(tile, x, y int, colour uint8) error {
+	addr, bit, err := tilePixelAddr(tile, x, y)
+	if err != nil {
+		return err
+	}
+	if colour > 3 {
+		return fmt.Errorf("bad colour %d: expected 0-3", colour)
+	}
+	low, high := gb.memory.Peek(addr), gb.memory.Peek(addr+1)
+	mask := uint8(1) << bit
+	low = low&^mask | (colour&1)<<bit
+	high = high&^mask | (colour>>1)<<bit
+	gb.memory.Write(addr, low)
+	gb.memory.Write(addr+1, high)
+	return nil
+}
+
+// SetPalette writes one of the palette registers bgp, obp0 or obp1
+func (gb *Gameboy) SetPalette(name string, value uint8) error {
+	addr, ok := Palettes[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown palette %q: expected bgp, obp0 or obp1", name)
+	}
+	gb.memory.Write(addr, value)
+	return nil
+}
+
+// tilePixelAddr returns the address of the low byte of a tile row and the bit for a pixel in it
+func tilePixelAddr(tile, x, y int) (uint16, uint, error) {
+	if tile < 0 || tile >= Tiles {
+		return 0, 0, fmt.Errorf("bad tile %d: expected 0-%d", tile, Tiles-1)
+	}
+	if x < 0 || x > 7 || y < 0 || y > 7 {
+		return 0, 0, fmt.Errorf("bad pixel %d,%d: expected 0-7", x, y)
+	}
+	return uint16(0x8000 + tile*16 + y*2), uint(7 - x), nil
+}
@@ -0,0 +1,42 @@
+package gb
+
+import "testing"
+
+func TestSetTilePixel(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.SetTilePixel(1, 0, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.SetTilePixel(1, 7, 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	if low, high := gameboy.PeekMemory(0x8014), gameboy.PeekMemory(0x8015); low != 0x80 || high != 0x81 {
+		t.Errorf("expected tile row bytes 80 81 but got %02x %02x", low, high)
+	}
+	if colour, _ := gameboy.TilePixel(1, 7, 2); colour != 2 {
+		t.Errorf("expected colour 2 but got %d", colour)
+	}
+	if err := gameboy.SetTilePixel(1, 0, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if colour, _ := gameboy.TilePixel(1, 0, 2); colour != 0 {
+		t.Errorf("expected colour 0 but got %d", colour)
+	}
+	for _, bad := range [][4]int{{384, 0, 0, 0}, {0, 8, 0, 0}, {0, 0, -1, 0}, {0, 0, 0, 4}} {
+		if err := gameboy.SetTilePixel(bad[0], bad[1], bad[2], uint8(bad[3])); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+	if err := gameboy.SetPalette("OBP1", 0x1b); err != nil {
+		t.Fatal(err)
+	}
+	if value := gameboy.PeekMemory(0xff49); value != 0x1b {
+		t.Errorf("expected obp1 to be 1b but got %02x", value)
+	}
+	if err := gameboy.SetPalette("obp2", 0); err == nil {
+		t.Errorf("expected an error for an unknown palette")
+	}
+}
@@ -0,0 +1,89 @@
+package gb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+)
+
+// Watch is an expression whose value is sampled at the start of every V-Blank, or at the end of
+// every frame while the LCD is off
+type Watch struct {
+	Expression string
+	Value      int
+}
+
+type watch struct {
+	expr  *expr.Expr
+	value int
+}
+
+// LoadSymbols reads a symbol file so that symbol names can be used in breakpoint conditions and watches
+func (gb *Gameboy) LoadSymbols(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to read the symbol file at \"%s\" (%v)", filename, err)
+	}
+	defer f.Close()
+	symbols, err := expr.LoadSymbols(f)
+	if err != nil {
+		return fmt.Errorf("Failed to read the symbol file at \"%s\" (%v)", filename, err)
+	}
+	gb.symbols = symbols
+	// Remember where the symbols came from so that a saved session can find them from anywhere
+	gb.symbolFilename, err = filepath.Abs(filename)
+	if err != nil {
+		gb.symbolFilename = filename
+	}
+	return nil
+}
+
+// Symbols returns the symbols loaded from a symbol file
+func (gb *Gameboy) Symbols() expr.Symbols {
+	return gb.symbols
+}
+
+// Symbol returns the address of a symbol loaded from a symbol file
+func (gb *Gameboy) Symbol(name string) (uint16, bool) {
+	addr, ok := gb.symbols[name]
+	return addr, ok
+}
+
+// AddWatch starts sampling an expression once per frame. A bare address or symbol
+// watches the byte of memory at that address.
+func (gb *Gameboy) AddWatch(expression string) error {
+	e, err := gb.symbols.ParseWatch(expression)
+	if err != nil {
+		return err
+	}
+	gb.watches = append(gb.watches, &watch{expr: e, value: e.Eval(gb)})
+	return nil
+}
+
+// RemoveWatch stops sampling an expression, returning false if it was not being watched
+func (gb *Gameboy) RemoveWatch(expression string) bool {
+	for i, w := range gb.watches {
+		if w.expr.String() == expression {
+			gb.watches = append(gb.watches[:i], gb.watches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Watches returns the watched expressions with the values sampled in the most recent frame
+func (gb *Gameboy) Watches() []Watch {
+	watches := make([]Watch, len(gb.watches))
+	for i, w := range gb.watches {
+		watches[i] = Watch{Expression: w.expr.String(), Value: w.value}
+	}
+	return watches
+}
+
+func (gb *Gameboy) sampleWatches() {
+	for _, w := range gb.watches {
+		w.value = w.expr.Eval(gb)
+	}
+}
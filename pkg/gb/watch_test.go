@@ -0,0 +1,28 @@
+package gb
+
+import "testing"
+
+func TestWatches(t *testing.T) {
+	gameboy, err := NewGameboy(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.AddWatch("0xc000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gameboy.AddWatch("[0xc000] + A"); err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0xc000, 0x42)
+	if value := gameboy.Watches()[0].Value; value != 0 {
+		t.Errorf("expected the watch not to be sampled until the end of the frame but got %d", value)
+	}
+	gameboy.RunFrames(1)
+	watches := gameboy.Watches()
+	if watches[0].Value != 0x42 || watches[1].Value != 0x43 {
+		t.Errorf("unexpected watch values: %v", watches)
+	}
+	if !gameboy.RemoveWatch("0xc000") || len(gameboy.Watches()) != 1 {
+		t.Errorf("expected the watch to be removed")
+	}
+}
@@ -0,0 +1,379 @@
+// Package gdbstub implements the GDB remote serial protocol so that gdb, or an IDE frontend that
+// speaks the protocol, can debug code running on the emulated Gameboy.
+//
+// GDB has no SM83 target description so registers are exchanged in the order used by Z80 targets:
+// AF, BC, DE, HL, SP and PC, each as a 16-bit little-endian value.
+package gdbstub
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/logging"
+)
+
+// Target is the part of the emulator controlled by the debugger
+type Target interface {
+	Registers() cpu.Registers
+	SetRegisters(cpu.Registers)
+	ReadMemory(addr uint16) uint8
+	WriteMemory(addr uint16, value uint8)
+	SetBreakpoint(addr uint16)
+	ClearBreakpoint(addr uint16) bool
+	Step()
+	Continue(ctx context.Context) bool
+	RunFrames(frames int)
+}
+
+// Signals reported to gdb when the target stops
+const (
+	sigint  = 2
+	sigtrap = 5
+)
+
+// interrupt is sent by gdb outside of a packet when the user presses Ctrl-C
+const interrupt = 0x03
+
+// ListenAndServe listens on a TCP address and serves one debugger connection at a time until the
+// context is done. The target runs freely while no debugger is attached.
+func ListenAndServe(ctx context.Context, addr string, target Target) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	logger := logging.Default().With("gdb")
+	logger.Infof("Waiting for gdb on %s", listener.Addr())
+	conns := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- conn
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case conn, ok := <-conns:
+			if !ok {
+				return errors.New("gdb listener closed")
+			}
+			logger.Infof("gdb attached from %s", conn.RemoteAddr())
+			err := Serve(ctx, conn, target)
+			conn.Close()
+			if err != nil && err != io.EOF {
+				logger.Warnf("gdb session ended: %v", err)
+			}
+		default:
+			target.RunFrames(1)
+		}
+	}
+}
+
+// Serve handles a single debugger connection. The target is stopped while it waits for commands.
+func Serve(ctx context.Context, conn io.ReadWriter, target Target) error {
+	s := &session{
+		target:     target,
+		w:          conn,
+		packets:    make(chan string),
+		interrupts: make(chan struct{}, 1),
+		errs:       make(chan error, 1),
+		done:       make(chan struct{}),
+	}
+	defer close(s.done)
+	go s.read(bufio.NewReader(conn))
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-s.errs:
+			return err
+		case <-s.interrupts:
+			// Already stopped
+			if err := s.send(fmt.Sprintf("S%02x", sigint)); err != nil {
+				return err
+			}
+		case packet := <-s.packets:
+			reply, detach := s.handle(ctx, packet)
+			if err := s.send(reply); err != nil {
+				return err
+			}
+			if detach {
+				return nil
+			}
+		}
+	}
+}
+
+type session struct {
+	target     Target
+	w          io.Writer
+	packets    chan string
+	interrupts chan struct{}
+	errs       chan error
+	done       chan struct{}
+}
+
+// read splits the incoming byte stream into packets and interrupts, acknowledging each packet
+func (s *session) read(r *bufio.Reader) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			s.errs <- err
+			return
+		}
+		switch b {
+		case interrupt:
+			select {
+			case s.interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				s.errs <- err
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+			checksum := make([]byte, 2)
+			if _, err := io.ReadFull(r, checksum); err != nil {
+				s.errs <- err
+				return
+			}
+			expected, err := strconv.ParseUint(string(checksum), 16, 8)
+			if err != nil || uint8(expected) != sum(data) {
+				s.w.Write([]byte("-"))
+				continue
+			}
+			s.w.Write([]byte("+"))
+			select {
+			case s.packets <- data:
+			case <-s.done:
+				return
+			}
+		default:
+			// Acknowledgements from gdb are ignored since the transport is reliable
+		}
+	}
+}
+
+func (s *session) send(data string) error {
+	_, err := fmt.Fprintf(s.w, "$%s#%02x", data, sum(data))
+	return err
+}
+
+func sum(data string) uint8 {
+	var total uint8
+	for i := 0; i < len(data); i++ {
+		total += data[i]
+	}
+	return total
+}
+
+// handle runs a command and returns the reply along with whether the debugger has detached
+func (s *session) handle(ctx context.Context, packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+	command, args := packet[0], packet[1:]
+	switch command {
+	case '?':
+		return fmt.Sprintf("S%02x", sigtrap), false
+	case 'g':
+		return encodeRegisters(s.target.Registers()), false
+	case 'G':
+		r, err := decodeRegisters(args, s.target.Registers())
+		if err != nil {
+			return "E01", false
+		}
+		s.target.SetRegisters(r)
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= registerCount {
+			return "E01", false
+		}
+		encoded := encodeRegisters(s.target.Registers())
+		return encoded[n*4 : n*4+4], false
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || n >= registerCount || len(parts) != 2 || len(parts[1]) != 4 {
+			return "E01", false
+		}
+		encoded := []byte(encodeRegisters(s.target.Registers()))
+		copy(encoded[n*4:], parts[1])
+		r, err := decodeRegisters(string(encoded), s.target.Registers())
+		if err != nil {
+			return "E01", false
+		}
+		s.target.SetRegisters(r)
+		return "OK", false
+	case 'm':
+		addr, length, err := parseAddrLength(args)
+		if err != nil {
+			return "E01", false
+		}
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = s.target.ReadMemory(addr + uint16(i))
+		}
+		return hex.EncodeToString(data), false
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, length, err := parseAddrLength(parts[0])
+		if err != nil || len(parts) != 2 {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != length {
+			return "E01", false
+		}
+		for i, value := range data {
+			s.target.WriteMemory(addr+uint16(i), value)
+		}
+		return "OK", false
+	case 's':
+		if err := s.resumeAt(args); err != nil {
+			return "E01", false
+		}
+		s.target.Step()
+		return fmt.Sprintf("S%02x", sigtrap), false
+	case 'c':
+		if err := s.resumeAt(args); err != nil {
+			return "E01", false
+		}
+		return s.cont(ctx), false
+	case 'Z', 'z':
+		parts := strings.Split(args, ",")
+		// Only software and hardware execution breakpoints are supported
+		if len(parts) < 2 || (parts[0] != "0" && parts[0] != "1") {
+			return "", false
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return "E01", false
+		}
+		if command == 'Z' {
+			s.target.SetBreakpoint(uint16(addr))
+		} else {
+			s.target.ClearBreakpoint(uint16(addr))
+		}
+		return "OK", false
+	case 'H':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		return "", true
+	case 'q':
+		switch {
+		case strings.HasPrefix(args, "Supported"):
+			return "PacketSize=1000", false
+		case args == "Attached":
+			return "1", false
+		case args == "C":
+			return "QC1", false
+		case args == "fThreadInfo":
+			return "m1", false
+		case args == "sThreadInfo":
+			return "l", false
+		}
+	}
+	// An empty reply tells gdb the command is not supported
+	return "", false
+}
+
+// resumeAt moves PC to the optional address given with a step or continue command
+func (s *session) resumeAt(args string) error {
+	if args == "" {
+		return nil
+	}
+	addr, err := strconv.ParseUint(args, 16, 16)
+	if err != nil {
+		return err
+	}
+	r := s.target.Registers()
+	r.PC = uint16(addr)
+	s.target.SetRegisters(r)
+	return nil
+}
+
+// cont runs the target until it reaches a breakpoint or gdb interrupts it
+func (s *session) cont(ctx context.Context) string {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.interrupts:
+			cancel()
+		case <-done:
+		}
+	}()
+	if s.target.Continue(ctx) {
+		return fmt.Sprintf("S%02x", sigtrap)
+	}
+	return fmt.Sprintf("S%02x", sigint)
+}
+
+func parseAddrLength(args string) (uint16, int, error) {
+	parts := strings.SplitN(args, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed address and length: %q", args)
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(addr), int(length), nil
+}
+
+const registerCount = 6
+
+func encodeRegisters(r cpu.Registers) string {
+	data := []byte{
+		r.F, r.A,
+		r.C, r.B,
+		r.E, r.D,
+		r.L, r.H,
+		uint8(r.SP), uint8(r.SP >> 8),
+		uint8(r.PC), uint8(r.PC >> 8),
+	}
+	return hex.EncodeToString(data)
+}
+
+func decodeRegisters(encoded string, r cpu.Registers) (cpu.Registers, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return r, err
+	}
+	if len(data) < registerCount*2 {
+		return r, fmt.Errorf("expected %d bytes of registers but got %d", registerCount*2, len(data))
+	}
+	r.F, r.A = data[0], data[1]
+	r.C, r.B = data[2], data[3]
+	r.E, r.D = data[4], data[5]
+	r.L, r.H = data[6], data[7]
+	r.SP = uint16(data[8]) | uint16(data[9])<<8
+	r.PC = uint16(data[10]) | uint16(data[11])<<8
+	return r, nil
+}
@@ -0,0 +1,80 @@
+package gdbstub
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) command(data string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", data, sum(data))
+	ack, err := c.r.ReadByte()
+	if err != nil || ack != '+' {
+		c.t.Fatalf("expected ack for %q but got %q (%v)", data, ack, err)
+	}
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	checksum := make([]byte, 2)
+	if _, err := c.r.Read(checksum); err != nil {
+		c.t.Fatal(err)
+	}
+	return reply[:len(reply)-1]
+}
+
+func TestSession(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, conn := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Serve(ctx, server, gameboy)
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// The empty ROM is full of NOPs and execution starts at 0x0100
+	for _, tc := range []struct {
+		command, expected string
+	}{
+		{"?", "S05"},
+		{"p5", "0001"},
+		{"s", "S05"},
+		{"p5", "0101"},
+		{"Z0,110,1", "OK"},
+		{"c", "S05"},
+		{"p5", "1001"},
+		{"z0,110,1", "OK"},
+		{"Mc000,2:beef", "OK"},
+		{"mc000,2", "beef"},
+		{"P2=3412", "OK"},
+		{"vMustReplyEmpty", ""},
+	} {
+		if actual := c.command(tc.command); actual != tc.expected {
+			t.Errorf("%s: expected %q but got %q", tc.command, tc.expected, actual)
+		}
+	}
+	if r := gameboy.Registers(); r.D != 0x12 || r.E != 0x34 {
+		t.Errorf("expected DE to be 0x1234 but got %s", r)
+	}
+	if g := c.command("g"); g != encodeRegisters(gameboy.Registers()) {
+		t.Errorf("unexpected registers: %s", g)
+	}
+	if actual := c.command("D"); actual != "OK" {
+		t.Errorf("expected detach to succeed but got %q", actual)
+	}
+}
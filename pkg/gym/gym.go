@@ -0,0 +1,137 @@
+// Package gym wraps a Gameboy in a reinforcement learning environment in the style of OpenAI Gym,
+// where an agent chooses the buttons to hold for each step and observes the screen and RAM after it
+package gym
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+)
+
+// DefaultFrameSkip is the number of frames that each step runs unless Options sets another
+const DefaultFrameSkip = 4
+
+// Options control the environment
+type Options struct {
+	// Gameboy creates the Gameboy for each episode. The ROM is read once and battery saves are neither
+	// read nor written, so that every episode starts from the same state.
+	Gameboy gb.Options
+	// FrameSkip is the number of frames that each step runs with its buttons held
+	FrameSkip int
+	// MaxSteps ends an episode after this many steps, or never when it is 0
+	MaxSteps int
+	// Done ends an episode when this condition becomes true at the end of a step, such as
+	// "[0xC0A0]==0" for when a counter of lives reaches 0. Names from Gameboy.SymbolFilename can be
+	// used in it.
+	Done string
+}
+
+// Observation is the state of the Gameboy after a step
+type Observation struct {
+	// Frame holds the picture shown by the display as rows of 8-bit RGBA pixels
+	Frame  []byte `json:"frame"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// WRAM holds work RAM from 0xC000 to 0xDFFF and HRAM holds high RAM from 0xFF80 to 0xFFFE
+	WRAM []byte `json:"wram"`
+	HRAM []byte `json:"hram"`
+	// Step counts the steps taken since the episode started
+	Step int  `json:"step"`
+	Done bool `json:"done"`
+}
+
+// Env is an environment that runs a Gameboy headless. Runs are deterministic so the same buttons
+// pressed in the same steps always give the same observations.
+type Env struct {
+	opts    Options
+	gameboy *gb.Gameboy
+	done    *expr.Expr
+	steps   int
+}
+
+// New returns an environment ready to be reset
+func New(opts Options) (*Env, error) {
+	if opts.FrameSkip <= 0 {
+		opts.FrameSkip = DefaultFrameSkip
+	}
+	if opts.Gameboy.Rom == nil && opts.Gameboy.RomFilename != "" {
+		rom, err := ioutil.ReadFile(opts.Gameboy.RomFilename)
+		if err != nil {
+			return nil, err
+		}
+		opts.Gameboy.Rom = rom
+	}
+	opts.Gameboy.RomFilename = ""
+	opts.Gameboy.SaveFilename = ""
+	opts.Gameboy.Pace = false
+	return &Env{opts: opts}, nil
+}
+
+// Reset starts a new episode by switching the Gameboy off and on again
+func (e *Env) Reset() (*Observation, error) {
+	gameboy, err := gb.NewGameboy(e.opts.Gameboy)
+	if err != nil {
+		return nil, err
+	}
+	var done *expr.Expr
+	if e.opts.Done != "" {
+		done, err = gameboy.Symbols().Parse(e.opts.Done)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.gameboy = gameboy
+	e.done = done
+	e.steps = 0
+	return e.observe(false), nil
+}
+
+// Step holds the buttons, and releases every other button, while the frames of a step are run. It
+// returns the observation at the end of the step, which is done when the episode has ended.
+func (e *Env) Step(buttons ...gb.Button) (*Observation, error) {
+	if e.gameboy == nil {
+		return nil, fmt.Errorf("the environment must be reset before the first step")
+	}
+	var held [8]bool
+	for _, button := range buttons {
+		if button < 0 || int(button) >= len(held) {
+			return nil, fmt.Errorf("unknown button %d", button)
+		}
+		held[button] = true
+	}
+	for button, pressed := range held {
+		e.gameboy.ButtonAction(gb.Button(button), pressed)
+	}
+	e.gameboy.RunFrames(e.opts.FrameSkip)
+	e.steps++
+	done := e.opts.MaxSteps > 0 && e.steps >= e.opts.MaxSteps
+	if e.done != nil && e.done.True(e.gameboy) {
+		done = true
+	}
+	return e.observe(done), nil
+}
+
+// Gameboy returns the Gameboy running the current episode, such as for reading other memory
+func (e *Env) Gameboy() *gb.Gameboy {
+	return e.gameboy
+}
+
+func (e *Env) observe(done bool) *Observation {
+	o := &Observation{
+		Frame: e.gameboy.FrameRGBA(nil),
+		WRAM:  make([]byte, 0x2000),
+		HRAM:  make([]byte, 0x7f),
+		Step:  e.steps,
+		Done:  done,
+	}
+	o.Width, o.Height = e.gameboy.ScreenSize()
+	for i := range o.WRAM {
+		o.WRAM[i] = e.gameboy.PeekMemory(0xc000 + uint16(i))
+	}
+	for i := range o.HRAM {
+		o.HRAM[i] = e.gameboy.PeekMemory(0xff80 + uint16(i))
+	}
+	return o
+}
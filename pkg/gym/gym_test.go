@@ -0,0 +1,102 @@
+package gym
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// testROM sets 0xC000 to 1 once A is pressed
+func testROM() []byte {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0100:], []byte{0xc3, 0x50, 0x01}) // JP $0150
+	copy(rom[0x0150:], []byte{
+		0x21, 0x00, 0xc0, // LD HL,$C000
+		0x36, 0x00, // LD (HL),$00
+		0x3e, 0x10, // LD A,$10
+		0xe0, 0x00, // LDH ($00),A
+		0xf0, 0x00, // LDH A,($00)
+		0xcb, 0x47, // BIT 0,A
+		0x20, 0xf6, // JR NZ,-10
+		0x36, 0x01, // LD (HL),$01
+		0x18, 0xf2, // JR -14
+	})
+	return rom
+}
+
+func TestStep(t *testing.T) {
+	env, err := New(Options{Gameboy: gb.Options{Rom: testROM()}, Done: "[0xC000]==1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Step(); err == nil {
+		t.Error("expected an error stepping before a reset")
+	}
+	for episode := 0; episode < 2; episode++ {
+		o, err := env.Reset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Step != 0 || o.Done || o.Width != 160 || o.Height != 144 || len(o.Frame) != 160*144*4 {
+			t.Fatalf("unexpected observation after a reset with step %d, done %v and a %dx%d frame of %d bytes", o.Step, o.Done, o.Width, o.Height, len(o.Frame))
+		}
+		for step := 1; step <= 3; step++ {
+			if o, err = env.Step(gb.B, gb.Start); err != nil {
+				t.Fatal(err)
+			}
+			if o.Step != step || o.Done || o.WRAM[0] != 0 {
+				t.Fatalf("expected step %d not to be done but got step %d with 0x%02x", step, o.Step, o.WRAM[0])
+			}
+		}
+		if o, err = env.Step(gb.A); err != nil {
+			t.Fatal(err)
+		}
+		if !o.Done || o.WRAM[0] != 1 || env.Gameboy().FrameCount() != 4*DefaultFrameSkip {
+			t.Errorf("expected the episode to be done after %d frames but got %v with 0x%02x after %d frames", 4*DefaultFrameSkip, o.Done, o.WRAM[0], env.Gameboy().FrameCount())
+		}
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	env, err := New(Options{Gameboy: gb.Options{Rom: testROM()}, FrameSkip: 1, MaxSteps: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for step, expected := range []bool{false, true} {
+		o, err := env.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Done != expected {
+			t.Errorf("expected done %v after step %d", expected, step+1)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	opts := Options{Gameboy: gb.Options{RomFilename: "../gb/testdata/blargg/cpu_instrs/individual/01-special.gb"}}
+	var frames [2][]byte
+	for i := range frames {
+		env, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := env.Reset(); err != nil {
+			t.Fatal(err)
+		}
+		var o *Observation
+		for step := 0; step < 20; step++ {
+			if o, err = env.Step(gb.Button(step % 8)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		frames[i] = append(o.Frame, o.WRAM...)
+	}
+	if !bytes.Equal(frames[0], frames[1]) {
+		t.Error("expected the same observations from the same steps")
+	}
+}
@@ -0,0 +1,101 @@
+// Package heatmap counts memory reads and writes per address so that hot game variables and DMA
+// patterns stand out
+package heatmap
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Heatmap accumulates read and write counts for every address and implements mem.Hooks
+type Heatmap struct {
+	Reads  [0x10000]uint64
+	Writes [0x10000]uint64
+}
+
+// New returns an empty heatmap
+func New() *Heatmap {
+	return &Heatmap{}
+}
+
+// OnRead implements mem.Hooks
+func (h *Heatmap) OnRead(addr uint16, value byte) {
+	h.Reads[addr]++
+}
+
+// OnWrite implements mem.Hooks
+func (h *Heatmap) OnWrite(addr uint16, value byte) {
+	h.Writes[addr]++
+}
+
+// WriteCSV writes the counts for every address that has been accessed
+func (h *Heatmap) WriteCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "address,reads,writes")
+	for addr := range h.Reads {
+		if h.Reads[addr] > 0 || h.Writes[addr] > 0 {
+			fmt.Fprintf(bw, "0x%04x,%d,%d\n", addr, h.Reads[addr], h.Writes[addr])
+		}
+	}
+	return bw.Flush()
+}
+
+// Image renders the address space as a 256x256 image with one pixel per address, where the row
+// is the high byte of the address and the column is the low byte. Reads are shown in green and
+// writes in red, on a log scale so that rarely accessed addresses are still visible.
+func (h *Heatmap) Image() *image.RGBA {
+	maxReads, maxWrites := max(h.Reads[:]), max(h.Writes[:])
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for addr := range h.Reads {
+		img.SetRGBA(addr&0xff, addr>>8, color.RGBA{
+			R: scale(h.Writes[addr], maxWrites),
+			G: scale(h.Reads[addr], maxReads),
+			A: 0xff,
+		})
+	}
+	return img
+}
+
+// Save writes the heatmap to a file as a PNG image or, if the filename ends with .csv, as CSV
+func (h *Heatmap) Save(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+		err = h.WriteCSV(f)
+	} else {
+		err = png.Encode(f, h.Image())
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func max(counts []uint64) uint64 {
+	var m uint64
+	for _, count := range counts {
+		if count > m {
+			m = count
+		}
+	}
+	return m
+}
+
+func scale(count, max uint64) uint8 {
+	if count == 0 {
+		return 0
+	}
+	// Any access at all is brighter than none
+	return uint8(64 + 191*math.Log(float64(count))/math.Log(float64(max)+1))
+}
@@ -0,0 +1,32 @@
+package heatmap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeatmap(t *testing.T) {
+	h := New()
+	h.OnRead(0xc000, 0)
+	h.OnRead(0xc000, 0)
+	h.OnWrite(0xc000, 1)
+	h.OnWrite(0xff46, 0xc1)
+	var buf bytes.Buffer
+	if err := h.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "address,reads,writes\n0xc000,2,1\n0xff46,0,1\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV %q but got %q", expected, buf.String())
+	}
+	img := h.Image()
+	if c := img.RGBAAt(0x00, 0xc0); c.R == 0 || c.G == 0 {
+		t.Errorf("expected 0xc000 to show reads and writes but got %v", c)
+	}
+	if c := img.RGBAAt(0x46, 0xff); c.R == 0 || c.G != 0 {
+		t.Errorf("expected 0xff46 to show only writes but got %v", c)
+	}
+	if c := img.RGBAAt(0x01, 0xc0); c.R != 0 || c.G != 0 {
+		t.Errorf("expected 0xc001 to be dark but got %v", c)
+	}
+}
@@ -0,0 +1,197 @@
+// Package input plays back scripted button presses so that gameplay sequences can be tested
+// reproducibly in headless runs
+//
+// A script has one command per line, with blank lines and lines starting with # ignored:
+//
+//	frame 120: press start for 5 frames
+//	frame 200: press a, right
+//	frame 230: release a, right
+//
+// Buttons pressed without a duration stay pressed until they are released. The same script can be
+// written as JSON:
+//
+//	[{"frame": 120, "press": ["start"], "frames": 5}, {"frame": 200, "press": ["a", "right"]},
+//	 {"frame": 230, "release": ["a", "right"]}]
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// Event presses or releases a button at the start of a frame
+type Event struct {
+	Frame   int
+	Button  gb.Button
+	Pressed bool
+}
+
+// Script is a list of events in frame order
+type Script struct {
+	Events []Event
+}
+
+// step is a command in the JSON form of a script
+type step struct {
+	Frame   int      `json:"frame"`
+	Press   []string `json:"press"`
+	Release []string `json:"release"`
+	Frames  int      `json:"frames"`
+}
+
+// Load reads a script from a file
+func Load(filename string) (*Script, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the input script at \"%s\" (%v)", filename, err)
+	}
+	defer f.Close()
+	script, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the input script at \"%s\" (%v)", filename, err)
+	}
+	return script, nil
+}
+
+// Parse reads a script in either the text or the JSON form
+func Parse(r io.Reader) (*Script, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var steps []step
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &steps); err != nil {
+			return nil, err
+		}
+	} else {
+		steps, err = parseText(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	script := &Script{}
+	for i, s := range steps {
+		if s.Frame < 0 || s.Frames < 0 {
+			return nil, fmt.Errorf("step %d: frames cannot be negative", i+1)
+		}
+		if err := script.add(s.Frame, s.Press, true); err != nil {
+			return nil, err
+		}
+		if err := script.add(s.Frame, s.Release, false); err != nil {
+			return nil, err
+		}
+		if s.Frames > 0 {
+			if err := script.add(s.Frame+s.Frames, s.Press, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Keep the order of events within a frame so that a press and release in one frame both happen
+	sort.SliceStable(script.Events, func(i, j int) bool { return script.Events[i].Frame < script.Events[j].Frame })
+	return script, nil
+}
+
+func (s *Script) add(frame int, buttons []string, pressed bool) error {
+	for _, name := range buttons {
+		button, err := gb.ParseButton(name)
+		if err != nil {
+			return err
+		}
+		s.Events = append(s.Events, Event{Frame: frame, Button: button, Pressed: pressed})
+	}
+	return nil
+}
+
+// parseText reads lines such as "frame 120: press start for 5 frames"
+func parseText(data []byte) ([]step, error) {
+	var steps []step
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		s, err := parseLine(strings.ToLower(text))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		steps = append(steps, s)
+	}
+	return steps, scanner.Err()
+}
+
+// line matches "frame <n>: press|release <buttons> [for <n> frames]"
+var line = regexp.MustCompile(`^frame\s+(\d+)\s*:\s*(press|release)\s+(.+?)(?:\s+for\s+(\d+)(?:\s+frames?)?)?$`)
+
+func parseLine(text string) (step, error) {
+	var s step
+	match := line.FindStringSubmatch(text)
+	if match == nil {
+		return s, fmt.Errorf("expected \"frame <n>: press|release <buttons> [for <n> frames]\" but got %q", text)
+	}
+	s.Frame, _ = strconv.Atoi(match[1])
+	buttons := strings.Fields(strings.Replace(match[3], ",", " ", -1))
+	if match[2] == "release" {
+		if match[4] != "" {
+			return s, fmt.Errorf("only presses can have a duration in %q", text)
+		}
+		s.Release = buttons
+		return s, nil
+	}
+	s.Press = buttons
+	if match[4] != "" {
+		s.Frames, _ = strconv.Atoi(match[4])
+		if s.Frames < 1 {
+			return s, fmt.Errorf("bad duration in %q", text)
+		}
+	}
+	return s, nil
+}
+
+// Frames returns the frame of the last event, after which the script has nothing more to do
+func (s *Script) Frames() int {
+	if len(s.Events) == 0 {
+		return 0
+	}
+	return s.Events[len(s.Events)-1].Frame
+}
+
+// Attach plays the script on a Gameboy as an input source of the first controller, pressing and
+// releasing buttons at the start of each frame counted from the Gameboy's current frame. The buttons
+// are combined with any pressed by the player.
+func (s *Script) Attach(gameboy *gb.Gameboy) {
+	start := gameboy.FrameCount()
+	next := 0
+	var held gb.Buttons
+	apply := func(frame int) {
+		for next < len(s.Events) && s.Events[next].Frame <= frame {
+			held = held.With(s.Events[next].Button, s.Events[next].Pressed)
+			next++
+		}
+	}
+	apply(0)
+	gameboy.OnFrame(func(_ *image.RGBA) {
+		// The frame count advances once the frame hooks have run
+		apply(gameboy.FrameCount() + 1 - start)
+	})
+	// Frame hooks and input sources are both called by the goroutine running the Gameboy
+	gameboy.AddInputSource(gb.InputSourceFunc(func(player int) gb.Buttons {
+		if player != 0 {
+			return 0
+		}
+		return held
+	}))
+}
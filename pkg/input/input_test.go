@@ -0,0 +1,67 @@
+package input
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+func TestParse(t *testing.T) {
+	text, err := Parse(strings.NewReader(`# Start the game
+frame 2: press Start for 3 frames
+frame 10: press a, right
+frame 12: release A,right
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := Parse(strings.NewReader(`[
+  {"frame": 2, "press": ["start"], "frames": 3},
+  {"frame": 10, "press": ["a", "right"]},
+  {"frame": 12, "release": ["a", "right"]}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		{2, gb.Start, true},
+		{5, gb.Start, false},
+		{10, gb.A, true},
+		{10, gb.Right, true},
+		{12, gb.A, false},
+		{12, gb.Right, false},
+	}
+	if !reflect.DeepEqual(text.Events, expected) || !reflect.DeepEqual(json.Events, expected) {
+		t.Errorf("expected %v but got %v and %v", expected, text.Events, json.Events)
+	}
+	if text.Frames() != 12 {
+		t.Errorf("expected the script to last 12 frames but got %d", text.Frames())
+	}
+	for _, bad := range []string{"frame x: press a", "frame 1: push a", "frame 1: press turbo", "frame 1: release a for 2 frames", "frame 1: press a for 0 frames"} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestAttach(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := Parse(strings.NewReader("frame 0: press select\nframe 2: press start for 1 frame"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script.Attach(gameboy)
+	// Select the buttons rather than the direction pad in JOYP
+	gameboy.WriteMemory(0xff00, 0x10)
+	for frame, expected := range []uint8{0xb, 0xb, 0x3, 0xb} {
+		if buttons := gameboy.PeekMemory(0xff00) & 0xf; buttons != expected {
+			t.Errorf("frame %d: expected buttons %x but got %x", frame, expected, buttons)
+		}
+		gameboy.RunFrames(1)
+	}
+}
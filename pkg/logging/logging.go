@@ -0,0 +1,182 @@
+// Package logging writes leveled log lines tagged with the subsystem that wrote them, as text or
+// as JSON, so that output from the CPU, LCD and other subsystems can be filtered independently
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line
+type Level int
+
+// Levels from most to least verbose
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads a level name such as "debug" or "warn"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q: expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// output is shared by a logger and every subsystem logger derived from it
+type output struct {
+	sync.Mutex
+	w      io.Writer
+	json   bool
+	level  Level
+	levels map[string]Level
+	now    func() time.Time
+}
+
+// Logger writes log lines for a subsystem
+type Logger struct {
+	out       *output
+	subsystem string
+}
+
+// New returns a logger that writes lines at or above a level to w, as JSON objects if json is true
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{out: &output{w: w, json: json, level: level, levels: map[string]Level{}, now: time.Now}}
+}
+
+var (
+	defaultLogger = New(os.Stderr, Info, false)
+	defaultLock   sync.Mutex
+)
+
+// Default returns the logger used when none is configured, which writes text to stderr at Info
+// unless replaced by SetDefault
+func Default() *Logger {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the logger used when none is configured
+func SetDefault(logger *Logger) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultLogger = logger
+}
+
+// With returns a logger for a subsystem that shares this logger's output and levels
+func (l *Logger) With(subsystem string) *Logger {
+	return &Logger{out: l.out, subsystem: subsystem}
+}
+
+// SetLevel changes the level of a subsystem, or of every subsystem without its own level if the
+// subsystem is empty
+func (l *Logger) SetLevel(subsystem string, level Level) {
+	l.out.Lock()
+	defer l.out.Unlock()
+	if subsystem == "" {
+		l.out.level = level
+		return
+	}
+	l.out.levels[subsystem] = level
+}
+
+// Configure sets levels from a comma-separated spec such as "warn,cpu=debug,lcd=info", where a
+// bare level applies to every subsystem without its own level
+func (l *Logger) Configure(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		subsystem, name := "", part
+		if eq := strings.Index(part, "="); eq >= 0 {
+			subsystem, name = strings.TrimSpace(part[:eq]), strings.TrimSpace(part[eq+1:])
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		l.SetLevel(subsystem, level)
+	}
+	return nil
+}
+
+// Enabled reports whether lines at a level are written for this logger's subsystem, so that callers
+// can skip formatting expensive messages
+func (l *Logger) Enabled(level Level) bool {
+	l.out.Lock()
+	defer l.out.Unlock()
+	return l.enabled(level)
+}
+
+func (l *Logger) enabled(level Level) bool {
+	if subsystemLevel, ok := l.out.levels[l.subsystem]; ok {
+		return level >= subsystemLevel
+	}
+	return level >= l.out.level
+}
+
+// Debugf logs detail that is only useful while investigating a problem
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(Debug, format, args...)
+}
+
+// Infof logs normal events such as files being written
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args...)
+}
+
+// Warnf logs problems that the emulator can carry on from
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(Warn, format, args...)
+}
+
+// Errorf logs failures
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args...)
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	l.out.Lock()
+	defer l.out.Unlock()
+	if !l.enabled(level) {
+		return
+	}
+	t := l.out.now()
+	message := fmt.Sprintf(format, args...)
+	if l.out.json {
+		line, _ := json.Marshal(struct {
+			Time      string `json:"time"`
+			Level     string `json:"level"`
+			Subsystem string `json:"subsystem,omitempty"`
+			Message   string `json:"msg"`
+		}{t.Format(time.RFC3339Nano), level.String(), l.subsystem, message})
+		fmt.Fprintf(l.out.w, "%s\n", line)
+		return
+	}
+	subsystem := ""
+	if l.subsystem != "" {
+		subsystem = l.subsystem + ": "
+	}
+	fmt.Fprintf(l.out.w, "%s %-5s %s%s\n", t.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), subsystem, message)
+}
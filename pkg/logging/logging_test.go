@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func fixedTime() time.Time {
+	return time.Date(2020, 5, 1, 12, 30, 0, 0, time.UTC)
+}
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Info, false)
+	logger.out.now = fixedTime
+	if err := logger.Configure("warn,cpu=debug"); err != nil {
+		t.Fatal(err)
+	}
+	cpu := logger.With("cpu")
+	lcd := logger.With("lcd")
+	cpu.Debugf("pc 0x%04x", 0x150)
+	lcd.Infof("dropped")
+	lcd.Warnf("large sprites are not supported")
+	logger.Errorf("failed")
+	expected := "2020/05/01 12:30:00 DEBUG cpu: pc 0x0150\n" +
+		"2020/05/01 12:30:00 WARN  lcd: large sprites are not supported\n" +
+		"2020/05/01 12:30:00 ERROR failed\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+	if lcd.Enabled(Info) || !cpu.Enabled(Debug) {
+		t.Error("expected the subsystem levels to be reported")
+	}
+	if err := logger.Configure("cpu=loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, Debug, true)
+	logger.out.now = fixedTime
+	logger.With("script").Errorf("line %d: %s", 3, `bad "call"`)
+	expected := `{"time":"2020-05-01T12:30:00Z","level":"error","subsystem":"script","msg":"line 3: bad \"call\""}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %s but got %s", expected, buf.String())
+	}
+}
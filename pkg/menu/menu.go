@@ -0,0 +1,195 @@
+// Package menu draws a pause menu over the picture and moves through it with the Gameboy's own
+// buttons, so that a game can be reset, saved or quit without remembering hotkeys
+package menu
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/overlay"
+)
+
+// Item is a line of the menu
+type Item struct {
+	// Label returns the text shown, which can change such as to show the current setting
+	Label func() string
+	// Select is called when A or Start is pressed on the item and returns true to close the menu. An
+	// error is shown under the items instead.
+	Select func() (bool, error)
+	// Change is called with -1 or 1 when left or right is pressed on the item, such as to cycle
+	// through settings. It may be nil.
+	Change func(step int) error
+	// Preview returns a picture shown behind the menu instead of the game while the item is
+	// selected, such as the screen of a save state. It may be nil or return nil.
+	Preview func() *image.RGBA
+}
+
+// Menu is a list of items shown over the frame while the game is paused
+type Menu struct {
+	items    []Item
+	selected int
+	open     bool
+	message  string
+}
+
+// margin is the space around the text in the box
+const margin = 4
+
+var (
+	textColour     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	selectedColour = color.RGBA{0xff, 0xd0, 0x40, 0xff}
+	messageColour  = color.RGBA{0xff, 0x80, 0x80, 0xff}
+	boxColour      = color.RGBA{0x10, 0x10, 0x30, 0xff}
+)
+
+// New returns a closed menu of items
+func New(items ...Item) *Menu {
+	return &Menu{items: items}
+}
+
+// Open shows the menu with the first item selected
+func (m *Menu) Open() {
+	m.open = true
+	m.selected = 0
+	m.message = ""
+}
+
+// Close hides the menu
+func (m *Menu) Close() {
+	m.open = false
+}
+
+// IsOpen returns true while the menu is shown
+func (m *Menu) IsOpen() bool {
+	return m.open
+}
+
+// Selected returns the index of the selected item
+func (m *Menu) Selected() int {
+	return m.selected
+}
+
+// Press moves through the menu with up and down, changes the selected item with left and right,
+// selects it with A or Start, and closes the menu with B
+func (m *Menu) Press(button gb.Button) {
+	if !m.open || len(m.items) == 0 {
+		return
+	}
+	item := m.items[m.selected]
+	var err error
+	switch button {
+	case gb.Up:
+		m.selected = (m.selected + len(m.items) - 1) % len(m.items)
+		m.message = ""
+	case gb.Down:
+		m.selected = (m.selected + 1) % len(m.items)
+		m.message = ""
+	case gb.Left, gb.Right:
+		if item.Change != nil {
+			step := 1
+			if button == gb.Left {
+				step = -1
+			}
+			err = item.Change(step)
+		}
+	case gb.A, gb.Start:
+		if item.Select != nil {
+			var close bool
+			close, err = item.Select()
+			if close && err == nil {
+				m.Close()
+			}
+		}
+	case gb.B:
+		m.Close()
+	}
+	if err != nil {
+		m.message = err.Error()
+	}
+}
+
+// Draw darkens a frame, or the preview of the selected item stretched over it, and draws the menu in
+// a box in the middle of it
+func (m *Menu) Draw(frame *image.RGBA) {
+	bounds := frame.Rect
+	if len(m.items) > 0 && m.items[m.selected].Preview != nil {
+		if preview := m.items[m.selected].Preview(); preview != nil {
+			stretch(frame, preview)
+		}
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := frame.Pix[frame.PixOffset(bounds.Min.X, y):frame.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			row[i], row[i+1], row[i+2] = row[i]/3, row[i+1]/3, row[i+2]/3
+		}
+	}
+	labels := make([]string, len(m.items))
+	columns := 0
+	for i, item := range m.items {
+		labels[i] = item.Label()
+		if len(labels[i]) > columns {
+			columns = len(labels[i])
+		}
+	}
+	// Two columns for the cursor
+	columns += 2
+	var message []string
+	if m.message != "" {
+		if len(m.message) > columns {
+			columns = len(m.message)
+		}
+		if max := (bounds.Dx() - 2*margin) / overlay.CharWidth; columns > max {
+			columns = max
+		}
+		message = wrap(m.message, columns)
+	}
+	width := columns*overlay.CharWidth + 2*margin
+	height := (len(labels)+len(message))*overlay.LineHeight + 2*margin
+	box := image.Rect(0, 0, width, height).Add(bounds.Min).Add(image.Pt((bounds.Dx()-width)/2, (bounds.Dy()-height)/2))
+	overlay.Box(frame, box, boxColour)
+	x, y := box.Min.X+margin, box.Min.Y+margin
+	for i, label := range labels {
+		if i == m.selected {
+			overlay.Text(frame, x, y, ">", selectedColour)
+			overlay.Text(frame, x+2*overlay.CharWidth, y, label, selectedColour)
+		} else {
+			overlay.Text(frame, x+2*overlay.CharWidth, y, label, textColour)
+		}
+		y += overlay.LineHeight
+	}
+	for _, line := range message {
+		overlay.Text(frame, x, y, line, messageColour)
+		y += overlay.LineHeight
+	}
+}
+
+// stretch scales a picture to cover a frame, picking the nearest pixel
+func stretch(frame, picture *image.RGBA) {
+	bounds, size := frame.Rect, picture.Rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			from := picture.PixOffset(picture.Rect.Min.X+x*size.X/bounds.Dx(), picture.Rect.Min.Y+y*size.Y/bounds.Dy())
+			to := frame.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			copy(frame.Pix[to:to+4], picture.Pix[from:from+4])
+		}
+	}
+}
+
+// wrap breaks text into lines of at most a number of characters, between words where it can
+func wrap(text string, columns int) []string {
+	var lines []string
+	for len(text) > columns {
+		end := strings.LastIndex(text[:columns+1], " ")
+		if end <= 0 {
+			end = columns
+		}
+		lines = append(lines, text[:end])
+		text = strings.TrimLeft(text[end:], " ")
+	}
+	return append(lines, text)
+}
@@ -0,0 +1,116 @@
+package menu
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+func TestPress(t *testing.T) {
+	resumed := false
+	slot := 1
+	m := New(
+		Item{Label: func() string { return "Resume" }, Select: func() (bool, error) {
+			resumed = true
+			return true, nil
+		}},
+		Item{Label: func() string { return fmt.Sprintf("Slot: %d", slot) }, Change: func(step int) error {
+			slot += step
+			return nil
+		}},
+		Item{Label: func() string { return "Save state" }, Select: func() (bool, error) {
+			return false, errors.New("no room")
+		}},
+	)
+
+	// Buttons do nothing until the menu is open
+	m.Press(gb.A)
+	if resumed || m.IsOpen() {
+		t.Fatal("expected the closed menu to ignore buttons")
+	}
+	m.Open()
+	m.Press(gb.Up)
+	if m.Selected() != 2 {
+		t.Errorf("expected up to wrap around to the last item but got %d", m.Selected())
+	}
+	m.Press(gb.A)
+	if !m.IsOpen() || m.message != "no room" {
+		t.Errorf("expected the menu to stay open with the error but got %v %q", m.IsOpen(), m.message)
+	}
+	m.Press(gb.Up)
+	m.Press(gb.Right)
+	m.Press(gb.Right)
+	m.Press(gb.Left)
+	if slot != 2 || m.message != "" {
+		t.Errorf("expected slot 2 without a message but got %d %q", slot, m.message)
+	}
+	m.Press(gb.Down)
+	m.Press(gb.Down)
+	m.Press(gb.Start)
+	if !resumed || m.IsOpen() {
+		t.Error("expected resume to close the menu")
+	}
+
+	// B closes the menu and opening it again starts at the top
+	m.Open()
+	m.Press(gb.Down)
+	m.Press(gb.B)
+	if m.IsOpen() {
+		t.Error("expected B to close the menu")
+	}
+	m.Open()
+	if m.Selected() != 0 {
+		t.Errorf("expected the first item to be selected but got %d", m.Selected())
+	}
+}
+
+func TestDraw(t *testing.T) {
+	m := New(Item{Label: func() string { return "Resume" }}, Item{Label: func() string { return "Quit" }})
+	m.Open()
+	frame := image.NewRGBA(image.Rect(0, 0, 160, 144))
+	for i := range frame.Pix {
+		frame.Pix[i] = 0xff
+	}
+	m.Draw(frame)
+	if corner := frame.RGBAAt(0, 0); corner != (color.RGBA{0x55, 0x55, 0x55, 0xff}) {
+		t.Errorf("expected the frame to be darkened but got %v", corner)
+	}
+	if centre := frame.RGBAAt(80, 72); centre == (color.RGBA{0x55, 0x55, 0x55, 0xff}) {
+		t.Error("expected the menu in the middle of the frame")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	lines := wrap("save states are not supported yet", 20)
+	expected := []string{"save states are not", "supported yet"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q but got %q", expected, lines)
+	}
+	if lines := wrap("abcdefghij", 4); !reflect.DeepEqual(lines, []string{"abcd", "efgh", "ij"}) {
+		t.Errorf("expected a long word to be broken but got %q", lines)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	preview := image.NewRGBA(image.Rect(0, 0, 80, 72))
+	for i := range preview.Pix {
+		preview.Pix[i] = 0x90
+	}
+	m := New(Item{Label: func() string { return "Resume" }}, Item{Label: func() string { return "Slot: 1" }, Preview: func() *image.RGBA { return preview }})
+	m.Open()
+	frame := image.NewRGBA(image.Rect(0, 0, 160, 144))
+	m.Draw(frame)
+	if corner := frame.RGBAAt(159, 143); corner != (color.RGBA{}) {
+		t.Errorf("expected the game behind an item without a preview but got %v", corner)
+	}
+	m.Press(gb.Down)
+	m.Draw(frame)
+	if corner := frame.RGBAAt(159, 143); corner != (color.RGBA{0x30, 0x30, 0x30, 0x90}) {
+		t.Errorf("expected the darkened preview but got %v", corner)
+	}
+}
@@ -0,0 +1,153 @@
+// Package metrics exposes emulator runtime metrics over HTTP in the Prometheus text format and as
+// expvars so that long-running headless instances can be monitored
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"image"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/logging"
+)
+
+// framesPerSecond is the refresh rate of a real Gameboy, used to report the emulated speed
+const framesPerSecond = 59.7275
+
+// Rates are recalculated once this much time has passed
+const sampleInterval = time.Second
+
+// Snapshot is the most recent set of emulator metrics
+type Snapshot struct {
+	Frames                uint64  `json:"frames"`
+	Instructions          uint64  `json:"instructions"`
+	FramesPerSecond       float64 `json:"framesPerSecond"`
+	InstructionsPerSecond float64 `json:"instructionsPerSecond"`
+	Speed                 float64 `json:"speed"`
+	AudioUnderruns        uint64  `json:"audioUnderruns"`
+}
+
+// Metrics samples a running Gameboy
+type Metrics struct {
+	underruns func() uint64
+	mu        sync.Mutex
+	snapshot  Snapshot
+	sampled   time.Time
+	frames    uint64
+	instrs    uint64
+}
+
+var (
+	publish sync.Once
+	current struct {
+		sync.Mutex
+		metrics *Metrics
+	}
+)
+
+// New starts sampling metrics at the end of every frame. The most recently created Metrics is also
+// published as the "emulator" expvar.
+func New(gameboy *gb.Gameboy) *Metrics {
+	m := &Metrics{sampled: time.Now()}
+	gameboy.OnFrame(func(_ *image.RGBA) {
+		// The frame count advances once the frame hooks have run
+		m.sample(uint64(gameboy.FrameCount()+1), gameboy.Instructions(), time.Now())
+	})
+	current.Lock()
+	current.metrics = m
+	current.Unlock()
+	publish.Do(func() {
+		expvar.Publish("emulator", expvar.Func(func() interface{} {
+			current.Lock()
+			defer current.Unlock()
+			return current.metrics.Snapshot()
+		}))
+	})
+	return m
+}
+
+// SetAudioUnderruns provides a count of the times that audio output ran out of samples
+func (m *Metrics) SetAudioUnderruns(underruns func() uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.underruns = underruns
+}
+
+// sample is called on the emulator goroutine at the end of each frame
+func (m *Metrics) sample(frames, instructions uint64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot.Frames = frames
+	m.snapshot.Instructions = instructions
+	elapsed := now.Sub(m.sampled)
+	if elapsed < sampleInterval {
+		return
+	}
+	m.snapshot.FramesPerSecond = float64(frames-m.frames) / elapsed.Seconds()
+	m.snapshot.InstructionsPerSecond = float64(instructions-m.instrs) / elapsed.Seconds()
+	m.snapshot.Speed = m.snapshot.FramesPerSecond / framesPerSecond
+	m.sampled, m.frames, m.instrs = now, frames, instructions
+}
+
+// Snapshot returns the latest metrics
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := m.snapshot
+	if m.underruns != nil {
+		snapshot.AudioUnderruns = m.underruns()
+	}
+	return snapshot
+}
+
+// Handler serves the metrics in the Prometheus text format at /metrics and as expvars at /debug/vars
+func (m *Metrics) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.servePrometheus)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// ListenAndServe serves the metrics on an HTTP address until the context is done
+func (m *Metrics) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: m.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logging.Default().With("metrics").Infof("Metrics available at http://%s/metrics", addr)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (m *Metrics) servePrometheus(w http.ResponseWriter, r *http.Request) {
+	s := m.Snapshot()
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"tetromino_frames_total", "counter", "Frames emulated since the Gameboy started.", float64(s.Frames)},
+		{"tetromino_instructions_total", "counter", "CPU instructions executed since the Gameboy started.", float64(s.Instructions)},
+		{"tetromino_frames_per_second", "gauge", "Frames emulated per second of real time.", s.FramesPerSecond},
+		{"tetromino_instructions_per_second", "gauge", "CPU instructions executed per second of real time.", s.InstructionsPerSecond},
+		{"tetromino_speed_ratio", "gauge", "Emulated speed relative to a real Gameboy.", s.Speed},
+		{"tetromino_audio_underruns_total", "counter", "Times that audio output ran out of samples.", float64(s.AudioUnderruns)},
+		{"go_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine())},
+		{"go_memstats_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(memStats.HeapAlloc)},
+		{"go_gc_cycles_total", "counter", "Completed GC cycles.", float64(memStats.NumGC)},
+		{"go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.", float64(memStats.PauseTotalNs) / 1e9},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+func TestMetrics(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(gameboy)
+	m.SetAudioUnderruns(func() uint64 { return 3 })
+	gameboy.RunFrames(2)
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	for path, expected := range map[string][]string{
+		"/metrics":    {"tetromino_frames_total 2\n", "# TYPE tetromino_speed_ratio gauge\n", "tetromino_audio_underruns_total 3\n", "go_gc_cycles_total"},
+		"/debug/vars": {`"emulator": {"frames":2,`, `"memstats":`},
+	} {
+		resp, err := server.Client().Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range expected {
+			if !strings.Contains(string(body), e) {
+				t.Errorf("%s: expected %q in:\n%s", path, e, body)
+			}
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	m := &Metrics{}
+	start := time.Now()
+	m.sample(0, 0, start)
+	m.sample(30, 500000, start.Add(500*time.Millisecond))
+	if s := m.Snapshot(); s.FramesPerSecond != 0 {
+		t.Errorf("expected no rate before a full interval but got %v", s.FramesPerSecond)
+	}
+	m.sample(120, 2000000, start.Add(2*time.Second))
+	s := m.Snapshot()
+	if s.FramesPerSecond != 60 || s.InstructionsPerSecond != 1000000 || s.Speed < 1 || s.Speed > 1.01 {
+		t.Errorf("unexpected rates %+v", s)
+	}
+}
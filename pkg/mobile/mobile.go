@@ -0,0 +1,133 @@
+// Package mobile is a small API over the emulator for apps that embed it with gomobile bind, which
+// only supports simple types such as ints, strings and byte slices:
+//
+//	gomobile bind -target=android ./pkg/mobile
+//
+// The app runs each frame, draws the RGBA pixels it returns and plays the audio samples it returns,
+// without needing the GLFW and PortAudio frontends.
+package mobile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+)
+
+// Buttons passed to SetButton
+const (
+	ButtonUp     = gb.Up
+	ButtonDown   = gb.Down
+	ButtonLeft   = gb.Left
+	ButtonRight  = gb.Right
+	ButtonA      = gb.A
+	ButtonB      = gb.B
+	ButtonSelect = gb.Select
+	ButtonStart  = gb.Start
+)
+
+// SampleRate is the number of stereo samples per second returned by Audio
+const SampleRate = 44100
+
+// Emulator runs a ROM one frame at a time
+type Emulator struct {
+	gameboy *gb.Gameboy
+	buffer  *audio.RingBuffer
+	width   int
+	height  int
+	pixels  []byte
+	samples []float32
+	pcm     []byte
+}
+
+// NewEmulator starts a Gameboy running a ROM. Battery saves are kept in saveFilename, or not kept at
+// all when it is empty.
+func NewEmulator(rom []byte, saveFilename string) (*Emulator, error) {
+	if len(rom) == 0 {
+		return nil, fmt.Errorf("The ROM is empty")
+	}
+	gameboy, err := gb.NewGameboy(gb.Options{Rom: rom, SaveFilename: saveFilename})
+	if err != nil {
+		return nil, err
+	}
+	e := &Emulator{
+		gameboy: gameboy,
+		// Enough for several frames of samples in case the app falls behind reading them
+		buffer:  audio.NewRingBuffer(8192),
+		samples: make([]float32, 16384),
+		pcm:     make([]byte, 0, 32768),
+	}
+	e.width, e.height = gameboy.ScreenSize()
+	e.pixels = make([]byte, e.width*e.height*4)
+	gameboy.RegisterSpeakers(speakers{e.buffer})
+	gameboy.OnFrame(e.copyFrame)
+	return e, nil
+}
+
+// speakers collects samples for Audio without adding a method that gomobile can't bind to Emulator
+type speakers struct {
+	buffer *audio.RingBuffer
+}
+
+// Buffer returns the ring buffer of samples collected for Audio
+func (s speakers) Buffer() *audio.RingBuffer {
+	return s.buffer
+}
+
+// copyFrame keeps the visible part of each frame
+func (e *Emulator) copyFrame(*image.RGBA) {
+	e.pixels = e.gameboy.FrameRGBA(e.pixels)
+}
+
+// RunFrame runs the Gameboy until it has drawn the next frame
+func (e *Emulator) RunFrame() {
+	// Drop the oldest samples that the app hasn't read rather than waiting for it
+	if excess := e.buffer.Buffered() - 4096; excess > 0 {
+		e.buffer.Read(e.samples[:excess*2])
+	}
+	e.gameboy.RunFrames(1)
+}
+
+// Width returns the width of frames in pixels
+func (e *Emulator) Width() int {
+	return e.width
+}
+
+// Height returns the height of frames in pixels
+func (e *Emulator) Height() int {
+	return e.height
+}
+
+// Frame returns the pixels of the most recent frame, row by row, with 4 bytes of red, green, blue and
+// alpha per pixel. The slice is reused for every frame.
+func (e *Emulator) Frame() []byte {
+	return e.pixels
+}
+
+// SetButton presses or releases one of the buttons
+func (e *Emulator) SetButton(button int, pressed bool) {
+	e.gameboy.ButtonAction(gb.Button(button), pressed)
+}
+
+// Audio returns the samples produced since it was last called as signed 16-bit little-endian PCM,
+// with the left and right channels interleaved, at SampleRate. The slice is reused for every call.
+func (e *Emulator) Audio() []byte {
+	n := e.buffer.Read(e.samples)
+	e.pcm = e.pcm[:n*2]
+	for i, sample := range e.samples[:n] {
+		if sample > 1 {
+			sample = 1
+		} else if sample < -1 {
+			sample = -1
+		}
+		binary.LittleEndian.PutUint16(e.pcm[i*2:], uint16(int16(sample*32767)))
+	}
+	return e.pcm
+}
+
+// Close writes the battery save
+func (e *Emulator) Close() error {
+	return e.gameboy.Close()
+}
@@ -0,0 +1,29 @@
+package mobile
+
+import (
+	"testing"
+)
+
+func TestEmulator(t *testing.T) {
+	if _, err := NewEmulator(nil, ""); err == nil {
+		t.Error("expected an error for an empty ROM")
+	}
+	e, err := NewEmulator(make([]byte, 0x8000), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetButton(ButtonStart, true)
+	for i := 0; i < 10; i++ {
+		e.RunFrame()
+	}
+	if len(e.Frame()) != e.Width()*e.Height()*4 || e.Width() != 160 || e.Height() != 144 {
+		t.Errorf("unexpected frame of %d bytes for %dx%d", len(e.Frame()), e.Width(), e.Height())
+	}
+	// 10 frames are about 7380 stereo samples, less any dropped while they weren't read
+	if n := len(e.Audio()); n < 4096*4 || n%4 != 0 {
+		t.Errorf("unexpected %d bytes of audio", n)
+	}
+	if n := len(e.Audio()); n != 0 {
+		t.Errorf("expected no more audio but got %d bytes", n)
+	}
+}
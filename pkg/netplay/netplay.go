@@ -0,0 +1,336 @@
+// Package netplay lets two players on different computers play the same game together. Each runs the
+// same ROM in their own emulator and the emulators exchange the buttons pressed on every frame over
+// UDP so that both run exactly the same frames, which relies on the emulator being deterministic.
+//
+// Buttons take effect a few frames after they are pressed, giving them time to reach the other
+// emulator, and a game waits for buttons that arrive later than that. Tetromino can't save states
+// yet, so late buttons can't be applied by rolling back and running the frames again.
+//
+// The player who hosts uses controller 1 and the player who joins uses controller 2, which Super Game
+// Boy games that support multiplayer can read.
+package netplay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/logging"
+)
+
+// DefaultDelay is the number of frames that buttons are delayed by, about 33ms, which is enough for
+// most connections across a country
+const DefaultDelay = 2
+
+const (
+	// history is the number of frames of buttons in each packet, so that a lost packet is made up
+	// for by the ones after it
+	history = 8
+	// window is the number of frames of buttons that are kept
+	window = 256
+	// resendInterval is how often a waiting emulator sends its buttons again in case they were lost
+	resendInterval = 50 * time.Millisecond
+	// timeout is how long to wait for the other emulator before giving up
+	timeout = 10 * time.Second
+)
+
+// Packets start with "TN" and their type
+const (
+	hello   = 1 // Sent by the joining emulator, with the ROM hash
+	welcome = 2 // Sent in reply by the hosting emulator, with the delay and the ROM hash
+	inputs  = 3 // The first frame, the number of frames and the buttons pressed on each
+)
+
+// input is the buttons pressed by a player on a frame, one bit for each gb.Button
+type input struct {
+	frame   int
+	buttons uint8
+	known   bool
+}
+
+// Session connects a Gameboy to one on another computer
+type Session struct {
+	gameboy *gb.Gameboy
+	conn    net.PacketConn
+	peer    net.Addr
+	player  int
+	delay   int
+	log     *logging.Logger
+
+	mutex   sync.Mutex
+	held    uint8
+	inputs  [2][window]input
+	arrived chan struct{}
+	pressed [2]uint8
+	err     error
+}
+
+// Host waits for another emulator to join with Join and starts a session with it. Both must be
+// running the same ROM from power on.
+func Host(addr string, gameboy *gb.Gameboy, delay int) (*Session, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s, err := host(conn, gameboy, delay)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func host(conn net.PacketConn, gameboy *gb.Gameboy, delay int) (*Session, error) {
+	if delay < 1 {
+		return nil, fmt.Errorf("bad delay %d: expected at least one frame", delay)
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 3 || buf[0] != 'T' || buf[1] != 'N' || buf[2] != hello {
+			continue
+		}
+		// The welcome is sent whatever the ROM so that the other emulator can tell it's different too
+		s := newSession(gameboy, conn, peer, 0, delay)
+		s.sendWelcome()
+		if hash := string(buf[3:n]); hash != gameboy.ROMHash() {
+			return nil, fmt.Errorf("the other emulator is running a different ROM")
+		}
+		s.start()
+		return s, nil
+	}
+}
+
+// Join starts a session with an emulator waiting in Host
+func Join(addr string, gameboy *gb.Gameboy) (*Session, error) {
+	peer, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	s, err := join(conn, peer, gameboy)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func join(conn net.PacketConn, peer net.Addr, gameboy *gb.Gameboy) (*Session, error) {
+	packet := append([]byte{'T', 'N', hello}, gameboy.ROMHash()...)
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(packet, peer); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(resendInterval))
+		n, from, err := conn.ReadFrom(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < 4 || from.String() != peer.String() || buf[0] != 'T' || buf[1] != 'N' || buf[2] != welcome {
+			continue
+		}
+		if hash := string(buf[4:n]); hash != gameboy.ROMHash() {
+			return nil, fmt.Errorf("the other emulator is running a different ROM")
+		}
+		conn.SetReadDeadline(time.Time{})
+		// The host chooses the delay so that both emulators apply buttons on the same frames
+		s := newSession(gameboy, conn, peer, 1, int(buf[3]))
+		s.start()
+		return s, nil
+	}
+	return nil, fmt.Errorf("no reply from %s", peer)
+}
+
+func newSession(gameboy *gb.Gameboy, conn net.PacketConn, peer net.Addr, player, delay int) *Session {
+	return &Session{
+		gameboy: gameboy,
+		conn:    conn,
+		peer:    peer,
+		player:  player,
+		delay:   delay,
+		log:     gameboy.Logger().With("netplay"),
+		arrived: make(chan struct{}, 1),
+	}
+}
+
+// start takes over the buttons pressed on the Gameboy and exchanges them at the end of each frame
+func (s *Session) start() {
+	s.gameboy.InterceptButtons(s.buttonAction)
+	s.gameboy.OnFrame(s.frameEnd)
+	go s.receive()
+}
+
+// Player returns the controller used by this emulator's player, 0 when hosting and 1 when joining
+func (s *Session) Player() int {
+	return s.player
+}
+
+// Err returns the error that ended the session, if any
+func (s *Session) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Close ends the session
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// buttonAction records the buttons held by the local player, whichever controller they were pressed on
+func (s *Session) buttonAction(player int, button gb.Button, pressed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if pressed {
+		s.held |= 1 << uint(button)
+	} else {
+		s.held &^= 1 << uint(button)
+	}
+}
+
+// frameEnd sends the buttons held now to take effect after the delay and then waits for the buttons
+// that both players pressed for the next frame
+func (s *Session) frameEnd(_ *image.RGBA) {
+	if s.Err() != nil {
+		return
+	}
+	// The frame count advances once the frame hooks have run
+	frame := s.gameboy.FrameCount()
+	s.mutex.Lock()
+	s.inputs[s.player][(frame+s.delay)%window] = input{frame: frame + s.delay, buttons: s.held, known: true}
+	s.mutex.Unlock()
+	s.sendInputs(frame + s.delay)
+
+	next := frame + 1
+	if next < s.delay {
+		// Nobody has pressed anything this early
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		local, remote, ok := s.buttons(next)
+		if ok {
+			s.apply(s.player, local)
+			s.apply(1-s.player, remote)
+			return
+		}
+		if time.Now().After(deadline) {
+			s.fail(fmt.Errorf("no buttons from the other emulator for frame %d", next))
+			return
+		}
+		select {
+		case <-s.arrived:
+		case <-time.After(resendInterval):
+			s.sendInputs(frame + s.delay)
+		}
+	}
+}
+
+// buttons returns the buttons pressed by both players on a frame, if they are known
+func (s *Session) buttons(frame int) (uint8, uint8, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	local := s.inputs[s.player][frame%window]
+	remote := s.inputs[1-s.player][frame%window]
+	if !remote.known || remote.frame != frame {
+		return 0, 0, false
+	}
+	return local.buttons, remote.buttons, true
+}
+
+// apply presses and releases the buttons on a controller that changed since the last frame
+func (s *Session) apply(player int, buttons uint8) {
+	for button := gb.Button(0); button < 8; button++ {
+		bit := uint8(1) << uint(button)
+		if buttons&bit != s.pressed[player]&bit {
+			s.gameboy.PressButton(player, button, buttons&bit != 0)
+		}
+	}
+	s.pressed[player] = buttons
+}
+
+func (s *Session) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err == nil {
+		s.err = err
+		s.log.Errorf("Netplay stopped: %v", err)
+	}
+}
+
+func (s *Session) sendWelcome() {
+	packet := append([]byte{'T', 'N', welcome, uint8(s.delay)}, s.gameboy.ROMHash()...)
+	s.conn.WriteTo(packet, s.peer)
+}
+
+// sendInputs sends the local player's buttons for the most recent frames up to the last one
+func (s *Session) sendInputs(last int) {
+	first := last - history + 1
+	if first < s.delay {
+		first = s.delay
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{'T', 'N', inputs})
+	binary.Write(&buf, binary.LittleEndian, uint32(first))
+	buf.WriteByte(uint8(last - first + 1))
+	s.mutex.Lock()
+	for frame := first; frame <= last; frame++ {
+		buf.WriteByte(s.inputs[s.player][frame%window].buttons)
+	}
+	s.mutex.Unlock()
+	if _, err := s.conn.WriteTo(buf.Bytes(), s.peer); err != nil {
+		s.fail(err)
+	}
+}
+
+// receive records the buttons sent by the other emulator until the session is closed
+func (s *Session) receive() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if n < 3 || from.String() != s.peer.String() || buf[0] != 'T' || buf[1] != 'N' {
+			continue
+		}
+		switch buf[2] {
+		case hello:
+			// The welcome was lost so the other emulator is still asking to join
+			s.sendWelcome()
+		case inputs:
+			if n < 8 || n < 8+int(buf[7]) {
+				continue
+			}
+			first := int(binary.LittleEndian.Uint32(buf[3:]))
+			s.mutex.Lock()
+			for i, buttons := range buf[8 : 8+int(buf[7])] {
+				frame := first + i
+				s.inputs[1-s.player][frame%window] = input{frame: frame, buttons: buttons, known: true}
+			}
+			s.mutex.Unlock()
+			select {
+			case s.arrived <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package netplay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// newGameboy returns a Gameboy running a ROM that copies the action buttons from JOYP to $C000
+func newGameboy(t *testing.T) *gb.Gameboy {
+	t.Helper()
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0100:], []byte{0xc3, 0x50, 0x01}) // JP $0150
+	copy(rom[0x0150:], []byte{
+		0x3e, 0x10, // LD A,$10
+		0xe0, 0x00, // LDH ($00),A
+		0xf0, 0x00, // LDH A,($00)
+		0xea, 0x00, 0xc0, // LD ($C000),A
+		0x18, 0xf5, // JR $0150
+	})
+	gameboy, err := gb.NewGameboy(gb.Options{Rom: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gameboy
+}
+
+func TestSession(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hosting := newGameboy(t)
+	joining := newGameboy(t)
+	hosted := make(chan *Session)
+	go func() {
+		s, err := host(conn, hosting, 3)
+		if err != nil {
+			t.Error(err)
+		}
+		hosted <- s
+	}()
+	guest, err := Join(conn.LocalAddr().String(), joining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guest.Close()
+	if s := <-hosted; s == nil || guest.Player() != 1 || guest.delay != 3 {
+		t.Fatalf("expected the guest to be player 2 with the host's delay but got player %d and delay %d", guest.Player()+1, guest.delay)
+	}
+
+	// Only the host's presses reach controller 1, which a DMG game reads
+	hosting.ButtonAction(gb.A, true)
+	joining.ButtonAction(gb.B, true)
+	done := make(chan struct{})
+	go func() {
+		hosting.RunFrames(20)
+		close(done)
+	}()
+	joining.RunFrames(20)
+	<-done
+
+	if err := guest.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if buttons := joining.PeekMemory(0xc000) & 0x0f; buttons != 0x0e {
+		t.Errorf("expected the guest to see A pressed but got %04b", buttons)
+	}
+	if hosting.StateHash() != joining.StateHash() {
+		t.Error("expected both emulators to be in the same state")
+	}
+}
+
+func TestDifferentROM(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hosted := make(chan error)
+	go func() {
+		_, err := host(conn, newGameboy(t), DefaultDelay)
+		hosted <- err
+	}()
+	other, err := gb.NewGameboy(gb.Options{Rom: make([]byte, 0x8000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Join(conn.LocalAddr().String(), other); err == nil {
+		t.Error("expected an error joining with a different ROM")
+	}
+	if err := <-hosted; err == nil {
+		t.Error("expected an error hosting a different ROM")
+	}
+}
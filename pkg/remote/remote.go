@@ -0,0 +1,366 @@
+// Package remote serves an HTTP and WebSocket API so that external tools such as bots, test drivers
+// and stream overlays can drive the emulator
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gym"
+	"golang.org/x/net/websocket"
+)
+
+// Loader creates a Gameboy for a ROM file, ready to be run
+type Loader func(romFilename string) (*gb.Gameboy, error)
+
+// Request is a command sent over the WebSocket, or built from a REST call
+type Request struct {
+	ID       int    `json:"id,omitempty"`
+	Command  string `json:"command"`
+	Filename string `json:"filename,omitempty"`
+	Button   string `json:"button,omitempty"`
+	Pressed  bool   `json:"pressed,omitempty"`
+	Addr     uint16 `json:"addr,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	// Slot is the save state slot, which defaults to 1
+	Slot int `json:"slot,omitempty"`
+	// Buttons are held for a step of a gym environment
+	Buttons []string `json:"buttons,omitempty"`
+}
+
+// Response is sent over the WebSocket for each request
+type Response struct {
+	ID     int         `json:"id,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Status describes the running emulator
+type Status struct {
+	ROM   string `json:"rom"`
+	Frame int    `json:"frame"`
+}
+
+// Memory is a range of bytes read from the Gameboy's address space
+type Memory struct {
+	Addr uint16 `json:"addr"`
+	// Values are ints so that they are encoded as a JSON array rather than base64
+	Values []int `json:"values"`
+}
+
+// errBadRequest marks errors caused by the request rather than the emulator
+var errBadRequest = errors.New("bad request")
+
+// errNotImplemented marks requests for features that the emulator does not have yet
+var errNotImplemented = errors.New("not implemented")
+
+// Server runs the emulator and handles requests between frames
+type Server struct {
+	gameboy  *gb.Gameboy
+	rom      string
+	load     Loader
+	screen   *image.RGBA
+	requests chan func()
+	env      *gym.Env
+}
+
+// New returns a server for a Gameboy running a ROM. The loader is used to replace it when a client
+// loads another ROM, and may be nil to disallow that.
+func New(gameboy *gb.Gameboy, rom string, load Loader) *Server {
+	s := &Server{
+		load:     load,
+		screen:   image.NewRGBA(image.Rect(0, 0, 160, 144)),
+		requests: make(chan func()),
+	}
+	s.attach(gameboy, rom)
+	return s
+}
+
+// NewEnv returns a server for a reinforcement learning environment, which only runs frames when a
+// client takes a step and starts each episode when a client resets it
+func NewEnv(env *gym.Env, rom string) (*Server, error) {
+	if _, err := env.Reset(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		screen:   image.NewRGBA(image.Rect(0, 0, 160, 144)),
+		requests: make(chan func()),
+		env:      env,
+	}
+	s.attach(env.Gameboy(), rom)
+	return s, nil
+}
+
+// attach starts running a Gameboy and keeps a copy of its latest frame for screenshots
+func (s *Server) attach(gameboy *gb.Gameboy, rom string) {
+	s.gameboy = gameboy
+	s.rom = rom
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		if s.gameboy == gameboy {
+			draw.Draw(s.screen, s.screen.Rect, frame, image.Point{}, draw.Src)
+		}
+	})
+}
+
+// ListenAndServe serves the API on an HTTP address and runs the emulator until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	s.gameboy.Logger().With("remote").Infof("Remote control API listening at http://%s/", addr)
+	s.Run(ctx)
+	server.Close()
+	err := <-errs
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Run drives the emulator, handling requests between frames, until the context is done
+func (s *Server) Run(ctx context.Context) {
+	if s.env != nil {
+		// Frames only run when a client takes a step
+		for {
+			select {
+			case request := <-s.requests:
+				request()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	for ctx.Err() == nil {
+		for handled := true; handled; {
+			select {
+			case request := <-s.requests:
+				request()
+			default:
+				handled = false
+			}
+		}
+		s.gameboy.RunFrames(1)
+	}
+}
+
+// Gameboy returns the Gameboy that is running, which changes when a client loads a ROM
+func (s *Server) Gameboy() *gb.Gameboy {
+	return s.gameboy
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.rest("GET", func(r *http.Request) Request {
+		return Request{Command: "status"}
+	}))
+	mux.HandleFunc("/rom", s.rest("POST", func(r *http.Request) Request {
+		return Request{Command: "load", Filename: r.FormValue("filename")}
+	}))
+	mux.HandleFunc("/buttons", s.rest("POST", func(r *http.Request) Request {
+		pressed, _ := strconv.ParseBool(r.FormValue("pressed"))
+		return Request{Command: "button", Button: r.FormValue("button"), Pressed: pressed}
+	}))
+	mux.HandleFunc("/memory", s.rest("GET", func(r *http.Request) Request {
+		addr, _ := strconv.ParseUint(r.FormValue("addr"), 0, 16)
+		length, _ := strconv.Atoi(r.FormValue("length"))
+		return Request{Command: "memory", Addr: uint16(addr), Length: length}
+	}))
+	mux.HandleFunc("/screenshot", s.rest("GET", func(r *http.Request) Request {
+		return Request{Command: "screenshot"}
+	}))
+	mux.HandleFunc("/state/save", s.rest("POST", func(r *http.Request) Request {
+		slot, _ := strconv.Atoi(r.FormValue("slot"))
+		return Request{Command: "saveState", Slot: slot}
+	}))
+	mux.HandleFunc("/state/load", s.rest("POST", func(r *http.Request) Request {
+		slot, _ := strconv.Atoi(r.FormValue("slot"))
+		return Request{Command: "loadState", Slot: slot}
+	}))
+	mux.HandleFunc("/gym/reset", s.rest("POST", func(r *http.Request) Request {
+		return Request{Command: "reset"}
+	}))
+	mux.HandleFunc("/gym/step", s.rest("POST", func(r *http.Request) Request {
+		r.ParseForm()
+		return Request{Command: "step", Buttons: r.Form["button"]}
+	}))
+	mux.Handle("/ws", websocket.Handler(s.serveWebSocket))
+	return mux
+}
+
+// rest adapts a REST endpoint to a request, writing the result as JSON or, for a screenshot, as a PNG
+func (s *Server) rest(method string, request func(*http.Request) Request) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := s.Do(r.Context(), request(r))
+		switch {
+		case errors.Is(err, errBadRequest):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errNotImplemented):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			if png, ok := result.([]byte); ok {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(png)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(result)
+		}
+	}
+}
+
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	for {
+		var request Request
+		if err := websocket.JSON.Receive(ws, &request); err != nil {
+			return
+		}
+		response := Response{ID: request.ID}
+		result, err := s.Do(ws.Request().Context(), request)
+		if err != nil {
+			response.Error = err.Error()
+		} else if png, ok := result.([]byte); ok {
+			response.Result = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+		} else {
+			response.Result = result
+		}
+		if err := websocket.JSON.Send(ws, response); err != nil {
+			return
+		}
+	}
+}
+
+// Do handles a request between frames on the goroutine that runs the emulator
+func (s *Server) Do(ctx context.Context, request Request) (interface{}, error) {
+	type reply struct {
+		result interface{}
+		err    error
+	}
+	replies := make(chan reply, 1)
+	select {
+	case s.requests <- func() {
+		result, err := s.handle(request)
+		replies <- reply{result, err}
+	}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	r := <-replies
+	return r.result, r.err
+}
+
+func (s *Server) handle(request Request) (interface{}, error) {
+	switch request.Command {
+	case "status":
+		return Status{ROM: s.rom, Frame: s.gameboy.FrameCount()}, nil
+	case "load":
+		return s.loadROM(request.Filename)
+	case "button":
+		button, err := gb.ParseButton(request.Button)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		s.gameboy.ButtonAction(button, request.Pressed)
+		return Status{ROM: s.rom, Frame: s.gameboy.FrameCount()}, nil
+	case "memory":
+		length := request.Length
+		if length == 0 {
+			length = 1
+		}
+		if length < 0 || int(request.Addr)+length > 0x10000 {
+			return nil, fmt.Errorf("%w: cannot read %d bytes from 0x%04x", errBadRequest, length, request.Addr)
+		}
+		memory := Memory{Addr: request.Addr, Values: make([]int, length)}
+		for i := range memory.Values {
+			memory.Values[i] = int(s.gameboy.PeekMemory(request.Addr + uint16(i)))
+		}
+		return memory, nil
+	case "screenshot":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, s.screen); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "reset":
+		if s.env == nil {
+			return nil, fmt.Errorf("%w: the emulator is not running as a gym environment", errNotImplemented)
+		}
+		observation, err := s.env.Reset()
+		if err != nil {
+			return nil, err
+		}
+		s.attach(s.env.Gameboy(), s.rom)
+		return observation, nil
+	case "step":
+		if s.env == nil {
+			return nil, fmt.Errorf("%w: the emulator is not running as a gym environment", errNotImplemented)
+		}
+		var buttons []gb.Button
+		for _, name := range request.Buttons {
+			button, err := gb.ParseButton(name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+			}
+			buttons = append(buttons, button)
+		}
+		return s.env.Step(buttons...)
+	case "saveState", "loadState":
+		slot := request.Slot
+		if slot == 0 {
+			slot = 1
+		}
+		filename := s.gameboy.StateFilename(slot)
+		if filename == "" {
+			return nil, fmt.Errorf("%w: save states need a ROM file", errBadRequest)
+		}
+		var err error
+		if request.Command == "saveState" {
+			err = s.gameboy.SaveStateFile(filename)
+		} else {
+			err = s.gameboy.LoadStateFile(filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Status{ROM: s.rom, Frame: s.gameboy.FrameCount()}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown command %q", errBadRequest, request.Command)
+}
+
+// loadROM replaces the running Gameboy with a new one for a ROM file
+func (s *Server) loadROM(filename string) (interface{}, error) {
+	if s.load == nil {
+		return nil, fmt.Errorf("%w: loading ROMs is not enabled", errNotImplemented)
+	}
+	if filename == "" {
+		return nil, fmt.Errorf("%w: expected a ROM filename", errBadRequest)
+	}
+	gameboy, err := s.load(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.gameboy.Close(); err != nil {
+		s.gameboy.Logger().With("remote").Errorf("Failed to save: %v", err)
+	}
+	s.attach(gameboy, filename)
+	return Status{ROM: s.rom, Frame: s.gameboy.FrameCount()}, nil
+}
@@ -0,0 +1,192 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gym"
+	"golang.org/x/net/websocket"
+)
+
+func TestREST(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "tetromino-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := New(gameboy, "", func(rom string) (*gb.Gameboy, error) {
+		return gb.NewGameboy(gb.Options{RomFilename: rom, SaveDir: dir})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL+"/buttons", url.Values{"button": {"start"}, "pressed": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the button press to succeed but got %s", resp.Status)
+	}
+
+	for path, status := range map[string]int{
+		"/memory?addr=0xff00&length=2": http.StatusOK,
+		"/memory?addr=0xffff&length=2": http.StatusBadRequest,
+		"/screenshot":                  http.StatusOK,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected status %d but got %s", path, status, resp.Status)
+		}
+		if path == "/screenshot" {
+			if _, err := png.Decode(resp.Body); err != nil {
+				t.Errorf("expected a PNG screenshot: %v", err)
+			}
+		}
+		resp.Body.Close()
+	}
+
+	resp, err = http.PostForm(server.URL+"/state/save", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected save states to need a ROM file but got %s", resp.Status)
+	}
+
+	rom := "../gb/testdata/blargg/cpu_instrs/individual/01-special.gb"
+	resp, err = http.PostForm(server.URL+"/rom", url.Values{"filename": {rom}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status Status
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil || status.ROM != rom {
+		t.Errorf("expected the ROM to be loaded but got %+v and %v", status, err)
+	}
+
+	for _, path := range []string{"/state/save", "/state/load"} {
+		resp, err = http.PostForm(server.URL+path, url.Values{"slot": {"2"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the save state in slot 2 to succeed but got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(gameboy, "", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, tc := range []struct {
+		request  Request
+		expected string
+	}{
+		{Request{ID: 1, Command: "button", Button: "a", Pressed: true}, `"frame":`},
+		{Request{ID: 2, Command: "memory", Addr: 0x0100, Length: 2}, `"values":[0,0]`},
+		{Request{ID: 3, Command: "screenshot"}, `data:image/png;base64,`},
+		{Request{ID: 4, Command: "button", Button: "turbo"}, `unknown button`},
+		{Request{ID: 5, Command: "load", Filename: "game.gb"}, `not enabled`},
+	} {
+		if err := websocket.JSON.Send(ws, tc.request); err != nil {
+			t.Fatal(err)
+		}
+		var raw json.RawMessage
+		if err := websocket.JSON.Receive(ws, &raw); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(raw), tc.expected) || !strings.HasPrefix(string(raw), `{"id":`) {
+			t.Errorf("%s: expected %q in %s", tc.request.Command, tc.expected, raw)
+		}
+	}
+}
+
+func TestGym(t *testing.T) {
+	env, err := gym.New(gym.Options{FrameSkip: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewEnv(env, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	for i := 1; i <= 2; i++ {
+		resp, err := http.PostForm(server.URL+"/gym/step", url.Values{"button": {"a", "right"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var observation gym.Observation
+		err = json.NewDecoder(resp.Body).Decode(&observation)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if observation.Step != i || len(observation.Frame) != 160*144*4 || len(observation.WRAM) != 0x2000 {
+			t.Errorf("unexpected observation at step %d with %d bytes of frame and %d of WRAM", observation.Step, len(observation.Frame), len(observation.WRAM))
+		}
+	}
+	if frame := s.Gameboy().FrameCount(); frame != 4 {
+		t.Errorf("expected frames to run only for steps but got %d", frame)
+	}
+
+	resp, err := http.PostForm(server.URL+"/gym/step", url.Values{"button": {"turbo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown button to be rejected but got %s", resp.Status)
+	}
+
+	resp, err = http.PostForm(server.URL+"/gym/reset", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || s.Gameboy().FrameCount() != 0 {
+		t.Errorf("expected the reset to start a new episode but got %s at frame %d", resp.Status, s.Gameboy().FrameCount())
+	}
+}
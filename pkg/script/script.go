@@ -0,0 +1,200 @@
+package script
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Engine runs a Lua script against a Gameboy
+//
+// The script can use these functions:
+//
+//	memory.read(addr)            read a byte
+//	memory.write(addr, value)    write a byte
+//	memory.onwrite(addr, fn)     call fn(addr, value) whenever addr is written
+//	joypad.set(button, pressed)  press or release "up", "down", "left", "right", "a", "b", "start" or "select"
+//	gui.text(x, y, text, [rgb])  draw text over the next frame
+//	gui.box(x, y, w, h, [rgb], [alpha])
+//	                             fill a box over the next frame, blended when alpha is below 255
+//	gui.message(text, [rgb], [frames])
+//	                             show text in the top-left corner for a number of frames
+//	emu.onframe(fn)              call fn(frame) at the end of each frame
+//	emu.onscanline(fn)           call fn(ly) as each visible line enters H-Blank
+//	emu.framecount()             number of frames run so far
+type Engine struct {
+	gameboy   *gb.Gameboy
+	state     *lua.LState
+	onFrame   []*lua.LFunction
+	onLine    []*lua.LFunction
+	onWrite   map[uint16][]*lua.LFunction
+	inWrite   bool
+	lastError error
+}
+
+// NewEngine creates a Lua runtime bound to the Gameboy and registers its hooks
+func NewEngine(gameboy *gb.Gameboy) *Engine {
+	e := &Engine{
+		gameboy: gameboy,
+		state:   lua.NewState(),
+		onWrite: map[uint16][]*lua.LFunction{},
+	}
+	e.register()
+	gameboy.OnFrame(e.frame)
+	gameboy.OnScanline(e.scanline)
+	gameboy.AddMemoryHooks(e)
+	return e
+}
+
+// Run loads and runs a Lua script file, which typically registers callbacks
+func (e *Engine) Run(filename string) error {
+	return e.state.DoFile(filename)
+}
+
+// RunString runs a snippet of Lua
+func (e *Engine) RunString(source string) error {
+	return e.state.DoString(source)
+}
+
+// Close releases the Lua runtime
+func (e *Engine) Close() {
+	e.state.Close()
+}
+
+// Err returns the first error raised by a callback, after which callbacks are no longer called
+func (e *Engine) Err() error {
+	return e.lastError
+}
+
+func (e *Engine) register() {
+	L := e.state
+	L.SetGlobal("memory", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"read":    e.memoryRead,
+		"write":   e.memoryWrite,
+		"onwrite": e.memoryOnWrite,
+	}))
+	L.SetGlobal("joypad", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"set": e.joypadSet,
+	}))
+	L.SetGlobal("gui", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"text":    e.guiText,
+		"box":     e.guiBox,
+		"message": e.guiMessage,
+	}))
+	L.SetGlobal("emu", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"onframe":    e.emuOnFrame,
+		"onscanline": e.emuOnScanline,
+		"framecount": e.emuFrameCount,
+	}))
+}
+
+func checkAddr(L *lua.LState, n int) uint16 {
+	addr := L.CheckInt(n)
+	if addr < 0 || addr > 0xffff {
+		L.ArgError(n, "address out of range")
+	}
+	return uint16(addr)
+}
+
+func (e *Engine) memoryRead(L *lua.LState) int {
+	L.Push(lua.LNumber(e.gameboy.ReadMemory(checkAddr(L, 1))))
+	return 1
+}
+
+func (e *Engine) memoryWrite(L *lua.LState) int {
+	e.gameboy.WriteMemory(checkAddr(L, 1), uint8(L.CheckInt(2)))
+	return 0
+}
+
+func (e *Engine) memoryOnWrite(L *lua.LState) int {
+	addr := checkAddr(L, 1)
+	e.onWrite[addr] = append(e.onWrite[addr], L.CheckFunction(2))
+	return 0
+}
+
+func (e *Engine) joypadSet(L *lua.LState) int {
+	button, err := gb.ParseButton(L.CheckString(1))
+	if err != nil {
+		L.ArgError(1, "unknown button")
+	}
+	e.gameboy.ButtonAction(button, L.ToBool(2))
+	return 0
+}
+
+// optColour reads an optional colour given as a number such as 0xff0000, with an alpha from 0 to 255
+func optColour(rgb, alpha int) color.NRGBA {
+	return color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), uint8(alpha)}
+}
+
+func (e *Engine) guiText(L *lua.LState) int {
+	e.gameboy.Overlay().Text(L.CheckInt(1), L.CheckInt(2), L.CheckString(3), optColour(L.OptInt(4, 0xffffff), 0xff))
+	return 0
+}
+
+func (e *Engine) guiBox(L *lua.LState) int {
+	x, y := L.CheckInt(1), L.CheckInt(2)
+	box := image.Rect(x, y, x+L.CheckInt(3), y+L.CheckInt(4))
+	e.gameboy.Overlay().Box(box, optColour(L.OptInt(5, 0xffffff), L.OptInt(6, 0xff)))
+	return 0
+}
+
+func (e *Engine) guiMessage(L *lua.LState) int {
+	e.gameboy.Overlay().Message(L.CheckString(1), optColour(L.OptInt(2, 0xffffff), 0xff), L.OptInt(3, 120))
+	return 0
+}
+
+func (e *Engine) emuOnFrame(L *lua.LState) int {
+	e.onFrame = append(e.onFrame, L.CheckFunction(1))
+	return 0
+}
+
+func (e *Engine) emuOnScanline(L *lua.LState) int {
+	e.onLine = append(e.onLine, L.CheckFunction(1))
+	return 0
+}
+
+func (e *Engine) emuFrameCount(L *lua.LState) int {
+	L.Push(lua.LNumber(e.gameboy.FrameCount()))
+	return 1
+}
+
+func (e *Engine) call(fn *lua.LFunction, args ...lua.LValue) {
+	if e.lastError != nil {
+		return
+	}
+	err := e.state.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...)
+	if err != nil {
+		e.lastError = err
+		e.gameboy.Logger().With("script").Errorf("Lua script failed: %v", err)
+	}
+}
+
+func (e *Engine) frame(_ *image.RGBA) {
+	for _, fn := range e.onFrame {
+		e.call(fn, lua.LNumber(e.gameboy.FrameCount()))
+	}
+}
+
+func (e *Engine) scanline(ly uint8) {
+	for _, fn := range e.onLine {
+		e.call(fn, lua.LNumber(ly))
+	}
+}
+
+// OnRead implements mem.Hooks
+func (e *Engine) OnRead(addr uint16, value byte) {}
+
+// OnWrite implements mem.Hooks
+func (e *Engine) OnWrite(addr uint16, value byte) {
+	fns := e.onWrite[addr]
+	if len(fns) == 0 || e.inWrite {
+		return
+	}
+	e.inWrite = true
+	for _, fn := range fns {
+		e.call(fn, lua.LNumber(addr), lua.LNumber(value))
+	}
+	e.inWrite = false
+}
@@ -0,0 +1,54 @@
+package script
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestScript(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewEngine(gameboy)
+	defer engine.Close()
+	err = engine.RunString(`
+		frames = 0
+		lines = 0
+		writes = 0
+		memory.onwrite(0xc000, function(addr, value) writes = writes + value end)
+		emu.onscanline(function(ly) lines = lines + 1 end)
+		emu.onframe(function(frame)
+			frames = frames + 1
+			memory.write(0xc000, 2)
+			memory.write(0xc001, memory.read(0xc000) + 1)
+			gui.text(0, 0, "frame " .. frame)
+		end)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gameboy.OnFrame(func(*image.RGBA) {
+		if gameboy.FrameCount() == 2 {
+			cancel()
+		}
+	})
+	gameboy.Run(ctx)
+	if engine.Err() != nil {
+		t.Fatal(engine.Err())
+	}
+	for name, expected := range map[string]lua.LNumber{"frames": 3, "lines": 3 * 144, "writes": 6} {
+		if actual := engine.state.GetGlobal(name); actual != expected {
+			t.Errorf("Wrong %s: %v", name, actual)
+		}
+	}
+	if gameboy.ReadMemory(0xc001) != 3 {
+		t.Errorf("Wrong memory value: %d", gameboy.ReadMemory(0xc001))
+	}
+}
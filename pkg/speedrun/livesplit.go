@@ -0,0 +1,63 @@
+package speedrun
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultLiveSplitAddr is where the LiveSplit Server component listens by default
+const DefaultLiveSplitAddr = "localhost:16834"
+
+// writeTimeout stops a stalled LiveSplit from stalling the emulator
+const writeTimeout = 100 * time.Millisecond
+
+// LiveSplit sends commands to the LiveSplit Server component over TCP
+type LiveSplit struct {
+	conn net.Conn
+}
+
+// DialLiveSplit connects to the LiveSplit Server component
+func DialLiveSplit(addr string) (*LiveSplit, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &LiveSplit{conn: conn}, nil
+}
+
+func (l *LiveSplit) send(command string) error {
+	l.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := fmt.Fprintf(l.conn, "%s\r\n", command)
+	return err
+}
+
+// Start starts the timer using game time, which is then set from emulated frames so that slowdown
+// and fast-forward don't affect it
+func (l *LiveSplit) Start() error {
+	if err := l.send("starttimer"); err != nil {
+		return err
+	}
+	if err := l.send("initgametime"); err != nil {
+		return err
+	}
+	return l.send("pausegametime")
+}
+
+// Split sets the game time and splits
+func (l *LiveSplit) Split(elapsed time.Duration) error {
+	if err := l.send("setgametime " + Format(elapsed)); err != nil {
+		return err
+	}
+	return l.send("split")
+}
+
+// SetGameTime sets the game time shown by LiveSplit
+func (l *LiveSplit) SetGameTime(elapsed time.Duration) error {
+	return l.send("setgametime " + Format(elapsed))
+}
+
+// Close the connection
+func (l *LiveSplit) Close() error {
+	return l.conn.Close()
+}
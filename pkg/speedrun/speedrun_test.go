@@ -0,0 +1,126 @@
+package speedrun
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+)
+
+func TestLoadSplits(t *testing.T) {
+	splits, err := LoadSplits(strings.NewReader(`
+# Any% route
+start: pc 0x0150
+Level 1: [0xC000]==1
+Credits: pc Credits
+`), expr.Symbols{"Credits": 0x4321})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(splits) != 3 {
+		t.Fatalf("expected 3 splits but got %d", len(splits))
+	}
+	for i, expected := range []string{"start: pc 0x0150", "Level 1: [0xC000]==1", "Credits: pc 0x4321"} {
+		if splits[i].String() != expected {
+			t.Errorf("expected %q but got %q", expected, splits[i])
+		}
+	}
+	for _, s := range []string{"", "no trigger", "bad: pc 0xzz", "bad: [0xC000]=="} {
+		if _, err := LoadSplits(strings.NewReader(s), nil); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                                     "0:00.00",
+		83*time.Second + 450*time.Millisecond: "1:23.45",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03.00",
+	} {
+		if actual := Format(d); actual != expected {
+			t.Errorf("expected %s but got %s", expected, actual)
+		}
+	}
+}
+
+func TestTimer(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0100:], []byte{0xc3, 0x50, 0x01}) // JP $0150
+	copy(rom[0x0150:], []byte{
+		0x06, 0x00, // LD B,$00
+		0x05,       // DEC B
+		0x20, 0xfd, // JR NZ,-3
+		0x3c,             // INC A
+		0xea, 0x00, 0xc0, // LD ($C000),A
+		0x18, 0xf6, // JR -10
+	})
+	gameboy, err := gb.NewGameboy(gb.Options{Rom: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameboy.WriteMemory(0xc000, 0)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	commands := make(chan string, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			commands <- scanner.Text()
+		}
+	}()
+	liveSplit, err := DialLiveSplit(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer liveSplit.Close()
+
+	splits, err := LoadSplits(strings.NewReader("start: pc 0x0150\nfirst: [0xC000]==0x10\nsecond: [0xC000]==0x80"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timer := New(gameboy, splits, Options{LiveSplit: liveSplit, Overlay: true})
+	gameboy.RunFrames(600)
+	if !timer.Done() {
+		t.Fatalf("expected the run to be done but reached %d splits", len(timer.Times()))
+	}
+	times := timer.Times()
+	if len(times) != 2 || times[0] <= 0 || times[1] <= times[0] || timer.Elapsed() != times[1] {
+		t.Errorf("unexpected split times %v with %v elapsed", times, timer.Elapsed())
+	}
+
+	var received []string
+	var splitsSent []string
+	for len(splitsSent) < 2 {
+		select {
+		case command := <-commands:
+			if command == "split" {
+				splitsSent = append(splitsSent, received[len(received)-1])
+			}
+			received = append(received, command)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected LiveSplit commands but got %q", received)
+		}
+	}
+	if strings.Join(received[:3], ",") != "starttimer,initgametime,pausegametime" {
+		t.Errorf("expected the timer to start but got %q", received)
+	}
+	for i, command := range splitsSent {
+		if expected := "setgametime " + Format(times[i]); command != expected {
+			t.Errorf("expected %q before split %d but got %q", expected, i, command)
+		}
+	}
+}
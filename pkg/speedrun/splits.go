@@ -0,0 +1,96 @@
+// Package speedrun times runs by emulated frames, splitting automatically when the game reaches an
+// address or a memory condition, and drives LiveSplit through its server component
+package speedrun
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/scottyw/tetromino/pkg/gb/expr"
+)
+
+// Split is a point in a run that is reached either when the CPU executes the instruction at PC or,
+// when Condition is set, when the condition becomes true at the end of a frame
+type Split struct {
+	Name      string
+	PC        uint16
+	Condition *expr.Expr
+}
+
+func (s Split) String() string {
+	if s.Condition != nil {
+		return fmt.Sprintf("%s: %s", s.Name, s.Condition)
+	}
+	return fmt.Sprintf("%s: pc 0x%04x", s.Name, s.PC)
+}
+
+// LoadSplits reads splits with one "name: trigger" entry per line, where the trigger is either
+// "pc 0x1234" or an expression such as "[0xC0A0]==5". Blank lines and lines starting with a # are
+// ignored. When the first split is named "start" it starts the timer rather than splitting.
+func LoadSplits(r io.Reader, symbols expr.Symbols) ([]Split, error) {
+	var splits []Split
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected \"name: trigger\" but got %q", line, text)
+		}
+		split := Split{Name: strings.TrimSpace(text[:i])}
+		trigger := strings.TrimSpace(text[i+1:])
+		if fields := strings.Fields(trigger); len(fields) == 2 && strings.EqualFold(fields[0], "pc") {
+			pc, err := parseAddress(fields[1], symbols)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			split.PC = pc
+		} else {
+			condition, err := symbols.Parse(trigger)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			split.Condition = condition
+		}
+		splits = append(splits, split)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(splits) == 0 {
+		return nil, fmt.Errorf("no splits found")
+	}
+	return splits, nil
+}
+
+// LoadSplitsFile reads splits from a file
+func LoadSplitsFile(filename string, symbols expr.Symbols) ([]Split, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	splits, err := LoadSplits(f, symbols)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return splits, nil
+}
+
+func parseAddress(s string, symbols expr.Symbols) (uint16, error) {
+	if addr, ok := symbols[s]; ok {
+		return addr, nil
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "$")
+	addr, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return uint16(addr), nil
+}
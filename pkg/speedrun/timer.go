@@ -0,0 +1,176 @@
+package speedrun
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/overlay"
+)
+
+// A frame lasts 70224 cycles of the 4.194304MHz clock
+const frameDuration = time.Duration(70224 * int64(time.Second) / 4194304)
+
+// The last split is shown for about three seconds
+const splitFrames = 180
+
+// LiveSplit is sent the game time about once a second between splits
+const syncFrames = 60
+
+// Options control the timer
+type Options struct {
+	// LiveSplit is sent the start and each split when it is set
+	LiveSplit *LiveSplit
+	// Overlay draws the time over the screen
+	Overlay bool
+}
+
+// Timer times a run in emulated frames, so that slowdown and fast-forward don't affect it, and splits
+// when the game reaches each split in turn
+type Timer struct {
+	gameboy  *gb.Gameboy
+	opts     Options
+	start    *Split
+	splits   []Split
+	started  bool
+	frames   int
+	next     int
+	reached  bool
+	times    []time.Duration
+	shownFor int
+}
+
+// New registers a timer with the Gameboy. When the first split is named "start" the timer starts
+// when it is reached and otherwise the timer starts straight away.
+func New(gameboy *gb.Gameboy, splits []Split, opts Options) *Timer {
+	t := &Timer{
+		gameboy: gameboy,
+		opts:    opts,
+		splits:  splits,
+	}
+	if len(splits) > 0 && splits[0].Name == "start" {
+		t.start = &splits[0]
+		t.splits = splits[1:]
+	} else {
+		t.begin()
+	}
+	gameboy.OnExecute(t.execute)
+	gameboy.OnFrame(t.frame)
+	return t
+}
+
+// Elapsed returns the time since the run started
+func (t *Timer) Elapsed() time.Duration {
+	return time.Duration(t.frames) * frameDuration
+}
+
+// Times returns the time at which each split so far was reached
+func (t *Timer) Times() []time.Duration {
+	return append([]time.Duration(nil), t.times...)
+}
+
+// Done returns true once every split has been reached
+func (t *Timer) Done() bool {
+	return t.started && t.next >= len(t.splits)
+}
+
+// target returns the split that the run is waiting for
+func (t *Timer) target() *Split {
+	if !t.started {
+		return t.start
+	}
+	if t.next < len(t.splits) {
+		return &t.splits[t.next]
+	}
+	return nil
+}
+
+func (t *Timer) execute(pc uint16) {
+	if target := t.target(); target != nil && target.Condition == nil && target.PC == pc {
+		t.reached = true
+	}
+}
+
+func (t *Timer) frame(_ *image.RGBA) {
+	if t.started && !t.Done() {
+		t.frames++
+	}
+	if target := t.target(); target != nil && target.Condition != nil && target.Condition.True(t.gameboy) {
+		t.reached = true
+	}
+	if t.reached {
+		t.reached = false
+		if t.started {
+			t.split()
+		} else {
+			t.begin()
+		}
+	} else if t.started && !t.Done() && t.opts.LiveSplit != nil && t.frames%syncFrames == 0 {
+		if err := t.opts.LiveSplit.SetGameTime(t.Elapsed()); err != nil {
+			t.warn(err)
+		}
+	}
+	if t.opts.Overlay && t.started {
+		t.draw()
+	}
+}
+
+func (t *Timer) begin() {
+	t.started = true
+	if t.opts.LiveSplit != nil {
+		if err := t.opts.LiveSplit.Start(); err != nil {
+			t.warn(err)
+		}
+	}
+}
+
+func (t *Timer) split() {
+	elapsed := t.Elapsed()
+	t.times = append(t.times, elapsed)
+	t.gameboy.Logger().With("speedrun").Infof("Split %s at %s", t.splits[t.next].Name, Format(elapsed))
+	t.next++
+	t.shownFor = 0
+	if t.opts.LiveSplit != nil {
+		if err := t.opts.LiveSplit.Split(elapsed); err != nil {
+			t.warn(err)
+		}
+	}
+}
+
+func (t *Timer) warn(err error) {
+	t.gameboy.Logger().With("speedrun").Warnf("Failed to update LiveSplit: %v", err)
+}
+
+// draw the time in the bottom right corner of the screen and the last split above it for a while
+func (t *Timer) draw() {
+	width, height := t.gameboy.ScreenSize()
+	layer := t.gameboy.Overlay()
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	if t.Done() {
+		white = color.RGBA{0xff, 0xd7, 0x00, 0xff}
+	}
+	text := Format(t.Elapsed())
+	layer.ShadowText(width-overlay.TextWidth(text)-2, height-15, text, white)
+	if len(t.times) > 0 && t.shownFor < splitFrames {
+		last := len(t.times) - 1
+		text = fmt.Sprintf("%s %s", t.splits[last].Name, Format(t.times[last]))
+		layer.ShadowText(width-overlay.TextWidth(text)-2, height-29, text, color.RGBA{0xff, 0xd7, 0x00, 0xff})
+		t.shownFor++
+	}
+}
+
+// Format returns a time as "m:ss.cc" or as "h:mm:ss.cc" once it reaches an hour, which is also a
+// format that LiveSplit understands
+func Format(d time.Duration) string {
+	centiseconds := int64(d / (10 * time.Millisecond))
+	hours := centiseconds / 360000
+	minutes := centiseconds / 6000 % 60
+	seconds := centiseconds / 100 % 60
+	centiseconds %= 100
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d.%02d", hours, minutes, seconds, centiseconds)
+	}
+	return fmt.Sprintf("%d:%02d.%02d", minutes, seconds, centiseconds)
+}
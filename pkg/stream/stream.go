@@ -0,0 +1,284 @@
+// Package stream serves the frames of a running Gameboy to browsers, either as MJPEG over HTTP or as
+// binary WebSocket messages, and presses the buttons that they send so that a headless emulator on a
+// server can be watched and played from anywhere
+package stream
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sync"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"golang.org/x/net/websocket"
+)
+
+// Options control how frames are sent
+type Options struct {
+	// Quality is the quality of MJPEG frames from 1 to 100
+	Quality int
+	// FrameSkip sends every nth frame, which saves bandwidth at the cost of smoothness
+	FrameSkip int
+}
+
+// DefaultOptions send 30 frames a second at a quality that keeps the pixels sharp
+var DefaultOptions = Options{Quality: 90, FrameSkip: 2}
+
+// Input is a button pressed or released by a browser, sent over the WebSocket as JSON such as
+// {"button": "a", "pressed": true}
+type Input struct {
+	// Player is the controller that the button is on, which is 0 except for Super Game Boy multiplayer
+	Player  int    `json:"player,omitempty"`
+	Button  string `json:"button"`
+	Pressed bool   `json:"pressed"`
+}
+
+// press is a button waiting to be pressed at the end of a frame
+type press struct {
+	player  int
+	button  gb.Button
+	pressed bool
+}
+
+// Server streams the frames of a Gameboy and presses the buttons sent by its viewers
+type Server struct {
+	gameboy   *gb.Gameboy
+	quality   int
+	frameSkip int
+
+	mutex       sync.Mutex
+	subscribers map[chan image.Image]bool
+	presses     []press
+}
+
+// New returns a server that streams frames from a Gameboy as they end
+func New(gameboy *gb.Gameboy, opts Options) *Server {
+	if opts.Quality < 1 || opts.Quality > 100 {
+		opts.Quality = DefaultOptions.Quality
+	}
+	if opts.FrameSkip < 1 {
+		opts.FrameSkip = DefaultOptions.FrameSkip
+	}
+	s := &Server{
+		gameboy:     gameboy,
+		quality:     opts.Quality,
+		frameSkip:   opts.FrameSkip,
+		subscribers: map[chan image.Image]bool{},
+	}
+	gameboy.OnFrame(s.frameEnd)
+	return s
+}
+
+// Press queues a button to be pressed or released at the end of the current frame
+func (s *Server) Press(input Input) error {
+	button, err := gb.ParseButton(input.Button)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.presses = append(s.presses, press{input.Player, button, input.Pressed})
+	return nil
+}
+
+// frameEnd presses the queued buttons and sends the frame to each viewer. A viewer that hasn't taken
+// the previous frame yet gets the new one instead, so slow connections drop frames rather than lag.
+func (s *Server) frameEnd(_ *image.RGBA) {
+	s.mutex.Lock()
+	presses := s.presses
+	s.presses = nil
+	watched := len(s.subscribers) > 0
+	s.mutex.Unlock()
+	for _, p := range presses {
+		s.gameboy.PlayerButtonAction(p.player, p.button, p.pressed)
+	}
+	if !watched || s.gameboy.FrameCount()%s.frameSkip != 0 {
+		return
+	}
+	frame := s.gameboy.Frame()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for frames := range s.subscribers {
+		select {
+		case <-frames:
+		default:
+		}
+		frames <- frame
+	}
+}
+
+func (s *Server) subscribe() chan image.Image {
+	frames := make(chan image.Image, 1)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscribers[frames] = true
+	return frames
+}
+
+func (s *Server) unsubscribe(frames chan image.Image) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.subscribers, frames)
+}
+
+// ListenAndServe serves the stream on an HTTP address until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	s.gameboy.Logger().With("stream").Infof("Streaming frames at http://%s/", addr)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the HTTP handler that serves a page to play in at /, MJPEG at /mjpeg and frames
+// and buttons over a WebSocket at /ws
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/mjpeg", s.serveMJPEG)
+	mux.Handle("/ws", websocket.Handler(s.serveWebSocket))
+	return mux
+}
+
+// serveMJPEG sends each frame as a JPEG part of a multipart response, which browsers show as video
+// in an img element
+func (s *Server) serveMJPEG(w http.ResponseWriter, r *http.Request) {
+	frames := s.subscribe()
+	defer s.unsubscribe(frames)
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+parts.Boundary())
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case frame := <-frames:
+			part, err := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"image/jpeg"}})
+			if err != nil {
+				return
+			}
+			if err := jpeg.Encode(part, frame, &jpeg.Options{Quality: s.quality}); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveWebSocket sends each frame as a binary message holding the width and height as big-endian
+// 16-bit numbers followed by the RGBA pixels, and presses the buttons in the JSON messages it receives
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	frames := s.subscribe()
+	defer s.unsubscribe(frames)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var input Input
+			if err := websocket.JSON.Receive(ws, &input); err != nil {
+				return
+			}
+			if err := s.Press(input); err != nil {
+				s.gameboy.Logger().With("stream").Warnf("Ignoring input from %s: %v", ws.Request().RemoteAddr, err)
+			}
+		}
+	}()
+	var message []byte
+	for {
+		select {
+		case frame := <-frames:
+			message = encodeFrame(message, frame.(*image.RGBA))
+			if err := websocket.Message.Send(ws, message); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// encodeFrame writes a frame into a binary WebSocket message, reusing its buffer
+func encodeFrame(message []byte, frame *image.RGBA) []byte {
+	width, height := frame.Rect.Dx(), frame.Rect.Dy()
+	if cap(message) < 4+width*height*4 {
+		message = make([]byte, 4+width*height*4)
+	}
+	message = message[:4+width*height*4]
+	binary.BigEndian.PutUint16(message[0:], uint16(width))
+	binary.BigEndian.PutUint16(message[2:], uint16(height))
+	for y := 0; y < height; y++ {
+		start := frame.PixOffset(frame.Rect.Min.X, frame.Rect.Min.Y+y)
+		copy(message[4+y*width*4:], frame.Pix[start:start+width*4])
+	}
+	return message
+}
+
+// page draws the frames from the WebSocket on a canvas and sends the keys pressed as buttons
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<title>Tetromino</title>
+<style>
+body { background: #222; color: #ccc; font-family: sans-serif; text-align: center; }
+canvas { width: 480px; image-rendering: pixelated; background: #000; }
+</style>
+</head>
+<body>
+<canvas id="screen" width="160" height="144"></canvas>
+<p>Arrow keys, X for A, Z for B, Enter for Start and Shift for Select</p>
+<script>
+const keys = {ArrowUp: "up", ArrowDown: "down", ArrowLeft: "left", ArrowRight: "right",
+  x: "a", z: "b", Enter: "start", Shift: "select"};
+const canvas = document.getElementById("screen");
+const context = canvas.getContext("2d");
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+ws.binaryType = "arraybuffer";
+ws.onmessage = (event) => {
+  const view = new DataView(event.data);
+  const width = view.getUint16(0), height = view.getUint16(2);
+  if (canvas.width !== width || canvas.height !== height) {
+    canvas.width = width;
+    canvas.height = height;
+    canvas.style.width = (width * 3) + "px";
+  }
+  const pixels = new Uint8ClampedArray(event.data, 4, width * height * 4);
+  context.putImageData(new ImageData(pixels, width, height), 0, 0);
+};
+function send(event, pressed) {
+  const button = keys[event.key.length === 1 ? event.key.toLowerCase() : event.key];
+  if (!button || event.repeat) {
+    return;
+  }
+  event.preventDefault();
+  if (ws.readyState === WebSocket.OPEN) {
+    ws.send(JSON.stringify({button: button, pressed: pressed}));
+  }
+}
+document.addEventListener("keydown", (event) => send(event, true));
+document.addEventListener("keyup", (event) => send(event, false));
+</script>
+</body>
+</html>
+`
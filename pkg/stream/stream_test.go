@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"encoding/binary"
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"golang.org/x/net/websocket"
+)
+
+// recorded is a button pressed on a Gameboy that was recorded instead of being pressed
+type recorded struct {
+	player  int
+	button  gb.Button
+	pressed bool
+}
+
+// run runs frames in the background until the returned function is called
+func run(gameboy *gb.Gameboy) func() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				gameboy.RunFrames(1)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stop) })
+		wg.Wait()
+	}
+}
+
+func TestMJPEG(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(New(gameboy, DefaultOptions).Handler())
+	defer server.Close()
+	stop := run(gameboy)
+	defer stop()
+
+	response, err := http.Get(server.URL + "/mjpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("expected a multipart stream but got %s", mediaType)
+	}
+	parts := multipart.NewReader(response.Body, params["boundary"])
+	for i := 0; i < 2; i++ {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := jpeg.Decode(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := frame.Bounds().Size(); size.X != 160 || size.Y != 144 {
+			t.Errorf("expected a 160x144 frame but got %v", size)
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	var presses []recorded
+	gameboy.InterceptButtons(func(player int, button gb.Button, pressed bool) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		presses = append(presses, recorded{player, button, pressed})
+	})
+	server := httptest.NewServer(New(gameboy, Options{FrameSkip: 1}).Handler())
+	defer server.Close()
+	stop := run(gameboy)
+	defer stop()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	for _, input := range []Input{{Button: "start", Pressed: true}, {Button: "jump", Pressed: true}, {Button: "start"}} {
+		if err := websocket.JSON.Send(ws, input); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Frames keep coming while the buttons are pressed
+	pressed := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(presses)
+	}
+	for i := 0; i < 5 || pressed() < 2 && i < 600; i++ {
+		var message []byte
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			t.Fatal(err)
+		}
+		width, height := binary.BigEndian.Uint16(message), binary.BigEndian.Uint16(message[2:])
+		if width != 160 || height != 144 || len(message) != 4+160*144*4 {
+			t.Fatalf("expected a 160x144 frame but got %dx%d in %d bytes", width, height, len(message))
+		}
+	}
+	stop()
+	expected := []recorded{{0, gb.Start, true}, {0, gb.Start, false}}
+	if !reflect.DeepEqual(presses, expected) {
+		t.Errorf("expected %v but got %v", expected, presses)
+	}
+}
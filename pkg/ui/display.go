@@ -3,43 +3,61 @@ package ui
 import (
 	"context"
 	"image"
+	"image/draw"
 	"runtime"
+	"time"
 
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
 	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/menu"
 )
 
 // GLDisplay implements the LCD display using GL
 type GLDisplay struct {
 	cancelFunc context.CancelFunc
+	gameboy    *gb.Gameboy
+	keys       map[glfw.Key]string
+	gamepads   gamepads
 	window     *glfw.Window
 	texture    uint32
-	width      float32
-	height     float32
+	// textureSize is the size of the texture's storage, which is allocated for the first frame
+	textureSize image.Point
+	width       float32
+	height      float32
+	orientation Orientation
+	// pauseUnfocused pauses the emulator and speakers while the window is in the background
+	pauseUnfocused bool
+	speakers       *PortaudioSpeakers
+	menu           *menu.Menu
+	// menuHeld is true while the gamepad button that opens the menu is held down
+	menuHeld bool
 }
 
-// NewGLDisplay implements an LCD display in GL
-func NewGLDisplay(gameboy *gb.Gameboy, cancelFunc context.CancelFunc) (*GLDisplay, error) {
+// NewGLDisplay implements an LCD display in GL with a window scaled up from the size of the LCD and
+// turned to an orientation, and keys bound to actions as described by config.Config
+func NewGLDisplay(gameboy *gb.Gameboy, cancelFunc context.CancelFunc, scale int, orientation Orientation, keys map[string]string) (*GLDisplay, error) {
+	bindings, err := bindKeys(keys)
+	if err != nil {
+		return nil, err
+	}
 	// initialize glfw
 	if err := glfw.Init(); err != nil {
 		return nil, err
 	}
 	// define window width
-	var width float32
-	var height float32
-	if gameboy.Debug() {
-		width = 256
-		height = 256
-	} else {
-		width = 160
-		height = 144
-	}
+	w, h := gameboy.ScreenSize()
+	width := float32(w)
+	height := float32(h)
 	// create window
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
 	glfw.WindowHint(glfw.Resizable, 0)
-	window, err := glfw.CreateWindow(int(width*3), int(height*3), "Tetromino", nil, nil)
+	windowWidth, windowHeight := w*scale, h*scale
+	if orientation.sideways() {
+		windowWidth, windowHeight = windowHeight, windowWidth
+	}
+	window, err := glfw.CreateWindow(windowWidth, windowHeight, "Tetromino", nil, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -53,17 +71,43 @@ func NewGLDisplay(gameboy *gb.Gameboy, cancelFunc context.CancelFunc) (*GLDispla
 		return nil, err
 	}
 	gl.Enable(gl.TEXTURE_2D)
-	window.SetKeyCallback(onKeyFunc(gameboy))
+	window.SetKeyCallback(onKeyFunc(gameboy, bindings, nil))
 	display := &GLDisplay{
-		cancelFunc: cancelFunc,
-		window:     window,
-		texture:    createTexture(),
-		width:      width,
-		height:     height,
+		cancelFunc:  cancelFunc,
+		gameboy:     gameboy,
+		keys:        bindings,
+		window:      window,
+		texture:     createTexture(),
+		width:       width,
+		height:      height,
+		orientation: orientation,
 	}
 	return display, nil
 }
 
+// SetGameboy sends keyboard and gamepad input to a different Gameboy, such as one running a newly
+// loaded ROM
+func (d *GLDisplay) SetGameboy(gameboy *gb.Gameboy) {
+	d.gameboy = gameboy
+	d.gamepads = gamepads{}
+	d.window.SetKeyCallback(onKeyFunc(gameboy, d.keys, d.menu))
+}
+
+// SetMenu shows a menu over the game, pausing it and stopping the speakers too when they are given,
+// when the key bound to "menu" or the guide button of a gamepad is pressed
+func (d *GLDisplay) SetMenu(m *menu.Menu, speakers *PortaudioSpeakers) {
+	d.menu = m
+	d.speakers = speakers
+	d.window.SetKeyCallback(onKeyFunc(d.gameboy, d.keys, m))
+}
+
+// PauseWhenUnfocused pauses the emulator while the window is in the background, stopping the speakers
+// too when they are given, and resumes it when the window has focus again
+func (d *GLDisplay) PauseWhenUnfocused(speakers *PortaudioSpeakers) {
+	d.pauseUnfocused = true
+	d.speakers = speakers
+}
+
 // Cleanup returns resources to the OS
 func (d *GLDisplay) Cleanup() {
 	glfw.Terminate()
@@ -71,44 +115,160 @@ func (d *GLDisplay) Cleanup() {
 
 // DisplayFrame draws a frame to the GL window and returns user input
 func (d *GLDisplay) DisplayFrame(image *image.RGBA) {
+	d.draw(image)
+	glfw.PollEvents()
+	if d.pauseUnfocused {
+		d.waitForFocus()
+	}
+	if d.menu != nil {
+		held := menuPressed()
+		if held && !d.menuHeld {
+			d.menu.Open()
+		}
+		d.menuHeld = held
+		if d.menu.IsOpen() {
+			d.showMenu(image)
+		}
+	}
+	d.gamepads.poll(d.gameboy)
+	d.tilt()
+	if d.window.ShouldClose() {
+		d.cancelFunc()
+	}
+}
+
+// draw shows a frame in the window
+func (d *GLDisplay) draw(image *image.RGBA) {
 	gl.Clear(gl.COLOR_BUFFER_BIT)
 	gl.BindTexture(gl.TEXTURE_2D, d.texture)
-	setTexture(image)
-	drawBuffer(d.window, d.width, d.height)
+	d.setTexture(image)
+	drawBuffer(d.window, d.width, d.height, d.orientation)
 	gl.BindTexture(gl.TEXTURE_2D, 0)
 	d.window.SwapBuffers()
-	glfw.PollEvents()
-	if d.window.ShouldClose() {
-		d.cancelFunc()
+}
+
+// showMenu draws the menu over a frame until it is closed, moving through it with the first gamepad
+// as well as the keys. The emulator draws each frame on this goroutine so it is paused until then.
+func (d *GLDisplay) showMenu(frame *image.RGBA) {
+	// Buttons held as the menu opened would otherwise stay held in the game
+	for button := gb.Button(gb.Up); button <= gb.Select; button++ {
+		d.gameboy.ButtonAction(button, false)
 	}
+	for player, pressed := range d.gamepads {
+		for button, down := range pressed {
+			if down {
+				d.gameboy.PlayerButtonAction(player, button, false)
+			}
+		}
+	}
+	d.gamepads = gamepads{}
+	d.window.SetTitle("Tetromino (paused)")
+	if d.speakers != nil {
+		d.speakers.Pause()
+	}
+	picture := image.NewRGBA(frame.Rect)
+	previous := pressedButtons(glfw.Joystick1)
+	for d.menu.IsOpen() && !d.window.ShouldClose() {
+		draw.Draw(picture, picture.Rect, frame, frame.Rect.Min, draw.Src)
+		d.menu.Draw(picture)
+		d.draw(picture)
+		// Gamepads can't wake the event loop so they are read at the rate of frames
+		time.Sleep(time.Second / 60)
+		glfw.PollEvents()
+		pressed := pressedButtons(glfw.Joystick1)
+		for button, down := range pressed {
+			if down && !previous[button] {
+				d.menu.Press(button)
+			}
+		}
+		previous = pressed
+		held := menuPressed()
+		if held && !d.menuHeld {
+			d.menu.Close()
+		}
+		d.menuHeld = held
+	}
+	if d.speakers != nil {
+		d.speakers.Resume()
+	}
+	d.window.SetTitle("Tetromino")
 }
 
-func onKeyFunc(gameboy *gb.Gameboy) func(*glfw.Window, glfw.Key, int, glfw.Action, glfw.ModifierKey) {
+// waitForFocus blocks while the window is in the background. The emulator draws each frame on this
+// goroutine so it is paused until then.
+func (d *GLDisplay) waitForFocus() {
+	if d.window.GetAttrib(glfw.Focused) == glfw.True || d.window.ShouldClose() {
+		return
+	}
+	d.window.SetTitle("Tetromino (paused)")
+	if d.speakers != nil {
+		d.speakers.Pause()
+	}
+	for d.window.GetAttrib(glfw.Focused) != glfw.True && !d.window.ShouldClose() {
+		glfw.WaitEvents()
+	}
+	if d.speakers != nil {
+		d.speakers.Resume()
+	}
+	d.window.SetTitle("Tetromino")
+}
+
+// onKeyFunc returns a callback that presses buttons on the Gameboy, or moves through the menu while
+// it is open so that keys held down repeat
+func onKeyFunc(gameboy *gb.Gameboy, bindings map[glfw.Key]string, m *menu.Menu) func(*glfw.Window, glfw.Key, int, glfw.Action, glfw.ModifierKey) {
 	return func(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		if m != nil && m.IsOpen() {
+			if bindings[key] == "menu" && action == glfw.Press {
+				m.Close()
+			} else if button, err := gb.ParseButton(bindings[key]); err == nil && action != glfw.Release {
+				m.Press(button)
+			}
+			return
+		}
 		if action != glfw.Press && action != glfw.Release {
 			return
 		}
-		switch key {
-		case glfw.KeyA:
+		switch bindings[key] {
+		case "start":
 			gameboy.ButtonAction(gb.Start, action == glfw.Press)
-		case glfw.KeyS:
+		case "select":
 			gameboy.ButtonAction(gb.Select, action == glfw.Press)
-		case glfw.KeyZ:
+		case "b":
 			gameboy.ButtonAction(gb.B, action == glfw.Press)
-		case glfw.KeyX:
+		case "a":
 			gameboy.ButtonAction(gb.A, action == glfw.Press)
-		case glfw.KeyUp:
+		case "up":
 			gameboy.ButtonAction(gb.Up, action == glfw.Press)
-		case glfw.KeyDown:
+		case "down":
 			gameboy.ButtonAction(gb.Down, action == glfw.Press)
-		case glfw.KeyLeft:
+		case "left":
 			gameboy.ButtonAction(gb.Left, action == glfw.Press)
-		case glfw.KeyRight:
+		case "right":
 			gameboy.ButtonAction(gb.Right, action == glfw.Press)
-		case glfw.KeyT:
+		case "screenshot":
 			if action == glfw.Press {
 				gameboy.EmulatorAction(gb.TakeScreenshot)
 			}
+		case "dumptrace":
+			if action == glfw.Press {
+				gameboy.EmulatorAction(gb.DumpTrace)
+			}
+		case "fastforward":
+			if action == glfw.Press {
+				gameboy.EmulatorAction(gb.StartFastForward)
+			} else {
+				gameboy.EmulatorAction(gb.StopFastForward)
+			}
+		case "rewind":
+			if action == glfw.Press {
+				gameboy.EmulatorAction(gb.StartRewind)
+			} else {
+				gameboy.EmulatorAction(gb.StopRewind)
+			}
+		case "menu":
+			if m != nil && action == glfw.Press {
+				m.Open()
+			}
 		}
 	}
 }
@@ -133,17 +293,29 @@ func createTexture() uint32 {
 	return texture
 }
 
-func setTexture(im *image.RGBA) {
+// setTexture copies a frame into the texture, only allocating the texture's storage when the size
+// of the frame changes so that the driver doesn't have to create it again for every frame
+func (d *GLDisplay) setTexture(im *image.RGBA) {
 	size := im.Rect.Size()
-	gl.TexImage2D(
-		gl.TEXTURE_2D, 0, gl.RGBA, int32(size.X), int32(size.Y),
-		0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(im.Pix))
+	if size != d.textureSize {
+		gl.TexImage2D(
+			gl.TEXTURE_2D, 0, gl.RGBA, int32(size.X), int32(size.Y),
+			0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+		d.textureSize = size
+	}
+	gl.TexSubImage2D(
+		gl.TEXTURE_2D, 0, 0, 0, int32(size.X), int32(size.Y),
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(im.Pix))
 }
 
-func drawBuffer(window *glfw.Window, width, height float32) {
+func drawBuffer(window *glfw.Window, width, height float32, orientation Orientation) {
 	w, h := window.GetFramebufferSize()
-	s1 := float32(w) / width
-	s2 := float32(h) / height
+	shownWidth, shownHeight := width, height
+	if orientation.sideways() {
+		shownWidth, shownHeight = height, width
+	}
+	s1 := float32(w) / shownWidth
+	s2 := float32(h) / shownHeight
 	f := float32(1 - 0)
 	var x, y float32
 	if s1 >= s2 {
@@ -153,14 +325,15 @@ func drawBuffer(window *glfw.Window, width, height float32) {
 		x = f
 		y = f * s1 / s2
 	}
+	corners := orientation.corners(width/256.0, height/256.0)
 	gl.Begin(gl.QUADS)
-	gl.TexCoord2f(0, height/256.0)
+	gl.TexCoord2f(corners[0][0], corners[0][1])
 	gl.Vertex2f(-x, -y)
-	gl.TexCoord2f(width/256.0, height/256.0)
+	gl.TexCoord2f(corners[1][0], corners[1][1])
 	gl.Vertex2f(x, -y)
-	gl.TexCoord2f(width/256.0, 0)
+	gl.TexCoord2f(corners[2][0], corners[2][1])
 	gl.Vertex2f(x, y)
-	gl.TexCoord2f(0, 0)
+	gl.TexCoord2f(corners[3][0], corners[3][1])
 	gl.Vertex2f(-x, y)
 	gl.End()
 }
@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"github.com/go-gl/glfw/v3.1/glfw"
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// gamepadButtons maps the buttons of a gamepad with an Xbox layout to Gameboy buttons
+var gamepadButtons = map[int]gb.Button{
+	0: gb.A,
+	1: gb.B,
+	6: gb.Select,
+	7: gb.Start,
+}
+
+// deadZone is how far a stick must move before it presses a direction
+const deadZone = 0.5
+
+// gamepads holds the buttons pressed on each of the first 4 gamepads so that only changes are sent to
+// the Gameboy. The first gamepad controls the same player as the keyboard and the others control the
+// extra players that a Super Game Boy supports.
+type gamepads [4]map[gb.Button]bool
+
+// menuButton is the guide button in the middle of a gamepad with an Xbox layout, which opens the menu
+const menuButton = 8
+
+// poll reads every connected gamepad and presses or releases buttons on the controller of the same
+// number
+func (g *gamepads) poll(gameboy *gb.Gameboy) {
+	for player := range g {
+		pressed := pressedButtons(glfw.Joystick1 + glfw.Joystick(player))
+		for button, down := range pressed {
+			if down != g[player][button] {
+				gameboy.PlayerButtonAction(player, button, down)
+			}
+		}
+		for button, down := range g[player] {
+			if down && !pressed[button] {
+				gameboy.PlayerButtonAction(player, button, false)
+			}
+		}
+		g[player] = pressed
+	}
+}
+
+// pressedButtons returns the Gameboy buttons held down on a gamepad, which is none if it isn't
+// connected
+func pressedButtons(joystick glfw.Joystick) map[gb.Button]bool {
+	pressed := map[gb.Button]bool{}
+	if !glfw.JoystickPresent(joystick) {
+		return pressed
+	}
+	for i, state := range glfw.GetJoystickButtons(joystick) {
+		if button, ok := gamepadButtons[i]; ok && state == byte(glfw.Press) {
+			pressed[button] = true
+		}
+	}
+	if axes := glfw.GetJoystickAxes(joystick); len(axes) >= 2 {
+		pressed[gb.Left] = axes[0] < -deadZone
+		pressed[gb.Right] = axes[0] > deadZone
+		pressed[gb.Up] = axes[1] < -deadZone
+		pressed[gb.Down] = axes[1] > deadZone
+	}
+	return pressed
+}
+
+// menuPressed returns true if the guide button of the first gamepad is held down
+func menuPressed() bool {
+	if !glfw.JoystickPresent(glfw.Joystick1) {
+		return false
+	}
+	buttons := glfw.GetJoystickButtons(glfw.Joystick1)
+	return len(buttons) > menuButton && buttons[menuButton] == byte(glfw.Press)
+}
@@ -0,0 +1,59 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// keyNames maps the names used in the config file to keys, ignoring case
+var keyNames = map[string]glfw.Key{
+	"up":           glfw.KeyUp,
+	"down":         glfw.KeyDown,
+	"left":         glfw.KeyLeft,
+	"right":        glfw.KeyRight,
+	"enter":        glfw.KeyEnter,
+	"space":        glfw.KeySpace,
+	"tab":          glfw.KeyTab,
+	"backspace":    glfw.KeyBackspace,
+	"escape":       glfw.KeyEscape,
+	"leftshift":    glfw.KeyLeftShift,
+	"rightshift":   glfw.KeyRightShift,
+	"leftcontrol":  glfw.KeyLeftControl,
+	"rightcontrol": glfw.KeyRightControl,
+	"leftalt":      glfw.KeyLeftAlt,
+	"rightalt":     glfw.KeyRightAlt,
+	"comma":        glfw.KeyComma,
+	"period":       glfw.KeyPeriod,
+	"slash":        glfw.KeySlash,
+	"semicolon":    glfw.KeySemicolon,
+}
+
+func init() {
+	for c := 'a'; c <= 'z'; c++ {
+		keyNames[string(c)] = glfw.KeyA + glfw.Key(c-'a')
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyNames[string(c)] = glfw.Key0 + glfw.Key(c-'0')
+	}
+	for n := 1; n <= 12; n++ {
+		keyNames[fmt.Sprintf("f%d", n)] = glfw.KeyF1 + glfw.Key(n-1)
+	}
+}
+
+// bindKeys maps each key to the action it is bound to
+func bindKeys(keys map[string]string) (map[glfw.Key]string, error) {
+	bindings := map[glfw.Key]string{}
+	for action, name := range keys {
+		key, ok := keyNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q for %s", name, action)
+		}
+		if other, ok := bindings[key]; ok {
+			return nil, fmt.Errorf("key %q is bound to both %s and %s", name, other, action)
+		}
+		bindings[key] = action
+	}
+	return bindings, nil
+}
@@ -0,0 +1,42 @@
+package ui
+
+import (
+	"fmt"
+)
+
+// Orientation turns the picture in the window, such as for a monitor on its side or for homebrew made
+// for a rotated screen
+type Orientation struct {
+	// Rotate turns the picture clockwise by 0, 90, 180 or 270 degrees
+	Rotate int
+	// Mirror flips the picture horizontally after it is turned
+	Mirror bool
+}
+
+// NewOrientation returns an orientation after checking that the rotation is a quarter turn
+func NewOrientation(rotate int, mirror bool) (Orientation, error) {
+	if rotate < 0 || rotate >= 360 || rotate%90 != 0 {
+		return Orientation{}, fmt.Errorf("rotation must be 0, 90, 180 or 270 degrees but got %d", rotate)
+	}
+	return Orientation{Rotate: rotate, Mirror: mirror}, nil
+}
+
+// sideways returns true if the picture is turned on its side, which swaps its width and height
+func (o Orientation) sideways() bool {
+	return o.Rotate%180 != 0
+}
+
+// corners returns the texture coordinates for the bottom left, bottom right, top right and top left
+// corners of the window in that order, given the width and height of the picture within the texture
+func (o Orientation) corners(width, height float32) [4][2]float32 {
+	unturned := [4][2]float32{{0, height}, {width, height}, {width, 0}, {0, 0}}
+	var corners [4][2]float32
+	for i := range corners {
+		corners[i] = unturned[(i+o.Rotate/90)%4]
+	}
+	if o.Mirror {
+		corners[0], corners[1] = corners[1], corners[0]
+		corners[2], corners[3] = corners[3], corners[2]
+	}
+	return corners
+}
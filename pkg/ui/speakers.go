@@ -2,15 +2,21 @@ package ui
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/gordonklaus/portaudio"
+	"github.com/scottyw/tetromino/pkg/gb/audio"
 )
 
+// bufferSamples is enough stereo samples for about 23ms of audio, which absorbs uneven emulation
+// speed without adding noticeable latency
+const bufferSamples = 1024
+
 // PortaudioSpeakers implements speakers using portaudio
 type PortaudioSpeakers struct {
-	stream *portaudio.Stream
-	l      chan float32
-	r      chan float32
+	stream    *portaudio.Stream
+	buffer    *audio.RingBuffer
+	underruns uint64
 }
 
 // NewPortaudioSpeakers starts audio output using portaudio
@@ -22,8 +28,7 @@ func NewPortaudioSpeakers() (*PortaudioSpeakers, error) {
 	}
 	parameters := portaudio.LowLatencyParameters(nil, host.DefaultOutputDevice)
 	speakers := &PortaudioSpeakers{
-		l: make(chan float32, 200),
-		r: make(chan float32, 200),
+		buffer: audio.NewRingBuffer(bufferSamples),
 	}
 	stream, err := portaudio.OpenStream(parameters, speakers.Callback)
 	if err != nil {
@@ -36,28 +41,41 @@ func NewPortaudioSpeakers() (*PortaudioSpeakers, error) {
 	return speakers, nil
 }
 
+// Pause stops playing sound until Resume is called, without counting the silence as underruns
+func (s *PortaudioSpeakers) Pause() {
+	if err := s.stream.Stop(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Resume starts playing sound again after Pause
+func (s *PortaudioSpeakers) Resume() {
+	if err := s.stream.Start(); err != nil {
+		fmt.Println(err)
+	}
+}
+
 // Cleanup returns resources to the OS
 func (s *PortaudioSpeakers) Cleanup() {
 	defer portaudio.Terminate()
-	close(s.l)
-	close(s.r)
+	s.buffer.Close()
 	err := s.stream.Close()
 	if err != nil {
 		fmt.Println(err)
 	}
 }
 
-// Left returns the channel that feeds the left speaker
-func (s *PortaudioSpeakers) Left() chan float32 {
-	return s.l
+// Buffer returns the ring buffer of samples that the speakers play
+func (s *PortaudioSpeakers) Buffer() *audio.RingBuffer {
+	return s.buffer
 }
 
-// Right returns the channel that feeds the right speaker
-func (s *PortaudioSpeakers) Right() chan float32 {
-	return s.r
+// Underruns returns the number of times that audio output ran out of samples
+func (s *PortaudioSpeakers) Underruns() uint64 {
+	return atomic.LoadUint64(&s.underruns)
 }
 
-// Callback from portaudio to consume the audio data written to the channel
+// Callback from portaudio to consume the audio data written to the ring buffer
 func (s *PortaudioSpeakers) Callback(out []float32) {
 
 	// Low latency callback every 1.44216ms approx i.e. 693.4 times per second approx
@@ -66,13 +84,15 @@ func (s *PortaudioSpeakers) Callback(out []float32) {
 	// High latency callback every 11.581337ms approx i.e. 86.3 times per second approx
 	// Array size is always 1022 i.e. 88200 elements per second
 
-	length := len(out)
-
 	// Left is 0th, 2nd, 4th ... array elements
 	// Right  is 1st, 3rd, 5th ... array elements
-	for i := 0; i < length; i += 2 {
-		out[i] = <-s.l
-		out[i+1] = <-s.r
-	}
+	n := s.buffer.Read(out)
 
+	// Play silence rather than waiting for the emulator when it has not produced enough samples
+	if n < len(out) {
+		atomic.AddUint64(&s.underruns, 1)
+		for i := n; i < len(out); i++ {
+			out[i] = 0
+		}
+	}
 }
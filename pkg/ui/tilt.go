@@ -0,0 +1,43 @@
+package ui
+
+import (
+	"math"
+
+	"github.com/go-gl/glfw/v3.1/glfw"
+)
+
+// tiltDeadZone is how far the stick must move before it tilts the cart, which hides the drift of
+// worn sticks
+const tiltDeadZone = 0.1
+
+// tilt tilts carts with an accelerometer by as much as the left stick of the first gamepad is moved,
+// or fully in the directions whose keys are held while the stick is centred
+func (d *GLDisplay) tilt() {
+	if !d.gameboy.HasAccelerometer() {
+		return
+	}
+	var x, y float64
+	if glfw.JoystickPresent(glfw.Joystick1) {
+		if axes := glfw.GetJoystickAxes(glfw.Joystick1); len(axes) >= 2 && math.Hypot(float64(axes[0]), float64(axes[1])) > tiltDeadZone {
+			x, y = float64(axes[0]), float64(axes[1])
+		}
+	}
+	if x == 0 && y == 0 {
+		for key, action := range d.keys {
+			if d.window.GetKey(key) != glfw.Press {
+				continue
+			}
+			switch action {
+			case "left":
+				x--
+			case "right":
+				x++
+			case "up":
+				y--
+			case "down":
+				y++
+			}
+		}
+	}
+	d.gameboy.Tilt(x, y)
+}
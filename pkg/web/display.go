@@ -0,0 +1,69 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Package web runs the emulator in a web page when built for WebAssembly, drawing frames on a canvas,
+// playing audio with WebAudio and reading the keyboard and gamepads through the browser
+package web
+
+import (
+	"image"
+	"syscall/js"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// CanvasDisplay implements the LCD display by drawing each frame on a canvas
+type CanvasDisplay struct {
+	gameboy   *gb.Gameboy
+	canvas    js.Value
+	context   js.Value
+	imageData js.Value
+	data      js.Value
+	size      image.Point
+	gamepads  gamepads
+}
+
+// NewCanvasDisplay draws frames on a canvas, which should be scaled up with CSS, and sends keys bound
+// to actions as described by config.Config to the Gameboy
+func NewCanvasDisplay(gameboy *gb.Gameboy, canvas js.Value, keys map[string]string) (*CanvasDisplay, error) {
+	bindings, err := bindKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	display := &CanvasDisplay{
+		canvas:  canvas,
+		context: canvas.Call("getContext", "2d"),
+	}
+	display.SetGameboy(gameboy)
+	document := js.Global().Get("document")
+	document.Call("addEventListener", "keydown", onKeyFunc(display, bindings, true))
+	document.Call("addEventListener", "keyup", onKeyFunc(display, bindings, false))
+	return display, nil
+}
+
+// SetGameboy sends keyboard and gamepad input to a different Gameboy, such as one running a newly
+// loaded ROM
+func (d *CanvasDisplay) SetGameboy(gameboy *gb.Gameboy) {
+	d.gameboy = gameboy
+	d.gamepads = gamepads{}
+	w, h := gameboy.ScreenSize()
+	d.canvas.Set("width", w)
+	d.canvas.Set("height", h)
+}
+
+// DisplayFrame draws a frame on the canvas and reads the gamepads
+func (d *CanvasDisplay) DisplayFrame(frame *image.RGBA) {
+	size := frame.Rect.Size()
+	if size != d.size {
+		// The pixels are copied into a Uint8Array that shares its buffer with the image data
+		d.data = js.Global().Get("Uint8Array").New(len(frame.Pix))
+		pixels := js.Global().Get("Uint8ClampedArray").New(d.data.Get("buffer"))
+		d.imageData = js.Global().Get("ImageData").New(pixels, size.X, size.Y)
+		d.size = size
+	}
+	js.CopyBytesToJS(d.data, frame.Pix)
+	// Only the top left of the frame is shown, as the frame can be larger than the screen
+	w, h := d.gameboy.ScreenSize()
+	d.context.Call("putImageData", d.imageData, 0, 0, 0, 0, w, h)
+	d.gamepads.poll(d.gameboy)
+}
@@ -0,0 +1,71 @@
+//go:build js && wasm
+// +build js,wasm
+
+package web
+
+import (
+	"syscall/js"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// gamepadButtons maps the buttons of a gamepad with the browser's standard layout to Gameboy buttons
+var gamepadButtons = map[int]gb.Button{
+	0:  gb.A,
+	1:  gb.B,
+	8:  gb.Select,
+	9:  gb.Start,
+	12: gb.Up,
+	13: gb.Down,
+	14: gb.Left,
+	15: gb.Right,
+}
+
+// deadZone is how far a stick must move before it presses a direction
+const deadZone = 0.5
+
+// gamepads holds the buttons pressed on each of the first 4 gamepads so that only changes are sent to
+// the Gameboy. The first gamepad controls the same player as the keyboard and the others control the
+// extra players that a Super Game Boy supports.
+type gamepads [4]map[gb.Button]bool
+
+// poll reads every connected gamepad and presses or releases buttons on the controller of the same
+// number
+func (g *gamepads) poll(gameboy *gb.Gameboy) {
+	navigator := js.Global().Get("navigator")
+	if navigator.Get("getGamepads").IsUndefined() {
+		return
+	}
+	connected := navigator.Call("getGamepads")
+	for player := range g {
+		pressed := map[gb.Button]bool{}
+		if player < connected.Length() {
+			if gamepad := connected.Index(player); gamepad.Truthy() {
+				buttons := gamepad.Get("buttons")
+				for i, button := range gamepadButtons {
+					if i < buttons.Length() && buttons.Index(i).Get("pressed").Bool() {
+						pressed[button] = true
+					}
+				}
+				if axes := gamepad.Get("axes"); axes.Length() >= 2 {
+					x, y := axes.Index(0).Float(), axes.Index(1).Float()
+					pressed[gb.Left] = pressed[gb.Left] || x < -deadZone
+					pressed[gb.Right] = pressed[gb.Right] || x > deadZone
+					pressed[gb.Up] = pressed[gb.Up] || y < -deadZone
+					pressed[gb.Down] = pressed[gb.Down] || y > deadZone
+				}
+			}
+		}
+		for button, down := range pressed {
+			if down != g[player][button] {
+				gameboy.PlayerButtonAction(player, button, down)
+			}
+		}
+		for button, down := range g[player] {
+			if down && !pressed[button] {
+				gameboy.PlayerButtonAction(player, button, false)
+			}
+		}
+		g[player] = pressed
+	}
+}
@@ -0,0 +1,108 @@
+//go:build js && wasm
+// +build js,wasm
+
+package web
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+)
+
+// keyCodes maps the names used in the config file to the codes of keyboard events, ignoring case
+var keyCodes = map[string]string{
+	"up":           "ArrowUp",
+	"down":         "ArrowDown",
+	"left":         "ArrowLeft",
+	"right":        "ArrowRight",
+	"enter":        "Enter",
+	"space":        "Space",
+	"tab":          "Tab",
+	"backspace":    "Backspace",
+	"escape":       "Escape",
+	"leftshift":    "ShiftLeft",
+	"rightshift":   "ShiftRight",
+	"leftcontrol":  "ControlLeft",
+	"rightcontrol": "ControlRight",
+	"leftalt":      "AltLeft",
+	"rightalt":     "AltRight",
+	"comma":        "Comma",
+	"period":       "Period",
+	"slash":        "Slash",
+	"semicolon":    "Semicolon",
+}
+
+func init() {
+	for c := 'a'; c <= 'z'; c++ {
+		keyCodes[string(c)] = "Key" + strings.ToUpper(string(c))
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyCodes[string(c)] = "Digit" + string(c)
+	}
+	for n := 1; n <= 12; n++ {
+		keyCodes[fmt.Sprintf("f%d", n)] = fmt.Sprintf("F%d", n)
+	}
+}
+
+// bindKeys maps the code of each key to the action it is bound to
+func bindKeys(keys map[string]string) (map[string]string, error) {
+	bindings := map[string]string{}
+	for action, name := range keys {
+		code, ok := keyCodes[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q for %s", name, action)
+		}
+		if other, ok := bindings[code]; ok {
+			return nil, fmt.Errorf("key %q is bound to both %s and %s", name, other, action)
+		}
+		bindings[code] = action
+	}
+	return bindings, nil
+}
+
+// buttons maps actions to the Gameboy buttons they press
+var buttons = map[string]gb.Button{
+	"start":  gb.Start,
+	"select": gb.Select,
+	"b":      gb.B,
+	"a":      gb.A,
+	"up":     gb.Up,
+	"down":   gb.Down,
+	"left":   gb.Left,
+	"right":  gb.Right,
+}
+
+// onKeyFunc returns a listener for keydown or keyup events. Taking screenshots and dumping traces
+// aren't supported since they write files.
+func onKeyFunc(d *CanvasDisplay, bindings map[string]string, pressed bool) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		event := args[0]
+		action, ok := bindings[event.Get("code").String()]
+		if !ok {
+			return nil
+		}
+		// Stop the arrow keys and tab from scrolling the page or moving the focus
+		event.Call("preventDefault")
+		if event.Get("repeat").Bool() {
+			return nil
+		}
+		if button, ok := buttons[action]; ok {
+			d.gameboy.ButtonAction(button, pressed)
+		} else if action == "fastforward" {
+			if pressed {
+				d.gameboy.EmulatorAction(gb.StartFastForward)
+			} else {
+				d.gameboy.EmulatorAction(gb.StopFastForward)
+			}
+		} else if action == "rewind" {
+			if pressed {
+				d.gameboy.EmulatorAction(gb.StartRewind)
+			} else {
+				d.gameboy.EmulatorAction(gb.StopRewind)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+//go:build js && wasm
+// +build js,wasm
+
+package web
+
+import (
+	"encoding/binary"
+	"math"
+	"syscall/js"
+
+	"github.com/scottyw/tetromino/pkg/gb/audio"
+)
+
+// processorSamples is the number of stereo samples played by each call to the script processor,
+// about 46ms of audio
+const processorSamples = 2048
+
+// WebAudioSpeakers implements speakers using WebAudio
+type WebAudioSpeakers struct {
+	context  js.Value
+	node     js.Value
+	callback js.Func
+	buffer   *audio.RingBuffer
+	samples  []float32
+	bytes    []byte
+	left     js.Value
+	right    js.Value
+	// leftBytes and rightBytes are views of the bytes of left and right
+	leftBytes  js.Value
+	rightBytes js.Value
+}
+
+// NewWebAudioSpeakers starts audio output using WebAudio. Browsers only play audio once the page has
+// been interacted with, so Resume should be called from a click or key press.
+func NewWebAudioSpeakers() *WebAudioSpeakers {
+	options := js.Global().Get("Object").New()
+	options.Set("sampleRate", 44100)
+	context := js.Global().Get("AudioContext").New(options)
+	s := &WebAudioSpeakers{
+		context: context,
+		node:    context.Call("createScriptProcessor", processorSamples, 0, 2),
+		buffer:  audio.NewRingBuffer(processorSamples * 2),
+		samples: make([]float32, processorSamples*2),
+		bytes:   make([]byte, processorSamples*4),
+		left:    js.Global().Get("Float32Array").New(processorSamples),
+		right:   js.Global().Get("Float32Array").New(processorSamples),
+	}
+	s.leftBytes = js.Global().Get("Uint8Array").New(s.left.Get("buffer"))
+	s.rightBytes = js.Global().Get("Uint8Array").New(s.right.Get("buffer"))
+	s.callback = js.FuncOf(s.process)
+	s.node.Set("onaudioprocess", s.callback)
+	s.node.Call("connect", context.Get("destination"))
+	return s
+}
+
+// Resume starts playing audio if the browser hasn't allowed it yet
+func (s *WebAudioSpeakers) Resume() {
+	s.context.Call("resume")
+}
+
+// Buffer returns the ring buffer of samples that the speakers play
+func (s *WebAudioSpeakers) Buffer() *audio.RingBuffer {
+	return s.buffer
+}
+
+// process fills the output of the script processor from the ring buffer, playing silence rather
+// than waiting for the emulator when it has not produced enough samples
+func (s *WebAudioSpeakers) process(this js.Value, args []js.Value) interface{} {
+	output := args[0].Get("outputBuffer")
+	n := s.buffer.Read(s.samples)
+	for i := n; i < len(s.samples); i++ {
+		s.samples[i] = 0
+	}
+	s.copyChannel(s.leftBytes, 0)
+	s.copyChannel(s.rightBytes, 1)
+	output.Call("getChannelData", 0).Call("set", s.left)
+	output.Call("getChannelData", 1).Call("set", s.right)
+	return nil
+}
+
+// copyChannel copies the left or right samples into the bytes of a Float32Array, since only bytes can
+// be copied to JavaScript directly
+func (s *WebAudioSpeakers) copyChannel(channel js.Value, offset int) {
+	for i := 0; i < processorSamples; i++ {
+		binary.LittleEndian.PutUint32(s.bytes[i*4:], math.Float32bits(s.samples[i*2+offset]))
+	}
+	js.CopyBytesToJS(channel, s.bytes)
+}
@@ -0,0 +1,173 @@
+package webdebug
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tetromino debugger</title>
+<style>
+body { font-family: monospace; display: flex; flex-wrap: wrap; gap: 16px; }
+section { border: 1px solid #ccc; padding: 8px; }
+img { image-rendering: pixelated; }
+#screen { width: 320px; height: 288px; }
+#tiles { width: 256px; height: 384px; }
+pre { margin: 0; }
+table { border-collapse: collapse; }
+td { padding: 0 4px; }
+</style>
+</head>
+<body>
+<section>
+<h3>Screen</h3>
+<img id="screen"><br>
+<button onclick="send('pause')">Pause</button>
+<button onclick="send('resume')">Resume</button>
+<button onclick="send('step')">Step</button>
+<button onclick="send('stepOver')">Step over</button>
+<button onclick="send('stepOut')">Step out</button>
+<button onclick="send('frame')">Frame</button>
+<p id="status"></p>
+</section>
+<section>
+<h3>Registers</h3>
+<pre id="registers"></pre>
+<h3>Timer</h3>
+<pre id="timer"></pre>
+<h3>Disassembly</h3>
+<pre id="disassembly"></pre>
+</section>
+<section>
+<h3>Breakpoints</h3>
+<input id="addr" placeholder="0150" size="6">
+<input id="condition" placeholder="A==0x3C && [0xC0A0]>5" size="24">
+<button onclick="addBreakpoint()">Add</button>
+<ul id="breakpoints"></ul>
+<p>Break on
+<label><input type="checkbox" onchange="breakOnInterrupt('vblank', this.checked)">VBlank</label>
+<label><input type="checkbox" onchange="breakOnInterrupt('stat', this.checked)">STAT</label>
+<label><input type="checkbox" onchange="breakOnInterrupt('timer', this.checked)">Timer</label>
+<label><input type="checkbox" onchange="breakOnInterrupt('serial', this.checked)">Serial</label>
+<label><input type="checkbox" onchange="breakOnInterrupt('joypad', this.checked)">Joypad</label>
+<label><input type="checkbox" onchange="ws.send(JSON.stringify({command: 'breakOnBankSwitch', enabled: this.checked}))">Bank switch</label>
+</p>
+<p>Break on scanline
+<input id="line" placeholder="0-153" size="4">
+<select id="mode">
+<option value="-1">any mode</option>
+<option value="0">mode 0 (H-Blank)</option>
+<option value="1">mode 1 (V-Blank)</option>
+<option value="2">mode 2 (OAM)</option>
+<option value="3">mode 3 (transfer)</option>
+</select>
+<button onclick="breakOnScanline(true)">Set</button>
+<button onclick="breakOnScanline(false)">Clear</button>
+<span id="scanline"></span>
+</p>
+</section>
+<section>
+<h3>Watches</h3>
+<input id="expression" placeholder="[0xC0A0] or wPlayerX" size="24">
+<button onclick="send('watch', 0, '', document.getElementById('expression').value)">Watch</button>
+<table id="watches"></table>
+</section>
+<section>
+<h3>Tiles</h3>
+<img id="tiles" onclick="paint(event)"><br>
+Paint colour
+<label><input type="radio" name="colour" value="0">0</label>
+<label><input type="radio" name="colour" value="1">1</label>
+<label><input type="radio" name="colour" value="2">2</label>
+<label><input type="radio" name="colour" value="3" checked>3</label>
+<p>
+<label>BGP <input id="bgp" size="2" onchange="setPalette('bgp', this.value)"></label>
+<label>OBP0 <input id="obp0" size="2" onchange="setPalette('obp0', this.value)"></label>
+<label>OBP1 <input id="obp1" size="2" onchange="setPalette('obp1', this.value)"></label>
+</p>
+</section>
+<section>
+<h3>OAM</h3>
+<table id="oam"></table>
+</section>
+<script>
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+function hex(value, digits) { return value.toString(16).padStart(digits, "0"); }
+function send(command, addr, condition, expression) {
+  ws.send(JSON.stringify({command: command, addr: addr || 0, condition: condition, expression: expression}));
+}
+function breakOnInterrupt(name, enabled) {
+  ws.send(JSON.stringify({command: "breakOnInterrupt", interrupt: name, enabled: enabled}));
+}
+function breakOnScanline(enabled) {
+  ws.send(JSON.stringify({command: "breakOnScanline", enabled: enabled,
+    line: parseInt(document.getElementById("line").value) || 0, mode: parseInt(document.getElementById("mode").value)}));
+}
+function addBreakpoint() {
+  send("break", parseInt(document.getElementById("addr").value, 16), document.getElementById("condition").value);
+}
+function paint(event) {
+  const img = event.target;
+  const x = Math.floor(event.offsetX * 128 / img.clientWidth);
+  const y = Math.floor(event.offsetY * 192 / img.clientHeight);
+  const colour = parseInt(document.querySelector("input[name=colour]:checked").value);
+  ws.send(JSON.stringify({command: "pixel", tile: Math.floor(y / 8) * 16 + Math.floor(x / 8), x: x % 8, y: y % 8, value: colour}));
+}
+function setPalette(name, value) {
+  ws.send(JSON.stringify({command: "palette", palette: name, value: parseInt(value, 16)}));
+}
+ws.onmessage = function (event) {
+  const s = JSON.parse(event.data);
+  const r = s.registers;
+  if (s.screen) { document.getElementById("screen").src = s.screen; }
+  document.getElementById("tiles").src = s.tiles;
+  ["bgp", "obp0", "obp1"].forEach(function (name) {
+    const input = document.getElementById(name);
+    if (document.activeElement !== input) { input.value = hex(s.palettes[name], 2); }
+  });
+  document.getElementById("status").textContent = (s.paused ? "Paused" : "Running") + " at frame " + s.frame + (s.message ? " - " + s.message : "");
+  document.getElementById("registers").textContent =
+    "AF " + hex(r.A, 2) + hex(r.F, 2) + "  BC " + hex(r.B, 2) + hex(r.C, 2) + "\n" +
+    "DE " + hex(r.D, 2) + hex(r.E, 2) + "  HL " + hex(r.H, 2) + hex(r.L, 2) + "\n" +
+    "SP " + hex(r.SP, 4) + "  PC " + hex(r.PC, 4) + "\n" +
+    "IME " + r.IME + "  Halted " + r.Halted;
+  const t = s.timer;
+  document.getElementById("timer").textContent =
+    "DIV " + hex(t.Counter >> 8, 2) + " (" + hex(t.Counter, 4) + ")\n" +
+    "TIMA " + hex(t.TIMA, 2) + "  TMA " + hex(t.TMA, 2) + "  TAC " + hex(t.TAC, 2) +
+    (t.Overflow ? "\nOverflowed, reloading from TMA" : "");
+  document.getElementById("disassembly").textContent = s.disassembly.join("\n");
+  const breakpoints = document.getElementById("breakpoints");
+  breakpoints.innerHTML = "";
+  (s.breakpoints || []).forEach(function (breakpoint) {
+    const item = document.createElement("li");
+    item.textContent = hex(breakpoint.addr, 4) + " " + (breakpoint.condition || "") + " ";
+    const remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.onclick = function () { send("clear", breakpoint.addr); };
+    item.appendChild(remove);
+    breakpoints.appendChild(item);
+  });
+  document.getElementById("scanline").textContent = s.scanline || "";
+  const watches = document.getElementById("watches");
+  watches.innerHTML = "";
+  (s.watches || []).forEach(function (watch) {
+    const row = watches.insertRow();
+    row.insertCell().textContent = watch.Expression;
+    row.insertCell().textContent = watch.Value + " (0x" + hex(watch.Value, 2) + ")";
+    const remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.onclick = function () { send("unwatch", 0, "", watch.Expression); };
+    row.insertCell().appendChild(remove);
+  });
+  const oam = document.getElementById("oam");
+  oam.innerHTML = "<tr><th>#</th><th>Y</th><th>X</th><th>Tile</th><th>Flags</th></tr>";
+  s.oam.forEach(function (sprite, i) {
+    const row = oam.insertRow();
+    [i, sprite.y, sprite.x, hex(sprite.tile, 2), hex(sprite.flags, 2)].forEach(function (value) {
+      row.insertCell().textContent = value;
+    });
+  });
+};
+</script>
+</body>
+</html>
+`
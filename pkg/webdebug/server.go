@@ -0,0 +1,372 @@
+// Package webdebug serves a browser-based debugger for the Gameboy
+package webdebug
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"github.com/scottyw/tetromino/pkg/gb/cpu"
+	"github.com/scottyw/tetromino/pkg/gb/timer"
+	"golang.org/x/net/websocket"
+)
+
+// The browser is sent a new snapshot this often while the game is running
+const publishFrames = 6
+
+// Command is sent by the browser to control the emulator
+type Command struct {
+	Command    string `json:"command"`
+	Addr       uint16 `json:"addr"`
+	Condition  string `json:"condition,omitempty"`
+	Interrupt  string `json:"interrupt,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	Enabled    bool   `json:"enabled"`
+	Tile       int    `json:"tile"`
+	X          int    `json:"x"`
+	Y          int    `json:"y"`
+	Palette    string `json:"palette,omitempty"`
+	Value      uint8  `json:"value"`
+	Line       uint8  `json:"line"`
+	Mode       int    `json:"mode"`
+}
+
+// Sprite is an entry in OAM
+type Sprite struct {
+	Y     uint8 `json:"y"`
+	X     uint8 `json:"x"`
+	Tile  uint8 `json:"tile"`
+	Flags uint8 `json:"flags"`
+}
+
+// Breakpoint is an address where the emulator pauses, optionally only when a condition is true
+type Breakpoint struct {
+	Addr      uint16 `json:"addr"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// Snapshot is the emulator state sent to the browser
+type Snapshot struct {
+	Paused      bool             `json:"paused"`
+	Frame       int              `json:"frame"`
+	Registers   cpu.Registers    `json:"registers"`
+	Timer       timer.State      `json:"timer"`
+	Disassembly []string         `json:"disassembly"`
+	Breakpoints []Breakpoint     `json:"breakpoints"`
+	OAM         []Sprite         `json:"oam"`
+	Screen      string           `json:"screen,omitempty"`
+	Tiles       string           `json:"tiles"`
+	Watches     []gb.Watch       `json:"watches"`
+	Scanline    string           `json:"scanline,omitempty"`
+	Palettes    map[string]uint8 `json:"palettes"`
+	Message     string           `json:"message,omitempty"`
+	version     int
+}
+
+// Server runs the emulator under the control of a browser
+type Server struct {
+	gameboy  *gb.Gameboy
+	screen   *image.RGBA
+	paused   bool
+	message  string
+	mu       sync.Mutex
+	commands []Command
+	cancel   context.CancelFunc
+	wake     chan struct{}
+	snapshot Snapshot
+}
+
+// New returns a debugger server for a Gameboy
+func New(gameboy *gb.Gameboy) *Server {
+	s := &Server{
+		gameboy: gameboy,
+		wake:    make(chan struct{}, 1),
+	}
+	gameboy.OnFrame(func(frame *image.RGBA) {
+		s.screen = frame
+		if gameboy.FrameCount()%publishFrames == 0 {
+			s.publish()
+		}
+	})
+	return s
+}
+
+// ListenAndServe serves the debugger UI on an HTTP address and runs the emulator until the context is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: s.Handler()}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	s.gameboy.Logger().With("webdebug").Infof("Debugger running at http://%s/", addr)
+	s.Run(ctx)
+	server.Close()
+	err := <-errs
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Handler returns the HTTP handler for the debugger UI
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+	mux.Handle("/ws", websocket.Handler(s.serveWebSocket))
+	return mux
+}
+
+// Run drives the emulator, handling commands from the browser, until the context is done
+func (s *Server) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		for _, command := range s.takeCommands() {
+			s.handle(ctx, command)
+		}
+		s.publish()
+		if s.paused {
+			select {
+			case <-ctx.Done():
+			case <-s.wake:
+			}
+			continue
+		}
+		if s.execute(ctx, s.gameboy.Continue) {
+			s.paused = true
+		}
+	}
+}
+
+// execute runs the emulator until it stops by itself or a command arrives from the browser
+func (s *Server) execute(ctx context.Context, run func(context.Context) bool) bool {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.setCancel(cancel)
+	defer s.setCancel(nil)
+	if run(runCtx) {
+		s.message = s.gameboy.StopReason()
+		return true
+	}
+	return false
+}
+
+func (s *Server) handle(ctx context.Context, command Command) {
+	s.message = ""
+	switch command.Command {
+	case "pause":
+		s.paused = true
+	case "resume":
+		s.paused = false
+	case "step":
+		s.paused = true
+		s.gameboy.Step()
+	case "stepOver":
+		s.paused = true
+		s.execute(ctx, s.gameboy.StepOver)
+	case "stepOut":
+		s.paused = true
+		var err error
+		s.execute(ctx, func(ctx context.Context) bool {
+			var stopped bool
+			stopped, err = s.gameboy.StepOut(ctx)
+			return stopped
+		})
+		if err != nil {
+			s.message = err.Error()
+		}
+	case "frame":
+		s.paused = true
+		s.gameboy.RunFrames(1)
+	case "break":
+		if command.Condition == "" {
+			s.gameboy.SetBreakpoint(command.Addr)
+		} else if err := s.gameboy.SetConditionalBreakpoint(command.Addr, command.Condition); err != nil {
+			s.message = err.Error()
+		}
+	case "clear":
+		s.gameboy.ClearBreakpoint(command.Addr)
+	case "breakOnInterrupt":
+		interrupt, err := gb.ParseInterrupt(command.Interrupt)
+		if err != nil {
+			s.message = err.Error()
+			return
+		}
+		s.gameboy.SetBreakOnInterrupt(interrupt, command.Enabled)
+	case "watch":
+		if err := s.gameboy.AddWatch(command.Expression); err != nil {
+			s.message = err.Error()
+		}
+	case "unwatch":
+		s.gameboy.RemoveWatch(command.Expression)
+	case "breakOnBankSwitch":
+		s.gameboy.SetBreakOnBankSwitch(command.Enabled)
+	case "breakOnScanline":
+		var scanline *gb.ScanlineBreak
+		if command.Enabled {
+			scanline = &gb.ScanlineBreak{Line: command.Line, Mode: command.Mode}
+		}
+		if err := s.gameboy.SetBreakOnScanline(scanline); err != nil {
+			s.message = err.Error()
+		}
+	case "pixel":
+		if err := s.gameboy.SetTilePixel(command.Tile, command.X, command.Y, command.Value); err != nil {
+			s.message = err.Error()
+		}
+	case "palette":
+		if err := s.gameboy.SetPalette(command.Palette, command.Value); err != nil {
+			s.message = err.Error()
+		}
+	}
+}
+
+// send queues a command and interrupts the emulator so that it is handled promptly
+func (s *Server) send(command Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, command)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) takeCommands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+func (s *Server) setCancel(cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel = cancel
+	// Commands that arrived since they were last taken must interrupt the run that is starting
+	if cancel != nil && len(s.commands) > 0 {
+		cancel()
+	}
+}
+
+// publish takes a snapshot of the emulator state for the browser, and must be called from the
+// goroutine that runs the emulator
+func (s *Server) publish() {
+	r := s.gameboy.Registers()
+	snapshot := Snapshot{
+		Paused:    s.paused,
+		Frame:     s.gameboy.FrameCount(),
+		Registers: r,
+		Timer:     s.gameboy.TimerState(),
+		Tiles:     encodePNG(s.tiles()),
+		Watches:   s.gameboy.Watches(),
+		Palettes:  map[string]uint8{},
+		Message:   s.message,
+	}
+	if scanline := s.gameboy.BreakOnScanline(); scanline != nil {
+		snapshot.Scanline = scanline.String()
+	}
+	for name, addr := range gb.Palettes {
+		snapshot.Palettes[name] = s.gameboy.PeekMemory(addr)
+	}
+	for _, addr := range s.gameboy.Breakpoints() {
+		snapshot.Breakpoints = append(snapshot.Breakpoints, Breakpoint{
+			Addr:      addr,
+			Condition: s.gameboy.BreakpointCondition(addr),
+		})
+	}
+	addr := r.PC
+	for i := 0; i < 16; i++ {
+		instruction := s.gameboy.Disassemble(addr)
+		snapshot.Disassembly = append(snapshot.Disassembly, instruction.String())
+		addr += uint16(len(instruction.Bytes))
+	}
+	for i := uint16(0); i < 40; i++ {
+		base := 0xfe00 + i*4
+		snapshot.OAM = append(snapshot.OAM, Sprite{
+			Y:     s.gameboy.PeekMemory(base),
+			X:     s.gameboy.PeekMemory(base + 1),
+			Tile:  s.gameboy.PeekMemory(base + 2),
+			Flags: s.gameboy.PeekMemory(base + 3),
+		})
+	}
+	if s.screen != nil {
+		snapshot.Screen = encodePNG(s.screen.SubImage(image.Rect(0, 0, 160, 144)))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot.version = s.snapshot.version + 1
+	s.snapshot = snapshot
+}
+
+func (s *Server) latest() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot
+}
+
+// tiles renders the 384 tiles in video RAM as a 16x24 grid using the background palette
+func (s *Server) tiles() image.Image {
+	shades := [4]uint8{0xff, 0xaa, 0x77, 0x33}
+	bgp := s.gameboy.PeekMemory(0xff47)
+	img := image.NewGray(image.Rect(0, 0, 16*8, 24*8))
+	for tile := 0; tile < 384; tile++ {
+		for y := 0; y < 8; y++ {
+			addr := uint16(0x8000 + tile*16 + y*2)
+			low, high := s.gameboy.PeekMemory(addr), s.gameboy.PeekMemory(addr+1)
+			for x := 0; x < 8; x++ {
+				bit := uint(7 - x)
+				index := (high>>bit&1)<<1 | low>>bit&1
+				shade := shades[bgp>>(index*2)&3]
+				img.SetGray((tile%16)*8+x, (tile/16)*8+y, color.Gray{Y: shade})
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(img image.Image) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ""
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	go func() {
+		for {
+			var command Command
+			if err := websocket.JSON.Receive(ws, &command); err != nil {
+				ws.Close()
+				return
+			}
+			s.send(command)
+		}
+	}()
+	var version int
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		snapshot := s.latest()
+		if snapshot.version == version {
+			continue
+		}
+		version = snapshot.version
+		if err := websocket.JSON.Send(ws, snapshot); err != nil {
+			return
+		}
+	}
+}
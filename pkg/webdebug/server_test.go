@@ -0,0 +1,57 @@
+package webdebug
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scottyw/tetromino/pkg/gb"
+	"golang.org/x/net/websocket"
+)
+
+func TestBreakpoint(t *testing.T) {
+	gameboy, err := gb.NewGameboy(gb.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(gameboy)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	ws, err := websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// The empty ROM is full of NOPs so execution always reaches the breakpoint
+	for _, command := range []Command{
+		{Command: "pause"},
+		{Command: "palette", Palette: "bgp", Value: 0xe4},
+		{Command: "break", Addr: 0x2000},
+		{Command: "resume"},
+	} {
+		if err := websocket.JSON.Send(ws, command); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		var snapshot Snapshot
+		if err := websocket.JSON.Receive(ws, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Paused && snapshot.Registers.PC == 0x2000 {
+			if len(snapshot.OAM) != 40 || !strings.HasPrefix(snapshot.Tiles, "data:image/png") {
+				t.Errorf("expected OAM and tiles in the snapshot")
+			}
+			if snapshot.Palettes["bgp"] != 0xe4 {
+				t.Errorf("expected bgp to be e4 but got %02x", snapshot.Palettes["bgp"])
+			}
+			return
+		}
+	}
+}